@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"m1pes/internal/models"
+	"m1pes/internal/repository/api/stocks/bybit"
+	"m1pes/internal/repository/api/stocks/sim"
+	"m1pes/internal/service/backtest"
+)
+
+func main() {
+	var (
+		symbol        = flag.String("symbol", "BTCUSDT", "symbol to test")
+		csvPath       = flag.String("csv", "", "csv file with candles, Bybit klines are used if it is empty")
+		interval      = flag.String("interval", "60", "Bybit kline interval: 1, 5, 15, 60, 240, D...")
+		from          = flag.String("from", time.Now().AddDate(0, -1, 0).Format(time.DateOnly), "start date of Bybit klines")
+		to            = flag.String("to", time.Now().Format(time.DateOnly), "end date of Bybit klines")
+		cacheDir      = flag.String("cache", ".cache/klines", "directory where Bybit klines are cached")
+		balance       = flag.Float64("balance", 1000, "initial USDT balance")
+		percent       = flag.Float64("percent", 0.01, "ladder step and take profit")
+		orderSize     = flag.Float64("order-size", models.DefaultOrderSize, "part of equity which is spent on entry order")
+		sizing        = flag.String("sizing", models.SizingFlat, "size of next buy: flat, linear, geometric or target")
+		multiplier    = flag.Float64("multiplier", 1, "how many times the second buy is bigger than the first one")
+		targetAverage = flag.Float64("target-average", 0, "part above next buy where target sizing moves average price, step by default")
+		spacing       = flag.String("spacing", models.SpacingFixed, "ladder steps: fixed, percent or widening")
+		stepWidening  = flag.Float64("step-widening", models.DefaultStepWidening, "how much every next step of widening spacing is wider")
+		maxDepth      = flag.Int("max-depth", 0, "how many buys ladder can have, 0 is unlimited")
+		makerFee      = flag.Float64("maker-fee", sim.DefaultMakerFee, "maker fee rate")
+		takerFee      = flag.Float64("taker-fee", sim.DefaultTakerFee, "taker fee rate")
+		qtyDecimals   = flag.Int("qty-decimals", 6, "decimals of order quantity")
+		priceDecimals = flag.Int("price-decimals", 2, "decimals of order price")
+		equityPath    = flag.String("equity", "", "csv file where equity curve is written")
+	)
+	flag.Parse()
+
+	ctx := context.Background()
+	service := backtest.New(bybit.New(bybit.URL), *cacheDir)
+
+	var candles []models.Candle
+	var err error
+	if *csvPath != "" {
+		candles, err = sim.LoadCandlesCSV(*csvPath)
+	} else {
+		candles, err = fetchCandles(ctx, service, *symbol, *interval, *from, *to)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := service.Run(ctx, backtest.Config{
+		Symbol:  *symbol,
+		Balance: *balance,
+		Percent: *percent,
+		Settings: models.CoinSettings{
+			OrderSize:     *orderSize,
+			Sizing:        *sizing,
+			Multiplier:    *multiplier,
+			TargetAverage: *targetAverage,
+			Spacing:       *spacing,
+			StepWidening:  *stepWidening,
+			MaxDepth:      *maxDepth,
+		},
+		Fees:    sim.Fees{Maker: *makerFee, Taker: *takerFee},
+		Coiniks: models.Coiniks{QtyDecimals: *qtyDecimals, PriceDecimals: *priceDecimals},
+	}, candles)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	printReport(report)
+
+	if *equityPath != "" {
+		if err = writeEquityCurve(*equityPath, report.EquityCurve); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func fetchCandles(ctx context.Context, service *backtest.Service, symbol, interval, from, to string) ([]models.Candle, error) {
+	fromTime, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		return nil, fmt.Errorf("parse from failed: %w", err)
+	}
+
+	toTime, err := time.Parse(time.DateOnly, to)
+	if err != nil {
+		return nil, fmt.Errorf("parse to failed: %w", err)
+	}
+
+	return service.FetchCandles(ctx, symbol, interval, fromTime, toTime)
+}
+
+func printReport(r backtest.Report) {
+	fmt.Printf("Symbol:              %s\n", r.Symbol)
+	fmt.Printf("Candles:             %d\n", r.Candles)
+	fmt.Printf("Start equity:        %.2f\n", r.StartEquity)
+	fmt.Printf("End equity:          %.2f\n", r.EndEquity)
+	fmt.Printf("PnL:                 %.2f (%.2f%%)\n", r.PnL, r.PnLPercent)
+	fmt.Printf("Realised PnL:        %.2f\n", r.RealisedPnL)
+	fmt.Printf("Unrealised PnL:      %.2f\n", r.UnrealisedPnL)
+	fmt.Printf("Fees:                %.2f\n", r.Fees)
+	fmt.Printf("Buy and hold:        %.2f%%\n", r.BuyAndHoldPercent)
+	fmt.Printf("Max drawdown:        %.2f (%.2f%%)\n", r.MaxDrawdown, r.MaxDrawdownPercent)
+	fmt.Printf("Capital utilisation: avg %.2f%%, max %.2f%%\n", r.AvgUtilisation*100, r.MaxUtilisation*100)
+	fmt.Printf("Ladder steps:        %d (max depth %d)\n", r.LadderSteps, r.MaxDepth)
+	fmt.Printf("Cycles:              %d\n", r.Cycles)
+	if r.Stops > 0 {
+		fmt.Printf("Stops:               %d\n", r.Stops)
+	}
+	fmt.Printf("Open position:       %v\n", r.OpenPosition)
+	if r.Errors > 0 {
+		fmt.Printf("Errors:              %d, last: %s\n", r.Errors, r.LastError)
+	}
+}
+
+func writeEquityCurve(path string, curve []backtest.EquityPoint) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	_ = writer.Write([]string{"time", "price", "equity", "utilisation"})
+	for _, point := range curve {
+		_ = writer.Write([]string{
+			strconv.FormatInt(point.Time, 10),
+			strconv.FormatFloat(point.Price, 'f', -1, 64),
+			strconv.FormatFloat(point.Equity, 'f', 4, 64),
+			strconv.FormatFloat(point.Utilisation, 'f', 4, 64),
+		})
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
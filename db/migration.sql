@@ -42,4 +42,195 @@ CREATE TABLE IF NOT EXISTS coiniks
     "qty_decimals"   int              default 0,
     "price_decimals" int              default 0,
     "min_sum_buy"    double precision default 0
-);
\ No newline at end of file
+);
+ALTER TABLE coin ADD COLUMN IF NOT EXISTS "coin_state" text;
+UPDATE coin
+SET coin_state = CASE
+                     WHEN count > 0 AND buy_order_id <> '' THEN 'Accumulating'
+                     WHEN count > 0 THEN 'WaitingExit'
+                     WHEN buy_order_id <> '' THEN 'WaitingEntry'
+                     ELSE 'Idle' END
+WHERE coin_state IS NULL;
+ALTER TABLE coin ALTER COLUMN "coin_state" SET DEFAULT 'Idle';
+
+CREATE TABLE IF NOT EXISTS coin_state_history
+(
+    "user_id"    bigint references users (tg_id),
+    "coin_name"  text,
+    "from_state" text,
+    "to_state"   text,
+    "reason"     text      default '',
+    "time"       timestamp default now() not null
+);
+
+ALTER TABLE coin ADD COLUMN IF NOT EXISTS "strategy" text default 'ladder';
+ALTER TABLE coin ADD COLUMN IF NOT EXISTS "strategy_params" text default '{}';
+
+CREATE TABLE IF NOT EXISTS paper_account
+(
+    "user_id" bigint primary key references users (tg_id),
+    "api_key" text    default '',
+    "active"  boolean default false
+);
+CREATE TABLE IF NOT EXISTS paper_balance
+(
+    "user_id" bigint references users (tg_id),
+    "coin"    text,
+    "amount"  double precision default 0,
+    primary key (user_id, coin)
+);
+CREATE TABLE IF NOT EXISTS paper_order
+(
+    "order_id"       text primary key,
+    "user_id"        bigint references users (tg_id),
+    "symbol"         text,
+    "side"           text,
+    "order_type"     text,
+    "status"         text,
+    "price"          double precision default 0,
+    "qty"            double precision default 0,
+    "cum_exec_qty"   double precision default 0,
+    "cum_exec_value" double precision default 0,
+    "cum_exec_fee"   double precision default 0,
+    "created_time"   bigint,
+    "updated_time"   bigint
+);
+CREATE TABLE IF NOT EXISTS paper_execution
+(
+    "exec_id"      text primary key,
+    "order_id"     text,
+    "user_id"      bigint references users (tg_id),
+    "symbol"       text,
+    "side"         text,
+    "exec_price"   double precision default 0,
+    "exec_qty"     double precision default 0,
+    "exec_value"   double precision default 0,
+    "exec_fee"     double precision default 0,
+    "fee_currency" text,
+    "exec_time"    bigint
+);
+
+CREATE TABLE IF NOT EXISTS grid_level
+(
+    "user_id"   bigint references users (tg_id),
+    "coin_name" text,
+    "level"     int,
+    "price"     double precision default 0,
+    "side"      text             default '',
+    "qty"       double precision default 0,
+    "buy_price" double precision default 0,
+    "order_id"  text             default '',
+    primary key (user_id, coin_name, level)
+);
+
+ALTER TABLE coin ADD COLUMN IF NOT EXISTS "stop_loss_percent" double precision default 0;
+ALTER TABLE coin ADD COLUMN IF NOT EXISTS "stop_loss_price" double precision default 0;
+ALTER TABLE coin ADD COLUMN IF NOT EXISTS "stopped" boolean default false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "max_drawdown" double precision default 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "peak_equity" double precision default 0;
+
+ALTER TABLE coin ADD COLUMN IF NOT EXISTS "trail_peak" double precision default 0;
+
+CREATE TABLE IF NOT EXISTS coin_settings
+(
+    "user_id"         bigint references users (tg_id),
+    "coin_name"       text,
+    "take_profit"     double precision default 0,
+    "step"            double precision default 0,
+    "order_size"      double precision default 0,
+    "order_size_usdt" double precision default 0,
+    "multiplier"      double precision default 0,
+    "max_depth"       int              default 0,
+    "max_allocation"  double precision default 0,
+    primary key (user_id, coin_name)
+);
+
+ALTER TABLE coin_settings ADD COLUMN IF NOT EXISTS "sizing" text default '';
+ALTER TABLE coin_settings ADD COLUMN IF NOT EXISTS "target_average" double precision default 0;
+ALTER TABLE coin_settings ADD COLUMN IF NOT EXISTS "spacing" text default '';
+ALTER TABLE coin_settings ADD COLUMN IF NOT EXISTS "step_widening" double precision default 0;
+
+ALTER TABLE coin ADD COLUMN IF NOT EXISTS "cost" double precision default 0;
+-- Cost of positions which have been bought before cost was counted is taken by mean of buys.
+UPDATE coin SET cost = count * (SELECT avg(b) FROM unnest(buy) b) WHERE cost = 0 AND count > 0 AND cardinality(buy) > 0;
+
+CREATE TABLE IF NOT EXISTS fills
+(
+    "id"         serial primary key,
+    "user_id"    bigint references users (tg_id),
+    "coin_name"  text,
+    "side"       text,
+    "order_id"   text,
+    "price"      double precision default 0,
+    "qty"        double precision default 0,
+    "fee"        double precision default 0,
+    "fee_coin"   text,
+    "time"       bigint,
+    "created_at" timestamp default now()
+);
+
+ALTER TABLE fills ADD COLUMN IF NOT EXISTS "exec_id" text;
+ALTER TABLE fills ADD COLUMN IF NOT EXISTS "strategy" text default '';
+CREATE UNIQUE INDEX IF NOT EXISTS fills_exec_id ON fills (user_id, exec_id);
+
+CREATE TABLE IF NOT EXISTS orders
+(
+    "id"            serial primary key,
+    "user_id"       bigint references users (tg_id),
+    "coin_name"     text,
+    "order_id"      text,
+    "side"          text,
+    "order_type"    text,
+    "price"         text default '',
+    "qty"           text default '',
+    "status"        text,
+    "reject_reason" text default '',
+    "cum_exec_qty"  text default '',
+    "request"       text,
+    "response"      text,
+    "created_at"    timestamp default now(),
+    "updated_at"    timestamp default now()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS orders_order_id ON orders (user_id, order_id);
+
+CREATE TABLE IF NOT EXISTS order_history
+(
+    "id"            serial primary key,
+    "user_id"       bigint references users (tg_id),
+    "order_id"      text,
+    "action"        text,
+    "status"        text default '',
+    "reject_reason" text default '',
+    "cum_exec_qty"  text default '',
+    "request"       text default '',
+    "response"      text default '',
+    "created_at"    timestamp default now()
+);
+
+-- Sequence of orders starts from time when coin is added, so coin which is added again does
+-- not repeat orderLinkId of deleted one.
+ALTER TABLE coin ADD COLUMN IF NOT EXISTS "order_seq" bigint default extract(epoch from now())::bigint;
+
+-- Coiniks are synced from every exchange's instruments, so coin has one row on each exchange.
+-- Index on coin_name only is of older migration.
+ALTER TABLE coiniks ADD COLUMN IF NOT EXISTS "exchange" text default 'bybit';
+DELETE FROM coiniks a USING coiniks b WHERE a.ctid < b.ctid AND a.exchange = b.exchange AND a.coin_name = b.coin_name;
+DROP INDEX IF EXISTS coiniks_coin_name;
+CREATE UNIQUE INDEX IF NOT EXISTS coiniks_exchange_coin_name ON coiniks (exchange, coin_name);
+ALTER TABLE coiniks ADD COLUMN IF NOT EXISTS "base_precision" double precision default 0;
+ALTER TABLE coiniks ADD COLUMN IF NOT EXISTS "quote_precision" double precision default 0;
+ALTER TABLE coiniks ADD COLUMN IF NOT EXISTS "tick_size" double precision default 0;
+ALTER TABLE coiniks ADD COLUMN IF NOT EXISTS "min_order_qty" double precision default 0;
+ALTER TABLE coiniks ADD COLUMN IF NOT EXISTS "min_order_amt" double precision default 0;
+ALTER TABLE coiniks ADD COLUMN IF NOT EXISTS "max_order_qty" double precision default 0;
+ALTER TABLE coiniks ADD COLUMN IF NOT EXISTS "status" text default 'Trading';
+ALTER TABLE coiniks ADD COLUMN IF NOT EXISTS "updated_at" timestamp default now();
+
+-- Exchange which user's keys belong to.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "exchange" text default 'bybit';
+
+-- Passphrase of user's OKX api key.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "passphrase" text default '';
+
+-- Bybit's environment of user's keys: mainnet, testnet or demo.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS "environment" text default 'mainnet';
@@ -7,10 +7,19 @@ import (
 	"m1pes/internal/config"
 	handler "m1pes/internal/delivery/telegram/bot"
 	"m1pes/internal/logging"
+	"m1pes/internal/models"
+	apiStockRepo "m1pes/internal/repository/api/stocks"
+	"m1pes/internal/repository/api/stocks/binance"
 	"m1pes/internal/repository/api/stocks/bybit"
+	"m1pes/internal/repository/api/stocks/okx"
+	paperExchange "m1pes/internal/repository/api/stocks/paper"
+	"m1pes/internal/repository/api/stocks/sim"
+	paperPostgres "m1pes/internal/repository/storage/paper/postgres"
 	stockPostgres "m1pes/internal/repository/storage/stocks/postgres"
 	userPostgres "m1pes/internal/repository/storage/user/postgres"
 	"m1pes/internal/service/algorithm"
+	"m1pes/internal/service/instruments"
+	"m1pes/internal/service/paper"
 	"m1pes/internal/service/stocks"
 	"m1pes/internal/service/user"
 	"os"
@@ -35,18 +44,65 @@ func New() (*App, error) {
 func (a *App) Start(ctx context.Context) error {
 	// Stock dependencies.
 	storageStock := stockPostgres.New(a.cfg.DBConn)
-	apiStock := bybit.New()
-	stockService := stocks.New(apiStock, storageStock)
+	apiStock := bybit.New(a.cfg.Bybit.URL)
+	apiStock.SetURL(models.EnvironmentTestnet, a.cfg.Bybit.TestnetURL)
+	apiStock.SetURL(models.EnvironmentDemo, a.cfg.Bybit.DemoURL)
+	apiStock.SetRecvWindow(a.cfg.Bybit.RecvWindow)
+
+	// Requests are signed by server's time of their environment, clock is synced again if bybit
+	// rejects timestamp.
+	for _, environment := range apiStock.Environments() {
+		if err := apiStock.SyncTime(bybit.WithEnvironment(ctx, environment)); err != nil {
+			slog.WarnContext(ctx, "Error syncing bybit time", "environment", environment, "err", err)
+		}
+	}
+	apiStream := bybit.NewStream("", "")
+
+	// Paper accounts' calls go to simulated exchange, prices are live or replayed.
+	storagePaper := paperPostgres.New(a.cfg.DBConn)
+	var prices apiStockRepo.StreamRepository = apiStream
+	if a.cfg.Paper.ReplayDir != "" {
+		step := a.cfg.Paper.ReplayStep
+		if step <= 0 {
+			step = paperExchange.DefaultReplayStep
+		}
+		prices = sim.NewReplayStream(a.cfg.Paper.ReplayDir, step)
+	}
+
+	exchange := paperExchange.New(apiStock, apiStream, prices, storagePaper, a.cfg.Paper)
+	if err := exchange.Load(ctx); err != nil {
+		return err
+	}
+
+	// Requests of okx are signed by its server's time too.
+	okxStock := okx.New(a.cfg.OKX.URL)
+	if err := okxStock.SyncTime(ctx); err != nil {
+		slog.WarnContext(ctx, "Error syncing okx time", "err", err)
+	}
+
+	// Users' calls go to exchange which their keys belong to, paper accounts stay on bybit's
+	// simulated exchange.
+	router := apiStockRepo.NewRouter(bybit.NewExchange(exchange, apiStock), map[string]apiStockRepo.Exchange{
+		models.ExchangeBinance: binance.New(a.cfg.Binance.URL),
+		models.ExchangeOKX:     okxStock,
+	}, exchange)
+
+	stockService := stocks.New(router, storageStock)
+
+	// Coiniks are kept up to date with instruments of every exchange.
+	instrumentsService := instruments.New(router.Exchanges(), storageStock)
+	go instrumentsService.Run(ctx, a.cfg.Instruments.SyncInterval)
 
 	// User dependencies.
 	storageUser := userPostgres.New(a.cfg.DBConn)
 	userService := user.New(storageUser)
+	paperService := paper.New(exchange, storageUser)
 
 	// Algorithm dependencies.
-	algoService := algorithm.New(apiStock, storageStock, storageUser)
+	algoService := algorithm.New(router, exchange, storageStock, storageUser, a.cfg.Engine)
 
 	// Init handler.
-	h := handler.New(stockService, userService, algoService, a.bot)
+	h := handler.New(stockService, userService, algoService, paperService, a.bot)
 
 	go func() {
 		if err := a.RunTelegramBot(ctx, h); err != nil {
@@ -62,6 +118,7 @@ func (a *App) Start(ctx context.Context) error {
 
 	storageUser.Conn.Close()
 	storageStock.Conn.Close()
+	storagePaper.Conn.Close()
 
 	return nil
 }
@@ -6,11 +6,20 @@ import (
 	"gopkg.in/yaml.v3"
 	"log"
 	"os"
+	"time"
 )
 
 type Config struct {
 	Bot    BotConfig    `yaml:"bot"`
 	DBConn DBConnConfig `yaml:"db-conn"`
+	Engine EngineConfig `yaml:"engine"`
+	Paper  PaperConfig  `yaml:"paper"`
+	Bybit  BybitConfig  `yaml:"bybit"`
+	// Binance is exchange of users whose keys are binance's.
+	Binance BinanceConfig `yaml:"binance"`
+	OKX     OKXConfig     `yaml:"okx"`
+
+	Instruments InstrumentsConfig `yaml:"instruments"`
 }
 
 type BotConfig struct {
@@ -25,6 +34,48 @@ type DBConnConfig struct {
 	Database string `yaml:"database"`
 }
 
+// EngineConfig bounds how often and how many coins are handled at the same time.
+type EngineConfig struct {
+	MinInterval    time.Duration `yaml:"min-interval"`
+	ResyncInterval time.Duration `yaml:"resync-interval"`
+	MaxConcurrent  int           `yaml:"max-concurrent"`
+}
+
+// BybitConfig sets where Bybit api is, by default it is mainnet. Users' requests of testnet
+// and demo trading are sent to TestnetURL and DemoURL, by default they are Bybit's. RecvWindow
+// is how long after its timestamp request is valid, by default it is 5s.
+type BybitConfig struct {
+	URL        string        `yaml:"url"`
+	TestnetURL string        `yaml:"testnet-url"`
+	DemoURL    string        `yaml:"demo-url"`
+	RecvWindow time.Duration `yaml:"recv-window"`
+}
+
+// BinanceConfig sets where Binance api is, by default it is mainnet.
+type BinanceConfig struct {
+	URL string `yaml:"url"`
+}
+
+// OKXConfig sets where OKX api is, by default it is mainnet.
+type OKXConfig struct {
+	URL string `yaml:"url"`
+}
+
+// InstrumentsConfig sets how often coiniks are synced from exchange's instruments.
+type InstrumentsConfig struct {
+	SyncInterval time.Duration `yaml:"sync-interval"`
+}
+
+// PaperConfig sets up simulated exchange of paper accounts. If ReplayDir is set, prices
+// are replayed from <ReplayDir>/<symbol>.csv candles for all users instead of live tickers.
+type PaperConfig struct {
+	InitialBalance float64       `yaml:"initial-balance"`
+	MakerFee       float64       `yaml:"maker-fee"`
+	TakerFee       float64       `yaml:"taker-fee"`
+	ReplayDir      string        `yaml:"replay-dir"`
+	ReplayStep     time.Duration `yaml:"replay-step"`
+}
+
 func InitConfig() (*Config, error) {
 	config := &Config{}
 
@@ -2,10 +2,13 @@ package bot
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
+	"math"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -18,17 +21,22 @@ import (
 
 type (
 	StockService interface {
-		GetCoinFromStockExchange(ctx context.Context, coinReq models.GetCoinRequest, apiKey, secretKey string) (models.GetCoinResponse, error)
+		GetCoinPrice(ctx context.Context, creds models.Credentials, coinName string) (float64, error)
 		DeleteCoin(ctx context.Context, coinTag string, userId int64) error
 		GetCoinList(ctx context.Context, userId int64) ([]models.Coin, error)
-		ExistCoin(ctx context.Context, coinTag string) (bool, error)
+		ExistCoin(ctx context.Context, exchange, coinTag string) (bool, error)
 		AddCoin(coin models.Coin) error
 		InsertIncome(userID int64, coinTag string, income, count float64) error
-		GetCoiniks(ctx context.Context, coinTag string) (models.Coiniks, error)
+		GetCoiniks(ctx context.Context, exchange, coinTag string) (models.Coiniks, error)
 		EditBuy(ctx context.Context, userId int64, buy bool) error
-		CreateOrder(apiKey, apiSecret string, order models.OrderCreate) (string, error)
-		GetUserWalletBalance(ctx context.Context, apiKey, apiSecret string) (float64, error)
-		GetApiKeyPermissions(ctx context.Context, apiKey, apiSecret string) (models.GetApiKeyPermissionsResponse, error)
+		CreateOrder(creds models.Credentials, order models.OrderCreate) (string, error)
+		GetUserWalletBalance(ctx context.Context, creds models.Credentials) (float64, error)
+		GetApiKeyPermissions(ctx context.Context, creds models.Credentials) (models.KeyPermissions, error)
+		SetGrid(ctx context.Context, userId int64, coinName string, params models.GridParams) error
+		SetStopLoss(ctx context.Context, userId int64, coinName string, percent, price float64) error
+		SetTrailing(ctx context.Context, userId int64, coinName string, callback float64) error
+		GetCoinSettings(ctx context.Context, userId int64, coinName string) (models.CoinSettings, error)
+		SetCoinSettings(ctx context.Context, settings models.CoinSettings) error
 	}
 
 	UserService interface {
@@ -37,12 +45,21 @@ type (
 		NewUser(ctx context.Context, user models.User) error
 		GetUser(ctx context.Context, userId int64) (models.User, error)
 		GetIncomeLastDay(ctx context.Context, userID int64) (float64, error)
+		SetMaxDrawdown(ctx context.Context, userId int64, maxDrawdown float64) error
+		ResetPeakEquity(ctx context.Context, userId int64) error
 	}
 
 	AlgorithmService interface {
 		StartTrading(ctx context.Context, userId int64, actionChanMap map[int64]chan models.Message) error
 		StopTrading(ctx context.Context, userID int64) error
 		DeleteCoin(ctx context.Context, userId int64, coin string) error
+		Reconcile(ctx context.Context, userId int64) ([]models.Correction, error)
+	}
+
+	PaperService interface {
+		EnablePaper(ctx context.Context, userId int64) error
+		DisablePaper(ctx context.Context, userId int64) error
+		IsPaper(ctx context.Context, userId int64) (bool, error)
 	}
 )
 
@@ -50,20 +67,28 @@ type Handler struct {
 	as            AlgorithmService
 	ss            StockService
 	us            UserService
+	ps            PaperService
 	actionChanMap map[int64]chan models.Message
 }
 
 const (
 	SellAction = "sell"
 	BuyAction  = "buy"
+	StopAction = "stop"
+	// StopFailedAction is sent when market sell of stopped coin fails, it is tried again.
+	StopFailedAction = "stopFailed"
+	// ApiKeyAction, BalanceAction and InvalidOrderAction are errors of exchange which user has to fix.
+	ApiKeyAction       = "apiKey"
+	BalanceAction      = "balance"
+	InvalidOrderAction = "invalidOrder"
 
 	ReportErrorChatId = -4216803774 // TG id of chat where bot sends errors.
 )
 
-func New(ss StockService, us UserService, as AlgorithmService, b *tgbotapi.BotAPI) *Handler {
+func New(ss StockService, us UserService, as AlgorithmService, ps PaperService, b *tgbotapi.BotAPI) *Handler {
 	ctx := context.Background()
 
-	h := &Handler{ss: ss, us: us, as: as, actionChanMap: make(map[int64]chan models.Message)}
+	h := &Handler{ss: ss, us: us, as: as, ps: ps, actionChanMap: make(map[int64]chan models.Message)}
 
 	users, err := h.us.GetAllUsers(ctx)
 	if err != nil {
@@ -72,6 +97,10 @@ func New(ss StockService, us UserService, as AlgorithmService, b *tgbotapi.BotAP
 
 	// Starting trading for all users which have tradingActivated as true.
 	for _, user := range users {
+		// Something could happen with orders while bot was stopped, coins of user who has not
+		// started trading are fixed too, they are handled by StartTrading later.
+		h.Reconcile(ctx, b, user.Id)
+
 		if !user.TradingActivated {
 			continue
 		}
@@ -90,6 +119,38 @@ func New(ss StockService, us UserService, as AlgorithmService, b *tgbotapi.BotAP
 	return h
 }
 
+// Reconcile fixes user's coins by exchange and sends summary of corrections to user and to chat with errors.
+func (h *Handler) Reconcile(ctx context.Context, b *tgbotapi.BotAPI, userId int64) {
+	ctx = logging.WithUserId(ctx, userId)
+
+	corrections, err := h.as.Reconcile(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in Reconcile", "err", err)
+		return
+	}
+
+	if len(corrections) == 0 {
+		return
+	}
+
+	text := "Сверка с биржей после перезапуска бота:\n"
+	for _, correction := range corrections {
+		text += fmt.Sprintf("\n%s: %s", correction.Coin, correction.Description)
+	}
+
+	for _, chatId := range []int64{userId, ReportErrorChatId} {
+		msg := tgbotapi.NewMessage(chatId, text)
+		if chatId == ReportErrorChatId {
+			msg.Text = fmt.Sprintf("user: %d\n%s", userId, text)
+		}
+
+		_, err = b.Send(msg)
+		if err != nil {
+			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in SendMessage", "err", err)
+		}
+	}
+}
+
 // Handlers with Cmd at the end need for setting new status for user, after them should be normal handler.
 
 func (h *Handler) Start(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
@@ -137,6 +198,12 @@ func (h *Handler) StartBuy(ctx context.Context, b *tgbotapi.BotAPI, update *tgbo
 		log.Println(err)
 	}
 
+	// Drawdown is counted again from current equity.
+	err = h.us.ResetPeakEquity(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in ResetPeakEquity", "err", err)
+	}
+
 	msg := tgbotapi.NewMessage(userId, "Торговля на монетах возобновилась")
 	_, err = b.Send(msg)
 	if err != nil {
@@ -209,7 +276,7 @@ func (h *Handler) StartTrading(ctx context.Context, b *tgbotapi.BotAPI, update *
 
 			var text string
 			var chatId int64
-			coiniks, err := h.ss.GetCoiniks(ctx, msg.Coin.Name)
+			coiniks, err := h.ss.GetCoiniks(ctx, funcUser.Credentials().Exchange, msg.Coin.Name)
 			if err != nil {
 				slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetCoiniks", err)
 				msg.Action = err.Error()
@@ -232,6 +299,34 @@ func (h *Handler) StartTrading(ctx context.Context, b *tgbotapi.BotAPI, update *
 
 				text = "ПОКУПКА\n" + def
 				chatId = msg.User.Id
+			case StopAction:
+				reason := "сработал стоп-лосс"
+				if msg.Reason == models.StopReasonDrawdown {
+					reason = "просадка депозита больше допустимой"
+				}
+
+				text = fmt.Sprintf("СТОП\nМонета: %s\nПричина: %s", msg.Coin.Name, reason)
+				if msg.Coin.Count > 0 {
+					a := trimTrailingZeros(fmt.Sprintf("%f", msg.Coin.CurrentPrice))
+					d := trimTrailingZeros(fmt.Sprintf("%."+strconv.Itoa(coiniks.QtyDecimals)+"f", msg.Coin.Count))
+					c := trimTrailingZeros(fmt.Sprintf("%.5f", msg.Coin.Income))
+
+					text += fmt.Sprintf("\nПродано по рынку: %s по цене %s\nРезультат: %s 💲", d, a, c)
+				}
+				text += "\nПокупки по монете остановлены, возобновить - /startBuy"
+				chatId = msg.User.Id
+			case StopFailedAction:
+				text = fmt.Sprintf("СТОП НЕ ВЫПОЛНЕН\nМонета: %s\nНе удалось продать монету по рынку: %s\nБот повторит продажу при следующей проверке монеты", msg.Coin.Name, msg.Reason)
+				chatId = msg.User.Id
+			case ApiKeyAction:
+				text = fmt.Sprintf("ОШИБКА API КЛЮЧА\nМонета: %s\nБиржа отклонила запрос: %s\nПроверь ключ и его права, бот продолжит торговлю после исправления", msg.Coin.Name, msg.Reason)
+				chatId = msg.User.Id
+			case BalanceAction:
+				text = fmt.Sprintf("НЕДОСТАТОЧНО СРЕДСТВ\nМонета: %s\nБиржа отклонила ордер: %s\nБот повторит ордер, когда средств станет достаточно", msg.Coin.Name, msg.Reason)
+				chatId = msg.User.Id
+			case InvalidOrderAction:
+				text = fmt.Sprintf("ОРДЕР ОТКЛОНЁН\nМонета: %s\nБиржа отклонила ордер: %s\nМонета остановлена, исправьте её настройки через /settings или возобновите покупки через /startBuy, либо удалите её", msg.Coin.Name, msg.Reason)
+				chatId = msg.User.Id
 			default:
 				text = fmt.Sprintf("Ошибка: %s \nfile: %s line: %d", msg.Action, msg.File, msg.Line)
 				chatId = ReportErrorChatId
@@ -274,7 +369,7 @@ func (h *Handler) StopTrading(ctx context.Context, b *tgbotapi.BotAPI, update *t
 func (h *Handler) ChangeApiAndSecretKeyCmd(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
 	ctx = logging.WithUserId(ctx, update.Message.Chat.ID)
 
-	botMsg := tgbotapi.NewMessage(update.Message.From.ID, "Введите ваш api и secret ключи через пробел.\nВАЖНО: у api ключа обязательно должны быть разрешения на: запись и чтение, торговлю на спотовом рынке и вывод средств для сбора комиссии.")
+	botMsg := tgbotapi.NewMessage(update.Message.From.ID, "Введите ваш api и secret ключи через пробел. Если ключи от Binance, добавьте через пробел binance, если от OKX - okx и passphrase ключа, по умолчанию ключи от Bybit. Для ключей тестнета Bybit добавьте bybit testnet, для демо-торговли - bybit demo.\nВАЖНО: у api ключа обязательно должны быть разрешения на: запись и чтение, торговлю на спотовом рынке и вывод средств для сбора комиссии.")
 	_, err := b.Send(botMsg)
 	if err != nil {
 		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in SendMessage", err)
@@ -289,34 +384,65 @@ func (h *Handler) ChangeApiAndSecretKeyCmd(ctx context.Context, b *tgbotapi.BotA
 	}
 }
 
-func (h *Handler) ChangeApiAndSecretKey(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
-	ctx = logging.WithUserId(ctx, update.Message.Chat.ID)
+// parseCredentials returns keys of message "apiKey secretKey [exchange [passphrase|environment]]",
+// exchange is bybit on mainnet if it is not set. Only okx's keys have passphrase and only bybit's
+// keys can be of testnet or demo trading.
+func parseCredentials(words []string) (models.Credentials, bool) {
+	if len(words) < 2 || len(words) > 4 {
+		return models.Credentials{}, false
+	}
 
-	keys := strings.Split(update.Message.Text, " ")
+	creds := models.Credentials{Exchange: models.ExchangeBybit, Environment: models.EnvironmentMainnet, ApiKey: words[0], SecretKey: words[1]}
+	if len(words) > 2 {
+		creds.Exchange = strings.ToLower(words[2])
+	}
 
-	perm, err := h.ss.GetApiKeyPermissions(ctx, keys[0], keys[1])
-	if err != nil {
-		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in NewApiAndSecretKey", err)
+	switch creds.Exchange {
+	case models.ExchangeBybit:
+		if len(words) > 3 {
+			creds.Environment = strings.ToLower(words[3])
+		}
+		switch creds.Environment {
+		case models.EnvironmentMainnet, models.EnvironmentTestnet, models.EnvironmentDemo:
+			return creds, true
+		default:
+			return models.Credentials{}, false
+		}
+	case models.ExchangeBinance:
+		return creds, len(words) < 4
+	case models.ExchangeOKX:
+		if len(words) > 3 {
+			creds.Passphrase = words[3]
+		}
+		return creds, creds.Passphrase != ""
+	default:
+		return models.Credentials{}, false
 	}
+}
 
-	// Checking permissions.
+func (h *Handler) ChangeApiAndSecretKey(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	ctx = logging.WithUserId(ctx, update.Message.Chat.ID)
+
+	keys := strings.Fields(update.Message.Text)
 
-	var isAllowed int
-	for _, permission := range perm.Result.Permissions.Wallet {
-		if permission == "Withdraw" {
-			isAllowed++
-			break
+	creds, ok := parseCredentials(keys)
+	if !ok {
+		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный формат, введите api и secret ключи через пробел, для Binance добавьте binance, для OKX - okx и passphrase, для тестнета Bybit - bybit testnet, для демо-торговли - bybit demo.")
+		_, err := b.Send(msg)
+		if err != nil {
+			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in sending message", "err", err)
 		}
+		return
 	}
 
-	for _, permission := range perm.Result.Permissions.Spot {
-		if permission == "SpotTrade" {
-			isAllowed++
-			break
-		}
+	perm, err := h.ss.GetApiKeyPermissions(ctx, creds)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in NewApiAndSecretKey", "err", err)
 	}
 
-	if isAllowed != 2 || perm.Result.ReadOnly != 0 {
+	// Checking permissions.
+
+	if !perm.Withdraw || !perm.SpotTrade || perm.ReadOnly {
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "В указанном api ключе отсутствуют некоторые разрешения.")
 		_, err = b.Send(msg)
 		if err != nil {
@@ -328,15 +454,31 @@ func (h *Handler) ChangeApiAndSecretKey(ctx context.Context, b *tgbotapi.BotAPI,
 
 	// If everything ok:
 
+	paper, err := h.ps.IsPaper(ctx, update.Message.From.ID)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in IsPaper", "err", err)
+	}
+
 	updateUser := models.NewUser(update.Message.From.ID)
-	updateUser.ApiKey = keys[0]
-	updateUser.SecretKey = keys[1]
+	updateUser.ApiKey = creds.ApiKey
+	updateUser.SecretKey = creds.SecretKey
+	updateUser.Passphrase = creds.Passphrase
+	updateUser.Exchange = creds.Exchange
+	updateUser.Environment = creds.Environment
 
 	err = h.us.UpdateUser(ctx, updateUser)
 	if err != nil {
 		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateUser", err)
 	}
 
+	// Paper account stays on simulated exchange with new keys until /live.
+	if paper {
+		err = h.ps.EnablePaper(ctx, update.Message.From.ID)
+		if err != nil {
+			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in EnablePaper", "err", err)
+		}
+	}
+
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Вы успешно изменили свои ключи ;)")
 	_, err = b.Send(msg)
 	if err != nil {
@@ -344,6 +486,558 @@ func (h *Handler) ChangeApiAndSecretKey(ctx context.Context, b *tgbotapi.BotAPI,
 	}
 }
 
+// Paper moves user's account to simulated exchange with virtual balance.
+func (h *Handler) Paper(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	if !h.tradingStopped(ctx, b, userId) {
+		return
+	}
+
+	err := h.ps.EnablePaper(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in EnablePaper", "err", err)
+		h.send(ctx, b, userId, "Не удалось включить бумажную торговлю, попробуйте позже")
+		return
+	}
+
+	user, err := h.us.GetUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetUser", "err", err)
+	}
+
+	balance, err := h.ss.GetUserWalletBalance(ctx, user.Credentials())
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetUserWalletBalance", "err", err)
+	}
+
+	text := fmt.Sprintf("Включена бумажная торговля: сделки идут на симуляции биржи по реальным ценам.\nВиртуальный баланс: %s 💲\nВернуться на биржу - /live", trimTrailingZeros(fmt.Sprintf("%.2f", balance)))
+	h.send(ctx, b, userId, text)
+}
+
+// Live moves user's account back to real exchange.
+func (h *Handler) Live(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	if !h.tradingStopped(ctx, b, userId) {
+		return
+	}
+
+	err := h.ps.DisablePaper(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in DisablePaper", "err", err)
+		h.send(ctx, b, userId, "Не удалось выключить бумажную торговлю, попробуйте позже")
+		return
+	}
+
+	user, err := h.us.GetUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetUser", "err", err)
+	}
+
+	text := "Бумажная торговля выключена, сделки идут на бирже."
+	if models.IsPaperApiKey(user.ApiKey) {
+		text += "\nУ вас нет api ключей биржи, чтобы добавить их - /changeKeys"
+	}
+	h.send(ctx, b, userId, text)
+}
+
+// tradingStopped checks that user's trading is stopped, coins of running trading can't
+// be moved between exchanges and can't change their strategy.
+func (h *Handler) tradingStopped(ctx context.Context, b *tgbotapi.BotAPI, userId int64) bool {
+	user, err := h.us.GetUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetUser", "err", err)
+		return false
+	}
+
+	if user.TradingActivated {
+		h.send(ctx, b, userId, "Сначала остановите торговлю - /stopTrading")
+		return false
+	}
+	return true
+}
+
+func (h *Handler) send(ctx context.Context, b *tgbotapi.BotAPI, chatId int64, text string) {
+	msg := tgbotapi.NewMessage(chatId, text)
+	_, err := b.Send(msg)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in SendMessage", "err", err)
+	}
+}
+
+func (h *Handler) GridCmd(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	if !h.tradingStopped(ctx, b, userId) {
+		return
+	}
+
+	user := models.NewUser(userId)
+	user.Status = "grid"
+
+	err := h.us.UpdateUser(ctx, user)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateStatus", "err", err)
+	}
+
+	h.send(ctx, b, userId, "Введите через пробел: монету, нижнюю и верхнюю границу сетки, количество уровней и сумму в USDT.\nНапример: BTCUSDT 60000 70000 10 500")
+}
+
+// Grid makes coin traded by grid: buys are placed on levels below price and sells on levels above it.
+func (h *Handler) Grid(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) != 5 {
+		h.send(ctx, b, userId, "Нужно 5 значений через пробел, например: BTCUSDT 60000 70000 10 500. Попробуйте ещё раз - /grid")
+		return
+	}
+
+	coinName := strings.ToUpper(fields[0])
+
+	var params models.GridParams
+	var errs [4]error
+	params.Lower, errs[0] = strconv.ParseFloat(fields[1], 64)
+	params.Upper, errs[1] = strconv.ParseFloat(fields[2], 64)
+	params.Levels, errs[2] = strconv.Atoi(fields[3])
+	params.Investment, errs[3] = strconv.ParseFloat(fields[4], 64)
+	if errors.Join(errs[:]...) != nil {
+		h.send(ctx, b, userId, "Границы, количество уровней и сумма должны быть числами. Попробуйте ещё раз - /grid")
+		return
+	}
+
+	switch {
+	case params.Lower <= 0 || params.Upper <= params.Lower:
+		h.send(ctx, b, userId, "Верхняя граница должна быть больше нижней. Попробуйте ещё раз - /grid")
+		return
+	case params.Levels < 2 || params.Levels > 50:
+		h.send(ctx, b, userId, "Уровней может быть от 2 до 50. Попробуйте ещё раз - /grid")
+		return
+	case params.Investment <= 0:
+		h.send(ctx, b, userId, "Сумма должна быть больше нуля. Попробуйте ещё раз - /grid")
+		return
+	}
+
+	user, err := h.us.GetUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetUser", "err", err)
+	}
+
+	can, err := h.ss.ExistCoin(ctx, user.Credentials().Exchange, coinName)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in ExistCoin", "err", err)
+	}
+	if !can {
+		h.send(ctx, b, userId, "Такой монеты не существует попробуйте ещё раз - /grid")
+		return
+	}
+
+	coiniks, err := h.ss.GetCoiniks(ctx, user.Credentials().Exchange, coinName)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetCoiniks", "err", err)
+		h.send(ctx, b, userId, "Не удалось настроить сетку, попробуйте позже")
+		return
+	}
+
+	// The smallest order of grid is buy on the upper level.
+	if params.Qty(params.Levels-2) < coiniks.MinSumBuy*1.1 {
+		h.send(ctx, b, userId, "Сумма на один уровень меньше минимального ордера биржи, увеличьте сумму или уменьшите количество уровней - /grid")
+		return
+	}
+
+	list, err := h.ss.GetCoinList(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetCoinList", "err", err)
+	}
+	if len(list) >= 5 && !slices.ContainsFunc(list, func(coin models.Coin) bool { return coin.Name == coinName }) {
+		h.send(ctx, b, userId, "У вас уже 5 монет, если хотите добавить новую - удалить старую /delete")
+		return
+	}
+
+	err = h.ss.SetGrid(ctx, userId, coinName, params)
+	if errors.Is(err, models.ErrCoinIsTraded) {
+		h.send(ctx, b, userId, "По этой монете уже есть покупки или ордера, сначала удалите её - /delete")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in SetGrid", "err", err)
+		h.send(ctx, b, userId, "Не удалось настроить сетку, попробуйте позже")
+		return
+	}
+
+	text := fmt.Sprintf("Сетка по %s настроена: %d уровней от %s до %s, на уровень %s USDT.\nЗапустите торговлю - /startTrading",
+		coinName,
+		params.Levels,
+		trimTrailingZeros(fmt.Sprintf("%f", params.Lower)),
+		trimTrailingZeros(fmt.Sprintf("%f", params.Upper)),
+		trimTrailingZeros(fmt.Sprintf("%.2f", params.Investment/float64(params.Levels-1))),
+	)
+	h.send(ctx, b, userId, text)
+}
+
+func (h *Handler) StopLossCmd(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	user := models.NewUser(userId)
+	user.Status = "stopLoss"
+
+	err := h.us.UpdateUser(ctx, user)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateStatus", "err", err)
+	}
+
+	h.send(ctx, b, userId, "Введите монету и стоп-лосс: процент ниже средней цены покупки или цену.\nНапример: BTCUSDT 5% или BTCUSDT 58000. Чтобы выключить - BTCUSDT 0")
+}
+
+// StopLoss sets stop loss of coin, position is sold at market when price reaches it.
+func (h *Handler) StopLoss(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) != 2 {
+		h.send(ctx, b, userId, "Нужно 2 значения через пробел, например: BTCUSDT 5%. Попробуйте ещё раз - /stopLoss")
+		return
+	}
+
+	coinName := strings.ToUpper(fields[0])
+	isPercent := strings.HasSuffix(fields[1], "%")
+
+	value, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "%"), 64)
+	if err != nil || value < 0 || (isPercent && value >= 100) {
+		h.send(ctx, b, userId, "Стоп-лосс должен быть процентом меньше 100 или ценой. Попробуйте ещё раз - /stopLoss")
+		return
+	}
+
+	var percent, price float64
+	if isPercent {
+		percent = value / 100
+	} else {
+		price = value
+	}
+
+	err = h.ss.SetStopLoss(ctx, userId, coinName, percent, price)
+	if errors.Is(err, models.ErrCoinNotFound) {
+		h.send(ctx, b, userId, "У вас нет такой монеты, список монет - /coin")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in SetStopLoss", "err", err)
+		h.send(ctx, b, userId, "Не удалось установить стоп-лосс, попробуйте позже")
+		return
+	}
+
+	switch {
+	case percent > 0:
+		h.send(ctx, b, userId, fmt.Sprintf("Стоп-лосс по %s: %s%% ниже средней цены покупки", coinName, trimTrailingZeros(fmt.Sprintf("%f", value))))
+	case price > 0:
+		h.send(ctx, b, userId, fmt.Sprintf("Стоп-лосс по %s: цена %s", coinName, trimTrailingZeros(fmt.Sprintf("%f", value))))
+	default:
+		h.send(ctx, b, userId, fmt.Sprintf("Стоп-лосс по %s выключен", coinName))
+	}
+}
+
+func (h *Handler) TrailingCmd(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	user := models.NewUser(userId)
+	user.Status = "trailing"
+
+	err := h.us.UpdateUser(ctx, user)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateStatus", "err", err)
+	}
+
+	h.send(ctx, b, userId, "Введите монету и откат от максимума цены в процентах, при котором позиция продаётся.\nНапример: BTCUSDT 1%. Чтобы продавать по лимитному ордеру - BTCUSDT 0")
+}
+
+// Trailing sets trailing take profit of coin: after price rises above take profit its peak
+// is tracked and position is sold at market when price falls back by callback.
+func (h *Handler) Trailing(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) != 2 {
+		h.send(ctx, b, userId, "Нужно 2 значения через пробел, например: BTCUSDT 1%. Попробуйте ещё раз - /trailing")
+		return
+	}
+
+	coinName := strings.ToUpper(fields[0])
+
+	value, err := strconv.ParseFloat(strings.TrimSuffix(fields[1], "%"), 64)
+	if err != nil || value < 0 || value >= 100 {
+		h.send(ctx, b, userId, "Откат должен быть числом от 0 до 100. Попробуйте ещё раз - /trailing")
+		return
+	}
+
+	err = h.ss.SetTrailing(ctx, userId, coinName, value/100)
+	switch {
+	case errors.Is(err, models.ErrCoinNotFound):
+		h.send(ctx, b, userId, "У вас нет такой монеты, список монет - /coin")
+		return
+	case errors.Is(err, models.ErrWrongStrategy):
+		h.send(ctx, b, userId, "Трейлинг работает только для монет без сетки")
+		return
+	case err != nil:
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in SetTrailing", "err", err)
+		h.send(ctx, b, userId, "Не удалось установить трейлинг, попробуйте позже")
+		return
+	}
+
+	if value == 0 {
+		h.send(ctx, b, userId, fmt.Sprintf("Трейлинг по %s выключен, позиция продаётся по лимитному ордеру", coinName))
+		return
+	}
+	h.send(ctx, b, userId, fmt.Sprintf("Трейлинг по %s: продажа при откате на %s%% от максимума цены", coinName, trimTrailingZeros(fmt.Sprintf("%f", value))))
+}
+
+func (h *Handler) SettingsCmd(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	user := models.NewUser(userId)
+	user.Status = "settings"
+
+	err := h.us.UpdateUser(ctx, user)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateStatus", "err", err)
+	}
+
+	h.send(ctx, b, userId, "Введите монету, чтобы посмотреть её настройки, например: BTCUSDT\n"+
+		"Или монету, настройку и значение, например: BTCUSDT tp 1.5\n"+
+		"tp - тейк-профит в процентах от средней цены покупки\n"+
+		"step - шаг между покупками в процентах\n"+
+		"size - первый ордер: 2% - процент депозита, 50 - сумма в USDT\n"+
+		"spacing - шаг: fixed - от цены входа, percent - от цены прошлой покупки, widening - каждый шаг шире\n"+
+		"widen - на сколько процентов первого шага расширяется каждый следующий\n"+
+		"sizing - размер покупок: flat - одинаковый, linear - растёт на одну величину, geometric - растёт в mult раз, target - тянет среднюю цену к target\n"+
+		"mult - во сколько раз вторая покупка больше первой\n"+
+		"target - на сколько процентов выше новой покупки будет средняя цена для sizing target\n"+
+		"depth - максимальное количество покупок\n"+
+		"alloc - максимальная доля депозита в монете в процентах\n"+
+		"Значение 0 возвращает настройку по умолчанию")
+}
+
+// Settings shows coin's settings or changes one of them.
+func (h *Handler) Settings(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) != 1 && len(fields) != 3 {
+		h.send(ctx, b, userId, "Нужна монета или монета, настройка и значение, например: BTCUSDT tp 1.5. Попробуйте ещё раз - /settings")
+		return
+	}
+
+	coinName := strings.ToUpper(fields[0])
+
+	settings, err := h.ss.GetCoinSettings(ctx, userId, coinName)
+	if errors.Is(err, models.ErrCoinNotFound) {
+		h.send(ctx, b, userId, "У вас нет такой монеты, список монет - /coin")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetCoinSettings", "err", err)
+		h.send(ctx, b, userId, "Не удалось получить настройки, попробуйте позже")
+		return
+	}
+
+	if len(fields) == 3 {
+		err = setCoinSetting(&settings, strings.ToLower(fields[1]), fields[2])
+		if err != nil {
+			h.send(ctx, b, userId, err.Error()+". Попробуйте ещё раз - /settings")
+			return
+		}
+
+		err = h.ss.SetCoinSettings(ctx, settings)
+		if err != nil {
+			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in SetCoinSettings", "err", err)
+			h.send(ctx, b, userId, "Не удалось сохранить настройки, попробуйте позже")
+			return
+		}
+	}
+
+	user, err := h.us.GetUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in GetUser", "err", err)
+		h.send(ctx, b, userId, "Не удалось получить настройки, попробуйте позже")
+		return
+	}
+
+	h.send(ctx, b, userId, coinSettingsText(settings, settings.WithDefaults(user)))
+}
+
+// setCoinSetting parses value of setting, error is message for user.
+func setCoinSetting(settings *models.CoinSettings, name, value string) error {
+	switch name {
+	case "sizing":
+		value = strings.ToLower(value)
+		if !slices.Contains([]string{"0", models.SizingFlat, models.SizingLinear, models.SizingGeometric, models.SizingTarget}, value) {
+			return fmt.Errorf("Размер покупок может быть: flat, linear, geometric, target")
+		}
+		settings.Sizing = strings.TrimPrefix(value, "0")
+		return nil
+	case "spacing":
+		value = strings.ToLower(value)
+		if !slices.Contains([]string{"0", models.SpacingFixed, models.SpacingPercent, models.SpacingWidening}, value) {
+			return fmt.Errorf("Шаг может быть: fixed, percent, widening")
+		}
+		settings.Spacing = strings.TrimPrefix(value, "0")
+		return nil
+	}
+
+	isPercent := strings.HasSuffix(value, "%")
+
+	number, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || number < 0 {
+		return fmt.Errorf("Значение должно быть неотрицательным числом")
+	}
+
+	switch name {
+	case "tp", "step", "target":
+		if number >= 100 {
+			return fmt.Errorf("Процент должен быть меньше 100")
+		}
+
+		switch name {
+		case "tp":
+			settings.TakeProfit = number / 100
+		case "step":
+			settings.Step = number / 100
+		default:
+			if number == 0 {
+				return fmt.Errorf("Целевая средняя должна быть больше 0")
+			}
+			settings.TargetAverage = number / 100
+		}
+	case "widen":
+		settings.StepWidening = number / 100
+	case "alloc":
+		if number > 100 {
+			return fmt.Errorf("Доля не может быть больше 100%%")
+		}
+		settings.MaxAllocation = number / 100
+	case "size":
+		settings.OrderSize, settings.OrderSizeUSDT = 0, 0
+		if isPercent {
+			if number >= 100 {
+				return fmt.Errorf("Процент должен быть меньше 100")
+			}
+			settings.OrderSize = number / 100
+		} else {
+			settings.OrderSizeUSDT = number
+		}
+	case "mult":
+		if number != 0 && number < 1 {
+			return fmt.Errorf("Множитель не может быть меньше 1")
+		}
+		settings.Multiplier = number
+	case "depth":
+		if number != math.Trunc(number) {
+			return fmt.Errorf("Количество покупок должно быть целым")
+		}
+		settings.MaxDepth = int(number)
+	default:
+		return fmt.Errorf("Неизвестная настройка %s, есть: tp, step, spacing, widen, size, sizing, mult, target, depth, alloc", name)
+	}
+
+	return nil
+}
+
+// coinSettingsText lists coin's settings, inherited ones are marked.
+func coinSettingsText(stored, settings models.CoinSettings) string {
+	inherited := func(value float64) string {
+		if value == 0 {
+			return " (по умолчанию)"
+		}
+		return ""
+	}
+	percent := func(value float64) string {
+		return trimTrailingZeros(fmt.Sprintf("%f", value*100)) + "%"
+	}
+
+	size := percent(settings.OrderSize) + " депозита"
+	if settings.OrderSizeUSDT > 0 {
+		size = trimTrailingZeros(fmt.Sprintf("%f", settings.OrderSizeUSDT)) + " USDT"
+	}
+
+	depth := "без ограничений"
+	if settings.MaxDepth > 0 {
+		depth = strconv.Itoa(settings.MaxDepth)
+	}
+
+	return fmt.Sprintf("Настройки %s:\n", settings.CoinName) +
+		fmt.Sprintf("Тейк-профит: %s%s\n", percent(settings.TakeProfit), inherited(stored.TakeProfit)) +
+		fmt.Sprintf("Шаг: %s%s\n", percent(settings.Step), inherited(stored.Step)) +
+		fmt.Sprintf("Первый ордер: %s%s\n", size, inherited(stored.OrderSize+stored.OrderSizeUSDT)) +
+		fmt.Sprintf("Режим шага: %s%s, расширение %s%s\n", settings.Spacing, inherited(float64(len(stored.Spacing))), percent(settings.StepWidening), inherited(stored.StepWidening)) +
+		fmt.Sprintf("Размер покупок: %s%s\n", settings.Sizing, inherited(float64(len(stored.Sizing)))) +
+		fmt.Sprintf("Множитель: %s%s\n", trimTrailingZeros(fmt.Sprintf("%f", settings.Multiplier)), inherited(stored.Multiplier)) +
+		fmt.Sprintf("Целевая средняя: %s%s\n", percent(settings.TargetAverage), inherited(stored.TargetAverage)) +
+		fmt.Sprintf("Максимум покупок: %s%s\n", depth, inherited(float64(stored.MaxDepth))) +
+		fmt.Sprintf("Доля депозита: %s%s", percent(settings.MaxAllocation), inherited(stored.MaxAllocation))
+}
+
+func (h *Handler) MaxDrawdownCmd(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	user := models.NewUser(userId)
+	user.Status = "maxDrawdown"
+
+	err := h.us.UpdateUser(ctx, user)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateStatus", "err", err)
+	}
+
+	h.send(ctx, b, userId, "Введите максимальную просадку депозита в процентах от его максимума, например: 20. Чтобы выключить - 0")
+}
+
+// MaxDrawdown sets user's max drawdown, all coins are sold and stopped when equity falls below it.
+func (h *Handler) MaxDrawdown(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
+	userId := update.Message.From.ID
+
+	ctx = logging.WithUserId(ctx, userId)
+
+	value, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(update.Message.Text), "%"), 64)
+	if err != nil || value < 0 || value >= 100 {
+		h.send(ctx, b, userId, "Просадка должна быть числом от 0 до 100. Попробуйте ещё раз - /maxDrawdown")
+		return
+	}
+
+	err = h.us.SetMaxDrawdown(ctx, userId, value/100)
+	if err != nil {
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in SetMaxDrawdown", "err", err)
+		h.send(ctx, b, userId, "Не удалось установить просадку, попробуйте позже")
+		return
+	}
+
+	if value == 0 {
+		h.send(ctx, b, userId, "Ограничение просадки выключено")
+		return
+	}
+	h.send(ctx, b, userId, fmt.Sprintf("Если депозит упадёт на %s%% от максимума, все монеты будут проданы по рынку и остановлены", trimTrailingZeros(fmt.Sprintf("%f", value))))
+}
+
 func (h *Handler) DeleteCoinCmd(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotapi.Update) {
 	ctx = logging.WithUserId(ctx, update.Message.Chat.ID)
 
@@ -431,7 +1125,7 @@ func (h *Handler) GetCoinList(ctx context.Context, b *tgbotapi.BotAPI, update *t
 
 	text := "Ваши монеты:\n"
 
-	bal, err := h.ss.GetUserWalletBalance(ctx, user.ApiKey, user.SecretKey)
+	bal, err := h.ss.GetUserWalletBalance(ctx, user.Credentials())
 	if err != nil {
 		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in Get Balance Frim Bybit", err)
 	}
@@ -450,17 +1144,10 @@ func (h *Handler) GetCoinList(ctx context.Context, b *tgbotapi.BotAPI, update *t
 
 	var userSum float64
 	for i := 0; i < len(list); i++ {
-		var coinSum float64
-		for d := 0; d < len(list[i].Buy); d++ {
-			coinSum += list[i].Buy[d]
-		}
-
-		if len(list[i].Buy) != 0 {
-			avg := coinSum / float64(len(list[i].Buy))
+		userSum += list[i].Cost
 
-			userSum += list[i].Count * avg
-
-			text += fmt.Sprintf("%s  купленно на: %.3f💲\n", list[i].Name, list[i].Count*avg)
+		if list[i].Count != 0 {
+			text += fmt.Sprintf("%s  купленно на: %.3f💲, средняя цена: %s\n", list[i].Name, list[i].Cost, trimTrailingZeros(fmt.Sprintf("%f", list[i].AvgPrice())))
 		} else {
 			text += fmt.Sprintf("%s  купленно на: 0💲\n", list[i].Name)
 		}
@@ -534,39 +1221,29 @@ func (h *Handler) AddCoin(ctx context.Context, b *tgbotapi.BotAPI, update *tgbot
 		}
 		return
 	}
-	can, err := h.ss.ExistCoin(ctx, update.Message.Text)
+	can, err := h.ss.ExistCoin(ctx, user.Credentials().Exchange, update.Message.Text)
 	if err != nil {
 		log.Println(err)
 	}
 	if can {
-		coiniks, err := h.ss.GetCoiniks(ctx, update.Message.Text)
+		coiniks, err := h.ss.GetCoiniks(ctx, user.Credentials().Exchange, update.Message.Text)
 		if err != nil {
 			slog.ErrorContext(ctx, "Error getting coiniks", err)
 			return
 		}
 
-		balance, err := h.ss.GetUserWalletBalance(ctx, user.ApiKey, user.SecretKey)
+		balance, err := h.ss.GetUserWalletBalance(ctx, user.Credentials())
 		if err != nil {
 			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in Get Balance Frim Bybit", err)
 		}
 
-		getCoinReqParams := make(models.GetCoinRequest)
-		getCoinReqParams["category"] = "spot"
-		getCoinReqParams["symbol"] = update.Message.Text
-
-		getCoinResp, err := h.ss.GetCoinFromStockExchange(ctx, getCoinReqParams, user.ApiKey, user.SecretKey)
+		currentPrice, err := h.ss.GetCoinPrice(ctx, user.Credentials(), update.Message.Text)
 		if err != nil {
 			slog.ErrorContext(ctx, "Error getting coin from algorithm", err)
 			return
 		}
 
-		currentPrice, err := strconv.ParseFloat(getCoinResp.Result.List[0].Price, 64)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error parsing current price to float", err)
-			return
-		}
-
-		if balance*0.015/currentPrice < coiniks.MinSumBuy*1.1 {
+		if balance*models.DefaultOrderSize/currentPrice < coiniks.MinSumBuy*1.1 {
 			user := models.NewUser(update.Message.From.ID)
 			user.Status = "none"
 
@@ -630,6 +1307,56 @@ func (h *Handler) UnknownCommand(ctx context.Context, b *tgbotapi.BotAPI, update
 		h.AddCoin(ctx, b, update)
 	case "deleteCoin":
 		h.DeleteCoin(ctx, b, update)
+	case "stopLoss":
+		updateUser := models.NewUser(update.Message.From.ID)
+		updateUser.Status = "none"
+
+		err = h.us.UpdateUser(ctx, updateUser)
+		if err != nil {
+			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateUser", "err", err)
+		}
+
+		h.StopLoss(ctx, b, update)
+	case "maxDrawdown":
+		updateUser := models.NewUser(update.Message.From.ID)
+		updateUser.Status = "none"
+
+		err = h.us.UpdateUser(ctx, updateUser)
+		if err != nil {
+			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateUser", "err", err)
+		}
+
+		h.MaxDrawdown(ctx, b, update)
+	case "settings":
+		updateUser := models.NewUser(update.Message.From.ID)
+		updateUser.Status = "none"
+
+		err = h.us.UpdateUser(ctx, updateUser)
+		if err != nil {
+			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateUser", "err", err)
+		}
+
+		h.Settings(ctx, b, update)
+	case "trailing":
+		updateUser := models.NewUser(update.Message.From.ID)
+		updateUser.Status = "none"
+
+		err = h.us.UpdateUser(ctx, updateUser)
+		if err != nil {
+			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateUser", "err", err)
+		}
+
+		h.Trailing(ctx, b, update)
+	case "grid":
+		updateUser := models.NewUser(update.Message.From.ID)
+		updateUser.Status = "none"
+
+		err = h.us.UpdateUser(ctx, updateUser)
+		if err != nil {
+			slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in UpdateUser", "err", err)
+		}
+
+		h.Grid(ctx, b, update)
 	case "changeKeys":
 		h.ChangeApiAndSecretKey(ctx, b, update)
 
@@ -47,6 +47,20 @@ func (h *Handler) Route(ctx context.Context, b *tgbotapi.BotAPI, update *tgbotap
 			h.AddCoinCmd(ctx, b, update)
 		case "changeKeys":
 			h.ChangeApiAndSecretKeyCmd(ctx, b, update)
+		case "paper":
+			h.Paper(ctx, b, update)
+		case "live":
+			h.Live(ctx, b, update)
+		case "grid":
+			h.GridCmd(ctx, b, update)
+		case "stopLoss":
+			h.StopLossCmd(ctx, b, update)
+		case "maxDrawdown":
+			h.MaxDrawdownCmd(ctx, b, update)
+		case "trailing":
+			h.TrailingCmd(ctx, b, update)
+		case "settings":
+			h.SettingsCmd(ctx, b, update)
 		default:
 			h.UnknownCommand(ctx, b, update)
 		}
@@ -5,17 +5,67 @@ package models
 type GetCoinRequest map[string]interface{}
 
 type GetCoinResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		Category string       `json:"category"`
+		List     []TickerInfo `json:"list"`
+	} `json:"result"`
+}
+
+type TickerInfo struct {
+	Symbol string `json:"symbol"`
+	Price  string `json:"lastPrice"`
+}
+
+// -----Get kline endpoint------
+
+type GetKlinesRequest map[string]interface{}
+
+type GetKlinesResponse struct {
 	RetCode int    `json:"retCode"`
 	RetMsg  string `json:"retMsg"`
 	Result  struct {
 		Category string `json:"category"`
-		List     []struct {
-			Symbol string `json:"symbol"`
-			Price  string `json:"lastPrice"`
-		} `json:"list"`
+		Symbol   string `json:"symbol"`
+		// Every kline is [startTime, open, high, low, close, volume, turnover], newest first.
+		List [][]string `json:"list"`
 	} `json:"result"`
 }
 
+// -----Get instruments info endpoint------
+
+type GetInstrumentsRequest map[string]interface{}
+
+type GetInstrumentsResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		Category       string           `json:"category"`
+		List           []InstrumentInfo `json:"list"`
+		NextPageCursor string           `json:"nextPageCursor"`
+	} `json:"result"`
+}
+
+// InstrumentInfo is spot instrument, its numbers are strings as bybit sends them.
+type InstrumentInfo struct {
+	Symbol        string `json:"symbol"`
+	BaseCoin      string `json:"baseCoin"`
+	QuoteCoin     string `json:"quoteCoin"`
+	Status        string `json:"status"`
+	LotSizeFilter struct {
+		BasePrecision  string `json:"basePrecision"`
+		QuotePrecision string `json:"quotePrecision"`
+		MinOrderQty    string `json:"minOrderQty"`
+		MaxOrderQty    string `json:"maxOrderQty"`
+		MinOrderAmt    string `json:"minOrderAmt"`
+		MaxOrderAmt    string `json:"maxOrderAmt"`
+	} `json:"lotSizeFilter"`
+	PriceFilter struct {
+		TickSize string `json:"tickSize"`
+	} `json:"priceFilter"`
+}
+
 // -----Create order endpoint------
 
 type CreateOrderRequest struct {
@@ -28,6 +78,7 @@ type CreateOrderRequest struct {
 	PositionIdx int    `json:"positionIdx"`
 	Price       string `json:"price"`
 	TimeInForce string `json:"timeInForce"`
+	OrderLinkId string `json:"orderLinkId,omitempty"`
 }
 
 type CreateOrderResponse struct {
@@ -68,61 +119,79 @@ type GetOrderResponse struct {
 	RetCode int    `json:"retCode"`
 	RetMsg  string `json:"retMsg"`
 	Result  struct {
-		NextPageCursor string `json:"nextPageCursor"`
-		Category       string `json:"category"`
-		List           []struct {
-			Symbol             string `json:"symbol"`
-			OrderType          string `json:"orderType"`
-			OrderLinkId        string `json:"orderLinkId"`
-			SlLimitPrice       string `json:"slLimitPrice"`
-			OrderId            string `json:"orderId"`
-			CancelType         string `json:"cancelType"`
-			AvgPrice           string `json:"avgPrice"`
-			StopOrderType      string `json:"stopOrderType"`
-			LastPriceOnCreated string `json:"lastPriceOnCreated"`
-			OrderStatus        string `json:"orderStatus"`
-			TakeProfit         string `json:"takeProfit"`
-			CumExecValue       string `json:"cumExecValue"`
-			SmpType            string `json:"smpType"`
-			TriggerDirection   int    `json:"triggerDirection"`
-			BlockTradeId       string `json:"blockTradeId"`
-			IsLeverage         string `json:"isLeverage"`
-			RejectReason       string `json:"rejectReason"`
-			Price              string `json:"price"`
-			OrderIv            string `json:"orderIv"`
-			CreatedTime        string `json:"createdTime"`
-			TpTriggerBy        string `json:"tpTriggerBy"`
-			PositionIdx        int    `json:"positionIdx"`
-			TrailingPercentage string `json:"trailingPercentage"`
-			TimeInForce        string `json:"timeInForce"`
-			LeavesValue        string `json:"leavesValue"`
-			BasePrice          string `json:"basePrice"`
-			UpdatedTime        string `json:"updatedTime"`
-			Side               string `json:"side"`
-			SmpGroup           int    `json:"smpGroup"`
-			TriggerPrice       string `json:"triggerPrice"`
-			TpLimitPrice       string `json:"tpLimitPrice"`
-			TrailingValue      string `json:"trailingValue"`
-			CumExecFee         string `json:"cumExecFee"`
-			LeavesQty          string `json:"leavesQty"`
-			SlTriggerBy        string `json:"slTriggerBy"`
-			CloseOnTrigger     bool   `json:"closeOnTrigger"`
-			PlaceType          string `json:"placeType"`
-			CumExecQty         string `json:"cumExecQty"`
-			ReduceOnly         bool   `json:"reduceOnly"`
-			ActivationPrice    string `json:"activationPrice"`
-			Qty                string `json:"qty"`
-			StopLoss           string `json:"stopLoss"`
-			MarketUnit         string `json:"marketUnit"`
-			SmpOrderId         string `json:"smpOrderId"`
-			TriggerBy          string `json:"triggerBy"`
-		} `json:"list"`
+		NextPageCursor string      `json:"nextPageCursor"`
+		Category       string      `json:"category"`
+		List           []OrderInfo `json:"list"`
 	} `json:"result"`
 	RetExtInfo struct {
 	} `json:"retExtInfo"`
 	Time int64 `json:"time"`
 }
 
+type OrderInfo struct {
+	Symbol             string `json:"symbol"`
+	OrderType          string `json:"orderType"`
+	OrderLinkId        string `json:"orderLinkId"`
+	SlLimitPrice       string `json:"slLimitPrice"`
+	OrderId            string `json:"orderId"`
+	CancelType         string `json:"cancelType"`
+	AvgPrice           string `json:"avgPrice"`
+	StopOrderType      string `json:"stopOrderType"`
+	LastPriceOnCreated string `json:"lastPriceOnCreated"`
+	OrderStatus        string `json:"orderStatus"`
+	TakeProfit         string `json:"takeProfit"`
+	CumExecValue       string `json:"cumExecValue"`
+	SmpType            string `json:"smpType"`
+	TriggerDirection   int    `json:"triggerDirection"`
+	BlockTradeId       string `json:"blockTradeId"`
+	IsLeverage         string `json:"isLeverage"`
+	RejectReason       string `json:"rejectReason"`
+	Price              string `json:"price"`
+	OrderIv            string `json:"orderIv"`
+	CreatedTime        string `json:"createdTime"`
+	TpTriggerBy        string `json:"tpTriggerBy"`
+	PositionIdx        int    `json:"positionIdx"`
+	TrailingPercentage string `json:"trailingPercentage"`
+	TimeInForce        string `json:"timeInForce"`
+	LeavesValue        string `json:"leavesValue"`
+	BasePrice          string `json:"basePrice"`
+	UpdatedTime        string `json:"updatedTime"`
+	Side               string `json:"side"`
+	SmpGroup           int    `json:"smpGroup"`
+	TriggerPrice       string `json:"triggerPrice"`
+	TpLimitPrice       string `json:"tpLimitPrice"`
+	TrailingValue      string `json:"trailingValue"`
+	CumExecFee         string `json:"cumExecFee"`
+	LeavesQty          string `json:"leavesQty"`
+	SlTriggerBy        string `json:"slTriggerBy"`
+	CloseOnTrigger     bool   `json:"closeOnTrigger"`
+	PlaceType          string `json:"placeType"`
+	CumExecQty         string `json:"cumExecQty"`
+	ReduceOnly         bool   `json:"reduceOnly"`
+	ActivationPrice    string `json:"activationPrice"`
+	Qty                string `json:"qty"`
+	StopLoss           string `json:"stopLoss"`
+	MarketUnit         string `json:"marketUnit"`
+	SmpOrderId         string `json:"smpOrderId"`
+	TriggerBy          string `json:"triggerBy"`
+}
+
+// -----Get executions endpoint------
+
+type GetExecutionsRequest map[string]interface{}
+
+type GetExecutionsResponse struct {
+	RetCode int    `json:"retCode"`
+	RetMsg  string `json:"retMsg"`
+	Result  struct {
+		NextPageCursor string `json:"nextPageCursor"`
+		Category       string `json:"category"`
+		// Executions have the same fields as in private stream.
+		List []ExecutionEvent `json:"list"`
+	} `json:"result"`
+	Time int64 `json:"time"`
+}
+
 // -----Get user's wallet balance endpoint------
 
 type GetUserWalletRequest map[string]interface{}
@@ -131,20 +200,24 @@ type GetUserWalletResponse struct {
 	RetCode int    `json:"retCode"`
 	RetMsg  string `json:"retMsg"`
 	Result  struct {
-		List []struct {
-			//TotalWalletBalance    string `json:"totalWalletBalance"`
-			//TotalAvailableBalance string `json:"totalAvailableBalance"`
-			//TotalMarginBalance    string `json:"totalMarginBalance"`
-			TotalEquity string `json:"totalEquity"`
-			Coin        []struct {
-				Coin   string `json:"coin"`
-				Equity string `json:"equity"`
-				//SpotHedgingQty string `json:"spotHedgingQty"`
-			} `json:"coin"`
-		} `json:"list"`
+		List []WalletInfo `json:"list"`
 	} `json:"result"`
 }
 
+type WalletInfo struct {
+	//TotalWalletBalance    string `json:"totalWalletBalance"`
+	//TotalAvailableBalance string `json:"totalAvailableBalance"`
+	//TotalMarginBalance    string `json:"totalMarginBalance"`
+	TotalEquity string       `json:"totalEquity"`
+	Coin        []WalletCoin `json:"coin"`
+}
+
+type WalletCoin struct {
+	Coin   string `json:"coin"`
+	Equity string `json:"equity"`
+	//SpotHedgingQty string `json:"spotHedgingQty"`
+}
+
 // -----Get api key permissions endpoint------
 
 type GetApiKeyPermissionsResponse struct {
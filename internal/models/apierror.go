@@ -0,0 +1,46 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind is class of exchange's error, callers branch on it instead of error's message.
+type ErrorKind string
+
+// Kinds of exchange's errors.
+const (
+	ErrorKindUnknown ErrorKind = ""
+	// ErrorKindRetryable is error of exchange's side or rate limit, the same request can succeed later.
+	ErrorKindRetryable ErrorKind = "retryable"
+	// ErrorKindAuth is invalid, expired or not permitted api key, user has to fix it.
+	ErrorKindAuth              ErrorKind = "auth"
+	ErrorKindInsufficientFunds ErrorKind = "insufficient funds"
+	ErrorKindOrderNotFound     ErrorKind = "order not found"
+	// ErrorKindInvalidOrder is order whose price or qty violates precision or limits of instrument,
+	// e.g. min notional.
+	ErrorKindInvalidOrder ErrorKind = "invalid order"
+	// ErrorKindDuplicateOrder is order whose orderLinkId has been placed already.
+	ErrorKindDuplicateOrder ErrorKind = "duplicate order"
+)
+
+// ApiError is error which exchange has returned in response, Code is exchange's code of it.
+type ApiError struct {
+	Code int
+	Msg  string
+	Kind ErrorKind
+}
+
+func (e *ApiError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Msg, e.Code)
+}
+
+// ErrorKindOf returns kind of exchange's error in err's chain, it is ErrorKindUnknown if
+// err is not exchange's error.
+func ErrorKindOf(err error) ErrorKind {
+	var apiErr *ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	return ErrorKindUnknown
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Candle is one kline of coin's price.
+type Candle struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
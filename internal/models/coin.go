@@ -1,8 +1,17 @@
 package models
 
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
 type Coin struct {
 	UserId        int64
 	Name          string
+	State         CoinState
 	SellOrderId   string
 	BuyOrderId    string
 	QtyDecimals   int
@@ -11,8 +20,25 @@ type Coin struct {
 	CurrentPrice  float64
 	Decrement     float64
 	Count         float64
-	Buy           []float64
-	Income        float64
+	// Cost is how much bought coins have cost with fees, average price is Cost/Count.
+	Cost   float64
+	Buy    []float64
+	Income float64
+	// TrailPeak is the highest price since trailing take profit has been activated, 0 if it is not.
+	TrailPeak float64
+	// Strategy is name of strategy which trades coin, StrategyParams are its parameters in JSON.
+	Strategy       string
+	StrategyParams json.RawMessage
+	// Position is sold at market when price falls StopLossPercent below average buy price
+	// or to StopLossPrice, zero values are turned off.
+	StopLossPercent float64
+	StopLossPrice   float64
+	// Stopped is true when coin has been stopped by protection or by error, it waits for user to
+	// resume buying.
+	Stopped bool
+	// OrderSeq is number of coin's orders, it is part of orderLinkId of the next order. It is
+	// stored together with order ids.
+	OrderSeq int64
 }
 
 func NewCoin(userId int64, coinName string) Coin {
@@ -20,11 +46,160 @@ func NewCoin(userId int64, coinName string) Coin {
 	return coin
 }
 
+// Position returns what is bought for accounting.
+func (c Coin) Position() Position {
+	return Position{Qty: c.Count, Cost: c.Cost}
+}
+
+// AvgPrice is volume weighted average price of bought coins with fees.
+func (c Coin) AvgPrice() float64 {
+	return c.Position().AvgPrice()
+}
+
+// CoinState is state of coin's ladder, it is stored in coin_state column.
+type CoinState string
+
+const (
+	// CoinStateIdle - nothing is bought and there are no orders.
+	CoinStateIdle CoinState = "Idle"
+	// CoinStateWaitingEntry - nothing is bought, first buy order is placed below entry price.
+	CoinStateWaitingEntry CoinState = "WaitingEntry"
+	// CoinStateAccumulating - something is bought, next buy order and sell order are placed.
+	CoinStateAccumulating CoinState = "Accumulating"
+	// CoinStateWaitingExit - something is bought, only sell order is placed.
+	CoinStateWaitingExit CoinState = "WaitingExit"
+	// CoinStateLiquidating - coin is being deleted, orders are canceled and bought coins are sold.
+	CoinStateLiquidating CoinState = "Liquidating"
+	// CoinStatePaused - buying is stopped by user or by protection, nothing is bought and there are no orders.
+	CoinStatePaused CoinState = "Paused"
+	// CoinStateError - coin can not be traded automatically until user resumes it by /startBuy or
+	// /settings or deletes it.
+	CoinStateError CoinState = "Error"
+)
+
+var ErrIllegalTransition = errors.New("illegal coin state transition")
+
+// ErrCoinNotFound is returned when user has no such coin.
+var ErrCoinNotFound = errors.New("coin is not found")
+
+// ErrWrongStrategy is returned when coin's setting doesn't belong to its strategy.
+var ErrWrongStrategy = errors.New("coin has another strategy")
+
+// ErrCoinIsTraded is returned when coin's strategy is changed while something is bought or ordered.
+var ErrCoinIsTraded = errors.New("coin is traded")
+
+// coinStateTransitions lists states which coin can be moved to from every state. Staying
+// in the same state is always allowed, it is used for replacing coin's orders.
+var coinStateTransitions = map[CoinState][]CoinState{
+	CoinStateIdle:         {CoinStateWaitingEntry, CoinStatePaused, CoinStateLiquidating, CoinStateError},
+	CoinStateWaitingEntry: {CoinStateIdle, CoinStateAccumulating, CoinStateWaitingExit, CoinStatePaused, CoinStateLiquidating, CoinStateError},
+	CoinStateAccumulating: {CoinStateWaitingExit, CoinStateWaitingEntry, CoinStateIdle, CoinStatePaused, CoinStateLiquidating, CoinStateError},
+	CoinStateWaitingExit:  {CoinStateAccumulating, CoinStateWaitingEntry, CoinStateIdle, CoinStatePaused, CoinStateLiquidating, CoinStateError},
+	CoinStateLiquidating:  {CoinStateIdle, CoinStatePaused, CoinStateError},
+	CoinStatePaused:       {CoinStateIdle, CoinStateLiquidating, CoinStateError},
+	CoinStateError:        {CoinStateIdle, CoinStateWaitingExit, CoinStateLiquidating},
+}
+
+// CanTransitionTo reports if coin can be moved from state s to state to.
+func (s CoinState) CanTransitionTo(to CoinState) bool {
+	if s == to {
+		return true
+	}
+
+	for _, state := range coinStateTransitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPosition reports if something is bought in that state.
+func (s CoinState) HasPosition() bool {
+	return s == CoinStateAccumulating || s == CoinStateWaitingExit
+}
+
 var CoinPrice = make(map[string]float64)
 
+// Statuses of coiniks, other ones are exchange's statuses of instrument as they are.
+const (
+	CoiniksStatusTrading  = "Trading"
+	CoiniksStatusDelisted = "Delisted"
+)
+
+// Coiniks are coin's instrument on exchange. Decimals are filled from steps by instruments
+// sync, coiniks without steps are rounded to decimals.
 type Coiniks struct {
+	// Exchange lists coin, every exchange has its own coiniks of the same coin.
+	Exchange      string
 	Name          string
 	QtyDecimals   int
 	PriceDecimals int
 	MinSumBuy     float64
+	// BasePrecision is step of order's qty, TickSize is step of order's price.
+	BasePrecision  float64
+	QuotePrecision float64
+	TickSize       float64
+	MinOrderQty    float64
+	MinOrderAmt    float64
+	MaxOrderQty    float64
+	Status         string
+}
+
+// QtyStep returns step of order's qty.
+func (c Coiniks) QtyStep() float64 {
+	if c.BasePrecision > 0 {
+		return c.BasePrecision
+	}
+	return math.Pow10(-c.QtyDecimals)
+}
+
+// PriceStep returns step of order's price.
+func (c Coiniks) PriceStep() float64 {
+	if c.TickSize > 0 {
+		return c.TickSize
+	}
+	return math.Pow10(-c.PriceDecimals)
+}
+
+// FloorQty cuts qty down to qty step, so order never takes more than there is.
+func (c Coiniks) FloorQty(qty float64) float64 {
+	step := c.QtyStep()
+	// Float error of division (0.3/0.1 is 2.9999999999999996) is dropped before cutting.
+	steps := math.Floor(math.Round(qty/step*1e6) / 1e6)
+	return roundTo(steps*step, StepDecimals(step))
+}
+
+// RoundPrice rounds price to the nearest tick.
+func (c Coiniks) RoundPrice(price float64) float64 {
+	step := c.PriceStep()
+	return roundTo(math.Round(price/step)*step, StepDecimals(step))
+}
+
+// FormatQty returns qty of order cut down to qty step.
+func (c Coiniks) FormatQty(qty float64) string {
+	return strconv.FormatFloat(c.FloorQty(qty), 'f', StepDecimals(c.QtyStep()), 64)
+}
+
+// FormatPrice returns price of order rounded to tick.
+func (c Coiniks) FormatPrice(price float64) string {
+	return strconv.FormatFloat(c.RoundPrice(price), 'f', StepDecimals(c.PriceStep()), 64)
+}
+
+// StepDecimals returns how many decimals step has, e.g. 2 for 0.01 and 0.05.
+func StepDecimals(step float64) int {
+	str := strconv.FormatFloat(step, 'f', -1, 64)
+	dotIdx := strings.Index(str, ".")
+	if dotIdx == -1 {
+		return 0
+	}
+	return len(str) - dotIdx - 1
+}
+
+func roundTo(value float64, decimals int) float64 {
+	rounded, err := strconv.ParseFloat(strconv.FormatFloat(value, 'f', decimals, 64), 64)
+	if err != nil {
+		return value
+	}
+	return rounded
 }
@@ -0,0 +1,65 @@
+package models
+
+// Exchanges which users' keys can belong to.
+const (
+	ExchangeBybit   = "bybit"
+	ExchangeBinance = "binance"
+	ExchangeOKX     = "okx"
+)
+
+// Environments of Bybit's keys, testnet and demo trading are Bybit's sandboxes with their own keys.
+const (
+	EnvironmentMainnet = "mainnet"
+	EnvironmentTestnet = "testnet"
+	EnvironmentDemo    = "demo"
+)
+
+// Credentials are user's keys and exchange which they belong to, empty exchange is Bybit.
+// Passphrase is set only for OKX, it is chosen by user on key's creation. Environment is
+// Bybit's environment of keys, empty environment is mainnet.
+type Credentials struct {
+	Exchange    string
+	Environment string
+	ApiKey      string
+	SecretKey   string
+	Passphrase  string
+}
+
+// PlaceOrderRequest is spot order of any exchange. Limit order is good till canceled, Qty and
+// Price are rounded to instrument's steps already.
+type PlaceOrderRequest struct {
+	Symbol      string `json:"symbol"`
+	Side        string `json:"side"`
+	OrderType   string `json:"orderType"`
+	Qty         string `json:"qty"`
+	Price       string `json:"price,omitempty"`
+	OrderLinkId string `json:"orderLinkId,omitempty"`
+}
+
+// PlacedOrder is order which exchange has accepted.
+type PlacedOrder struct {
+	OrderId     string `json:"orderId"`
+	OrderLinkId string `json:"orderLinkId"`
+}
+
+// OrderQuery finds symbol's order by its id or by its orderLinkId, query without them finds
+// symbol's open orders, no more than Limit if it is set.
+type OrderQuery struct {
+	Symbol      string
+	OrderId     string
+	OrderLinkId string
+	Limit       int
+}
+
+// Balances are user's spot wallet, TotalEquity is value of all coins in USDT.
+type Balances struct {
+	TotalEquity float64
+	Coins       map[string]float64
+}
+
+// KeyPermissions are what user's api key is allowed to do.
+type KeyPermissions struct {
+	ReadOnly  bool
+	SpotTrade bool
+	Withdraw  bool
+}
@@ -0,0 +1,32 @@
+package models
+
+// GridLevel is price level of coin's grid. Level without side is the gap between buys and
+// sells, level with side waits for its order if OrderId is empty.
+type GridLevel struct {
+	Level int
+	Price float64
+	Side  string
+	Qty   float64
+	// BuyPrice is price which coins of sell level have been bought at.
+	BuyPrice float64
+	OrderId  string
+}
+
+// GridParams are coin's parameters of grid strategy.
+type GridParams struct {
+	Lower  float64 `json:"lower"`
+	Upper  float64 `json:"upper"`
+	Levels int     `json:"levels"`
+	// Investment is USDT which grid trades with, every cell between levels gets equal part.
+	Investment float64 `json:"investment"`
+}
+
+// Price returns price of level i, levels are spread evenly between bounds.
+func (p GridParams) Price(i int) float64 {
+	return p.Lower + float64(i)*(p.Upper-p.Lower)/float64(p.Levels-1)
+}
+
+// Qty returns quantity which is bought by buy order of level i.
+func (p GridParams) Qty(i int) float64 {
+	return p.Investment / float64(p.Levels-1) / p.Price(i)
+}
@@ -4,11 +4,26 @@ type Message struct {
 	User   User
 	Coin   Coin
 	Action string
+	// Reason is why coin has been stopped, it is one of StopReason constants. For errors which
+	// user has to fix it is error of exchange.
+	Reason string
 	File   string
 	Line   int
 }
 
+// Reasons of coin's stop by protection.
+const (
+	StopReasonStopLoss = "stop loss"
+	StopReasonDrawdown = "max drawdown"
+)
+
 type Error struct {
 	File string
 	Line int
 }
+
+// Correction is something that was fixed in coin by reconciliation with exchange.
+type Correction struct {
+	Coin        string
+	Description string
+}
@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+// Statuses of exchange's orders.
+const (
+	OrderStatusNew             = "New"
+	OrderStatusPartiallyFilled = "PartiallyFilled"
+	OrderStatusFilled          = "Filled"
+	OrderStatusCancelled       = "Cancelled"
+	OrderStatusRejected        = "Rejected"
+	// OrderStatusPartiallyFilledCanceled is spot order which has been canceled after partial fill.
+	OrderStatusPartiallyFilledCanceled = "PartiallyFilledCanceled"
+	// OrderStatusUntriggered is conditional order which waits for its trigger price.
+	OrderStatusUntriggered = "Untriggered"
+)
+
+// Actions of order's history.
+const (
+	OrderActionCreate = "create"
+	OrderActionCancel = "cancel"
+	OrderActionStatus = "status"
+)
+
+// Order is order which bot has placed. Order which exchange has not accepted has no OrderId
+// and is Rejected.
+type Order struct {
+	UserId       int64
+	CoinName     string
+	OrderId      string
+	Side         string
+	OrderType    string
+	Price        string
+	Qty          string
+	Status       string
+	RejectReason string
+	CumExecQty   string
+	// Request and Response are json of create order call, Response is error if call has failed.
+	Request   string
+	Response  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// OrderUpdate is what has happened with order: it has been created, canceled or its status
+// has been changed.
+type OrderUpdate struct {
+	UserId       int64
+	OrderId      string
+	Action       string
+	Status       string
+	RejectReason string
+	CumExecQty   string
+	// Request and Response are json of create or cancel call.
+	Request  string
+	Response string
+	Time     time.Time
+}
+
+// OrderInfoUpdate returns status update of order which has been requested from api.
+func OrderInfoUpdate(userId int64, order OrderInfo) OrderUpdate {
+	return OrderUpdate{
+		UserId:       userId,
+		OrderId:      order.OrderId,
+		Action:       OrderActionStatus,
+		Status:       order.OrderStatus,
+		RejectReason: order.RejectReason,
+		CumExecQty:   order.CumExecQty,
+	}
+}
+
+// OrderEventUpdate returns status update of order from private stream.
+func OrderEventUpdate(userId int64, order OrderEvent) OrderUpdate {
+	return OrderUpdate{
+		UserId:       userId,
+		OrderId:      order.OrderId,
+		Action:       OrderActionStatus,
+		Status:       order.OrderStatus,
+		RejectReason: order.RejectReason,
+		CumExecQty:   order.CumExecQty,
+	}
+}
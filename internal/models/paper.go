@@ -0,0 +1,26 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+)
+
+const paperApiKeyPrefix = "paper-"
+
+// PaperAccount binds user's api key to simulated exchange. All calls with that key
+// go to simulated exchange while account is active.
+type PaperAccount struct {
+	UserId int64
+	ApiKey string
+	Active bool
+}
+
+// PaperApiKey is api key which is given to user without real keys for paper trading.
+func PaperApiKey(userId int64) string {
+	return paperApiKeyPrefix + strconv.FormatInt(userId, 10)
+}
+
+// IsPaperApiKey reports if key has been given by PaperApiKey.
+func IsPaperApiKey(apiKey string) bool {
+	return strings.HasPrefix(apiKey, paperApiKeyPrefix)
+}
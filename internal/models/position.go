@@ -0,0 +1,112 @@
+package models
+
+import "strings"
+
+// QuoteCurrency is currency which positions are counted in.
+const QuoteCurrency = "USDT"
+
+// Fill is execution of order which changes coin's position.
+type Fill struct {
+	UserId   int64
+	CoinName string
+	Side     string
+	OrderId  string
+	// ExecId is id of exchange's execution, fills of ledger are unique by it.
+	ExecId string
+	// Strategy is strategy of coin when order has been filled.
+	Strategy string
+	Price    float64
+	Qty      float64
+	Fee      float64
+	// FeeCoin is currency of fee: spot buy pays it in coin, sell in USDT.
+	FeeCoin string
+	// Time is unix time of fill in milliseconds.
+	Time int64
+}
+
+// NewFill returns fill of coin's order, fee of buy is taken in coin and fee of sell in USDT.
+func NewFill(coin Coin, side, orderId string, price, qty, fee float64) Fill {
+	feeCoin := QuoteCurrency
+	if side == "Buy" {
+		feeCoin = strings.TrimSuffix(coin.Name, QuoteCurrency)
+	}
+
+	return Fill{
+		UserId:   coin.UserId,
+		CoinName: coin.Name,
+		Side:     side,
+		OrderId:  orderId,
+		Price:    price,
+		Qty:      qty,
+		Fee:      fee,
+		FeeCoin:  feeCoin,
+	}
+}
+
+// Position is what is bought and how much it has cost with fees. Average price is volume
+// weighted, sell takes cost of sold part at average price.
+type Position struct {
+	Qty  float64
+	Cost float64
+}
+
+// Apply changes position by fill and returns realised PnL of sell.
+func (p *Position) Apply(fill Fill) float64 {
+	if fill.Side == "Buy" {
+		qty := fill.Qty
+		value := fill.Price * fill.Qty
+		if fill.FeeCoin == QuoteCurrency {
+			value += fill.Fee
+		} else {
+			qty -= fill.Fee
+		}
+
+		p.Qty += qty
+		p.Cost += value
+		return 0
+	}
+
+	cost := p.Cost
+	if sold := soldQty(fill); p.Qty > 0 && sold < p.Qty {
+		cost = p.Cost * sold / p.Qty
+	}
+	return p.Close(fill, cost)
+}
+
+// Close sells part of position which has cost, e.g. lot of grid level, and returns realised PnL.
+func (p *Position) Close(fill Fill, cost float64) float64 {
+	value := fill.Price * fill.Qty
+	if fill.FeeCoin == QuoteCurrency {
+		value -= fill.Fee
+	}
+
+	p.Qty -= soldQty(fill)
+	p.Cost -= cost
+	// Dust which is left after rounding is sold too.
+	if p.Qty <= 0 {
+		*p = Position{}
+	}
+
+	return value - cost
+}
+
+// soldQty is how many coins sell takes from position with fee.
+func soldQty(fill Fill) float64 {
+	if fill.FeeCoin == QuoteCurrency {
+		return fill.Qty
+	}
+	return fill.Qty + fill.Fee
+}
+
+// AvgPrice is volume weighted average price of position with fees.
+func (p Position) AvgPrice() float64 {
+	if p.Qty <= 0 {
+		return 0
+	}
+	return p.Cost / p.Qty
+}
+
+// Unrealised is PnL which position would have if it was sold at price without fee.
+func (p Position) Unrealised(price float64) float64 {
+	return price*p.Qty - p.Cost
+}
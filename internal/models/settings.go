@@ -0,0 +1,107 @@
+package models
+
+const (
+	// DefaultOrderSize is part of equity which is spent on entry order.
+	DefaultOrderSize = 0.015
+	// DefaultMaxAllocation is part of equity which can be spent on all coins of user or on one coin.
+	DefaultMaxAllocation = 0.95
+	// DefaultStepWidening is how much every next step of widening spacing is wider.
+	DefaultStepWidening = 0.5
+)
+
+// Sizing modes of ladder's next buy.
+const (
+	// SizingFlat buys as much as the first buy.
+	SizingFlat = "flat"
+	// SizingLinear adds the same amount to every next buy, the second buy is Multiplier times the first.
+	SizingLinear = "linear"
+	// SizingGeometric buys Multiplier times more than previous buy.
+	SizingGeometric = "geometric"
+	// SizingTarget buys as much as moves average price of position to TargetAverage above buy.
+	SizingTarget = "target"
+)
+
+// Spacing modes of ladder's steps.
+const (
+	// SpacingFixed steps by Step of entry price.
+	SpacingFixed = "fixed"
+	// SpacingPercent steps by Step of previous buy price.
+	SpacingPercent = "percent"
+	// SpacingWidening makes every next step StepWidening of the first one wider.
+	SpacingWidening = "widening"
+)
+
+// CoinSettings are user's parameters of coin's ladder. Zero ones are inherited: take profit
+// and step from user's percent, the others from defaults.
+type CoinSettings struct {
+	UserId   int64
+	CoinName string
+	// TakeProfit is part above average price of buys where position is sold.
+	TakeProfit float64
+	// Step is part of entry price between buys of ladder.
+	Step float64
+	// OrderSize is part of equity which is spent on entry order, OrderSizeUSDT is fixed sum
+	// which is spent instead of it.
+	OrderSize     float64
+	OrderSizeUSDT float64
+	// Sizing is mode of next buy's size, geometric if Multiplier is set and flat otherwise.
+	Sizing string
+	// Multiplier is how many times every next buy is bigger than previous one.
+	Multiplier float64
+	// TargetAverage is part above next buy where target sizing moves average price, Step by default.
+	TargetAverage float64
+	// Spacing is mode of ladder's steps, fixed by default.
+	Spacing      string
+	StepWidening float64
+	// MaxDepth is how many buys ladder can have, zero is unlimited.
+	MaxDepth int
+	// MaxAllocation is part of equity which coin's position can take.
+	MaxAllocation float64
+}
+
+func NewCoinSettings(userId int64, coinName string) CoinSettings {
+	return CoinSettings{UserId: userId, CoinName: coinName}
+}
+
+// WithDefaults returns settings where zero parameters are inherited from user and defaults.
+func (s CoinSettings) WithDefaults(user User) CoinSettings {
+	if s.TakeProfit == 0 {
+		s.TakeProfit = user.Percent
+	}
+	if s.Step == 0 {
+		s.Step = user.Percent
+	}
+	if s.OrderSize == 0 && s.OrderSizeUSDT == 0 {
+		s.OrderSize = DefaultOrderSize
+	}
+	if s.Sizing == "" {
+		s.Sizing = SizingFlat
+		if s.Multiplier != 0 {
+			s.Sizing = SizingGeometric
+		}
+	}
+	if s.Multiplier == 0 {
+		s.Multiplier = 1
+	}
+	if s.TargetAverage == 0 {
+		s.TargetAverage = s.Step
+	}
+	if s.Spacing == "" {
+		s.Spacing = SpacingFixed
+	}
+	if s.StepWidening == 0 {
+		s.StepWidening = DefaultStepWidening
+	}
+	if s.MaxAllocation == 0 {
+		s.MaxAllocation = DefaultMaxAllocation
+	}
+	return s
+}
+
+// EntrySum is how much USDT is spent on entry order.
+func (s CoinSettings) EntrySum(equity float64) float64 {
+	if s.OrderSizeUSDT > 0 {
+		return s.OrderSizeUSDT
+	}
+	return equity * s.OrderSize
+}
@@ -0,0 +1,75 @@
+package models
+
+// -----Public tickers stream------
+
+type TickerEvent struct {
+	Symbol string `json:"symbol"`
+	Price  string `json:"lastPrice"`
+	Ts     int64  `json:"-"`
+}
+
+// -----Private order stream------
+
+type OrderEvent struct {
+	Symbol       string `json:"symbol"`
+	OrderId      string `json:"orderId"`
+	OrderLinkId  string `json:"orderLinkId"`
+	Side         string `json:"side"`
+	OrderType    string `json:"orderType"`
+	OrderStatus  string `json:"orderStatus"`
+	Price        string `json:"price"`
+	Qty          string `json:"qty"`
+	AvgPrice     string `json:"avgPrice"`
+	CumExecQty   string `json:"cumExecQty"`
+	CumExecValue string `json:"cumExecValue"`
+	CumExecFee   string `json:"cumExecFee"`
+	RejectReason string `json:"rejectReason"`
+	CreatedTime  string `json:"createdTime"`
+	UpdatedTime  string `json:"updatedTime"`
+}
+
+// -----Private execution stream------
+
+type ExecutionEvent struct {
+	Symbol      string `json:"symbol"`
+	OrderId     string `json:"orderId"`
+	OrderLinkId string `json:"orderLinkId"`
+	Side        string `json:"side"`
+	ExecId      string `json:"execId"`
+	ExecPrice   string `json:"execPrice"`
+	ExecQty     string `json:"execQty"`
+	ExecValue   string `json:"execValue"`
+	ExecFee     string `json:"execFee"`
+	FeeCurrency string `json:"feeCurrency"`
+	ExecType    string `json:"execType"`
+	ExecTime    string `json:"execTime"`
+}
+
+// -----Private wallet stream------
+
+type WalletEvent struct {
+	AccountType string       `json:"accountType"`
+	TotalEquity string       `json:"totalEquity"`
+	Coin        []WalletCoin `json:"coin"`
+}
+
+// PrivateEvent is one message of private stream, only one of the slices is filled
+// depending on topic.
+type PrivateEvent struct {
+	Topic      string
+	Orders     []OrderEvent
+	Executions []ExecutionEvent
+	Wallets    []WalletEvent
+}
+
+// Symbols returns all symbols which are mentioned in event.
+func (e PrivateEvent) Symbols() []string {
+	symbols := make([]string, 0, len(e.Orders)+len(e.Executions))
+	for _, o := range e.Orders {
+		symbols = append(symbols, o.Symbol)
+	}
+	for _, ex := range e.Executions {
+		symbols = append(symbols, ex.Symbol)
+	}
+	return symbols
+}
@@ -8,15 +8,35 @@ type User struct {
 	Income           float64
 	ApiKey           string
 	SecretKey        string
+	Passphrase       string
+	Exchange         string
+	Environment      string
 	Status           string
 	TradingActivated bool
 	Buy              bool
+	// MaxDrawdown is part of PeakEquity which user can lose before all coins are stopped,
+	// zero is turned off.
+	MaxDrawdown float64
+	PeakEquity  float64
 }
 
 func NewUser(userId int64) User {
 	return User{Id: userId}
 }
 
+// Credentials returns user's keys with their exchange and environment.
+func (u User) Credentials() Credentials {
+	exchange := u.Exchange
+	if exchange == "" {
+		exchange = ExchangeBybit
+	}
+	environment := u.Environment
+	if environment == "" {
+		environment = EnvironmentMainnet
+	}
+	return Credentials{Exchange: exchange, Environment: environment, ApiKey: u.ApiKey, SecretKey: u.SecretKey, Passphrase: u.Passphrase}
+}
+
 func (u User) UpdateUserId(userId int64) {
 	u.Id = userId
 }
@@ -0,0 +1,675 @@
+package binance
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"m1pes/internal/models"
+)
+
+const (
+	URL = "https://api.binance.com"
+
+	OrderEndpoint           = "/api/v3/order"
+	OpenOrdersEndpoint      = "/api/v3/openOrders"
+	MyTradesEndpoint        = "/api/v3/myTrades"
+	TickerPriceEndpoint     = "/api/v3/ticker/price"
+	AccountEndpoint         = "/api/v3/account"
+	ExchangeInfoEndpoint    = "/api/v3/exchangeInfo"
+	ApiRestrictionsEndpoint = "/sapi/v1/account/apiRestrictions"
+
+	// Only coins which are bought for USDT are traded by bot, USDT is their equity.
+	quoteCoin = "USDT"
+
+	recvWindow    = "5000"
+	retryAttempts = 3
+	retryMinDelay = 200 * time.Millisecond
+	retryMaxDelay = 5 * time.Second
+)
+
+// Statuses of binance's order, they are mapped to models' OrderStatus constants.
+const (
+	statusNew             = "NEW"
+	statusPartiallyFilled = "PARTIALLY_FILLED"
+	statusFilled          = "FILLED"
+	statusCanceled        = "CANCELED"
+	statusExpired         = "EXPIRED"
+	statusRejected        = "REJECTED"
+)
+
+// Repository is binance spot behind apiStock.Exchange.
+type Repository struct {
+	cli *http.Client
+	url string
+
+	// rules are instruments of symbols which orders have been placed for, order's qty and price
+	// are rounded to binance's steps, they can differ from coiniks of bybit.
+	rulesMu sync.Mutex
+	rules   map[string]models.Coiniks
+}
+
+// New creates repository which sends requests to url, it is URL if url is empty.
+func New(url string) *Repository {
+	if url == "" {
+		url = URL
+	}
+
+	return &Repository{
+		cli: &http.Client{
+			Timeout: time.Minute,
+		},
+		url:   url,
+		rules: make(map[string]models.Coiniks),
+	}
+}
+
+type order struct {
+	Symbol              string `json:"symbol"`
+	OrderId             int64  `json:"orderId"`
+	ClientOrderId       string `json:"clientOrderId"`
+	Price               string `json:"price"`
+	OrigQty             string `json:"origQty"`
+	ExecutedQty         string `json:"executedQty"`
+	CummulativeQuoteQty string `json:"cummulativeQuoteQty"`
+	Status              string `json:"status"`
+	TimeInForce         string `json:"timeInForce"`
+	Type                string `json:"type"`
+	Side                string `json:"side"`
+	Time                int64  `json:"time"`
+	UpdateTime          int64  `json:"updateTime"`
+}
+
+type trade struct {
+	Symbol          string `json:"symbol"`
+	Id              int64  `json:"id"`
+	OrderId         int64  `json:"orderId"`
+	Price           string `json:"price"`
+	Qty             string `json:"qty"`
+	QuoteQty        string `json:"quoteQty"`
+	Commission      string `json:"commission"`
+	CommissionAsset string `json:"commissionAsset"`
+	Time            int64  `json:"time"`
+	IsBuyer         bool   `json:"isBuyer"`
+}
+
+type tickerPrice struct {
+	Symbol string `json:"symbol"`
+	Price  string `json:"price"`
+}
+
+type symbolInfo struct {
+	Symbol              string `json:"symbol"`
+	Status              string `json:"status"`
+	BaseAsset           string `json:"baseAsset"`
+	QuoteAsset          string `json:"quoteAsset"`
+	QuoteAssetPrecision int    `json:"quoteAssetPrecision"`
+	Filters             []struct {
+		FilterType  string `json:"filterType"`
+		TickSize    string `json:"tickSize"`
+		StepSize    string `json:"stepSize"`
+		MinQty      string `json:"minQty"`
+		MaxQty      string `json:"maxQty"`
+		MinNotional string `json:"minNotional"`
+	} `json:"filters"`
+}
+
+func (r *Repository) PlaceOrder(ctx context.Context, creds models.Credentials, req models.PlaceOrderRequest) (models.PlacedOrder, error) {
+	req, err := r.normalize(ctx, req)
+	if err != nil {
+		return models.PlacedOrder{}, err
+	}
+
+	params := url.Values{}
+	params.Set("symbol", req.Symbol)
+	params.Set("side", strings.ToUpper(req.Side))
+	params.Set("type", strings.ToUpper(req.OrderType))
+	params.Set("quantity", req.Qty)
+	if req.OrderType == "Limit" {
+		params.Set("timeInForce", "GTC")
+		params.Set("price", req.Price)
+	}
+	if req.OrderLinkId != "" {
+		params.Set("newClientOrderId", req.OrderLinkId)
+	}
+	params.Set("newOrderRespType", "ACK")
+
+	var resp order
+	// Order with client id is not placed twice, binance rejects the same id as duplicate.
+	err = r.send(ctx, http.MethodPost, OrderEndpoint, params, &creds, req.OrderLinkId != "", &resp)
+	if err != nil {
+		return models.PlacedOrder{}, fmt.Errorf("place order failed: %w", err)
+	}
+
+	return models.PlacedOrder{OrderId: strconv.FormatInt(resp.OrderId, 10), OrderLinkId: resp.ClientOrderId}, nil
+}
+
+func (r *Repository) CancelOrder(ctx context.Context, creds models.Credentials, symbol, orderId string) error {
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("orderId", orderId)
+
+	err := r.send(ctx, http.MethodDelete, OrderEndpoint, params, &creds, false, nil)
+	if err != nil {
+		return fmt.Errorf("cancel order failed: %w", err)
+	}
+	return nil
+}
+
+// GetOrders returns order by its id as list, list is empty if binance does not know it.
+func (r *Repository) GetOrders(ctx context.Context, creds models.Credentials, query models.OrderQuery) ([]models.OrderInfo, error) {
+	params := url.Values{}
+	params.Set("symbol", query.Symbol)
+
+	if query.OrderId == "" && query.OrderLinkId == "" {
+		var resp []order
+		err := r.send(ctx, http.MethodGet, OpenOrdersEndpoint, params, &creds, true, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("get open orders failed: %w", err)
+		}
+
+		list := make([]models.OrderInfo, 0, len(resp))
+		for _, o := range resp {
+			list = append(list, orderInfo(o, 0))
+		}
+		return list, nil
+	}
+
+	if query.OrderId != "" {
+		params.Set("orderId", query.OrderId)
+	} else {
+		params.Set("origClientOrderId", query.OrderLinkId)
+	}
+
+	var resp order
+	err := r.send(ctx, http.MethodGet, OrderEndpoint, params, &creds, true, &resp)
+	if models.ErrorKindOf(err) == models.ErrorKindOrderNotFound {
+		return []models.OrderInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get order failed: %w", err)
+	}
+
+	// Order has no fee, it is taken from trades of executed order.
+	var fee float64
+	if parseFloat(resp.ExecutedQty) > 0 {
+		fee, err = r.orderFee(ctx, creds, resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []models.OrderInfo{orderInfo(resp, fee)}, nil
+}
+
+func (r *Repository) GetExecutions(ctx context.Context, creds models.Credentials, symbol, orderId string) ([]models.ExecutionEvent, error) {
+	trades, err := r.trades(ctx, creds, symbol, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	executions := make([]models.ExecutionEvent, 0, len(trades))
+	for _, t := range trades {
+		side := "Sell"
+		if t.IsBuyer {
+			side = "Buy"
+		}
+
+		// Fee in other coin does not change position, as in orderFee.
+		fee := t.Commission
+		if t.CommissionAsset != feeCoin(t.Symbol, t.IsBuyer) {
+			fee = "0"
+		}
+
+		executions = append(executions, models.ExecutionEvent{
+			Symbol:      t.Symbol,
+			OrderId:     strconv.FormatInt(t.OrderId, 10),
+			Side:        side,
+			ExecId:      strconv.FormatInt(t.Id, 10),
+			ExecPrice:   t.Price,
+			ExecQty:     t.Qty,
+			ExecValue:   t.QuoteQty,
+			ExecFee:     fee,
+			FeeCurrency: t.CommissionAsset,
+			ExecType:    "Trade",
+			ExecTime:    strconv.FormatInt(t.Time, 10),
+		})
+	}
+
+	return executions, nil
+}
+
+func (r *Repository) GetPrice(ctx context.Context, creds models.Credentials, symbol string) (float64, error) {
+	params := url.Values{}
+	params.Set("symbol", symbol)
+
+	var resp tickerPrice
+	err := r.send(ctx, http.MethodGet, TickerPriceEndpoint, params, nil, true, &resp)
+	if err != nil {
+		return 0, fmt.Errorf("get price failed: %w", err)
+	}
+
+	price, err := strconv.ParseFloat(resp.Price, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse price failed: %w", err)
+	}
+	return price, nil
+}
+
+// GetBalances returns spot wallet, TotalEquity is counted by last prices of coins' USDT pairs.
+// Coins which have no such pair are not counted.
+func (r *Repository) GetBalances(ctx context.Context, creds models.Credentials) (models.Balances, error) {
+	params := url.Values{}
+	params.Set("omitZeroBalances", "true")
+
+	var resp struct {
+		Balances []struct {
+			Asset  string `json:"asset"`
+			Free   string `json:"free"`
+			Locked string `json:"locked"`
+		} `json:"balances"`
+	}
+	err := r.send(ctx, http.MethodGet, AccountEndpoint, params, &creds, true, &resp)
+	if err != nil {
+		return models.Balances{}, fmt.Errorf("get account failed: %w", err)
+	}
+
+	balances := models.Balances{Coins: make(map[string]float64, len(resp.Balances))}
+	for _, balance := range resp.Balances {
+		qty := parseFloat(balance.Free) + parseFloat(balance.Locked)
+		if qty > 0 {
+			balances.Coins[balance.Asset] = qty
+		}
+	}
+
+	var prices []tickerPrice
+	if len(balances.Coins) > 1 || balances.Coins[quoteCoin] == 0 {
+		err = r.send(ctx, http.MethodGet, TickerPriceEndpoint, url.Values{}, nil, true, &prices)
+		if err != nil {
+			return models.Balances{}, fmt.Errorf("get prices failed: %w", err)
+		}
+	}
+
+	priceOf := make(map[string]float64, len(prices))
+	for _, price := range prices {
+		priceOf[price.Symbol] = parseFloat(price.Price)
+	}
+
+	for coin, qty := range balances.Coins {
+		if coin == quoteCoin {
+			balances.TotalEquity += qty
+			continue
+		}
+		balances.TotalEquity += qty * priceOf[coin+quoteCoin]
+	}
+
+	return balances, nil
+}
+
+func (r *Repository) GetInstruments(ctx context.Context) ([]models.Coiniks, error) {
+	symbols, err := r.exchangeInfo(ctx, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]models.Coiniks, 0, len(symbols))
+	for _, info := range symbols {
+		coiniks, err := symbolCoiniks(info)
+		if err != nil {
+			slog.WarnContext(ctx, "Error parsing instrument", "err", err, "symbol", info.Symbol)
+			continue
+		}
+		list = append(list, coiniks)
+	}
+
+	return list, nil
+}
+
+func (r *Repository) GetKeyPermissions(ctx context.Context, creds models.Credentials) (models.KeyPermissions, error) {
+	var resp struct {
+		EnableReading              bool `json:"enableReading"`
+		EnableSpotAndMarginTrading bool `json:"enableSpotAndMarginTrading"`
+		EnableWithdrawals          bool `json:"enableWithdrawals"`
+		EnableMargin               bool `json:"enableMargin"`
+		EnableFutures              bool `json:"enableFutures"`
+	}
+	err := r.send(ctx, http.MethodGet, ApiRestrictionsEndpoint, url.Values{}, &creds, true, &resp)
+	if err != nil {
+		return models.KeyPermissions{}, fmt.Errorf("get api restrictions failed: %w", err)
+	}
+
+	return models.KeyPermissions{
+		ReadOnly:  !resp.EnableSpotAndMarginTrading && !resp.EnableWithdrawals && !resp.EnableMargin && !resp.EnableFutures,
+		SpotTrade: resp.EnableSpotAndMarginTrading,
+		Withdraw:  resp.EnableWithdrawals,
+	}, nil
+}
+
+// normalize rounds qty and price of order to steps of binance's instrument.
+func (r *Repository) normalize(ctx context.Context, req models.PlaceOrderRequest) (models.PlaceOrderRequest, error) {
+	rules, err := r.symbolRules(ctx, req.Symbol)
+	if err != nil {
+		return req, err
+	}
+
+	qty, err := strconv.ParseFloat(req.Qty, 64)
+	if err != nil {
+		return req, fmt.Errorf("parse qty failed: %w", err)
+	}
+	req.Qty = rules.FormatQty(qty)
+
+	if req.Price != "" {
+		price, err := strconv.ParseFloat(req.Price, 64)
+		if err != nil {
+			return req, fmt.Errorf("parse price failed: %w", err)
+		}
+		req.Price = rules.FormatPrice(price)
+	}
+
+	return req, nil
+}
+
+// symbolRules returns instrument of symbol, it is requested once.
+func (r *Repository) symbolRules(ctx context.Context, symbol string) (models.Coiniks, error) {
+	r.rulesMu.Lock()
+	rules, ok := r.rules[symbol]
+	r.rulesMu.Unlock()
+	if ok {
+		return rules, nil
+	}
+
+	params := url.Values{}
+	params.Set("symbol", symbol)
+
+	symbols, err := r.exchangeInfo(ctx, params)
+	if err != nil {
+		return models.Coiniks{}, err
+	}
+	if len(symbols) == 0 {
+		return models.Coiniks{}, fmt.Errorf("symbol %s is not found", symbol)
+	}
+
+	rules, err = symbolCoiniks(symbols[0])
+	if err != nil {
+		return models.Coiniks{}, fmt.Errorf("parse rules of %s failed: %w", symbol, err)
+	}
+
+	r.rulesMu.Lock()
+	r.rules[symbol] = rules
+	r.rulesMu.Unlock()
+
+	return rules, nil
+}
+
+func (r *Repository) exchangeInfo(ctx context.Context, params url.Values) ([]symbolInfo, error) {
+	var resp struct {
+		Symbols []symbolInfo `json:"symbols"`
+	}
+	err := r.send(ctx, http.MethodGet, ExchangeInfoEndpoint, params, nil, true, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("get exchange info failed: %w", err)
+	}
+	return resp.Symbols, nil
+}
+
+func (r *Repository) trades(ctx context.Context, creds models.Credentials, symbol, orderId string) ([]trade, error) {
+	params := url.Values{}
+	params.Set("symbol", symbol)
+	params.Set("orderId", orderId)
+
+	var resp []trade
+	err := r.send(ctx, http.MethodGet, MyTradesEndpoint, params, &creds, true, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("get trades failed: %w", err)
+	}
+	return resp, nil
+}
+
+// orderFee returns fee of order as bybit counts it: in base coin for buy and in quote coin for
+// sell. Fee which is paid in other coin, e.g. BNB, does not change position.
+func (r *Repository) orderFee(ctx context.Context, creds models.Credentials, o order) (float64, error) {
+	trades, err := r.trades(ctx, creds, o.Symbol, strconv.FormatInt(o.OrderId, 10))
+	if err != nil {
+		return 0, err
+	}
+
+	var fee float64
+	for _, t := range trades {
+		if t.CommissionAsset == feeCoin(o.Symbol, o.Side == "BUY") {
+			fee += parseFloat(t.Commission)
+		}
+	}
+	return fee, nil
+}
+
+// feeCoin returns coin which fee of position is counted in: base coin for buy and quote coin
+// for sell.
+func feeCoin(symbol string, buy bool) string {
+	if buy {
+		return strings.TrimSuffix(symbol, quoteCoin)
+	}
+	return quoteCoin
+}
+
+// send signs request if creds are set and unmarshals response to resp. Requests which binance
+// has rejected by rate limit or timestamp are not handled, so they are retried always. Requests
+// which have failed on the way or on server could be handled, so they are retried only if
+// retry is true: request is idempotent or it is order with client id.
+func (r *Repository) send(ctx context.Context, method, endPoint string, params url.Values, creds *models.Credentials, retry bool, resp any) error {
+	for attempt := 0; ; attempt++ {
+		data, err := r.do(ctx, method, endPoint, params, creds)
+
+		transient := retry && err != nil
+		var apiErr *models.ApiError
+		if errors.As(err, &apiErr) {
+			transient = rejected(apiErr.Code) || (retry && apiErr.Kind == models.ErrorKindRetryable)
+		}
+
+		if !transient || attempt+1 >= retryAttempts {
+			if err != nil {
+				return err
+			}
+			if resp == nil {
+				return nil
+			}
+			if err = json.Unmarshal(data, resp); err != nil {
+				return fmt.Errorf("unmarshal response failed: %w", err)
+			}
+			return nil
+		}
+
+		delay := backoff(attempt)
+		slog.WarnContext(ctx, "binance request failed, retrying", "endpoint", endPoint, "attempt", attempt+1, "delay", delay, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed wait for retry: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// do sends request once, every try has its own timestamp and signature. Error's response is
+// returned as *models.ApiError.
+func (r *Repository) do(ctx context.Context, method, endPoint string, params url.Values, creds *models.Credentials) ([]byte, error) {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+
+	if creds != nil {
+		query.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli(), 10))
+		query.Set("recvWindow", recvWindow)
+	}
+
+	rawQuery := query.Encode()
+	if creds != nil {
+		rawQuery += "&signature=" + sign(rawQuery, creds.SecretKey)
+	}
+
+	target := r.url + endPoint
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed create new request: %w", err)
+	}
+	if creds != nil {
+		request.Header.Set("X-MBX-APIKEY", creds.ApiKey)
+	}
+
+	response, err := r.cli.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed do request: %w", err)
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed read body: %w", err)
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		var errResp struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Code != 0 {
+			return nil, NewError(errResp.Code, errResp.Msg)
+		}
+		return nil, fmt.Errorf("bad response status: %s", response.Status)
+	}
+
+	return data, nil
+}
+
+// sign returns hex of HMAC SHA256 of query by secret key.
+func sign(query, secretKey string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(query))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// orderInfo returns binance's order in bybit's form which models use.
+func orderInfo(o order, fee float64) models.OrderInfo {
+	info := models.OrderInfo{
+		Symbol:       o.Symbol,
+		OrderId:      strconv.FormatInt(o.OrderId, 10),
+		OrderLinkId:  o.ClientOrderId,
+		OrderType:    titleCase(o.Type),
+		Side:         titleCase(o.Side),
+		Price:        o.Price,
+		Qty:          o.OrigQty,
+		CumExecQty:   o.ExecutedQty,
+		CumExecValue: o.CummulativeQuoteQty,
+		CumExecFee:   strconv.FormatFloat(fee, 'f', -1, 64),
+		TimeInForce:  o.TimeInForce,
+		CreatedTime:  strconv.FormatInt(o.Time, 10),
+		UpdatedTime:  strconv.FormatInt(o.UpdateTime, 10),
+	}
+
+	executed := parseFloat(o.ExecutedQty)
+	if executed > 0 {
+		info.AvgPrice = strconv.FormatFloat(parseFloat(o.CummulativeQuoteQty)/executed, 'f', -1, 64)
+	}
+	info.LeavesQty = strconv.FormatFloat(math.Max(parseFloat(o.OrigQty)-executed, 0), 'f', -1, 64)
+
+	switch o.Status {
+	case statusNew:
+		info.OrderStatus = models.OrderStatusNew
+	case statusPartiallyFilled:
+		info.OrderStatus = models.OrderStatusPartiallyFilled
+	case statusFilled:
+		info.OrderStatus = models.OrderStatusFilled
+	case statusCanceled, statusExpired:
+		info.OrderStatus = models.OrderStatusCancelled
+		if executed > 0 {
+			info.OrderStatus = models.OrderStatusPartiallyFilledCanceled
+		}
+	case statusRejected:
+		info.OrderStatus = models.OrderStatusRejected
+	default:
+		info.OrderStatus = o.Status
+	}
+
+	return info
+}
+
+// symbolCoiniks returns coiniks of binance's symbol, decimals are taken from its steps.
+func symbolCoiniks(info symbolInfo) (models.Coiniks, error) {
+	coiniks := models.Coiniks{Name: info.Symbol, Status: info.Status}
+	if info.Status == "TRADING" {
+		coiniks.Status = models.CoiniksStatusTrading
+	}
+	if info.QuoteAssetPrecision > 0 {
+		coiniks.QuotePrecision = math.Pow10(-info.QuoteAssetPrecision)
+	}
+
+	for _, filter := range info.Filters {
+		var err error
+		switch filter.FilterType {
+		case "PRICE_FILTER":
+			coiniks.TickSize, err = strconv.ParseFloat(filter.TickSize, 64)
+		case "LOT_SIZE":
+			coiniks.BasePrecision, err = strconv.ParseFloat(filter.StepSize, 64)
+			if err == nil {
+				coiniks.MinOrderQty, err = strconv.ParseFloat(filter.MinQty, 64)
+			}
+			if err == nil {
+				coiniks.MaxOrderQty, err = strconv.ParseFloat(filter.MaxQty, 64)
+			}
+		case "NOTIONAL", "MIN_NOTIONAL":
+			coiniks.MinOrderAmt, err = strconv.ParseFloat(filter.MinNotional, 64)
+		}
+		if err != nil {
+			return models.Coiniks{}, fmt.Errorf("parse %s failed: %w", filter.FilterType, err)
+		}
+	}
+
+	if coiniks.BasePrecision <= 0 || coiniks.TickSize <= 0 {
+		return models.Coiniks{}, fmt.Errorf("instrument has no steps")
+	}
+
+	coiniks.QtyDecimals = models.StepDecimals(coiniks.BasePrecision)
+	coiniks.PriceDecimals = models.StepDecimals(coiniks.TickSize)
+	coiniks.MinSumBuy = coiniks.MinOrderAmt
+	return coiniks, nil
+}
+
+// titleCase returns binance's enum as bybit's one, e.g. Buy for BUY.
+func titleCase(value string) string {
+	if value == "" {
+		return value
+	}
+	return value[:1] + strings.ToLower(value[1:])
+}
+
+func parseFloat(value string) float64 {
+	f, _ := strconv.ParseFloat(value, 64)
+	return f
+}
+
+// backoff returns delay before retry of attempt, it is doubled every attempt and has jitter.
+func backoff(attempt int) time.Duration {
+	delay := retryMinDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
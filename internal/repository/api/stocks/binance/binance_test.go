@@ -0,0 +1,185 @@
+package binance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"m1pes/internal/models"
+)
+
+const (
+	testApiKey    = "key"
+	testSecretKey = "secret"
+)
+
+var testCreds = models.Credentials{Exchange: models.ExchangeBinance, ApiKey: testApiKey, SecretKey: testSecretKey}
+
+// newTestServer serves binance's recorded responses by endpoint and checks signature of
+// private requests.
+func newTestServer(t *testing.T, responses map[string]string) (*Repository, map[string][]*http.Request) {
+	t.Helper()
+
+	requests := make(map[string][]*http.Request)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests[req.URL.Path] = append(requests[req.URL.Path], req)
+
+		if query := req.URL.RawQuery; strings.Contains(query, "signature=") {
+			signed, signature, _ := strings.Cut(query, "&signature=")
+			if req.Header.Get("X-MBX-APIKEY") != testApiKey || signature != sign(signed, testSecretKey) {
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"code":-1022,"msg":"Signature for this request is not valid."}`))
+				return
+			}
+		}
+
+		resp, ok := responses[req.Method+" "+req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.HasPrefix(resp, `{"code"`) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		_, _ = w.Write([]byte(resp))
+	}))
+	t.Cleanup(server.Close)
+
+	return New(server.URL), requests
+}
+
+const exchangeInfo = `{"symbols":[{"symbol":"BTCUSDT","status":"TRADING","baseAsset":"BTC","quoteAsset":"USDT","quoteAssetPrecision":8,
+"filters":[{"filterType":"PRICE_FILTER","minPrice":"0.01","maxPrice":"1000000.00","tickSize":"0.01"},
+{"filterType":"LOT_SIZE","minQty":"0.00001000","maxQty":"9000.00000000","stepSize":"0.00001000"},
+{"filterType":"NOTIONAL","minNotional":"5.00000000"}]}]}`
+
+// TestPlaceOrder checks that order is signed and rounded to binance's steps.
+func TestPlaceOrder(t *testing.T) {
+	repo, requests := newTestServer(t, map[string]string{
+		"GET " + ExchangeInfoEndpoint: exchangeInfo,
+		"POST " + OrderEndpoint:       `{"symbol":"BTCUSDT","orderId":28,"clientOrderId":"m1-b-1","transactTime":1507725176595}`,
+	})
+
+	placed, err := repo.PlaceOrder(context.Background(), testCreds, models.PlaceOrderRequest{
+		Symbol:      "BTCUSDT",
+		Side:        "Buy",
+		OrderType:   "Limit",
+		Qty:         "0.0012345",
+		Price:       "30000.126",
+		OrderLinkId: "m1-b-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed.OrderId != "28" || placed.OrderLinkId != "m1-b-1" {
+		t.Fatalf("placed order is %+v", placed)
+	}
+
+	query := requests[OrderEndpoint][0].URL.Query()
+	if query.Get("side") != "BUY" || query.Get("type") != "LIMIT" || query.Get("timeInForce") != "GTC" ||
+		query.Get("quantity") != "0.00123" || query.Get("price") != "30000.13" || query.Get("newClientOrderId") != "m1-b-1" {
+		t.Fatalf("order request is %s", requests[OrderEndpoint][0].URL.RawQuery)
+	}
+
+	// Rules are requested once.
+	_, err = repo.PlaceOrder(context.Background(), testCreds, models.PlaceOrderRequest{Symbol: "BTCUSDT", Side: "Sell", OrderType: "Market", Qty: "0.001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests[ExchangeInfoEndpoint]) != 1 {
+		t.Fatalf("exchange info is requested %d times", len(requests[ExchangeInfoEndpoint]))
+	}
+}
+
+// TestGetOrders checks statuses and fee of binance's order in models' form.
+func TestGetOrders(t *testing.T) {
+	repo, _ := newTestServer(t, map[string]string{
+		"GET " + OrderEndpoint: `{"symbol":"BTCUSDT","orderId":28,"clientOrderId":"m1-b-1","price":"30000.00","origQty":"0.00200000",
+"executedQty":"0.00100000","cummulativeQuoteQty":"30.00000000","status":"CANCELED","timeInForce":"GTC","type":"LIMIT","side":"BUY","time":1,"updateTime":2}`,
+		"GET " + MyTradesEndpoint: `[{"symbol":"BTCUSDT","id":1,"orderId":28,"price":"30000.00","qty":"0.00060000","quoteQty":"18.00","commission":"0.00000060","commissionAsset":"BTC","time":1,"isBuyer":true},
+{"symbol":"BTCUSDT","id":2,"orderId":28,"price":"30000.00","qty":"0.00040000","quoteQty":"12.00","commission":"0.00001000","commissionAsset":"BNB","time":2,"isBuyer":true}]`,
+	})
+
+	orders, err := repo.GetOrders(context.Background(), testCreds, models.OrderQuery{Symbol: "BTCUSDT", OrderId: "28"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("got %d orders", len(orders))
+	}
+
+	order := orders[0]
+	if order.OrderStatus != models.OrderStatusPartiallyFilledCanceled || order.Side != "Buy" || order.OrderType != "Limit" ||
+		order.CumExecQty != "0.00100000" || order.AvgPrice != "30000" || order.CumExecFee != "0.0000006" {
+		t.Fatalf("order is %+v", order)
+	}
+}
+
+// TestGetExecutions checks that fee of execution is kept only if it is in coin of position,
+// fee in BNB is zero as in order's fee.
+func TestGetExecutions(t *testing.T) {
+	repo, _ := newTestServer(t, map[string]string{
+		"GET " + MyTradesEndpoint: `[{"symbol":"BTCUSDT","id":1,"orderId":28,"price":"30000.00","qty":"0.00060000","quoteQty":"18.00","commission":"0.00000060","commissionAsset":"BTC","time":1,"isBuyer":true},
+{"symbol":"BTCUSDT","id":2,"orderId":28,"price":"30000.00","qty":"0.00040000","quoteQty":"12.00","commission":"0.00001000","commissionAsset":"BNB","time":2,"isBuyer":true},
+{"symbol":"BTCUSDT","id":3,"orderId":28,"price":"31000.00","qty":"0.00050000","quoteQty":"15.50","commission":"0.01550000","commissionAsset":"USDT","time":3,"isBuyer":false},
+{"symbol":"BTCUSDT","id":4,"orderId":28,"price":"31000.00","qty":"0.00050000","quoteQty":"15.50","commission":"0.00002000","commissionAsset":"BNB","time":4,"isBuyer":false}]`,
+	})
+
+	executions, err := repo.GetExecutions(context.Background(), testCreds, "BTCUSDT", "28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 4 {
+		t.Fatalf("got %d executions", len(executions))
+	}
+
+	for i, want := range []struct {
+		side string
+		fee  string
+	}{
+		{"Buy", "0.00000060"},
+		{"Buy", "0"},
+		{"Sell", "0.01550000"},
+		{"Sell", "0"},
+	} {
+		if execution := executions[i]; execution.Side != want.side || execution.ExecFee != want.fee {
+			t.Fatalf("execution %d is %+v", i, execution)
+		}
+	}
+}
+
+// TestErrorKinds checks that binance's errors have kinds and unknown order is empty list.
+func TestErrorKinds(t *testing.T) {
+	repo, requests := newTestServer(t, map[string]string{
+		"GET " + OrderEndpoint:        `{"code":-2013,"msg":"Order does not exist."}`,
+		"POST " + OrderEndpoint:       `{"code":-2010,"msg":"Account has insufficient balance for requested action."}`,
+		"DELETE " + OrderEndpoint:     `{"code":-2011,"msg":"Unknown order sent."}`,
+		"GET " + ExchangeInfoEndpoint: exchangeInfo,
+	})
+
+	orders, err := repo.GetOrders(context.Background(), testCreds, models.OrderQuery{Symbol: "BTCUSDT", OrderLinkId: "m1-b-1"})
+	if err != nil || len(orders) != 0 {
+		t.Fatalf("got %d orders, err %v", len(orders), err)
+	}
+
+	_, err = repo.PlaceOrder(context.Background(), testCreds, models.PlaceOrderRequest{Symbol: "BTCUSDT", Side: "Buy", OrderType: "Market", Qty: "1", OrderLinkId: "m1-b-1"})
+	if kind := models.ErrorKindOf(err); kind != models.ErrorKindInsufficientFunds {
+		t.Fatalf("place order error is %v of kind %q", err, kind)
+	}
+	// Rejected order is not retried even with client id.
+	if len(requests[OrderEndpoint]) != 2 {
+		t.Fatalf("order endpoint is requested %d times", len(requests[OrderEndpoint]))
+	}
+
+	err = repo.CancelOrder(context.Background(), testCreds, "BTCUSDT", "28")
+	if kind := models.ErrorKindOf(err); kind != models.ErrorKindOrderNotFound {
+		t.Fatalf("cancel order error is %v of kind %q", err, kind)
+	}
+
+	_, err = repo.GetBalances(context.Background(), models.Credentials{ApiKey: testApiKey, SecretKey: "wrong"})
+	if kind := models.ErrorKindOf(err); kind != models.ErrorKindAuth {
+		t.Fatalf("get balances error is %v of kind %q", err, kind)
+	}
+}
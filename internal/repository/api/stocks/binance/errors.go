@@ -0,0 +1,76 @@
+package binance
+
+import (
+	"strings"
+
+	"m1pes/internal/models"
+)
+
+// Codes which binance returns in code of error's response.
+const (
+	CodeUnknown          = -1000
+	CodeDisconnected     = -1001
+	CodeUnauthorized     = -1002
+	CodeTooManyRequests  = -1003
+	CodeTimeout          = -1007
+	CodeServerBusy       = -1008
+	CodeInvalidTimestamp = -1021
+	CodeInvalidSignature = -1022
+	CodeFilterFailure    = -1013
+	CodeIllegalChars     = -1100
+	CodeMandatoryParam   = -1102
+	CodeBadPrecision     = -1111
+	CodeBadSymbol        = -1121
+
+	CodeNewOrderRejected = -2010
+	CodeCancelRejected   = -2011
+	CodeNoSuchOrder      = -2013
+	CodeBadApiKeyFormat  = -2014
+	CodeRejectedApiKey   = -2015
+)
+
+var codeKinds = map[int]models.ErrorKind{
+	CodeUnknown:          models.ErrorKindRetryable,
+	CodeDisconnected:     models.ErrorKindRetryable,
+	CodeTooManyRequests:  models.ErrorKindRetryable,
+	CodeTimeout:          models.ErrorKindRetryable,
+	CodeServerBusy:       models.ErrorKindRetryable,
+	CodeInvalidTimestamp: models.ErrorKindRetryable,
+
+	CodeUnauthorized:     models.ErrorKindAuth,
+	CodeInvalidSignature: models.ErrorKindAuth,
+	CodeBadApiKeyFormat:  models.ErrorKindAuth,
+	CodeRejectedApiKey:   models.ErrorKindAuth,
+
+	CodeCancelRejected: models.ErrorKindOrderNotFound,
+	CodeNoSuchOrder:    models.ErrorKindOrderNotFound,
+
+	CodeFilterFailure:  models.ErrorKindInvalidOrder,
+	CodeIllegalChars:   models.ErrorKindInvalidOrder,
+	CodeMandatoryParam: models.ErrorKindInvalidOrder,
+	CodeBadPrecision:   models.ErrorKindInvalidOrder,
+	CodeBadSymbol:      models.ErrorKindInvalidOrder,
+}
+
+// NewError returns error of binance's response with its kind. Rejected new order has one code
+// for all reasons, so its kind is taken from message.
+func NewError(code int, msg string) error {
+	kind := codeKinds[code]
+	if code == CodeNewOrderRejected {
+		switch {
+		case strings.Contains(msg, "insufficient balance"):
+			kind = models.ErrorKindInsufficientFunds
+		case strings.Contains(msg, "Duplicate order"):
+			kind = models.ErrorKindDuplicateOrder
+		default:
+			kind = models.ErrorKindInvalidOrder
+		}
+	}
+	return &models.ApiError{Code: code, Msg: msg, Kind: kind}
+}
+
+// rejected reports if binance has rejected request before handling it, so it can be sent again
+// even if it is not idempotent.
+func rejected(code int) bool {
+	return code == CodeTooManyRequests || code == CodeInvalidTimestamp
+}
@@ -9,9 +9,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"m1pes/internal/logging"
 	"m1pes/internal/models"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -21,28 +23,92 @@ import (
 const (
 	URL = "https://api.bybit.com"
 
-	CreateOrderEndpoint   = "/v5/order/create"
-	CancelOrderEndpoint   = "/v5/order/cancel"
-	GetOrderEndpoint      = "/v5/order/realtime"
-	GetUserWalletEndpoint = "/v5/account/wallet-balance"
-	GetCoinEndpoint       = "/v5/market/tickers"
-	GetApiKeyPermissions  = "/v5/user/query-api"
+	CreateOrderEndpoint    = "/v5/order/create"
+	CancelOrderEndpoint    = "/v5/order/cancel"
+	GetOrderEndpoint       = "/v5/order/realtime"
+	GetExecutionsEndpoint  = "/v5/execution/list"
+	GetUserWalletEndpoint  = "/v5/account/wallet-balance"
+	GetCoinEndpoint        = "/v5/market/tickers"
+	GetKlinesEndpoint      = "/v5/market/kline"
+	GetInstrumentsEndpoint = "/v5/market/instruments-info"
+	GetApiKeyPermissions   = "/v5/user/query-api"
 
 	SuccessfulOrderStatus = "Filled"
+
+	retryAttempts = 3
+	retryMinDelay = 200 * time.Millisecond
+	retryMaxDelay = 5 * time.Second
 )
 
 type Repository struct {
-	cli *http.Client
+	cli        *http.Client
+	hosts      map[string]*host
+	limiter    *limiter
+	recvWindow time.Duration
+}
+
+// host is where environment's requests are sent, every host has its own clock.
+type host struct {
+	url   string
+	clock *clock
 }
 
-func New() *Repository {
+// New creates repository which sends mainnet's requests to url, it is URL if url is empty.
+// Requests of testnet and demo trading are sent to TestnetURL and DemoURL.
+func New(url string) *Repository {
+	if url == "" {
+		url = URL
+	}
+
 	return &Repository{
 		cli: &http.Client{
 			Timeout: 5 * time.Minute,
 		},
+		hosts: map[string]*host{
+			models.EnvironmentMainnet: {url: url, clock: &clock{}},
+			models.EnvironmentTestnet: {url: TestnetURL, clock: &clock{}},
+			models.EnvironmentDemo:    {url: DemoURL, clock: &clock{}},
+		},
+		limiter:    newLimiter(),
+		recvWindow: DefaultRecvWindow,
+	}
+}
+
+// SetRecvWindow sets how long after its timestamp request is valid for bybit, window which
+// is not positive is ignored. It is not safe to call it while repository is used.
+func (r *Repository) SetRecvWindow(window time.Duration) {
+	if window > 0 {
+		r.recvWindow = window
+	}
+}
+
+// SetURL sets host of environment's requests, empty url is ignored. It is not safe to call
+// it while repository is used.
+func (r *Repository) SetURL(environment, url string) {
+	if url != "" {
+		r.hosts[environment] = &host{url: url, clock: &clock{}}
 	}
 }
 
+// Environments returns environments whose hosts are set.
+func (r *Repository) Environments() []string {
+	environments := make([]string, 0, len(r.hosts))
+	for environment := range r.hosts {
+		environments = append(environments, environment)
+	}
+	slices.Sort(environments)
+	return environments
+}
+
+// hostOf returns host of ctx's environment.
+func (r *Repository) hostOf(ctx context.Context) (*host, error) {
+	h, ok := r.hosts[environmentOf(ctx)]
+	if !ok {
+		return nil, errors.Errorf("unknown environment: %s", environmentOf(ctx))
+	}
+	return h, nil
+}
+
 func (r *Repository) GetCoin(ctx context.Context, coinReq models.GetCoinRequest, apiKey, secretKey string) (models.GetCoinResponse, error) {
 	byteParams, err := json.Marshal(coinReq)
 	if err != nil {
@@ -50,7 +116,7 @@ func (r *Repository) GetCoin(ctx context.Context, coinReq models.GetCoinRequest,
 	}
 
 	var getCoinResp models.GetCoinResponse
-	body, err := r.CreateSignRequestAndGetRespBody(string(byteParams), GetCoinEndpoint, http.MethodGet, apiKey, secretKey)
+	body, err := r.send(ctx, string(byteParams), GetCoinEndpoint, http.MethodGet, apiKey, secretKey, true)
 	if err != nil {
 		return models.GetCoinResponse{}, fmt.Errorf("get coin request failed: %w", err)
 	}
@@ -60,20 +126,71 @@ func (r *Repository) GetCoin(ctx context.Context, coinReq models.GetCoinRequest,
 		return models.GetCoinResponse{}, fmt.Errorf("unmarshal get coin response failed: %w", err)
 	}
 
-	if getCoinResp.RetMsg != "OK" {
-		return models.GetCoinResponse{}, fmt.Errorf("get coin response failed: %s", getCoinResp.RetMsg)
+	if err = NewError(getCoinResp.RetCode, getCoinResp.RetMsg); err != nil {
+		return models.GetCoinResponse{}, fmt.Errorf("get coin response failed: %w", err)
 	}
 
 	return getCoinResp, nil
 }
 
+// GetKlines requests coin's klines, it is public endpoint so keys are not needed.
+// Timestamps in request have to be strings, otherwise they are sent in exponent form.
+func (r *Repository) GetKlines(ctx context.Context, req models.GetKlinesRequest) (models.GetKlinesResponse, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return models.GetKlinesResponse{}, fmt.Errorf("marshal get klines request failed: %w", err)
+	}
+
+	body, err := r.send(ctx, string(jsonData), GetKlinesEndpoint, http.MethodGet, "", "", true)
+	if err != nil {
+		return models.GetKlinesResponse{}, fmt.Errorf("get klines request failed: %w", err)
+	}
+
+	var getKlinesResp models.GetKlinesResponse
+	err = json.Unmarshal(body, &getKlinesResp)
+	if err != nil {
+		return models.GetKlinesResponse{}, fmt.Errorf("unmarshal get klines response failed: %w", err)
+	}
+
+	if err = NewError(getKlinesResp.RetCode, getKlinesResp.RetMsg); err != nil {
+		return models.GetKlinesResponse{}, fmt.Errorf("get klines failed: %w", err)
+	}
+
+	return getKlinesResp, nil
+}
+
+// GetInstruments requests instruments info, it is public endpoint so keys are not needed.
+func (r *Repository) GetInstruments(ctx context.Context, req models.GetInstrumentsRequest) (models.GetInstrumentsResponse, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return models.GetInstrumentsResponse{}, fmt.Errorf("marshal get instruments request failed: %w", err)
+	}
+
+	body, err := r.send(ctx, string(jsonData), GetInstrumentsEndpoint, http.MethodGet, "", "", true)
+	if err != nil {
+		return models.GetInstrumentsResponse{}, fmt.Errorf("get instruments request failed: %w", err)
+	}
+
+	var getInstrumentsResp models.GetInstrumentsResponse
+	err = json.Unmarshal(body, &getInstrumentsResp)
+	if err != nil {
+		return models.GetInstrumentsResponse{}, fmt.Errorf("unmarshal get instruments response failed: %w", err)
+	}
+
+	if err = NewError(getInstrumentsResp.RetCode, getInstrumentsResp.RetMsg); err != nil {
+		return models.GetInstrumentsResponse{}, fmt.Errorf("get instruments failed: %w", err)
+	}
+
+	return getInstrumentsResp, nil
+}
+
 func (r *Repository) GetUserWalletBalance(ctx context.Context, req models.GetUserWalletRequest, apiKey, secretKey string) (models.GetUserWalletResponse, error) {
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return models.GetUserWalletResponse{}, fmt.Errorf("marshal get user's wallet request failed: %w", err)
 	}
 
-	body, err := r.CreateSignRequestAndGetRespBody(string(jsonData), GetUserWalletEndpoint, http.MethodGet, apiKey, secretKey)
+	body, err := r.send(ctx, string(jsonData), GetUserWalletEndpoint, http.MethodGet, apiKey, secretKey, true)
 	if err != nil {
 		return models.GetUserWalletResponse{}, fmt.Errorf("get user's wallet request failed: %w", err)
 	}
@@ -85,8 +202,8 @@ func (r *Repository) GetUserWalletBalance(ctx context.Context, req models.GetUse
 		return models.GetUserWalletResponse{}, fmt.Errorf("unmarshal get user's wallet response failed: %w", err)
 	}
 
-	if getUserWalletResp.RetMsg != "OK" {
-		return models.GetUserWalletResponse{}, fmt.Errorf("get user's wallet failed: %s", getUserWalletResp.RetMsg)
+	if err = NewError(getUserWalletResp.RetCode, getUserWalletResp.RetMsg); err != nil {
+		return models.GetUserWalletResponse{}, fmt.Errorf("get user's wallet failed: %w", err)
 	}
 
 	return getUserWalletResp, nil
@@ -98,7 +215,8 @@ func (r *Repository) CreateOrder(ctx context.Context, orderReq models.CreateOrde
 		return models.CreateOrderResponse{}, logging.WrapError(ctx, fmt.Errorf("marshal order request failed: %w", err))
 	}
 
-	body, err := r.CreateSignRequestAndGetRespBody(string(jsonData), CreateOrderEndpoint, http.MethodPost, apiKey, secretKey)
+	// Order with orderLinkId is not placed twice, bybit rejects duplicate.
+	body, err := r.send(ctx, string(jsonData), CreateOrderEndpoint, http.MethodPost, apiKey, secretKey, orderReq.OrderLinkId != "")
 	if err != nil {
 		return models.CreateOrderResponse{}, fmt.Errorf("create order request failed: %w", err)
 	}
@@ -109,8 +227,8 @@ func (r *Repository) CreateOrder(ctx context.Context, orderReq models.CreateOrde
 		return models.CreateOrderResponse{}, fmt.Errorf("unmarshal create order response failed: %w", err)
 	}
 
-	if createOrderResp.RetMsg != "OK" {
-		return models.CreateOrderResponse{}, fmt.Errorf("create order failed: %s", createOrderResp.RetMsg)
+	if err = NewError(createOrderResp.RetCode, createOrderResp.RetMsg); err != nil {
+		return models.CreateOrderResponse{}, fmt.Errorf("create order failed: %w", err)
 	}
 
 	return createOrderResp, nil
@@ -122,7 +240,7 @@ func (r *Repository) CancelOrder(ctx context.Context, orderReq models.CancelOrde
 		return models.CancelOrderResponse{}, fmt.Errorf("marshal cancel order request failed: %w", err)
 	}
 
-	body, err := r.CreateSignRequestAndGetRespBody(string(jsonData), CancelOrderEndpoint, http.MethodPost, apiKey, secretKey)
+	body, err := r.send(ctx, string(jsonData), CancelOrderEndpoint, http.MethodPost, apiKey, secretKey, false)
 	if err != nil {
 		return models.CancelOrderResponse{}, fmt.Errorf("create cancel order request failed: %w", err)
 	}
@@ -133,8 +251,8 @@ func (r *Repository) CancelOrder(ctx context.Context, orderReq models.CancelOrde
 		return models.CancelOrderResponse{}, fmt.Errorf("unmarshal cancel order response failed: %w", err)
 	}
 
-	if cancelOrderResp.RetMsg != "OK" {
-		return models.CancelOrderResponse{}, fmt.Errorf("cancel order failed: %s", cancelOrderResp.RetMsg)
+	if err = NewError(cancelOrderResp.RetCode, cancelOrderResp.RetMsg); err != nil {
+		return models.CancelOrderResponse{}, fmt.Errorf("cancel order failed: %w", err)
 	}
 
 	return cancelOrderResp, nil
@@ -146,7 +264,7 @@ func (r *Repository) GetOrder(ctx context.Context, orderReq models.GetOrderReque
 		return models.GetOrderResponse{}, fmt.Errorf("marshalling order request failed: %w", err)
 	}
 
-	body, err := r.CreateSignRequestAndGetRespBody(string(jsonData), GetOrderEndpoint, http.MethodGet, apiKey, secretKey)
+	body, err := r.send(ctx, string(jsonData), GetOrderEndpoint, http.MethodGet, apiKey, secretKey, true)
 	if err != nil {
 		return models.GetOrderResponse{}, fmt.Errorf("create order request failed: %w", err)
 	}
@@ -158,14 +276,106 @@ func (r *Repository) GetOrder(ctx context.Context, orderReq models.GetOrderReque
 		return models.GetOrderResponse{}, fmt.Errorf("unmarshal order response failed: %w", err)
 	}
 
-	if getOrderResp.RetMsg != "OK" {
-		return models.GetOrderResponse{}, fmt.Errorf("get order failed: %s", getOrderResp.RetMsg)
+	if err = NewError(getOrderResp.RetCode, getOrderResp.RetMsg); err != nil {
+		return models.GetOrderResponse{}, fmt.Errorf("get order failed: %w", err)
 	}
 
 	return getOrderResp, nil
 }
 
-func (r *Repository) CreateSignRequestAndGetRespBody(params, endPoint, method, apiKey, apiSecret string) ([]byte, error) {
+func (r *Repository) GetExecutions(ctx context.Context, req models.GetExecutionsRequest, apiKey, secretKey string) (models.GetExecutionsResponse, error) {
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return models.GetExecutionsResponse{}, fmt.Errorf("marshal get executions request failed: %w", err)
+	}
+
+	body, err := r.send(ctx, string(jsonData), GetExecutionsEndpoint, http.MethodGet, apiKey, secretKey, true)
+	if err != nil {
+		return models.GetExecutionsResponse{}, fmt.Errorf("get executions request failed: %w", err)
+	}
+
+	var getExecutionsResp models.GetExecutionsResponse
+	err = json.Unmarshal(body, &getExecutionsResp)
+	if err != nil {
+		return models.GetExecutionsResponse{}, fmt.Errorf("unmarshal get executions response failed: %w", err)
+	}
+
+	if err = NewError(getExecutionsResp.RetCode, getExecutionsResp.RetMsg); err != nil {
+		return models.GetExecutionsResponse{}, fmt.Errorf("get executions failed: %w", err)
+	}
+
+	return getExecutionsResp, nil
+}
+
+// CreateSignRequestAndGetRespBody sends signed request and returns body of response. GET
+// requests are idempotent, so they are retried on failure.
+func (r *Repository) CreateSignRequestAndGetRespBody(ctx context.Context, params, endPoint, method, apiKey, apiSecret string) ([]byte, error) {
+	return r.send(ctx, params, endPoint, method, apiKey, apiSecret, method == http.MethodGet)
+}
+
+// send waits for rate limit of api key's endpoint and sends request. Requests which bybit has
+// rejected by rate limit are not handled, so they are retried always. Request which is rejected
+// by timestamp is signed again once after clock is synced with server. Requests which have failed
+// on the way or on server could be handled, so they are retried only if retry is true: request
+// is idempotent or it is order with orderLinkId.
+func (r *Repository) send(ctx context.Context, params, endPoint, method, apiKey, apiSecret string, retry bool) ([]byte, error) {
+	h, err := r.hostOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := r.limiter.bucket(environmentOf(ctx), apiKey, endPoint)
+	resynced := false
+
+	for attempt := 0; ; attempt++ {
+		if err := bucket.wait(ctx); err != nil {
+			return nil, errors.Wrap(err, "failed wait for rate limit")
+		}
+
+		data, header, err := r.do(ctx, h, params, endPoint, method, apiKey, apiSecret)
+		if header != nil {
+			bucket.update(header, time.Now())
+		}
+
+		code, msg := retStatus(data)
+		if code == RetCodeTimestampExpired && !resynced {
+			resynced = true
+			slog.WarnContext(ctx, "bybit timestamp expired, syncing time", "endpoint", endPoint, "msg", msg)
+
+			if err := r.SyncTime(ctx); err != nil {
+				slog.WarnContext(ctx, "bybit time sync failed", "err", err)
+			}
+			continue
+		}
+
+		// Timestamp which has expired after sync is not retried anymore.
+		transient := retry && err != nil
+		if code != RetCodeTimestampExpired && (rejected(code) || (retry && retCodeKinds[code] == models.ErrorKindRetryable)) {
+			transient = true
+			err = NewError(code, msg)
+		}
+		if !transient || attempt+1 >= retryAttempts {
+			// Rejected response is returned as is, caller checks its retCode.
+			if data != nil {
+				return data, nil
+			}
+			return nil, err
+		}
+
+		delay := backoff(attempt)
+		slog.WarnContext(ctx, "bybit request failed, retrying", "endpoint", endPoint, "attempt", attempt+1, "delay", delay, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "failed wait for retry")
+		case <-time.After(delay):
+		}
+	}
+}
+
+// do sends request signed by host's clock to host once, server error is returned as error with
+// response's headers.
+func (r *Repository) do(ctx context.Context, h *host, params, endPoint, method, apiKey, apiSecret string) ([]byte, http.Header, error) {
 	var request *http.Request
 	switch method {
 	case http.MethodGet:
@@ -174,7 +384,7 @@ func (r *Repository) CreateSignRequestAndGetRespBody(params, endPoint, method, a
 
 			err := json.Unmarshal([]byte(params), &paramsMap)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
 			params = ""
@@ -188,31 +398,32 @@ func (r *Repository) CreateSignRequestAndGetRespBody(params, endPoint, method, a
 				params += fmt.Sprintf("&%s=%v", key, val)
 			}
 
-			request, err = http.NewRequest(method, URL+endPoint+"?"+params, nil)
+			request, err = http.NewRequestWithContext(ctx, method, h.url+endPoint+"?"+params, nil)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed create new request")
+				return nil, nil, errors.Wrap(err, "failed create new request")
 			}
 		} else {
-			req, err := http.NewRequest(method, URL+endPoint, nil)
+			req, err := http.NewRequestWithContext(ctx, method, h.url+endPoint, nil)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed create new request")
+				return nil, nil, errors.Wrap(err, "failed create new request")
 			}
 			request = req
 		}
 	case http.MethodPost:
-		newRequest, err := http.NewRequest(method, URL+endPoint, bytes.NewBuffer([]byte(params)))
+		newRequest, err := http.NewRequestWithContext(ctx, method, h.url+endPoint, bytes.NewBuffer([]byte(params)))
 		if err != nil {
-			return nil, errors.Wrap(err, "failed create new request")
+			return nil, nil, errors.Wrap(err, "failed create new request")
 		}
 
 		request = newRequest
 	default:
-		return nil, errors.Errorf("unsupported method: %s", method)
+		return nil, nil, errors.Errorf("unsupported method: %s", method)
 	}
 
-	timestamp := time.Now().UnixMilli()
+	timestamp := h.clock.now().UnixMilli()
+	recvWindow := strconv.FormatInt(r.recvWindow.Milliseconds(), 10)
 	hmac256 := hmac.New(sha256.New, []byte(apiSecret))
-	hmac256.Write([]byte(strconv.FormatInt(timestamp, 10) + apiKey + "5000" + params))
+	hmac256.Write([]byte(strconv.FormatInt(timestamp, 10) + apiKey + recvWindow + params))
 	signature := hex.EncodeToString(hmac256.Sum(nil))
 
 	request.Header.Set("Content-Type", "application/json")
@@ -220,18 +431,35 @@ func (r *Repository) CreateSignRequestAndGetRespBody(params, endPoint, method, a
 	request.Header.Set("X-BAPI-SIGN", signature)
 	request.Header.Set("X-BAPI-TIMESTAMP", strconv.FormatInt(timestamp, 10))
 	request.Header.Set("X-BAPI-SIGN-TYPE", "2")
-	request.Header.Set("X-BAPI-RECV-WINDOW", "5000")
+	request.Header.Set("X-BAPI-RECV-WINDOW", recvWindow)
 
+	sent := time.Now()
 	resp, err := r.cli.Do(request)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed do request")
+		return nil, nil, errors.Wrap(err, "failed do request")
 	}
 	defer resp.Body.Close()
 
+	h.clock.observeHeader(resp.Header, sent, time.Now())
+
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed read body")
+		return nil, resp.Header, errors.Wrap(err, "failed read body")
 	}
 
-	return data, err
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return nil, resp.Header, fmt.Errorf("bad response status: %s", resp.Status)
+	}
+
+	return data, resp.Header, nil
+}
+
+// retStatus returns retCode and retMsg of bybit's response, code is 0 if response can't be parsed.
+func retStatus(data []byte) (int, string) {
+	var resp struct {
+		RetCode int    `json:"retCode"`
+		RetMsg  string `json:"retMsg"`
+	}
+	_ = json.Unmarshal(data, &resp)
+	return resp.RetCode, resp.RetMsg
 }
@@ -0,0 +1,338 @@
+// Package bybittest provides fake Bybit v5 api for tests. Orders are matched by simulated
+// exchange, so limit orders are filled when test moves price to them.
+package bybittest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"m1pes/internal/models"
+	"m1pes/internal/repository/api/stocks/bybit"
+	"m1pes/internal/repository/api/stocks/sim"
+)
+
+// Codes which Bybit returns in retCode.
+const (
+	RetCodeOK                  = bybit.RetCodeOK
+	RetCodeParamsError         = bybit.RetCodeParamsError
+	RetCodeTimestampExpired    = bybit.RetCodeTimestampExpired
+	RetCodeInvalidApiKey       = bybit.RetCodeInvalidApiKey
+	RetCodeInvalidSign         = bybit.RetCodeInvalidSign
+	RetCodeRateLimit           = bybit.RetCodeRateLimit
+	RetCodeServerError         = bybit.RetCodeServerError
+	RetCodeInsufficientBalance = bybit.RetCodeInsufficientBalance
+	RetCodeDuplicateLinkId     = bybit.RetCodeDuplicateLinkId
+	RetCodeOrderValueTooLow    = bybit.RetCodeOrderValueTooLow
+	RetCodeOrderNotExists      = bybit.RetCodeSpotOrderNotExists
+)
+
+// Error is injected instead of endpoint's response. If Status is not 0 and not 200,
+// it is sent as http status with empty body. If Lost is true request is handled and only
+// its response is replaced, as if it has been lost on the way back.
+type Error struct {
+	Status  int
+	RetCode int
+	RetMsg  string
+	Lost    bool
+}
+
+// Server is fake Bybit v5 api. Requests of private endpoints have to be signed by
+// key of account which has been added by AddAccount. Streams are served on PublicStreamURL
+// and PrivateStreamURL, their events are sent by test.
+type Server struct {
+	URL              string
+	PublicStreamURL  string
+	PrivateStreamURL string
+
+	srv      *httptest.Server
+	exchange *sim.Exchange
+
+	mu       sync.Mutex
+	secrets  map[string]string
+	paths    map[string][]float64
+	errors   map[string][]Error
+	requests map[string]int
+	streams  map[*streamConn]struct{}
+}
+
+func NewServer() *Server {
+	s := &Server{
+		exchange: sim.New(sim.Fees{Maker: sim.DefaultMakerFee, Taker: sim.DefaultTakerFee}),
+		secrets:  make(map[string]string),
+		paths:    make(map[string][]float64),
+		errors:   make(map[string][]Error),
+		requests: make(map[string]int),
+		streams:  make(map[*streamConn]struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(bybit.CreateOrderEndpoint, s.private(s.createOrder))
+	mux.HandleFunc(bybit.CancelOrderEndpoint, s.private(s.cancelOrder))
+	mux.HandleFunc(bybit.GetOrderEndpoint, s.private(s.getOrder))
+	mux.HandleFunc(bybit.GetExecutionsEndpoint, s.private(s.getExecutions))
+	mux.HandleFunc(bybit.GetUserWalletEndpoint, s.private(s.getWallet))
+	mux.HandleFunc(bybit.GetApiKeyPermissions, s.private(s.getApiKeyPermissions))
+	mux.HandleFunc(bybit.GetCoinEndpoint, s.public(s.getTickers))
+	mux.HandleFunc(PublicStreamPath, s.stream(false))
+	mux.HandleFunc(PrivateStreamPath, s.stream(true))
+
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	s.PublicStreamURL = "ws" + strings.TrimPrefix(s.URL, "http") + PublicStreamPath
+	s.PrivateStreamURL = "ws" + strings.TrimPrefix(s.URL, "http") + PrivateStreamPath
+
+	return s
+}
+
+func (s *Server) Close() {
+	s.DropStreams()
+	s.srv.Close()
+}
+
+// Exchange returns simulated exchange which serves server's accounts.
+func (s *Server) Exchange() *sim.Exchange {
+	return s.exchange
+}
+
+// AddAccount adds account with USDT balance.
+func (s *Server) AddAccount(apiKey, secretKey string, balance float64) {
+	s.mu.Lock()
+	s.secrets[apiKey] = secretKey
+	s.mu.Unlock()
+
+	s.exchange.Deposit(apiKey, sim.QuoteCoin, balance)
+}
+
+// SetPrice sets last price of symbol, limit orders which are reached by it are filled.
+func (s *Server) SetPrice(symbol string, price float64) {
+	s.exchange.SetPrice(symbol, price)
+}
+
+// SetPricePath scripts next prices of symbol, they are set one by one by Step.
+func (s *Server) SetPricePath(symbol string, prices ...float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.paths[symbol] = append([]float64(nil), prices...)
+}
+
+// Step sets next price of symbol's path, false is returned when path is over.
+func (s *Server) Step(symbol string) (float64, bool) {
+	s.mu.Lock()
+	path := s.paths[symbol]
+	if len(path) == 0 {
+		s.mu.Unlock()
+		return 0, false
+	}
+	price := path[0]
+	s.paths[symbol] = path[1:]
+	s.mu.Unlock()
+
+	s.exchange.SetPrice(symbol, price)
+	return price, true
+}
+
+// FailNext makes next request to endpoint fail with err, errors are returned in order
+// they have been added.
+func (s *Server) FailNext(endpoint string, err Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors[endpoint] = append(s.errors[endpoint], err)
+}
+
+// Requests returns how many requests have come to endpoint, rejected ones included.
+func (s *Server) Requests(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[endpoint]
+}
+
+type handlerFunc func(ctx context.Context, apiKey string, params map[string]interface{}, body []byte) (interface{}, error)
+
+func (s *Server) public(handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, r, false, handler)
+	}
+}
+
+func (s *Server) private(handler handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serve(w, r, true, handler)
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request, auth bool, handler handlerFunc) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, RetCodeParamsError, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	var injected *Error
+	if queue := s.errors[r.URL.Path]; len(queue) > 0 {
+		injected = &queue[0]
+		s.errors[r.URL.Path] = queue[1:]
+	}
+	s.mu.Unlock()
+
+	if injected != nil && !injected.Lost {
+		if injected.Status != 0 && injected.Status != http.StatusOK {
+			w.WriteHeader(injected.Status)
+			return
+		}
+		if injected.RetCode == RetCodeRateLimit {
+			// Limit of endpoint is exhausted until reset timestamp, as bybit reports it.
+			w.Header().Set("X-Bapi-Limit", "10")
+			w.Header().Set("X-Bapi-Limit-Status", "0")
+			w.Header().Set("X-Bapi-Limit-Reset-Timestamp", strconv.FormatInt(time.Now().Add(100*time.Millisecond).UnixMilli(), 10))
+		}
+		writeError(w, injected.RetCode, injected.RetMsg)
+		return
+	}
+
+	apiKey := r.Header.Get("X-BAPI-API-KEY")
+	if auth {
+		payload := r.URL.RawQuery
+		if r.Method == http.MethodPost {
+			payload = string(body)
+		}
+
+		if retCode, retMsg := s.verify(r.Header, payload); retCode != RetCodeOK {
+			writeError(w, retCode, retMsg)
+			return
+		}
+	}
+
+	params := make(map[string]interface{})
+	for key, values := range r.URL.Query() {
+		params[key] = values[0]
+	}
+
+	resp, err := handler(r.Context(), apiKey, params, body)
+	if injected != nil {
+		if injected.Status != 0 && injected.Status != http.StatusOK {
+			w.WriteHeader(injected.Status)
+			return
+		}
+		writeError(w, injected.RetCode, injected.RetMsg)
+		return
+	}
+	if err != nil {
+		// Simulated exchange returns errors of bybit, other errors are bad params.
+		var apiErr *models.ApiError
+		if !errors.As(err, &apiErr) {
+			apiErr = &models.ApiError{Code: RetCodeParamsError, Msg: err.Error()}
+		}
+		writeError(w, apiErr.Code, apiErr.Msg)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// verify checks signature as Bybit does: HMAC-SHA256 of timestamp, api key, recv window
+// and query string or body.
+func (s *Server) verify(header http.Header, payload string) (int, string) {
+	apiKey := header.Get("X-BAPI-API-KEY")
+
+	s.mu.Lock()
+	secret, ok := s.secrets[apiKey]
+	s.mu.Unlock()
+	if !ok {
+		return RetCodeInvalidApiKey, "API key is invalid."
+	}
+
+	timestamp := header.Get("X-BAPI-TIMESTAMP")
+	recvWindow := header.Get("X-BAPI-RECV-WINDOW")
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return RetCodeParamsError, "invalid timestamp"
+	}
+
+	window, err := strconv.ParseInt(recvWindow, 10, 64)
+	if err != nil {
+		window = 5000
+	}
+
+	if diff := time.Now().UnixMilli() - ts; diff > window || diff < -1000 {
+		return RetCodeTimestampExpired, "invalid request, please check your server timestamp or recv_window param"
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + apiKey + recvWindow + payload))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(header.Get("X-BAPI-SIGN"))) {
+		return RetCodeInvalidSign, "error sign! origin_string[" + timestamp + apiKey + recvWindow + payload + "]"
+	}
+
+	return RetCodeOK, ""
+}
+
+func (s *Server) createOrder(ctx context.Context, apiKey string, params map[string]interface{}, body []byte) (interface{}, error) {
+	var req models.CreateOrderRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	return s.exchange.CreateOrder(ctx, req, apiKey, "")
+}
+
+func (s *Server) cancelOrder(ctx context.Context, apiKey string, params map[string]interface{}, body []byte) (interface{}, error) {
+	var req models.CancelOrderRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, err
+	}
+	return s.exchange.CancelOrder(ctx, req, apiKey, "")
+}
+
+func (s *Server) getOrder(ctx context.Context, apiKey string, params map[string]interface{}, body []byte) (interface{}, error) {
+	return s.exchange.GetOrder(ctx, params, apiKey, "")
+}
+
+func (s *Server) getExecutions(ctx context.Context, apiKey string, params map[string]interface{}, body []byte) (interface{}, error) {
+	return s.exchange.GetExecutions(ctx, params, apiKey, "")
+}
+
+func (s *Server) getWallet(ctx context.Context, apiKey string, params map[string]interface{}, body []byte) (interface{}, error) {
+	return s.exchange.GetUserWalletBalance(ctx, params, apiKey, "")
+}
+
+func (s *Server) getTickers(ctx context.Context, apiKey string, params map[string]interface{}, body []byte) (interface{}, error) {
+	return s.exchange.GetCoin(ctx, params, apiKey, "")
+}
+
+// getApiKeyPermissions gives every key all permissions which bot asks for.
+func (s *Server) getApiKeyPermissions(ctx context.Context, apiKey string, params map[string]interface{}, body []byte) (interface{}, error) {
+	var resp models.GetApiKeyPermissionsResponse
+	resp.RetMsg = "OK"
+	resp.Result.Permissions.Spot = []string{"SpotTrade"}
+	resp.Result.Permissions.Wallet = []string{"AccountTransfer", "Withdraw"}
+
+	return resp, nil
+}
+
+func writeError(w http.ResponseWriter, retCode int, retMsg string) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"retCode":    retCode,
+		"retMsg":     retMsg,
+		"result":     map[string]interface{}{},
+		"retExtInfo": map[string]interface{}{},
+		"time":       time.Now().UnixMilli(),
+	})
+}
@@ -0,0 +1,201 @@
+package bybittest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"m1pes/internal/models"
+	"m1pes/internal/repository/api/stocks/bybit"
+)
+
+// Paths of Bybit's v5 streams.
+const (
+	PublicStreamPath  = "/v5/public/spot"
+	PrivateStreamPath = "/v5/private"
+)
+
+var upgrader = websocket.Upgrader{}
+
+// streamConn is client's connection to stream. Connection to private stream gets events only
+// after it has been authorized by key of account.
+type streamConn struct {
+	ws      *websocket.Conn
+	private bool
+	writeMu sync.Mutex
+
+	// apiKey and topics are guarded by server's mu.
+	apiKey string
+	topics map[string]bool
+}
+
+type streamRequest struct {
+	Op   string   `json:"op"`
+	Args []string `json:"args"`
+}
+
+type streamResponse struct {
+	Op      string      `json:"op"`
+	Success *bool       `json:"success,omitempty"`
+	RetMsg  string      `json:"ret_msg,omitempty"`
+	Topic   string      `json:"topic,omitempty"`
+	Ts      int64       `json:"ts,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (c *streamConn) write(resp streamResponse) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_ = c.ws.WriteJSON(resp)
+}
+
+// stream serves Bybit's websocket: auth, subscribe, unsubscribe and ping operations.
+func (s *Server) stream(private bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		conn := &streamConn{ws: ws, private: private, topics: make(map[string]bool)}
+
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		s.streams[conn] = struct{}{}
+		s.mu.Unlock()
+
+		defer func() {
+			s.mu.Lock()
+			delete(s.streams, conn)
+			s.mu.Unlock()
+			_ = ws.Close()
+		}()
+
+		for {
+			var req streamRequest
+			if err := ws.ReadJSON(&req); err != nil {
+				return
+			}
+
+			resp := streamResponse{Op: req.Op, Success: new(bool)}
+			switch req.Op {
+			case "ping":
+				resp.RetMsg = "pong"
+				*resp.Success = true
+			case "auth":
+				if retMsg := s.verifyStream(req.Args); retMsg != "" {
+					resp.RetMsg = retMsg
+					break
+				}
+
+				s.mu.Lock()
+				conn.apiKey = req.Args[0]
+				s.mu.Unlock()
+				*resp.Success = true
+			case "subscribe", "unsubscribe":
+				s.mu.Lock()
+				if private && conn.apiKey == "" {
+					s.mu.Unlock()
+					resp.RetMsg = "Request not authorized"
+					break
+				}
+				for _, topic := range req.Args {
+					conn.topics[topic] = req.Op == "subscribe"
+				}
+				s.mu.Unlock()
+				*resp.Success = true
+			default:
+				resp.RetMsg = "unknown operation " + req.Op
+			}
+
+			conn.write(resp)
+		}
+	}
+}
+
+// verifyStream checks auth of private stream as Bybit does: HMAC-SHA256 of "GET/realtime"
+// and expiration time. Empty message means that key is accepted.
+func (s *Server) verifyStream(args []string) string {
+	if len(args) != 3 {
+		return "invalid auth args"
+	}
+	apiKey, expires, signature := args[0], args[1], args[2]
+
+	s.mu.Lock()
+	secret, ok := s.secrets[apiKey]
+	s.mu.Unlock()
+	if !ok {
+		return "Invalid apikey"
+	}
+
+	ms, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || ms < time.Now().UnixMilli() {
+		return "Params Error"
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("GET/realtime" + expires))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(signature)) {
+		return "Invalid sign"
+	}
+
+	return ""
+}
+
+// Subscribed returns how many connections are subscribed to topic.
+func (s *Server) Subscribed(topic string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for conn := range s.streams {
+		if conn.topics[topic] {
+			n++
+		}
+	}
+	return n
+}
+
+// SendTicker sends last price of symbol to connections which are subscribed to its tickers.
+func (s *Server) SendTicker(symbol string, price float64) {
+	event := models.TickerEvent{Symbol: symbol, Price: strconv.FormatFloat(price, 'f', -1, 64)}
+	s.send(bybit.TickersTopic+symbol, "", event)
+}
+
+// SendPrivate sends data of private topic, e.g. orders, to connections of account.
+func (s *Server) SendPrivate(apiKey, topic string, data interface{}) {
+	s.send(topic, apiKey, data)
+}
+
+func (s *Server) send(topic, apiKey string, data interface{}) {
+	s.mu.Lock()
+	conns := make([]*streamConn, 0)
+	for conn := range s.streams {
+		if conn.topics[topic] && conn.private == (apiKey != "") && conn.apiKey == apiKey {
+			conns = append(conns, conn)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.write(streamResponse{Topic: topic, Ts: time.Now().UnixMilli(), Data: data})
+	}
+}
+
+// DropStreams closes all connections to streams as if they have been lost.
+func (s *Server) DropStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.streams {
+		_ = conn.ws.Close()
+		delete(s.streams, conn)
+	}
+}
@@ -0,0 +1,93 @@
+package bybit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ServerTimeEndpoint = "/v5/market/time"
+
+	// DefaultRecvWindow is how long after its timestamp request is valid for bybit.
+	DefaultRecvWindow = 5 * time.Second
+
+	serverTimeHeader = "Timenow"
+)
+
+// clock is local time corrected by offset of bybit's server time, requests are signed by it.
+type clock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return time.Now().Add(c.offset)
+}
+
+// observe sets offset by server time of response to request which has been sent at sent and
+// received at received, server time is taken as the middle of round trip.
+func (c *clock) observe(server, sent, received time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.offset = server.Sub(sent.Add(received.Sub(sent) / 2))
+}
+
+// observeHeader sets offset by server time in header of response, header without it is ignored.
+func (c *clock) observeHeader(header http.Header, sent, received time.Time) {
+	ms, err := strconv.ParseInt(header.Get(serverTimeHeader), 10, 64)
+	if err != nil || ms <= 0 {
+		return
+	}
+	c.observe(time.UnixMilli(ms), sent, received)
+}
+
+// SyncTime sets offset of clock by bybit's server time, clock of ctx's environment is synced.
+func (r *Repository) SyncTime(ctx context.Context) error {
+	h, err := r.hostOf(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := r.limiter.bucket(environmentOf(ctx), "", ServerTimeEndpoint).wait(ctx); err != nil {
+		return errors.Wrap(err, "failed wait for rate limit")
+	}
+
+	sent := time.Now()
+	data, _, err := r.do(ctx, h, "", ServerTimeEndpoint, http.MethodGet, "", "")
+	if err != nil {
+		return errors.Wrap(err, "failed get server time")
+	}
+	received := time.Now()
+
+	var resp struct {
+		RetCode int    `json:"retCode"`
+		RetMsg  string `json:"retMsg"`
+		Result  struct {
+			TimeNano string `json:"timeNano"`
+		} `json:"result"`
+	}
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return errors.Wrap(err, "failed unmarshal server time")
+	}
+	if err = NewError(resp.RetCode, resp.RetMsg); err != nil {
+		return err
+	}
+
+	nano, err := strconv.ParseInt(resp.Result.TimeNano, 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "failed parse server time")
+	}
+
+	h.clock.observe(time.Unix(0, nano), sent, received)
+	return nil
+}
@@ -0,0 +1,140 @@
+package bybit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"m1pes/internal/models"
+)
+
+// TestTimestampResync checks that request which bybit rejects by timestamp is signed again
+// once by server's time and recv window is configured.
+func TestTimestampResync(t *testing.T) {
+	// Server's clock is ahead of local one.
+	const drift = time.Hour
+
+	var syncs, requests int
+	var expired bool
+	var recvWindows []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		now := time.Now().Add(drift)
+
+		if expired {
+			requests++
+			_, _ = w.Write([]byte(`{"retCode":10002,"retMsg":"invalid request, please check your server timestamp or recv_window param"}`))
+			return
+		}
+
+		if req.URL.Path == ServerTimeEndpoint {
+			syncs++
+			_, _ = fmt.Fprintf(w, `{"retCode":0,"retMsg":"OK","result":{"timeSecond":"%d","timeNano":"%d"}}`, now.Unix(), now.UnixNano())
+			return
+		}
+
+		requests++
+		recvWindows = append(recvWindows, req.Header.Get("X-BAPI-RECV-WINDOW"))
+
+		timestamp, _ := strconv.ParseInt(req.Header.Get("X-BAPI-TIMESTAMP"), 10, 64)
+		window, _ := strconv.ParseInt(req.Header.Get("X-BAPI-RECV-WINDOW"), 10, 64)
+		if diff := now.UnixMilli() - timestamp; diff > window || diff < -1000 {
+			_, _ = w.Write([]byte(`{"retCode":10002,"retMsg":"invalid request, please check your server timestamp or recv_window param"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"retCode":0,"retMsg":"OK","result":{"category":"spot","list":[{"symbol":"BTCUSDT","lastPrice":"30000"}]}}`))
+	}))
+	defer server.Close()
+
+	repo := New(server.URL)
+	repo.SetRecvWindow(10 * time.Second)
+	exchange := NewExchange(repo, repo)
+	creds := models.Credentials{Exchange: models.ExchangeBybit, ApiKey: "key", SecretKey: "secret"}
+
+	for i := 0; i < 2; i++ {
+		price, err := exchange.GetPrice(context.Background(), creds, "BTCUSDT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if price != 30000 {
+			t.Fatalf("price is %v", price)
+		}
+	}
+
+	// Clock is synced once, the second request is signed by synced clock.
+	if syncs != 1 || requests != 3 {
+		t.Fatalf("time is synced %d times for %d requests", syncs, requests)
+	}
+	for _, window := range recvWindows {
+		if window != "10000" {
+			t.Fatalf("recv window is %s", window)
+		}
+	}
+
+	// Request is signed again only once if timestamp is still expired.
+	expired = true
+
+	_, err := exchange.GetPrice(context.Background(), creds, "BTCUSDT")
+	if kind := models.ErrorKindOf(err); kind != models.ErrorKindRetryable {
+		t.Fatalf("get price error is %v of kind %q", err, kind)
+	}
+	if requests != 6 {
+		t.Fatalf("got %d requests", requests)
+	}
+}
+
+// newDriftedHost serves time and ticker of environment whose clock is ahead of local one by
+// drift, it sends differences between its time and timestamps of requests to diffs.
+func newDriftedHost(t *testing.T, drift time.Duration, diffs *[]time.Duration) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		now := time.Now().Add(drift)
+
+		if req.URL.Path == ServerTimeEndpoint {
+			_, _ = fmt.Fprintf(w, `{"retCode":0,"retMsg":"OK","result":{"timeSecond":"%d","timeNano":"%d"}}`, now.Unix(), now.UnixNano())
+			return
+		}
+
+		timestamp, _ := strconv.ParseInt(req.Header.Get("X-BAPI-TIMESTAMP"), 10, 64)
+		*diffs = append(*diffs, now.Sub(time.UnixMilli(timestamp)))
+		_, _ = w.Write([]byte(`{"retCode":0,"retMsg":"OK","result":{"category":"spot","list":[{"symbol":"BTCUSDT","lastPrice":"30000"}]}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// TestClockPerEnvironment checks that every environment is signed by time of its own server.
+func TestClockPerEnvironment(t *testing.T) {
+	var mainnetDiffs, testnetDiffs []time.Duration
+
+	repo := New(newDriftedHost(t, time.Hour, &mainnetDiffs))
+	repo.SetURL(models.EnvironmentTestnet, newDriftedHost(t, -time.Hour, &testnetDiffs))
+	exchange := NewExchange(repo, repo)
+
+	environments := []string{models.EnvironmentMainnet, models.EnvironmentTestnet}
+
+	// Both clocks are synced before requests, so one could not overwrite the other.
+	for _, environment := range environments {
+		if err := repo.SyncTime(WithEnvironment(context.Background(), environment)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, environment := range environments {
+		creds := models.Credentials{Exchange: models.ExchangeBybit, Environment: environment, ApiKey: "key", SecretKey: "secret"}
+		if _, err := exchange.GetPrice(context.Background(), creds, "BTCUSDT"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, diffs := range [][]time.Duration{mainnetDiffs, testnetDiffs} {
+		if len(diffs) != 1 || diffs[0] > time.Second || diffs[0] < -time.Second {
+			t.Fatalf("timestamps of mainnet differ by %v, of testnet by %v", mainnetDiffs, testnetDiffs)
+		}
+	}
+}
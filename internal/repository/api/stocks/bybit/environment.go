@@ -0,0 +1,29 @@
+package bybit
+
+import (
+	"context"
+
+	"m1pes/internal/models"
+)
+
+// Hosts of bybit's sandboxes, they have their own keys and balances.
+const (
+	TestnetURL = "https://api-testnet.bybit.com"
+	DemoURL    = "https://api-demo.bybit.com"
+)
+
+type environmentKey struct{}
+
+// WithEnvironment returns ctx whose requests are sent to host of bybit's environment, requests
+// of ctx without it are sent to mainnet.
+func WithEnvironment(ctx context.Context, environment string) context.Context {
+	return context.WithValue(ctx, environmentKey{}, environment)
+}
+
+func environmentOf(ctx context.Context) string {
+	environment, _ := ctx.Value(environmentKey{}).(string)
+	if environment == "" {
+		return models.EnvironmentMainnet
+	}
+	return environment
+}
@@ -0,0 +1,101 @@
+package bybit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"m1pes/internal/models"
+)
+
+// newHost serves ticker of bybit's environment, its price tells which host has answered.
+func newHost(t *testing.T, price string, keys *[]string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*keys = append(*keys, req.Header.Get("X-BAPI-API-KEY"))
+		_, _ = fmt.Fprintf(w, `{"retCode":0,"retMsg":"OK","result":{"category":"spot","list":[{"symbol":"BTCUSDT","lastPrice":"%s"}]}}`, price)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+// TestEnvironment checks that requests are sent to host of credentials' environment.
+func TestEnvironment(t *testing.T) {
+	var mainnetKeys, testnetKeys, demoKeys []string
+
+	repo := New(newHost(t, "1", &mainnetKeys))
+	repo.SetURL(models.EnvironmentTestnet, newHost(t, "2", &testnetKeys))
+	repo.SetURL(models.EnvironmentDemo, newHost(t, "3", &demoKeys))
+	exchange := NewExchange(repo, repo)
+
+	for _, tc := range []struct {
+		environment string
+		price       float64
+	}{
+		{"", 1},
+		{models.EnvironmentMainnet, 1},
+		{models.EnvironmentTestnet, 2},
+		{models.EnvironmentDemo, 3},
+	} {
+		creds := models.Credentials{Exchange: models.ExchangeBybit, Environment: tc.environment, ApiKey: "key-" + tc.environment, SecretKey: "secret"}
+
+		price, err := exchange.GetPrice(context.Background(), creds, "BTCUSDT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if price != tc.price {
+			t.Fatalf("price of environment %q is %v, want %v", tc.environment, price, tc.price)
+		}
+	}
+
+	if len(mainnetKeys) != 2 || len(testnetKeys) != 1 || testnetKeys[0] != "key-testnet" || len(demoKeys) != 1 || demoKeys[0] != "key-demo" {
+		t.Fatalf("keys of mainnet %v, testnet %v, demo %v", mainnetKeys, testnetKeys, demoKeys)
+	}
+
+	_, err := exchange.GetPrice(context.Background(), models.Credentials{Environment: "devnet"}, "BTCUSDT")
+	if err == nil {
+		t.Fatal("request of unknown environment is sent")
+	}
+}
+
+// TestEnvironmentLimits checks that rate limit of key on one environment does not hold requests
+// of the same key on another one.
+func TestEnvironmentLimits(t *testing.T) {
+	exhausted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(limitHeader, "10")
+		w.Header().Set(limitStatusHeader, "0")
+		w.Header().Set(limitResetHeader, strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10))
+		_, _ = w.Write([]byte(`{"retCode":0,"retMsg":"OK","result":{"category":"spot","list":[{"symbol":"BTCUSDT","lastPrice":"1"}]}}`))
+	}))
+	defer exhausted.Close()
+
+	var testnetKeys []string
+	repo := New(exhausted.URL)
+	repo.SetURL(models.EnvironmentTestnet, newHost(t, "2", &testnetKeys))
+	exchange := NewExchange(repo, repo)
+
+	mainnet := models.Credentials{Exchange: models.ExchangeBybit, ApiKey: "key", SecretKey: "secret"}
+	if _, err := exchange.GetPrice(context.Background(), mainnet, "BTCUSDT"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	testnet := mainnet
+	testnet.Environment = models.EnvironmentTestnet
+	if _, err := exchange.GetPrice(ctx, testnet, "BTCUSDT"); err != nil {
+		t.Fatalf("testnet request is held by mainnet's limit: %v", err)
+	}
+
+	// Mainnet's key waits for reset of its limit.
+	if _, err := exchange.GetPrice(ctx, mainnet, "BTCUSDT"); err == nil {
+		t.Fatal("mainnet request is sent over limit")
+	}
+}
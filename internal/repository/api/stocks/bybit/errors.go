@@ -0,0 +1,80 @@
+package bybit
+
+import "m1pes/internal/models"
+
+// Codes which bybit returns in retCode.
+const (
+	RetCodeOK               = 0
+	RetCodeServerTimeout    = 10000
+	RetCodeParamsError      = 10001
+	RetCodeTimestampExpired = 10002
+	RetCodeInvalidApiKey    = 10003
+	RetCodeInvalidSign      = 10004
+	RetCodePermissionDenied = 10005
+	RetCodeRateLimit        = 10006
+	RetCodeAuthFailed       = 10007
+	RetCodeIpBanned         = 10009
+	RetCodeUnmatchedIp      = 10010
+	RetCodeServerError      = 10016
+	RetCodeIpRateLimit      = 10018
+	RetCodeApiKeyExpired    = 33004
+
+	RetCodeOrderNotExists       = 110001
+	RetCodeBackendTimeout       = 170007
+	RetCodeInsufficientBalance  = 170131
+	RetCodeOrderPriceTooHigh    = 170132
+	RetCodeOrderPriceTooLow     = 170133
+	RetCodePriceDecimalTooLong  = 170134
+	RetCodeQtyTooLow            = 170136
+	RetCodeQtyDecimalTooLong    = 170137
+	RetCodeOrderValueTooLow     = 170140
+	RetCodeDuplicateLinkId      = 170141
+	RetCodeAmountDecimalTooLong = 170148
+	RetCodeSpotOrderNotExists   = 170213
+)
+
+var retCodeKinds = map[int]models.ErrorKind{
+	RetCodeServerTimeout:    models.ErrorKindRetryable,
+	RetCodeTimestampExpired: models.ErrorKindRetryable,
+	RetCodeRateLimit:        models.ErrorKindRetryable,
+	RetCodeServerError:      models.ErrorKindRetryable,
+	RetCodeIpRateLimit:      models.ErrorKindRetryable,
+	RetCodeBackendTimeout:   models.ErrorKindRetryable,
+
+	RetCodeInvalidApiKey:    models.ErrorKindAuth,
+	RetCodeInvalidSign:      models.ErrorKindAuth,
+	RetCodePermissionDenied: models.ErrorKindAuth,
+	RetCodeAuthFailed:       models.ErrorKindAuth,
+	RetCodeIpBanned:         models.ErrorKindAuth,
+	RetCodeUnmatchedIp:      models.ErrorKindAuth,
+	RetCodeApiKeyExpired:    models.ErrorKindAuth,
+
+	RetCodeInsufficientBalance: models.ErrorKindInsufficientFunds,
+
+	RetCodeOrderNotExists:     models.ErrorKindOrderNotFound,
+	RetCodeSpotOrderNotExists: models.ErrorKindOrderNotFound,
+
+	RetCodeOrderPriceTooHigh:    models.ErrorKindInvalidOrder,
+	RetCodeOrderPriceTooLow:     models.ErrorKindInvalidOrder,
+	RetCodePriceDecimalTooLong:  models.ErrorKindInvalidOrder,
+	RetCodeQtyTooLow:            models.ErrorKindInvalidOrder,
+	RetCodeQtyDecimalTooLong:    models.ErrorKindInvalidOrder,
+	RetCodeOrderValueTooLow:     models.ErrorKindInvalidOrder,
+	RetCodeAmountDecimalTooLong: models.ErrorKindInvalidOrder,
+
+	RetCodeDuplicateLinkId: models.ErrorKindDuplicateOrder,
+}
+
+// NewError returns error of bybit's response with its kind, it is nil if retCode is OK.
+func NewError(retCode int, retMsg string) error {
+	if retCode == RetCodeOK {
+		return nil
+	}
+	return &models.ApiError{Code: retCode, Msg: retMsg, Kind: retCodeKinds[retCode]}
+}
+
+// rejected reports if bybit has rejected request before handling it, so it can be sent again
+// even if it is not idempotent.
+func rejected(retCode int) bool {
+	return retCode == RetCodeRateLimit || retCode == RetCodeIpRateLimit
+}
@@ -0,0 +1,249 @@
+package bybit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"m1pes/internal/models"
+	apiStock "m1pes/internal/repository/api/stocks"
+)
+
+// Exchange is bybit behind apiStock.Exchange. Its api is real client, paper or simulated exchange,
+// they all speak bybit's requests. Requests are sent to environment of credentials.
+type Exchange struct {
+	api         apiStock.Repository
+	instruments apiStock.InstrumentRepository
+}
+
+// NewExchange creates exchange of api, instruments are requested from instruments, it can be nil
+// if exchange is not used for instruments sync.
+func NewExchange(api apiStock.Repository, instruments apiStock.InstrumentRepository) *Exchange {
+	return &Exchange{api: api, instruments: instruments}
+}
+
+func (e *Exchange) PlaceOrder(ctx context.Context, creds models.Credentials, req models.PlaceOrderRequest) (models.PlacedOrder, error) {
+	ctx = WithEnvironment(ctx, creds.Environment)
+
+	createReq := models.CreateOrderRequest{
+		Category:    "spot",
+		Side:        req.Side,
+		Symbol:      req.Symbol,
+		OrderType:   req.OrderType,
+		Qty:         req.Qty,
+		Price:       req.Price,
+		TimeInForce: "GTC",
+		OrderLinkId: req.OrderLinkId,
+	}
+
+	createOrderResp, err := e.api.CreateOrder(ctx, createReq, creds.ApiKey, creds.SecretKey)
+	if err != nil {
+		return models.PlacedOrder{}, err
+	}
+
+	return models.PlacedOrder{OrderId: createOrderResp.Result.OrderID, OrderLinkId: createOrderResp.Result.OrderLinkID}, nil
+}
+
+func (e *Exchange) CancelOrder(ctx context.Context, creds models.Credentials, symbol, orderId string) error {
+	ctx = WithEnvironment(ctx, creds.Environment)
+
+	cancelReq := models.CancelOrderRequest{
+		Category: "spot",
+		OrderId:  orderId,
+		Symbol:   symbol,
+	}
+
+	_, err := e.api.CancelOrder(ctx, cancelReq, creds.ApiKey, creds.SecretKey)
+	return err
+}
+
+func (e *Exchange) GetOrders(ctx context.Context, creds models.Credentials, query models.OrderQuery) ([]models.OrderInfo, error) {
+	ctx = WithEnvironment(ctx, creds.Environment)
+
+	getReq := make(models.GetOrderRequest)
+	getReq["category"] = "spot"
+	getReq["symbol"] = query.Symbol
+	if query.OrderId != "" {
+		getReq["orderId"] = query.OrderId
+	}
+	if query.OrderLinkId != "" {
+		getReq["orderLinkId"] = query.OrderLinkId
+	}
+	if query.Limit > 0 {
+		getReq["limit"] = query.Limit
+	}
+
+	getOrderResp, err := e.api.GetOrder(ctx, getReq, creds.ApiKey, creds.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return getOrderResp.Result.List, nil
+}
+
+func (e *Exchange) GetExecutions(ctx context.Context, creds models.Credentials, symbol, orderId string) ([]models.ExecutionEvent, error) {
+	ctx = WithEnvironment(ctx, creds.Environment)
+
+	getReq := make(models.GetExecutionsRequest)
+	getReq["category"] = "spot"
+	getReq["symbol"] = symbol
+	getReq["orderId"] = orderId
+	getReq["limit"] = 100
+
+	getExecutionsResp, err := e.api.GetExecutions(ctx, getReq, creds.ApiKey, creds.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return getExecutionsResp.Result.List, nil
+}
+
+func (e *Exchange) GetPrice(ctx context.Context, creds models.Credentials, symbol string) (float64, error) {
+	ctx = WithEnvironment(ctx, creds.Environment)
+
+	getCoinReq := make(models.GetCoinRequest)
+	getCoinReq["category"] = "spot"
+	getCoinReq["symbol"] = symbol
+
+	getCoinResp, err := e.api.GetCoin(ctx, getCoinReq, creds.ApiKey, creds.SecretKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(getCoinResp.Result.List) == 0 {
+		return 0, fmt.Errorf("empty ticker list for %s", symbol)
+	}
+
+	price, err := strconv.ParseFloat(getCoinResp.Result.List[0].Price, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse price failed: %w", err)
+	}
+	return price, nil
+}
+
+// GetBalances returns unified account, bot trades only in it.
+func (e *Exchange) GetBalances(ctx context.Context, creds models.Credentials) (models.Balances, error) {
+	ctx = WithEnvironment(ctx, creds.Environment)
+
+	getWalletReq := make(models.GetUserWalletRequest)
+	getWalletReq["accountType"] = "UNIFIED"
+
+	getWalletResp, err := e.api.GetUserWalletBalance(ctx, getWalletReq, creds.ApiKey, creds.SecretKey)
+	if err != nil {
+		return models.Balances{}, err
+	}
+
+	if len(getWalletResp.Result.List) == 0 {
+		return models.Balances{}, fmt.Errorf("empty wallet list")
+	}
+	wallet := getWalletResp.Result.List[0]
+
+	balances := models.Balances{Coins: make(map[string]float64, len(wallet.Coin))}
+	balances.TotalEquity, err = strconv.ParseFloat(wallet.TotalEquity, 64)
+	if err != nil {
+		return models.Balances{}, fmt.Errorf("parse total equity failed: %w", err)
+	}
+
+	for _, coin := range wallet.Coin {
+		equity, err := strconv.ParseFloat(coin.Equity, 64)
+		if err != nil {
+			return models.Balances{}, fmt.Errorf("parse equity of %s failed: %w", coin.Coin, err)
+		}
+		balances.Coins[coin.Coin] = equity
+	}
+
+	return balances, nil
+}
+
+func (e *Exchange) GetInstruments(ctx context.Context) ([]models.Coiniks, error) {
+	if e.instruments == nil {
+		return nil, fmt.Errorf("exchange has no instruments repository")
+	}
+
+	req := make(models.GetInstrumentsRequest)
+	req["category"] = "spot"
+
+	resp, err := e.instruments.GetInstruments(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]models.Coiniks, 0, len(resp.Result.List))
+	for _, info := range resp.Result.List {
+		coiniks, err := instrumentCoiniks(info)
+		if err != nil {
+			slog.WarnContext(ctx, "Error parsing instrument", "err", err, "symbol", info.Symbol)
+			continue
+		}
+		list = append(list, coiniks)
+	}
+
+	return list, nil
+}
+
+func (e *Exchange) GetKeyPermissions(ctx context.Context, creds models.Credentials) (models.KeyPermissions, error) {
+	ctx = WithEnvironment(ctx, creds.Environment)
+
+	body, err := e.api.CreateSignRequestAndGetRespBody(ctx, "", GetApiKeyPermissions, http.MethodGet, creds.ApiKey, creds.SecretKey)
+	if err != nil {
+		return models.KeyPermissions{}, err
+	}
+
+	var resp models.GetApiKeyPermissionsResponse
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return models.KeyPermissions{}, fmt.Errorf("unmarshal api key permissions failed: %w", err)
+	}
+
+	if err = NewError(resp.RetCode, resp.RetMsg); err != nil {
+		return models.KeyPermissions{}, err
+	}
+
+	return models.KeyPermissions{
+		ReadOnly:  resp.Result.ReadOnly != 0,
+		SpotTrade: slices.Contains(resp.Result.Permissions.Spot, "SpotTrade"),
+		Withdraw:  slices.Contains(resp.Result.Permissions.Wallet, "Withdraw"),
+	}, nil
+}
+
+// instrumentCoiniks returns coiniks of spot instrument, decimals are taken from its steps.
+func instrumentCoiniks(info models.InstrumentInfo) (models.Coiniks, error) {
+	coiniks := models.Coiniks{Name: info.Symbol, Status: info.Status}
+
+	fields := []struct {
+		name  string
+		value string
+		dst   *float64
+	}{
+		{"basePrecision", info.LotSizeFilter.BasePrecision, &coiniks.BasePrecision},
+		{"quotePrecision", info.LotSizeFilter.QuotePrecision, &coiniks.QuotePrecision},
+		{"tickSize", info.PriceFilter.TickSize, &coiniks.TickSize},
+		{"minOrderQty", info.LotSizeFilter.MinOrderQty, &coiniks.MinOrderQty},
+		{"minOrderAmt", info.LotSizeFilter.MinOrderAmt, &coiniks.MinOrderAmt},
+		{"maxOrderQty", info.LotSizeFilter.MaxOrderQty, &coiniks.MaxOrderQty},
+	}
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(field.value, 64)
+		if err != nil {
+			return models.Coiniks{}, fmt.Errorf("parse %s failed: %w", field.name, err)
+		}
+		*field.dst = value
+	}
+
+	if coiniks.BasePrecision <= 0 || coiniks.TickSize <= 0 {
+		return models.Coiniks{}, fmt.Errorf("instrument has no steps")
+	}
+
+	coiniks.QtyDecimals = models.StepDecimals(coiniks.BasePrecision)
+	coiniks.PriceDecimals = models.StepDecimals(coiniks.TickSize)
+	coiniks.MinSumBuy = coiniks.MinOrderAmt
+	return coiniks, nil
+}
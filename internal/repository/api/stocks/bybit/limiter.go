@@ -0,0 +1,138 @@
+package bybit
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultRateLimit is requests per second of endpoint until bybit reports its limit in headers.
+	defaultRateLimit = 10
+
+	limitHeader       = "X-Bapi-Limit"
+	limitStatusHeader = "X-Bapi-Limit-Status"
+	limitResetHeader  = "X-Bapi-Limit-Reset-Timestamp"
+)
+
+// limiter keeps token bucket of every environment, api key and endpoint, bybit limits requests
+// for every uid and endpoint separately and every environment is host with its own limits.
+// Public requests have empty api key.
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newLimiter() *limiter {
+	return &limiter{buckets: make(map[string]*bucket)}
+}
+
+func (l *limiter) bucket(environment, apiKey, endpoint string) *bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := environment + ":" + apiKey + endpoint
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limit: defaultRateLimit, tokens: defaultRateLimit, last: time.Now()}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// bucket is refilled by limit tokens per second, its capacity is limit too. Headers of response
+// replace what bucket has counted by itself: bybit knows about requests of other clients of key.
+type bucket struct {
+	mu     sync.Mutex
+	limit  float64
+	tokens float64
+	last   time.Time
+	// resetAt is time when limit exhausted by bybit is restored.
+	resetAt time.Time
+}
+
+// wait takes token, it blocks until bucket has one or ctx is done.
+func (b *bucket) wait(ctx context.Context) error {
+	for {
+		delay := b.take(time.Now())
+		if delay == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// take takes token and returns 0, otherwise it returns how long to wait for one.
+func (b *bucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.resetAt.IsZero() {
+		if now.Before(b.resetAt) {
+			return b.resetAt.Sub(now)
+		}
+		b.resetAt = time.Time{}
+		b.tokens = b.limit
+		b.last = now
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.limit
+	if b.tokens > b.limit {
+		b.tokens = b.limit
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit * float64(time.Second))
+}
+
+// update takes limit of endpoint and its rest from response's headers, if rest is exhausted
+// bucket is empty until reset timestamp.
+func (b *bucket) update(header http.Header, now time.Time) {
+	limit, limitErr := strconv.ParseFloat(header.Get(limitHeader), 64)
+	status, statusErr := strconv.ParseFloat(header.Get(limitStatusHeader), 64)
+	reset, resetErr := strconv.ParseInt(header.Get(limitResetHeader), 10, 64)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if limitErr == nil && limit > 0 {
+		b.limit = limit
+	}
+	if statusErr != nil {
+		return
+	}
+
+	b.tokens = status
+	if b.tokens > b.limit {
+		b.tokens = b.limit
+	}
+	b.last = now
+
+	if status < 1 && resetErr == nil {
+		if resetAt := time.UnixMilli(reset); resetAt.After(now) {
+			b.resetAt = resetAt
+		}
+	}
+}
+
+// backoff returns delay before retry of attempt, it is doubled every attempt and has jitter,
+// so clients which have failed together don't retry together.
+func backoff(attempt int) time.Duration {
+	delay := retryMinDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
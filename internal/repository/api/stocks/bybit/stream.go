@@ -0,0 +1,471 @@
+package bybit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	"m1pes/internal/models"
+)
+
+const (
+	PublicSpotStreamURL = "wss://stream.bybit.com/v5/public/spot"
+	PrivateStreamURL    = "wss://stream.bybit.com/v5/private"
+
+	TickersTopic   = "tickers."
+	OrderTopic     = "order"
+	ExecutionTopic = "execution"
+	WalletTopic    = "wallet"
+
+	streamPingInterval    = 20 * time.Second
+	streamReadTimeout     = time.Minute
+	streamWriteTimeout    = 10 * time.Second
+	streamAuthExpiration  = 10 * time.Second
+	streamMinReconnect    = time.Second
+	streamMaxReconnect    = time.Minute
+	streamEventBuffer     = 64
+	streamMaxTopicsPerReq = 10 // bybit limit for spot public stream.
+)
+
+// Stream is websocket client for bybit v5 public and private streams.
+// Only one connection is opened for public data and one per api key for private data,
+// subscribers share them.
+type Stream struct {
+	publicURL  string
+	privateURL string
+	dialer     *websocket.Dialer
+
+	mu         sync.Mutex
+	public     *streamConn
+	tickerSubs map[string]map[chan models.TickerEvent]struct{}
+	private    map[string]*privateStream
+}
+
+type privateStream struct {
+	conn   *streamConn
+	subs   map[chan models.PrivateEvent]struct{}
+	cancel context.CancelFunc
+}
+
+// NewStream returns stream of urls, empty ones are bybit's mainnet.
+func NewStream(publicURL, privateURL string) *Stream {
+	if publicURL == "" {
+		publicURL = PublicSpotStreamURL
+	}
+	if privateURL == "" {
+		privateURL = PrivateStreamURL
+	}
+
+	return &Stream{
+		publicURL:  publicURL,
+		privateURL: privateURL,
+		dialer:     websocket.DefaultDialer,
+		tickerSubs: make(map[string]map[chan models.TickerEvent]struct{}),
+		private:    make(map[string]*privateStream),
+	}
+}
+
+func (s *Stream) SubscribeTickers(ctx context.Context, symbol string) (<-chan models.TickerEvent, error) {
+	if symbol == "" {
+		return nil, errors.New("symbol is required")
+	}
+
+	ch := make(chan models.TickerEvent, streamEventBuffer)
+
+	s.mu.Lock()
+	if s.public == nil {
+		s.public = newStreamConn(s.publicURL, "", "", s.dialer, s.handlePublic)
+		go s.public.run(context.Background())
+	}
+
+	subs, ok := s.tickerSubs[symbol]
+	if !ok {
+		subs = make(map[chan models.TickerEvent]struct{})
+		s.tickerSubs[symbol] = subs
+	}
+	subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	if !ok {
+		s.public.subscribe(TickersTopic + symbol)
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(subs, ch)
+		close(ch)
+
+		if len(subs) == 0 {
+			delete(s.tickerSubs, symbol)
+			s.public.unsubscribe(TickersTopic + symbol)
+		}
+	}()
+
+	return ch, nil
+}
+
+func (s *Stream) SubscribePrivate(ctx context.Context, apiKey, secretKey string) (<-chan models.PrivateEvent, error) {
+	if apiKey == "" || secretKey == "" {
+		return nil, errors.New("api and secret keys are required for private stream")
+	}
+
+	ch := make(chan models.PrivateEvent, streamEventBuffer)
+
+	s.mu.Lock()
+	ps, ok := s.private[apiKey]
+	if !ok {
+		connCtx, cancel := context.WithCancel(context.Background())
+		ps = &privateStream{subs: make(map[chan models.PrivateEvent]struct{}), cancel: cancel}
+		ps.conn = newStreamConn(s.privateURL, apiKey, secretKey, s.dialer, func(msg streamMessage) {
+			s.handlePrivate(ps, msg)
+		})
+		ps.conn.subscribe(OrderTopic, ExecutionTopic, WalletTopic)
+		s.private[apiKey] = ps
+
+		go ps.conn.run(connCtx)
+	}
+	ps.subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(ps.subs, ch)
+		close(ch)
+
+		if len(ps.subs) == 0 {
+			delete(s.private, apiKey)
+			ps.cancel()
+		}
+	}()
+
+	return ch, nil
+}
+
+func (s *Stream) handlePublic(msg streamMessage) {
+	if !strings.HasPrefix(msg.Topic, TickersTopic) {
+		return
+	}
+
+	var event models.TickerEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		slog.Warn("failed unmarshal ticker event", "topic", msg.Topic, "err", err)
+		return
+	}
+	event.Ts = msg.Ts
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.tickerSubs[event.Symbol] {
+		// Old prices are useless, so slow subscriber just misses some of them.
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+func (s *Stream) handlePrivate(ps *privateStream, msg streamMessage) {
+	event := models.PrivateEvent{Topic: msg.Topic}
+
+	var err error
+	switch msg.Topic {
+	case OrderTopic:
+		err = json.Unmarshal(msg.Data, &event.Orders)
+	case ExecutionTopic:
+		err = json.Unmarshal(msg.Data, &event.Executions)
+	case WalletTopic:
+		err = json.Unmarshal(msg.Data, &event.Wallets)
+	default:
+		return
+	}
+	if err != nil {
+		slog.Warn("failed unmarshal private event", "topic", msg.Topic, "err", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range ps.subs {
+		select {
+		case ch <- event:
+		default:
+			slog.Warn("private event was dropped, subscriber is too slow", "topic", msg.Topic)
+		}
+	}
+}
+
+type streamMessage struct {
+	Op      string          `json:"op"`
+	Success *bool           `json:"success"`
+	RetMsg  string          `json:"ret_msg"`
+	Topic   string          `json:"topic"`
+	Type    string          `json:"type"`
+	Ts      int64           `json:"ts"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type streamRequest struct {
+	Op   string        `json:"op"`
+	Args []interface{} `json:"args,omitempty"`
+}
+
+// streamConn is single websocket connection which reconnects and resubscribes
+// to all its topics until its context is done.
+type streamConn struct {
+	url       string
+	apiKey    string
+	secretKey string
+	dialer    *websocket.Dialer
+	onMessage func(msg streamMessage)
+
+	mu      sync.Mutex
+	ws      *websocket.Conn
+	topics  map[string]struct{}
+	writeMu sync.Mutex
+}
+
+func newStreamConn(url, apiKey, secretKey string, dialer *websocket.Dialer, onMessage func(msg streamMessage)) *streamConn {
+	return &streamConn{
+		url:       url,
+		apiKey:    apiKey,
+		secretKey: secretKey,
+		dialer:    dialer,
+		onMessage: onMessage,
+		topics:    make(map[string]struct{}),
+	}
+}
+
+func (c *streamConn) run(ctx context.Context) {
+	delay := streamMinReconnect
+	for {
+		connected, err := c.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			delay = streamMinReconnect
+		}
+
+		slog.WarnContext(ctx, "bybit stream disconnected, reconnecting", "url", c.url, "delay", delay, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > streamMaxReconnect {
+			delay = streamMaxReconnect
+		}
+	}
+}
+
+// serve connects to stream and reads it until error. Returned bool reports
+// if connection has been established.
+func (c *streamConn) serve(ctx context.Context) (bool, error) {
+	ws, _, err := c.dialer.DialContext(ctx, c.url, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "failed dial stream")
+	}
+	defer ws.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// Closing connection unblocks reading when ctx is done.
+	go func() {
+		select {
+		case <-ctx.Done():
+			ws.Close()
+		case <-done:
+		}
+	}()
+
+	if c.apiKey != "" {
+		if err = c.auth(ws); err != nil {
+			return false, err
+		}
+	}
+
+	c.mu.Lock()
+	c.ws = ws
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.ws = nil
+		c.mu.Unlock()
+	}()
+
+	if err = c.send(ws, "subscribe", topics); err != nil {
+		return true, err
+	}
+
+	go c.ping(ws, done)
+
+	for {
+		err = ws.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		if err != nil {
+			return true, errors.Wrap(err, "failed set read deadline")
+		}
+
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return true, errors.Wrap(err, "failed read stream message")
+		}
+
+		var msg streamMessage
+		if err = json.Unmarshal(data, &msg); err != nil {
+			slog.WarnContext(ctx, "failed unmarshal stream message", "err", err)
+			continue
+		}
+
+		if msg.Op != "" {
+			if msg.Success != nil && !*msg.Success {
+				slog.WarnContext(ctx, "bybit stream operation failed", "op", msg.Op, "retMsg", msg.RetMsg)
+			}
+			continue
+		}
+
+		if msg.Topic != "" {
+			c.onMessage(msg)
+		}
+	}
+}
+
+func (c *streamConn) auth(ws *websocket.Conn) error {
+	expires := strconv.FormatInt(time.Now().Add(streamAuthExpiration).UnixMilli(), 10)
+
+	hmac256 := hmac.New(sha256.New, []byte(c.secretKey))
+	hmac256.Write([]byte("GET/realtime" + expires))
+	signature := hex.EncodeToString(hmac256.Sum(nil))
+
+	err := c.write(ws, streamRequest{Op: "auth", Args: []interface{}{c.apiKey, expires, signature}})
+	if err != nil {
+		return err
+	}
+
+	err = ws.SetReadDeadline(time.Now().Add(streamWriteTimeout))
+	if err != nil {
+		return errors.Wrap(err, "failed set read deadline")
+	}
+
+	var resp streamMessage
+	if err = ws.ReadJSON(&resp); err != nil {
+		return errors.Wrap(err, "failed read auth response")
+	}
+
+	if resp.Op != "auth" || resp.Success == nil || !*resp.Success {
+		return errors.Errorf("stream auth failed: %s", resp.RetMsg)
+	}
+
+	return nil
+}
+
+func (c *streamConn) ping(ws *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(streamPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.write(ws, streamRequest{Op: "ping"}); err != nil {
+				slog.Warn("failed ping bybit stream", "err", err)
+				ws.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *streamConn) subscribe(topics ...string) {
+	c.mu.Lock()
+	for _, topic := range topics {
+		c.topics[topic] = struct{}{}
+	}
+	ws := c.ws
+	c.mu.Unlock()
+
+	// If there is no connection, topics will be subscribed after connecting.
+	if ws != nil {
+		if err := c.send(ws, "subscribe", topics); err != nil {
+			slog.Warn("failed subscribe to bybit stream", "topics", topics, "err", err)
+		}
+	}
+}
+
+func (c *streamConn) unsubscribe(topics ...string) {
+	c.mu.Lock()
+	for _, topic := range topics {
+		delete(c.topics, topic)
+	}
+	ws := c.ws
+	c.mu.Unlock()
+
+	if ws != nil {
+		if err := c.send(ws, "unsubscribe", topics); err != nil {
+			slog.Warn("failed unsubscribe from bybit stream", "topics", topics, "err", err)
+		}
+	}
+}
+
+// send sends operation with topics split into allowed chunks.
+func (c *streamConn) send(ws *websocket.Conn, op string, topics []string) error {
+	for len(topics) > 0 {
+		n := min(len(topics), streamMaxTopicsPerReq)
+
+		args := make([]interface{}, 0, n)
+		for _, topic := range topics[:n] {
+			args = append(args, topic)
+		}
+
+		if err := c.write(ws, streamRequest{Op: op, Args: args}); err != nil {
+			return err
+		}
+		topics = topics[n:]
+	}
+	return nil
+}
+
+func (c *streamConn) write(ws *websocket.Conn, req streamRequest) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	err := ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if err != nil {
+		return errors.Wrap(err, "failed set write deadline")
+	}
+
+	err = ws.WriteJSON(req)
+	if err != nil {
+		return errors.Wrap(err, "failed write stream message")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package bybit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"m1pes/internal/models"
+	"m1pes/internal/repository/api/stocks/bybit"
+	"m1pes/internal/repository/api/stocks/bybit/bybittest"
+)
+
+const (
+	testApiKey = "key"
+	testSecret = "secret"
+)
+
+func newTestStream(t *testing.T) (*bybittest.Server, *bybit.Stream) {
+	t.Helper()
+
+	server := bybittest.NewServer()
+	t.Cleanup(server.Close)
+
+	return server, bybit.NewStream(server.PublicStreamURL, server.PrivateStreamURL)
+}
+
+// waitFor waits until cond is true, stream reconnects not earlier than in a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+}
+
+func receive[E any](t *testing.T, events <-chan E) E {
+	t.Helper()
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events are closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+	}
+	panic("unreachable")
+}
+
+// TestStreamAuthFailure checks that private stream which bybit has not authorized subscribes
+// to nothing and connects again until key is accepted.
+func TestStreamAuthFailure(t *testing.T) {
+	server, stream := newTestStream(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := stream.SubscribePrivate(ctx, testApiKey, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Key is unknown to bybit yet.
+	waitFor(t, "reconnect after failed auth", func() bool { return server.Requests(bybittest.PrivateStreamPath) >= 2 })
+	if n := server.Subscribed(bybit.OrderTopic); n != 0 {
+		t.Fatalf("%d connections are subscribed without auth", n)
+	}
+
+	server.AddAccount(testApiKey, testSecret, 0)
+	waitFor(t, "subscription after auth", func() bool { return server.Subscribed(bybit.OrderTopic) == 1 })
+
+	server.SendPrivate(testApiKey, bybit.OrderTopic, []models.OrderEvent{{Symbol: "BTCUSDT", OrderId: "1", OrderStatus: models.OrderStatusFilled}})
+	event := receive(t, events)
+	if event.Topic != bybit.OrderTopic || len(event.Orders) != 1 || event.Orders[0].OrderId != "1" {
+		t.Fatalf("event is %+v", event)
+	}
+}
+
+// TestStreamResubscribe checks that topics are subscribed again after connection is lost.
+func TestStreamResubscribe(t *testing.T) {
+	server, stream := newTestStream(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tickers, err := stream.SubscribeTickers(ctx, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic := bybit.TickersTopic + "BTCUSDT"
+	waitFor(t, "subscription", func() bool { return server.Subscribed(topic) == 1 })
+
+	server.SendTicker("BTCUSDT", 100)
+	if ticker := receive(t, tickers); ticker.Symbol != "BTCUSDT" || ticker.Price != "100" {
+		t.Fatalf("ticker is %+v", ticker)
+	}
+
+	server.DropStreams()
+	waitFor(t, "subscription after reconnect", func() bool { return server.Subscribed(topic) == 1 })
+
+	server.SendTicker("BTCUSDT", 101)
+	if ticker := receive(t, tickers); ticker.Price != "101" {
+		t.Fatalf("ticker after reconnect is %+v", ticker)
+	}
+	if n := server.Requests(bybittest.PublicStreamPath); n != 2 {
+		t.Fatalf("public stream is connected %d times", n)
+	}
+}
+
+// TestStreamTickersClose checks that channel of tickers is closed when its ctx is done and
+// topic is unsubscribed after its last subscriber.
+func TestStreamTickersClose(t *testing.T) {
+	server, stream := newTestStream(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	other, cancelOther := context.WithCancel(context.Background())
+	defer cancelOther()
+
+	tickers, err := stream.SubscribeTickers(ctx, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherTickers, err := stream.SubscribeTickers(other, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topic := bybit.TickersTopic + "BTCUSDT"
+	waitFor(t, "subscription", func() bool { return server.Subscribed(topic) == 1 })
+
+	cancel()
+	select {
+	case _, ok := <-tickers:
+		if ok {
+			t.Fatal("ticker is received after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("tickers are not closed after cancel")
+	}
+
+	// Other subscriber still gets prices.
+	server.SendTicker("BTCUSDT", 100)
+	if ticker := receive(t, otherTickers); ticker.Price != "100" {
+		t.Fatalf("ticker is %+v", ticker)
+	}
+
+	cancelOther()
+	waitFor(t, "unsubscribe", func() bool { return server.Subscribed(topic) == 0 })
+	if _, ok := <-otherTickers; ok {
+		t.Fatal("other tickers are not closed")
+	}
+}
@@ -0,0 +1,64 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const ServerTimeEndpoint = "/api/v5/public/time"
+
+// clock is local time corrected by offset of okx's server time, requests are signed by it.
+type clock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return time.Now().Add(c.offset)
+}
+
+// observe sets offset by server time of response to request which has been sent at sent and
+// received at received, server time is taken as the middle of round trip.
+func (c *clock) observe(server, sent, received time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.offset = server.Sub(sent.Add(received.Sub(sent) / 2))
+}
+
+// SyncTime sets offset of clock by okx's server time.
+func (r *Repository) SyncTime(ctx context.Context) error {
+	sent := time.Now()
+	data, err := r.do(ctx, http.MethodGet, ServerTimeEndpoint, nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("get server time failed: %w", err)
+	}
+	received := time.Now()
+
+	var resp []struct {
+		Ts string `json:"ts"`
+	}
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return fmt.Errorf("unmarshal server time failed: %w", err)
+	}
+	if len(resp) == 0 {
+		return errors.New("server time is empty")
+	}
+
+	ms, err := strconv.ParseInt(resp[0].Ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse server time failed: %w", err)
+	}
+
+	r.clock.observe(time.UnixMilli(ms), sent, received)
+	return nil
+}
@@ -0,0 +1,67 @@
+package okx
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestTimestampResync checks that request which okx rejects by timestamp is signed again once
+// by server's time.
+func TestTimestampResync(t *testing.T) {
+	// Server's clock is ahead of local one.
+	const drift = time.Hour
+
+	balance, err := os.ReadFile(filepath.Join("testdata", "balance.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var syncs, requests int
+	var expired bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		now := time.Now().Add(drift)
+
+		if req.URL.Path == ServerTimeEndpoint {
+			syncs++
+			_, _ = fmt.Fprintf(w, `{"code":"0","msg":"","data":[{"ts":"%d"}]}`, now.UnixMilli())
+			return
+		}
+
+		requests++
+		timestamp, err := time.Parse(timestampLayout, req.Header.Get("OK-ACCESS-TIMESTAMP"))
+		if expired || err != nil || now.Sub(timestamp).Abs() > 30*time.Second {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"code":"50102","msg":"Timestamp request expired"}`))
+			return
+		}
+		_, _ = w.Write(balance)
+	}))
+	defer server.Close()
+
+	repo := New(server.URL)
+
+	for i := 0; i < 2; i++ {
+		if _, err := repo.GetBalances(context.Background(), testCreds); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if syncs != 1 || requests != 3 {
+		t.Fatalf("%d syncs and %d requests, want 1 sync and 3 requests", syncs, requests)
+	}
+
+	// Timestamp which is rejected after sync is an error.
+	expired = true
+	syncs, requests = 0, 0
+	if _, err := repo.GetBalances(context.Background(), testCreds); err == nil {
+		t.Fatal("expired timestamp is not an error")
+	}
+	if syncs != 1 || requests != 2 {
+		t.Fatalf("%d syncs and %d requests of expired timestamp", syncs, requests)
+	}
+}
@@ -0,0 +1,75 @@
+package okx
+
+import "m1pes/internal/models"
+
+// Codes which okx returns in code of response or in sCode of order.
+const (
+	CodeOK                 = 0
+	CodeServiceUnavailable = 50001
+	CodeEndpointTimeout    = 50004
+	CodeRateLimit          = 50011
+	CodeSystemBusy         = 50013
+	CodeAccountFrozen      = 50100
+	CodeTimestampExpired   = 50102
+	CodeWrongPassphrase    = 50105
+	CodeIpNotWhitelisted   = 50110
+	CodeInvalidApiKey      = 50111
+	CodeInvalidSign        = 50113
+	CodeInvalidAuth        = 50114
+	CodeApiKeyNotExists    = 50119
+	CodeNoPermission       = 50120
+
+	CodeParamsError          = 51000
+	CodeInstrumentNotExists  = 51001
+	CodeOrderPriceOutOfLimit = 51006
+	CodeInsufficientBalance  = 51008
+	CodeDuplicateClOrdId     = 51016
+	CodeOrderAmountTooLow    = 51020
+	CodeLotSizeViolation     = 51121
+	CodeCancelFailed         = 51400
+	CodeOrderNotExists       = 51603
+)
+
+var codeKinds = map[int]models.ErrorKind{
+	CodeServiceUnavailable: models.ErrorKindRetryable,
+	CodeEndpointTimeout:    models.ErrorKindRetryable,
+	CodeRateLimit:          models.ErrorKindRetryable,
+	CodeSystemBusy:         models.ErrorKindRetryable,
+	CodeTimestampExpired:   models.ErrorKindRetryable,
+
+	CodeAccountFrozen:    models.ErrorKindAuth,
+	CodeWrongPassphrase:  models.ErrorKindAuth,
+	CodeIpNotWhitelisted: models.ErrorKindAuth,
+	CodeInvalidApiKey:    models.ErrorKindAuth,
+	CodeInvalidSign:      models.ErrorKindAuth,
+	CodeInvalidAuth:      models.ErrorKindAuth,
+	CodeApiKeyNotExists:  models.ErrorKindAuth,
+	CodeNoPermission:     models.ErrorKindAuth,
+
+	CodeInsufficientBalance: models.ErrorKindInsufficientFunds,
+
+	CodeCancelFailed:   models.ErrorKindOrderNotFound,
+	CodeOrderNotExists: models.ErrorKindOrderNotFound,
+
+	CodeParamsError:          models.ErrorKindInvalidOrder,
+	CodeInstrumentNotExists:  models.ErrorKindInvalidOrder,
+	CodeOrderPriceOutOfLimit: models.ErrorKindInvalidOrder,
+	CodeOrderAmountTooLow:    models.ErrorKindInvalidOrder,
+	CodeLotSizeViolation:     models.ErrorKindInvalidOrder,
+
+	CodeDuplicateClOrdId: models.ErrorKindDuplicateOrder,
+}
+
+// NewError returns error of okx's response with its kind, it is nil if code is OK.
+func NewError(code int, msg string) error {
+	if code == CodeOK {
+		return nil
+	}
+	return &models.ApiError{Code: code, Msg: msg, Kind: codeKinds[code]}
+}
+
+// rejected reports if okx has rejected request before handling it, so it can be sent again
+// even if it is not idempotent.
+func rejected(code int) bool {
+	return code == CodeRateLimit || code == CodeTimestampExpired
+}
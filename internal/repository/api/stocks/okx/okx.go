@@ -0,0 +1,611 @@
+package okx
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"m1pes/internal/models"
+)
+
+const (
+	URL = "https://www.okx.com"
+
+	PlaceOrderEndpoint    = "/api/v5/trade/order"
+	CancelOrderEndpoint   = "/api/v5/trade/cancel-order"
+	GetOrderEndpoint      = "/api/v5/trade/order"
+	PendingOrdersEndpoint = "/api/v5/trade/orders-pending"
+	FillsEndpoint         = "/api/v5/trade/fills"
+	TickerEndpoint        = "/api/v5/market/ticker"
+	BalanceEndpoint       = "/api/v5/account/balance"
+	AccountConfigEndpoint = "/api/v5/account/config"
+	InstrumentsEndpoint   = "/api/v5/public/instruments"
+
+	// Only coins which are bought for USDT are traded by bot.
+	quoteCoin = "USDT"
+
+	timestampLayout = "2006-01-02T15:04:05.000Z"
+
+	retryAttempts = 3
+	retryMinDelay = 200 * time.Millisecond
+	retryMaxDelay = 5 * time.Second
+)
+
+// States of okx's order, they are mapped to models' OrderStatus constants.
+const (
+	stateLive            = "live"
+	statePartiallyFilled = "partially_filled"
+	stateFilled          = "filled"
+	stateCanceled        = "canceled"
+	stateMmpCanceled     = "mmp_canceled"
+)
+
+// Repository is okx spot behind apiStock.Exchange, it trades in cash mode. Requests are
+// signed by okx's server time, see SyncTime.
+type Repository struct {
+	cli   *http.Client
+	url   string
+	clock *clock
+}
+
+// New creates repository which sends requests to url, it is URL if url is empty.
+func New(url string) *Repository {
+	if url == "" {
+		url = URL
+	}
+
+	return &Repository{
+		cli: &http.Client{
+			Timeout: time.Minute,
+		},
+		url:   url,
+		clock: &clock{},
+	}
+}
+
+type order struct {
+	InstId    string `json:"instId"`
+	OrdId     string `json:"ordId"`
+	ClOrdId   string `json:"clOrdId"`
+	Px        string `json:"px"`
+	Sz        string `json:"sz"`
+	OrdType   string `json:"ordType"`
+	Side      string `json:"side"`
+	State     string `json:"state"`
+	AccFillSz string `json:"accFillSz"`
+	AvgPx     string `json:"avgPx"`
+	Fee       string `json:"fee"`
+	FeeCcy    string `json:"feeCcy"`
+	CTime     string `json:"cTime"`
+	UTime     string `json:"uTime"`
+}
+
+type fill struct {
+	InstId  string `json:"instId"`
+	TradeId string `json:"tradeId"`
+	OrdId   string `json:"ordId"`
+	ClOrdId string `json:"clOrdId"`
+	FillPx  string `json:"fillPx"`
+	FillSz  string `json:"fillSz"`
+	Side    string `json:"side"`
+	Fee     string `json:"fee"`
+	FeeCcy  string `json:"feeCcy"`
+	Ts      string `json:"ts"`
+}
+
+type instrument struct {
+	InstId   string `json:"instId"`
+	BaseCcy  string `json:"baseCcy"`
+	QuoteCcy string `json:"quoteCcy"`
+	TickSz   string `json:"tickSz"`
+	LotSz    string `json:"lotSz"`
+	MinSz    string `json:"minSz"`
+	MaxLmtSz string `json:"maxLmtSz"`
+	State    string `json:"state"`
+}
+
+// orderResult is result of order's placing or cancel, order's error is in sCode.
+type orderResult struct {
+	OrdId   string `json:"ordId"`
+	ClOrdId string `json:"clOrdId"`
+	SCode   string `json:"sCode"`
+	SMsg    string `json:"sMsg"`
+}
+
+func (r *Repository) PlaceOrder(ctx context.Context, creds models.Credentials, req models.PlaceOrderRequest) (models.PlacedOrder, error) {
+	body := map[string]string{
+		"instId":  instId(req.Symbol),
+		"tdMode":  "cash",
+		"side":    strings.ToLower(req.Side),
+		"ordType": strings.ToLower(req.OrderType),
+		"sz":      req.Qty,
+		// Size of market buy is in quote coin by default, bot's qty is always in base coin.
+		"tgtCcy": "base_ccy",
+	}
+	if req.Price != "" {
+		body["px"] = req.Price
+	}
+	if req.OrderLinkId != "" {
+		body["clOrdId"] = clOrdId(req.OrderLinkId)
+	}
+
+	var results []orderResult
+	// Order with client id is not placed twice, okx rejects the same id as duplicate.
+	err := r.send(ctx, http.MethodPost, PlaceOrderEndpoint, nil, body, &creds, req.OrderLinkId != "", &results)
+	if err != nil {
+		return models.PlacedOrder{}, fmt.Errorf("place order failed: %w", err)
+	}
+	if len(results) == 0 {
+		return models.PlacedOrder{}, fmt.Errorf("place order failed: empty result")
+	}
+
+	return models.PlacedOrder{OrderId: results[0].OrdId, OrderLinkId: orderLinkId(results[0].ClOrdId)}, nil
+}
+
+func (r *Repository) CancelOrder(ctx context.Context, creds models.Credentials, symbol, orderId string) error {
+	body := map[string]string{
+		"instId": instId(symbol),
+		"ordId":  orderId,
+	}
+
+	err := r.send(ctx, http.MethodPost, CancelOrderEndpoint, nil, body, &creds, false, nil)
+	if err != nil {
+		return fmt.Errorf("cancel order failed: %w", err)
+	}
+	return nil
+}
+
+// GetOrders returns order by its id as list, list is empty if okx does not know it.
+func (r *Repository) GetOrders(ctx context.Context, creds models.Credentials, query models.OrderQuery) ([]models.OrderInfo, error) {
+	params := url.Values{}
+	params.Set("instId", instId(query.Symbol))
+
+	endPoint := GetOrderEndpoint
+	switch {
+	case query.OrderId != "":
+		params.Set("ordId", query.OrderId)
+	case query.OrderLinkId != "":
+		params.Set("clOrdId", clOrdId(query.OrderLinkId))
+	default:
+		endPoint = PendingOrdersEndpoint
+		params.Set("instType", "SPOT")
+		if query.Limit > 0 {
+			params.Set("limit", strconv.Itoa(query.Limit))
+		}
+	}
+
+	var orders []order
+	err := r.send(ctx, http.MethodGet, endPoint, params, nil, &creds, true, &orders)
+	if models.ErrorKindOf(err) == models.ErrorKindOrderNotFound {
+		return []models.OrderInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get orders failed: %w", err)
+	}
+
+	list := make([]models.OrderInfo, 0, len(orders))
+	for _, o := range orders {
+		list = append(list, orderInfo(o))
+	}
+	return list, nil
+}
+
+func (r *Repository) GetExecutions(ctx context.Context, creds models.Credentials, symbol, orderId string) ([]models.ExecutionEvent, error) {
+	params := url.Values{}
+	params.Set("instType", "SPOT")
+	params.Set("instId", instId(symbol))
+	params.Set("ordId", orderId)
+
+	var fills []fill
+	err := r.send(ctx, http.MethodGet, FillsEndpoint, params, nil, &creds, true, &fills)
+	if err != nil {
+		return nil, fmt.Errorf("get fills failed: %w", err)
+	}
+
+	executions := make([]models.ExecutionEvent, 0, len(fills))
+	for _, f := range fills {
+		price, qty := parseFloat(f.FillPx), parseFloat(f.FillSz)
+		executions = append(executions, models.ExecutionEvent{
+			Symbol:      symbolOf(f.InstId),
+			OrderId:     f.OrdId,
+			OrderLinkId: orderLinkId(f.ClOrdId),
+			Side:        titleCase(f.Side),
+			ExecId:      f.TradeId,
+			ExecPrice:   f.FillPx,
+			ExecQty:     f.FillSz,
+			ExecValue:   formatFloat(price * qty),
+			// Okx's fee is negative when it is charged.
+			ExecFee:     formatFloat(-parseFloat(f.Fee)),
+			FeeCurrency: f.FeeCcy,
+			ExecType:    "Trade",
+			ExecTime:    f.Ts,
+		})
+	}
+
+	return executions, nil
+}
+
+func (r *Repository) GetPrice(ctx context.Context, creds models.Credentials, symbol string) (float64, error) {
+	params := url.Values{}
+	params.Set("instId", instId(symbol))
+
+	var tickers []struct {
+		InstId string `json:"instId"`
+		Last   string `json:"last"`
+	}
+	err := r.send(ctx, http.MethodGet, TickerEndpoint, params, nil, nil, true, &tickers)
+	if err != nil {
+		return 0, fmt.Errorf("get ticker failed: %w", err)
+	}
+	if len(tickers) == 0 {
+		return 0, fmt.Errorf("empty ticker list for %s", symbol)
+	}
+
+	price, err := strconv.ParseFloat(tickers[0].Last, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse price failed: %w", err)
+	}
+	return price, nil
+}
+
+// GetBalances returns trading account, okx counts its total equity in USD.
+func (r *Repository) GetBalances(ctx context.Context, creds models.Credentials) (models.Balances, error) {
+	var accounts []struct {
+		TotalEq string `json:"totalEq"`
+		Details []struct {
+			Ccy string `json:"ccy"`
+			Eq  string `json:"eq"`
+		} `json:"details"`
+	}
+	err := r.send(ctx, http.MethodGet, BalanceEndpoint, url.Values{}, nil, &creds, true, &accounts)
+	if err != nil {
+		return models.Balances{}, fmt.Errorf("get balance failed: %w", err)
+	}
+	if len(accounts) == 0 {
+		return models.Balances{}, fmt.Errorf("empty account list")
+	}
+	account := accounts[0]
+
+	balances := models.Balances{Coins: make(map[string]float64, len(account.Details))}
+	balances.TotalEquity, err = strconv.ParseFloat(account.TotalEq, 64)
+	if err != nil {
+		return models.Balances{}, fmt.Errorf("parse total equity failed: %w", err)
+	}
+
+	for _, detail := range account.Details {
+		balances.Coins[detail.Ccy] = parseFloat(detail.Eq)
+	}
+	return balances, nil
+}
+
+func (r *Repository) GetInstruments(ctx context.Context) ([]models.Coiniks, error) {
+	params := url.Values{}
+	params.Set("instType", "SPOT")
+
+	var instruments []instrument
+	err := r.send(ctx, http.MethodGet, InstrumentsEndpoint, params, nil, nil, true, &instruments)
+	if err != nil {
+		return nil, fmt.Errorf("get instruments failed: %w", err)
+	}
+
+	list := make([]models.Coiniks, 0, len(instruments))
+	for _, info := range instruments {
+		coiniks, err := instrumentCoiniks(info)
+		if err != nil {
+			slog.WarnContext(ctx, "Error parsing instrument", "err", err, "symbol", info.InstId)
+			continue
+		}
+		list = append(list, coiniks)
+	}
+
+	return list, nil
+}
+
+func (r *Repository) GetKeyPermissions(ctx context.Context, creds models.Credentials) (models.KeyPermissions, error) {
+	var configs []struct {
+		Perm string `json:"perm"`
+	}
+	err := r.send(ctx, http.MethodGet, AccountConfigEndpoint, url.Values{}, nil, &creds, true, &configs)
+	if err != nil {
+		return models.KeyPermissions{}, fmt.Errorf("get account config failed: %w", err)
+	}
+	if len(configs) == 0 {
+		return models.KeyPermissions{}, fmt.Errorf("empty account config")
+	}
+
+	perms := strings.Split(configs[0].Perm, ",")
+	perm := models.KeyPermissions{}
+	for _, p := range perms {
+		switch strings.TrimSpace(p) {
+		case "trade":
+			perm.SpotTrade = true
+		case "withdraw":
+			perm.Withdraw = true
+		}
+	}
+	perm.ReadOnly = !perm.SpotTrade && !perm.Withdraw
+	return perm, nil
+}
+
+// send signs request if creds are set and unmarshals data of response to resp. Requests which
+// okx has rejected by rate limit or timestamp are not handled, so they are retried always.
+// Requests which have failed on the way or on server could be handled, so they are retried
+// only if retry is true: request is idempotent or it is order with client id. Expired
+// timestamp syncs clock once.
+func (r *Repository) send(ctx context.Context, method, endPoint string, params url.Values, body any, creds *models.Credentials, retry bool, resp any) error {
+	resynced := false
+
+	for attempt := 0; ; attempt++ {
+		data, err := r.do(ctx, method, endPoint, params, body, creds)
+
+		var apiErr *models.ApiError
+		expired := errors.As(err, &apiErr) && apiErr.Code == CodeTimestampExpired
+		if expired && !resynced {
+			resynced = true
+			slog.WarnContext(ctx, "okx timestamp expired, syncing time", "endpoint", endPoint, "err", err)
+
+			if err := r.SyncTime(ctx); err != nil {
+				slog.WarnContext(ctx, "okx time sync failed", "err", err)
+			}
+			continue
+		}
+
+		// Timestamp which has expired after sync is not retried anymore.
+		transient := retry && err != nil
+		if apiErr != nil {
+			transient = !expired && (rejected(apiErr.Code) || (retry && apiErr.Kind == models.ErrorKindRetryable))
+		}
+
+		if !transient || attempt+1 >= retryAttempts {
+			if err != nil {
+				return err
+			}
+			if resp == nil {
+				return nil
+			}
+			if err = json.Unmarshal(data, resp); err != nil {
+				return fmt.Errorf("unmarshal response failed: %w", err)
+			}
+			return nil
+		}
+
+		delay := backoff(attempt)
+		slog.WarnContext(ctx, "okx request failed, retrying", "endpoint", endPoint, "attempt", attempt+1, "delay", delay, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed wait for retry: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// do sends request once and returns data of response, error of response is returned as
+// *models.ApiError. Error of order is in sCode of its result, code of response is 1 then.
+func (r *Repository) do(ctx context.Context, method, endPoint string, params url.Values, body any, creds *models.Credentials) ([]byte, error) {
+	requestPath := endPoint
+	if len(params) > 0 {
+		requestPath += "?" + params.Encode()
+	}
+
+	var rawBody []byte
+	if body != nil {
+		var err error
+		rawBody, err = json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("marshal request failed: %w", err)
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, r.url+requestPath, bytes.NewReader(rawBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed create new request: %w", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	if creds != nil {
+		timestamp := r.clock.now().UTC().Format(timestampLayout)
+		request.Header.Set("OK-ACCESS-KEY", creds.ApiKey)
+		request.Header.Set("OK-ACCESS-SIGN", sign(timestamp, method, requestPath, string(rawBody), creds.SecretKey))
+		request.Header.Set("OK-ACCESS-TIMESTAMP", timestamp)
+		request.Header.Set("OK-ACCESS-PASSPHRASE", creds.Passphrase)
+	}
+
+	response, err := r.cli.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed do request: %w", err)
+	}
+	defer response.Body.Close()
+
+	raw, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed read body: %w", err)
+	}
+
+	var envelope struct {
+		Code string          `json:"code"`
+		Msg  string          `json:"msg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err = json.Unmarshal(raw, &envelope); err != nil {
+		if response.StatusCode >= http.StatusBadRequest {
+			return nil, fmt.Errorf("bad response status: %s", response.Status)
+		}
+		return nil, fmt.Errorf("unmarshal response failed: %w", err)
+	}
+
+	code, _ := strconv.Atoi(envelope.Code)
+	if code != CodeOK {
+		var results []orderResult
+		_ = json.Unmarshal(envelope.Data, &results)
+		for _, result := range results {
+			if sCode, _ := strconv.Atoi(result.SCode); sCode != CodeOK {
+				return nil, NewError(sCode, result.SMsg)
+			}
+		}
+		return nil, NewError(code, envelope.Msg)
+	}
+
+	return envelope.Data, nil
+}
+
+// sign returns base64 of HMAC SHA256 of timestamp, method, request path with query and body.
+func sign(timestamp, method, requestPath, body, secretKey string) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write([]byte(timestamp + method + requestPath + body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// orderInfo returns okx's order in bybit's form which models use.
+func orderInfo(o order) models.OrderInfo {
+	executed := parseFloat(o.AccFillSz)
+	avgPrice := parseFloat(o.AvgPx)
+
+	info := models.OrderInfo{
+		Symbol:       symbolOf(o.InstId),
+		OrderId:      o.OrdId,
+		OrderLinkId:  orderLinkId(o.ClOrdId),
+		OrderType:    titleCase(o.OrdType),
+		Side:         titleCase(o.Side),
+		Price:        o.Px,
+		Qty:          o.Sz,
+		AvgPrice:     o.AvgPx,
+		CumExecQty:   o.AccFillSz,
+		CumExecValue: formatFloat(executed * avgPrice),
+		CumExecFee:   formatFloat(orderFee(o)),
+		LeavesQty:    formatFloat(math.Max(parseFloat(o.Sz)-executed, 0)),
+		CreatedTime:  o.CTime,
+		UpdatedTime:  o.UTime,
+	}
+
+	switch o.State {
+	case stateLive:
+		info.OrderStatus = models.OrderStatusNew
+	case statePartiallyFilled:
+		info.OrderStatus = models.OrderStatusPartiallyFilled
+	case stateFilled:
+		info.OrderStatus = models.OrderStatusFilled
+	case stateCanceled, stateMmpCanceled:
+		info.OrderStatus = models.OrderStatusCancelled
+		if executed > 0 {
+			info.OrderStatus = models.OrderStatusPartiallyFilledCanceled
+		}
+	default:
+		info.OrderStatus = o.State
+	}
+
+	return info
+}
+
+// orderFee returns fee of order as bybit counts it: in base coin for buy and in quote coin for
+// sell. Fee which is paid in other coin does not change position.
+func orderFee(o order) float64 {
+	base, quote, _ := strings.Cut(o.InstId, "-")
+
+	feeCoin := quote
+	if o.Side == "buy" {
+		feeCoin = base
+	}
+	if o.FeeCcy != feeCoin {
+		return 0
+	}
+	return -parseFloat(o.Fee)
+}
+
+// instrumentCoiniks returns coiniks of okx's spot instrument, decimals are taken from its steps.
+func instrumentCoiniks(info instrument) (models.Coiniks, error) {
+	coiniks := models.Coiniks{Name: symbolOf(info.InstId), Status: info.State}
+	if info.State == "live" {
+		coiniks.Status = models.CoiniksStatusTrading
+	}
+
+	fields := []struct {
+		name  string
+		value string
+		dst   *float64
+	}{
+		{"lotSz", info.LotSz, &coiniks.BasePrecision},
+		{"tickSz", info.TickSz, &coiniks.TickSize},
+		{"minSz", info.MinSz, &coiniks.MinOrderQty},
+		{"maxLmtSz", info.MaxLmtSz, &coiniks.MaxOrderQty},
+	}
+	for _, field := range fields {
+		if field.value == "" {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(field.value, 64)
+		if err != nil {
+			return models.Coiniks{}, fmt.Errorf("parse %s failed: %w", field.name, err)
+		}
+		*field.dst = value
+	}
+
+	if coiniks.BasePrecision <= 0 || coiniks.TickSize <= 0 {
+		return models.Coiniks{}, fmt.Errorf("instrument has no steps")
+	}
+
+	coiniks.QtyDecimals = models.StepDecimals(coiniks.BasePrecision)
+	coiniks.PriceDecimals = models.StepDecimals(coiniks.TickSize)
+	return coiniks, nil
+}
+
+// instId returns okx's instrument of symbol, e.g. BTC-USDT for BTCUSDT.
+func instId(symbol string) string {
+	return strings.TrimSuffix(symbol, quoteCoin) + "-" + quoteCoin
+}
+
+// symbolOf returns symbol of okx's instrument, e.g. BTCUSDT for BTC-USDT.
+func symbolOf(instId string) string {
+	return strings.ReplaceAll(instId, "-", "")
+}
+
+// clOrdId returns orderLinkId as okx's client id, it allows only letters and digits. Bot's ids
+// have no capital letters, so dash is replaced by Z and it is restored by orderLinkId.
+func clOrdId(orderLinkId string) string {
+	return strings.ReplaceAll(orderLinkId, "-", "Z")
+}
+
+func orderLinkId(clOrdId string) string {
+	return strings.ReplaceAll(clOrdId, "Z", "-")
+}
+
+// titleCase returns okx's enum as bybit's one, e.g. Buy for buy.
+func titleCase(value string) string {
+	if value == "" {
+		return value
+	}
+	return strings.ToUpper(value[:1]) + value[1:]
+}
+
+func parseFloat(value string) float64 {
+	f, _ := strconv.ParseFloat(value, 64)
+	return f
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// backoff returns delay before retry of attempt, it is doubled every attempt and has jitter.
+func backoff(attempt int) time.Duration {
+	delay := retryMinDelay << attempt
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
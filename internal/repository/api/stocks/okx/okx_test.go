@@ -0,0 +1,224 @@
+package okx
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"m1pes/internal/models"
+)
+
+const (
+	testApiKey     = "key"
+	testSecretKey  = "secret"
+	testPassphrase = "passphrase"
+)
+
+var testCreds = models.Credentials{Exchange: models.ExchangeOKX, ApiKey: testApiKey, SecretKey: testSecretKey, Passphrase: testPassphrase}
+
+// recorded is body of request which test server has got.
+type recorded struct {
+	query string
+	body  map[string]string
+}
+
+// newTestServer serves okx's recorded responses of testdata by method and endpoint, it checks
+// signature and passphrase of private requests as okx does.
+func newTestServer(t *testing.T, responses map[string]string) (*Repository, map[string][]recorded) {
+	t.Helper()
+
+	requests := make(map[string][]recorded)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		raw, _ := io.ReadAll(req.Body)
+
+		rec := recorded{query: req.URL.RawQuery}
+		_ = json.Unmarshal(raw, &rec.body)
+		key := req.Method + " " + req.URL.Path
+		requests[key] = append(requests[key], rec)
+
+		if req.Header.Get("OK-ACCESS-KEY") != "" {
+			requestPath := req.URL.Path
+			if req.URL.RawQuery != "" {
+				requestPath += "?" + req.URL.RawQuery
+			}
+
+			switch {
+			case req.Header.Get("OK-ACCESS-SIGN") != sign(req.Header.Get("OK-ACCESS-TIMESTAMP"), req.Method, requestPath, string(raw), testSecretKey):
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"code":"50113","msg":"Invalid Sign"}`))
+				return
+			case req.Header.Get("OK-ACCESS-PASSPHRASE") != testPassphrase:
+				w.WriteHeader(http.StatusUnauthorized)
+				_, _ = w.Write([]byte(`{"code":"50105","msg":"Your OK-ACCESS-PASSPHRASE is incorrect."}`))
+				return
+			}
+		}
+
+		name, ok := responses[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Errorf("read recorded response: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	return New(server.URL), requests
+}
+
+// TestPlaceOrder checks that order is signed, its size is in base coin and its orderLinkId
+// comes back from okx's client id.
+func TestPlaceOrder(t *testing.T) {
+	repo, requests := newTestServer(t, map[string]string{
+		"POST " + PlaceOrderEndpoint: "place_order.json",
+	})
+
+	placed, err := repo.PlaceOrder(context.Background(), testCreds, models.PlaceOrderRequest{
+		Symbol:      "BTCUSDT",
+		Side:        "Buy",
+		OrderType:   "Market",
+		Qty:         "0.002",
+		OrderLinkId: "m1-5f3a9c1e-b-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed.OrderId != "312269865356374016" || placed.OrderLinkId != "m1-5f3a9c1e-b-1" {
+		t.Fatalf("placed order is %+v", placed)
+	}
+
+	body := requests["POST "+PlaceOrderEndpoint][0].body
+	if body["instId"] != "BTC-USDT" || body["tdMode"] != "cash" || body["side"] != "buy" || body["ordType"] != "market" ||
+		body["sz"] != "0.002" || body["tgtCcy"] != "base_ccy" || body["clOrdId"] != "m1Z5f3a9c1eZbZ1" {
+		t.Fatalf("order request is %v", body)
+	}
+	if _, ok := body["px"]; ok {
+		t.Fatalf("market order has price %s", body["px"])
+	}
+}
+
+// TestGetOrders checks state and fee of okx's order in models' form and its executions.
+func TestGetOrders(t *testing.T) {
+	repo, _ := newTestServer(t, map[string]string{
+		"GET " + GetOrderEndpoint: "get_order.json",
+		"GET " + FillsEndpoint:    "fills.json",
+	})
+
+	orders, err := repo.GetOrders(context.Background(), testCreds, models.OrderQuery{Symbol: "BTCUSDT", OrderId: "312269865356374016"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("got %d orders", len(orders))
+	}
+
+	order := orders[0]
+	if order.Symbol != "BTCUSDT" || order.OrderStatus != models.OrderStatusPartiallyFilledCanceled || order.Side != "Buy" ||
+		order.OrderType != "Limit" || order.OrderLinkId != "m1-5f3a9c1e-b-1" || order.CumExecQty != "0.001" ||
+		order.CumExecValue != "30" || order.CumExecFee != "0.000001" || order.LeavesQty != "0.001" {
+		t.Fatalf("order is %+v", order)
+	}
+
+	executions, err := repo.GetExecutions(context.Background(), testCreds, "BTCUSDT", order.OrderId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 1 || executions[0].ExecId != "744876980" || executions[0].ExecFee != "0.000001" ||
+		executions[0].ExecValue != "30" || executions[0].FeeCurrency != "BTC" || executions[0].Side != "Buy" {
+		t.Fatalf("executions are %+v", executions)
+	}
+}
+
+// TestAccount checks price, balances, instruments and permissions of key.
+func TestAccount(t *testing.T) {
+	repo, requests := newTestServer(t, map[string]string{
+		"GET " + TickerEndpoint:        "ticker.json",
+		"GET " + BalanceEndpoint:       "balance.json",
+		"GET " + InstrumentsEndpoint:   "instruments.json",
+		"GET " + AccountConfigEndpoint: "account_config.json",
+	})
+	ctx := context.Background()
+
+	price, err := repo.GetPrice(ctx, testCreds, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price != 30012.5 || requests["GET "+TickerEndpoint][0].query != "instId=BTC-USDT" {
+		t.Fatalf("price is %v by query %s", price, requests["GET "+TickerEndpoint][0].query)
+	}
+
+	balances, err := repo.GetBalances(ctx, testCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balances.TotalEquity != 1030.11 || balances.Coins["BTC"] != 0.001 || balances.Coins["USDT"] != 1000 {
+		t.Fatalf("balances are %+v", balances)
+	}
+
+	instruments, err := repo.GetInstruments(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instruments) != 2 {
+		t.Fatalf("got %d instruments", len(instruments))
+	}
+	btc := instruments[0]
+	if btc.Name != "BTCUSDT" || btc.Status != models.CoiniksStatusTrading || btc.BasePrecision != 0.00000001 ||
+		btc.QtyDecimals != 8 || btc.TickSize != 0.1 || btc.PriceDecimals != 1 || btc.MinOrderQty != 0.00001 {
+		t.Fatalf("instrument is %+v", btc)
+	}
+	if instruments[1].Status != "suspend" {
+		t.Fatalf("suspended instrument has status %s", instruments[1].Status)
+	}
+
+	perm, err := repo.GetKeyPermissions(ctx, testCreds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm != (models.KeyPermissions{SpotTrade: true}) {
+		t.Fatalf("permissions are %+v", perm)
+	}
+}
+
+// TestErrorKinds checks that okx's errors and errors of orders have kinds and unknown order
+// is empty list.
+func TestErrorKinds(t *testing.T) {
+	repo, requests := newTestServer(t, map[string]string{
+		"POST " + PlaceOrderEndpoint: "place_order_insufficient.json",
+		"GET " + GetOrderEndpoint:    "order_not_exists.json",
+	})
+	ctx := context.Background()
+
+	_, err := repo.PlaceOrder(ctx, testCreds, models.PlaceOrderRequest{Symbol: "BTCUSDT", Side: "Buy", OrderType: "Limit", Qty: "1", Price: "30000", OrderLinkId: "m1-5f3a9c1e-b-1"})
+	if kind := models.ErrorKindOf(err); kind != models.ErrorKindInsufficientFunds {
+		t.Fatalf("place order error is %v of kind %q", err, kind)
+	}
+	// Refused order is not retried even with client id.
+	if n := len(requests["POST "+PlaceOrderEndpoint]); n != 1 {
+		t.Fatalf("order is placed %d times", n)
+	}
+
+	orders, err := repo.GetOrders(ctx, testCreds, models.OrderQuery{Symbol: "BTCUSDT", OrderLinkId: "m1-5f3a9c1e-b-1"})
+	if err != nil || len(orders) != 0 {
+		t.Fatalf("got %d orders, err %v", len(orders), err)
+	}
+
+	wrong := testCreds
+	wrong.Passphrase = "wrong"
+	_, err = repo.GetBalances(ctx, wrong)
+	if kind := models.ErrorKindOf(err); kind != models.ErrorKindAuth {
+		t.Fatalf("get balances error is %v of kind %q", err, kind)
+	}
+}
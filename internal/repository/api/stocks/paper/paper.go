@@ -0,0 +1,418 @@
+package paper
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"m1pes/internal/config"
+	"m1pes/internal/logging"
+	"m1pes/internal/models"
+
+	apiStock "m1pes/internal/repository/api/stocks"
+	"m1pes/internal/repository/api/stocks/sim"
+	storagePaper "m1pes/internal/repository/storage/paper"
+)
+
+const (
+	DefaultInitialBalance = 10000
+	DefaultReplayStep     = time.Second
+
+	// Orders and executions of that period are loaded into simulated exchange on start.
+	restorePeriod = 30 * 24 * time.Hour
+	// How long order waits for first price of symbol from feed before live price is asked.
+	priceWait = 5 * time.Second
+)
+
+// Repository sends calls with api keys of paper accounts to simulated exchange and all other
+// calls to live exchange. Simulated exchange is matched by prices from prices stream, its
+// state is kept in storage and restored by Load.
+type Repository struct {
+	live       apiStock.Repository
+	liveStream apiStock.StreamRepository
+	prices     apiStock.StreamRepository
+	storage    storagePaper.Repository
+	exchange   *sim.Exchange
+
+	initialBalance float64
+
+	mu sync.Mutex
+	// Active paper api keys and their users.
+	accounts map[string]int64
+	// Users which have had paper account, they get initial balance only once.
+	known       map[int64]bool
+	feeds       map[string]bool
+	subscribers map[int64][]chan models.PrivateEvent
+}
+
+func New(live apiStock.Repository, liveStream, prices apiStock.StreamRepository, storage storagePaper.Repository, cfg config.PaperConfig) *Repository {
+	fees := sim.Fees{Maker: cfg.MakerFee, Taker: cfg.TakerFee}
+	if fees.Maker == 0 && fees.Taker == 0 {
+		fees = sim.Fees{Maker: sim.DefaultMakerFee, Taker: sim.DefaultTakerFee}
+	}
+
+	initialBalance := cfg.InitialBalance
+	if initialBalance <= 0 {
+		initialBalance = DefaultInitialBalance
+	}
+
+	r := &Repository{
+		live:           live,
+		liveStream:     liveStream,
+		prices:         prices,
+		storage:        storage,
+		exchange:       sim.New(fees),
+		initialBalance: initialBalance,
+		accounts:       make(map[string]int64),
+		known:          make(map[int64]bool),
+		feeds:          make(map[string]bool),
+		subscribers:    make(map[int64][]chan models.PrivateEvent),
+	}
+	r.exchange.SetListener(r.onEvent)
+
+	return r
+}
+
+// Load restores paper accounts from storage and starts price feeds of their coins.
+func (r *Repository) Load(ctx context.Context) error {
+	accounts, err := r.storage.GetAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	since := time.Now().Add(-restorePeriod)
+	symbols := make(map[string]bool)
+
+	for _, account := range accounts {
+		ctx := logging.WithUserId(ctx, account.UserId)
+
+		balances, err := r.storage.GetBalances(ctx, account.UserId)
+		if err != nil {
+			return logging.WrapError(ctx, err)
+		}
+
+		orders, err := r.storage.GetOrders(ctx, account.UserId, since)
+		if err != nil {
+			return logging.WrapError(ctx, err)
+		}
+
+		executions, err := r.storage.GetExecutions(ctx, account.UserId, since)
+		if err != nil {
+			return logging.WrapError(ctx, err)
+		}
+
+		err = r.exchange.Restore(accountId(account.UserId), balances, orders, executions)
+		if err != nil {
+			return logging.WrapError(ctx, err)
+		}
+
+		for coin, amount := range balances {
+			if coin != sim.QuoteCoin && amount > 0 {
+				symbols[coin+sim.QuoteCoin] = true
+			}
+		}
+		for _, order := range orders {
+			if order.OrderStatus == sim.OrderStatusNew || order.OrderStatus == sim.OrderStatusPartiallyFilled {
+				symbols[order.Symbol] = true
+			}
+		}
+
+		r.mu.Lock()
+		r.known[account.UserId] = true
+		if account.Active {
+			r.accounts[account.ApiKey] = account.UserId
+		}
+		r.mu.Unlock()
+	}
+
+	for symbol := range symbols {
+		if err = r.ensureFeed(symbol); err != nil {
+			slog.ErrorContext(logging.WithCoinTag(ctx, symbol), "error in ensureFeed", "err", err)
+		}
+	}
+
+	return nil
+}
+
+// SetPaper binds api key of user to simulated exchange or unbinds it. Account gets initial
+// balance when it is enabled for the first time.
+func (r *Repository) SetPaper(ctx context.Context, userId int64, apiKey string, paper bool) error {
+	err := r.storage.SaveAccount(ctx, models.PaperAccount{UserId: userId, ApiKey: apiKey, Active: paper})
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	for key, id := range r.accounts {
+		if id == userId {
+			delete(r.accounts, key)
+		}
+	}
+	if paper {
+		r.accounts[apiKey] = userId
+	}
+
+	deposit := paper && !r.known[userId]
+	if paper {
+		r.known[userId] = true
+	}
+	r.mu.Unlock()
+
+	if deposit {
+		r.exchange.Deposit(accountId(userId), sim.QuoteCoin, r.initialBalance)
+	}
+
+	return nil
+}
+
+func (r *Repository) IsPaper(apiKey string) bool {
+	_, ok := r.account(apiKey)
+	return ok
+}
+
+func (r *Repository) GetCoin(ctx context.Context, coinReq models.GetCoinRequest, apiKey, secretKey string) (models.GetCoinResponse, error) {
+	account, ok := r.account(apiKey)
+	if !ok {
+		return r.live.GetCoin(ctx, coinReq, apiKey, secretKey)
+	}
+
+	if err := r.ensurePrice(ctx, fmt.Sprint(coinReq["symbol"])); err != nil {
+		return models.GetCoinResponse{}, err
+	}
+	return r.exchange.GetCoin(ctx, coinReq, account, secretKey)
+}
+
+func (r *Repository) GetUserWalletBalance(ctx context.Context, req models.GetUserWalletRequest, apiKey, secretKey string) (models.GetUserWalletResponse, error) {
+	account, ok := r.account(apiKey)
+	if !ok {
+		return r.live.GetUserWalletBalance(ctx, req, apiKey, secretKey)
+	}
+	return r.exchange.GetUserWalletBalance(ctx, req, account, secretKey)
+}
+
+func (r *Repository) CreateOrder(ctx context.Context, orderReq models.CreateOrderRequest, apiKey, secretKey string) (models.CreateOrderResponse, error) {
+	account, ok := r.account(apiKey)
+	if !ok {
+		return r.live.CreateOrder(ctx, orderReq, apiKey, secretKey)
+	}
+
+	if err := r.ensurePrice(ctx, orderReq.Symbol); err != nil {
+		return models.CreateOrderResponse{}, err
+	}
+	return r.exchange.CreateOrder(ctx, orderReq, account, secretKey)
+}
+
+func (r *Repository) CancelOrder(ctx context.Context, orderReq models.CancelOrderRequest, apiKey, secretKey string) (models.CancelOrderResponse, error) {
+	account, ok := r.account(apiKey)
+	if !ok {
+		return r.live.CancelOrder(ctx, orderReq, apiKey, secretKey)
+	}
+	return r.exchange.CancelOrder(ctx, orderReq, account, secretKey)
+}
+
+func (r *Repository) GetOrder(ctx context.Context, orderReq models.GetOrderRequest, apiKey, secretKey string) (models.GetOrderResponse, error) {
+	account, ok := r.account(apiKey)
+	if !ok {
+		return r.live.GetOrder(ctx, orderReq, apiKey, secretKey)
+	}
+	return r.exchange.GetOrder(ctx, orderReq, account, secretKey)
+}
+
+func (r *Repository) GetExecutions(ctx context.Context, req models.GetExecutionsRequest, apiKey, secretKey string) (models.GetExecutionsResponse, error) {
+	account, ok := r.account(apiKey)
+	if !ok {
+		return r.live.GetExecutions(ctx, req, apiKey, secretKey)
+	}
+	return r.exchange.GetExecutions(ctx, req, account, secretKey)
+}
+
+func (r *Repository) CreateSignRequestAndGetRespBody(ctx context.Context, params, endPoint, method, apiKey, apiSecret string) ([]byte, error) {
+	account, ok := r.account(apiKey)
+	if !ok {
+		return r.live.CreateSignRequestAndGetRespBody(ctx, params, endPoint, method, apiKey, apiSecret)
+	}
+	return r.exchange.CreateSignRequestAndGetRespBody(ctx, params, endPoint, method, account, apiSecret)
+}
+
+func (r *Repository) SubscribeTickers(ctx context.Context, symbol string) (<-chan models.TickerEvent, error) {
+	return r.prices.SubscribeTickers(ctx, symbol)
+}
+
+// SubscribePrivate gives events of simulated exchange to paper accounts.
+func (r *Repository) SubscribePrivate(ctx context.Context, apiKey, secretKey string) (<-chan models.PrivateEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	userId, ok := r.accounts[apiKey]
+	if !ok {
+		return r.liveStream.SubscribePrivate(ctx, apiKey, secretKey)
+	}
+
+	events := make(chan models.PrivateEvent, 64)
+	r.subscribers[userId] = append(r.subscribers[userId], events)
+
+	go func() {
+		<-ctx.Done()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		subscribers := r.subscribers[userId]
+		for i, subscriber := range subscribers {
+			if subscriber == events {
+				r.subscribers[userId] = append(subscribers[:i], subscribers[i+1:]...)
+				break
+			}
+		}
+		close(events)
+	}()
+
+	return events, nil
+}
+
+// onEvent stores every change of simulated exchange and sends it to user's subscribers.
+func (r *Repository) onEvent(account string, event models.PrivateEvent) {
+	userId, err := strconv.ParseInt(account, 10, 64)
+	if err != nil {
+		return
+	}
+
+	ctx := logging.WithUserId(context.Background(), userId)
+
+	for _, order := range event.Orders {
+		if err = r.storage.SaveOrder(ctx, userId, order); err != nil {
+			slog.ErrorContext(logging.WithOrderId(ctx, order.OrderId), "error in SaveOrder", "err", err)
+		}
+	}
+
+	for _, execution := range event.Executions {
+		if err = r.storage.SaveExecution(ctx, userId, execution); err != nil {
+			slog.ErrorContext(logging.WithOrderId(ctx, execution.OrderId), "error in SaveExecution", "err", err)
+		}
+	}
+
+	for _, wallet := range event.Wallets {
+		for _, coin := range wallet.Coin {
+			amount, err := strconv.ParseFloat(coin.Equity, 64)
+			if err != nil {
+				slog.ErrorContext(ctx, "error parsing paper balance", "err", err)
+				continue
+			}
+
+			if err = r.storage.SaveBalance(ctx, userId, coin.Coin, amount); err != nil {
+				slog.ErrorContext(ctx, "error in SaveBalance", "err", err)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Slow subscriber skips events as with real stream, reconcile catches up.
+	for _, subscriber := range r.subscribers[userId] {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// ensurePrice makes sure simulated exchange knows price of symbol before order is matched.
+// Feed is waited for a while, live price is asked if it has not come.
+func (r *Repository) ensurePrice(ctx context.Context, symbol string) error {
+	if _, ok := r.exchange.Price(symbol); ok {
+		return nil
+	}
+
+	if err := r.ensureFeed(symbol); err != nil {
+		slog.ErrorContext(logging.WithCoinTag(ctx, symbol), "error in ensureFeed", "err", err)
+	}
+
+	deadline := time.Now().Add(priceWait)
+	for time.Now().Before(deadline) {
+		if _, ok := r.exchange.Price(symbol); ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	getCoinReq := make(models.GetCoinRequest)
+	getCoinReq["category"] = "spot"
+	getCoinReq["symbol"] = symbol
+
+	getCoinResp, err := r.live.GetCoin(ctx, getCoinReq, "", "")
+	if err != nil {
+		return err
+	}
+
+	if len(getCoinResp.Result.List) == 0 {
+		return fmt.Errorf("no price of %s", symbol)
+	}
+
+	price, err := strconv.ParseFloat(getCoinResp.Result.List[0].Price, 64)
+	if err != nil {
+		return fmt.Errorf("parse price of %s failed: %w", symbol, err)
+	}
+
+	r.exchange.SetPrice(symbol, price)
+	return nil
+}
+
+// ensureFeed subscribes simulated exchange to prices of symbol once.
+func (r *Repository) ensureFeed(symbol string) error {
+	r.mu.Lock()
+	if r.feeds[symbol] {
+		r.mu.Unlock()
+		return nil
+	}
+	r.feeds[symbol] = true
+	r.mu.Unlock()
+
+	tickers, err := r.prices.SubscribeTickers(context.Background(), symbol)
+	if err != nil {
+		r.mu.Lock()
+		delete(r.feeds, symbol)
+		r.mu.Unlock()
+		return err
+	}
+
+	go func() {
+		for ticker := range tickers {
+			price, err := strconv.ParseFloat(ticker.Price, 64)
+			if err != nil || price <= 0 {
+				continue
+			}
+			r.exchange.SetPrice(symbol, price)
+		}
+
+		r.mu.Lock()
+		delete(r.feeds, symbol)
+		r.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// account returns account of simulated exchange by user's api key.
+func (r *Repository) account(apiKey string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	userId, ok := r.accounts[apiKey]
+	if !ok {
+		return "", false
+	}
+	return accountId(userId), true
+}
+
+func accountId(userId int64) string {
+	return strconv.FormatInt(userId, 10)
+}
@@ -0,0 +1,108 @@
+package stocks
+
+import (
+	"context"
+	"fmt"
+
+	"m1pes/internal/models"
+)
+
+// Router sends every call to exchange of credentials. Paper accounts are simulated by bybit's
+// paper exchange whatever exchange their keys belong to, so they go to default exchange.
+type Router struct {
+	def       Exchange
+	exchanges map[string]Exchange
+	paper     PaperRepository
+}
+
+// NewRouter creates router whose default exchange is bybit, paper can be nil.
+func NewRouter(def Exchange, exchanges map[string]Exchange, paper PaperRepository) *Router {
+	return &Router{def: def, exchanges: exchanges, paper: paper}
+}
+
+// Exchanges returns every exchange of router by its name, default one is bybit.
+func (r *Router) Exchanges() map[string]Exchange {
+	exchanges := make(map[string]Exchange, len(r.exchanges)+1)
+	for name, exchange := range r.exchanges {
+		exchanges[name] = exchange
+	}
+	exchanges[models.ExchangeBybit] = r.def
+	return exchanges
+}
+
+func (r *Router) exchange(creds models.Credentials) (Exchange, error) {
+	if creds.Exchange == "" || creds.Exchange == models.ExchangeBybit {
+		return r.def, nil
+	}
+	if r.paper != nil && r.paper.IsPaper(creds.ApiKey) {
+		return r.def, nil
+	}
+
+	exchange, ok := r.exchanges[creds.Exchange]
+	if !ok {
+		return nil, fmt.Errorf("unknown exchange %q", creds.Exchange)
+	}
+	return exchange, nil
+}
+
+func (r *Router) PlaceOrder(ctx context.Context, creds models.Credentials, req models.PlaceOrderRequest) (models.PlacedOrder, error) {
+	exchange, err := r.exchange(creds)
+	if err != nil {
+		return models.PlacedOrder{}, err
+	}
+	return exchange.PlaceOrder(ctx, creds, req)
+}
+
+func (r *Router) CancelOrder(ctx context.Context, creds models.Credentials, symbol, orderId string) error {
+	exchange, err := r.exchange(creds)
+	if err != nil {
+		return err
+	}
+	return exchange.CancelOrder(ctx, creds, symbol, orderId)
+}
+
+func (r *Router) GetOrders(ctx context.Context, creds models.Credentials, query models.OrderQuery) ([]models.OrderInfo, error) {
+	exchange, err := r.exchange(creds)
+	if err != nil {
+		return nil, err
+	}
+	return exchange.GetOrders(ctx, creds, query)
+}
+
+func (r *Router) GetExecutions(ctx context.Context, creds models.Credentials, symbol, orderId string) ([]models.ExecutionEvent, error) {
+	exchange, err := r.exchange(creds)
+	if err != nil {
+		return nil, err
+	}
+	return exchange.GetExecutions(ctx, creds, symbol, orderId)
+}
+
+func (r *Router) GetPrice(ctx context.Context, creds models.Credentials, symbol string) (float64, error) {
+	exchange, err := r.exchange(creds)
+	if err != nil {
+		return 0, err
+	}
+	return exchange.GetPrice(ctx, creds, symbol)
+}
+
+func (r *Router) GetBalances(ctx context.Context, creds models.Credentials) (models.Balances, error) {
+	exchange, err := r.exchange(creds)
+	if err != nil {
+		return models.Balances{}, err
+	}
+	return exchange.GetBalances(ctx, creds)
+}
+
+// GetInstruments returns instruments of default exchange, instruments of other exchanges are
+// got from Exchanges.
+func (r *Router) GetInstruments(ctx context.Context) ([]models.Coiniks, error) {
+	return r.def.GetInstruments(ctx)
+}
+
+func (r *Router) GetKeyPermissions(ctx context.Context, creds models.Credentials) (models.KeyPermissions, error) {
+	exchange, err := r.exchange(creds)
+	if err != nil {
+		return models.KeyPermissions{}, err
+	}
+	return exchange.GetKeyPermissions(ctx, creds)
+}
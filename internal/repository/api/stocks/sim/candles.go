@@ -0,0 +1,116 @@
+package sim
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"m1pes/internal/models"
+)
+
+// PricePath returns prices which candle has passed through: open, low, high, close for rising
+// candle and open, high, low, close for falling one.
+func PricePath(candle models.Candle) []float64 {
+	if candle.Close >= candle.Open {
+		return []float64{candle.Open, candle.Low, candle.High, candle.Close}
+	}
+	return []float64{candle.Open, candle.High, candle.Low, candle.Close}
+}
+
+// LoadCandlesCSV reads candles from csv file with columns time, open, high, low, close and
+// optional volume. Time is unix milliseconds or RFC3339, header line is skipped.
+func LoadCandlesCSV(path string) ([]models.Candle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+
+	candles := make([]models.Candle, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv failed: %w", err)
+		}
+
+		candle, err := ParseCandle(record)
+		if err != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		candles = append(candles, candle)
+	}
+
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time.Before(candles[j].Time) })
+
+	return candles, nil
+}
+
+// WriteCandlesCSV writes candles in format which is read by LoadCandlesCSV.
+func WriteCandlesCSV(path string, candles []models.Candle) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	_ = writer.Write([]string{"time", "open", "high", "low", "close", "volume"})
+	for _, candle := range candles {
+		_ = writer.Write([]string{
+			strconv.FormatInt(candle.Time.UnixMilli(), 10),
+			strconv.FormatFloat(candle.Open, 'f', -1, 64),
+			strconv.FormatFloat(candle.High, 'f', -1, 64),
+			strconv.FormatFloat(candle.Low, 'f', -1, 64),
+			strconv.FormatFloat(candle.Close, 'f', -1, 64),
+			strconv.FormatFloat(candle.Volume, 'f', -1, 64),
+		})
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// ParseCandle parses [time, open, high, low, close, volume...], it is both csv line and Bybit's kline.
+func ParseCandle(fields []string) (models.Candle, error) {
+	if len(fields) < 5 {
+		return models.Candle{}, fmt.Errorf("candle has %d fields, at least 5 are needed", len(fields))
+	}
+
+	var candle models.Candle
+
+	if ms, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+		candle.Time = time.UnixMilli(ms).UTC()
+	} else if t, err := time.Parse(time.RFC3339, fields[0]); err == nil {
+		candle.Time = t
+	} else {
+		return models.Candle{}, fmt.Errorf("parse candle time %q failed", fields[0])
+	}
+
+	values := []*float64{&candle.Open, &candle.High, &candle.Low, &candle.Close, &candle.Volume}
+	for i, value := range values {
+		if i+1 >= len(fields) {
+			break
+		}
+
+		parsed, err := strconv.ParseFloat(fields[i+1], 64)
+		if err != nil {
+			return models.Candle{}, fmt.Errorf("parse candle field %d failed: %w", i+1, err)
+		}
+		*value = parsed
+	}
+
+	return candle, nil
+}
@@ -0,0 +1,73 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"m1pes/internal/models"
+)
+
+// ReplayStream sends tickers from historical candles instead of exchange, so bot can be run
+// on replayed prices. Candles of symbol are read from <dir>/<symbol>.csv, every price of
+// candle's path is sent after step and replay starts again when candles are over.
+type ReplayStream struct {
+	dir  string
+	step time.Duration
+}
+
+func NewReplayStream(dir string, step time.Duration) *ReplayStream {
+	return &ReplayStream{dir: dir, step: step}
+}
+
+func (s *ReplayStream) SubscribeTickers(ctx context.Context, symbol string) (<-chan models.TickerEvent, error) {
+	candles, err := LoadCandlesCSV(filepath.Join(s.dir, symbol+".csv"))
+	if err != nil {
+		return nil, fmt.Errorf("load candles of %s failed: %w", symbol, err)
+	}
+
+	if len(candles) == 0 {
+		return nil, fmt.Errorf("no candles of %s to replay", symbol)
+	}
+
+	tickers := make(chan models.TickerEvent, 1)
+
+	go func() {
+		defer close(tickers)
+
+		ticker := time.NewTicker(s.step)
+		defer ticker.Stop()
+
+		for {
+			for _, candle := range candles {
+				for _, price := range PricePath(candle) {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+					}
+
+					event := models.TickerEvent{
+						Symbol: symbol,
+						Price:  strconv.FormatFloat(price, 'f', -1, 64),
+						Ts:     candle.Time.UnixMilli(),
+					}
+
+					// Slow subscriber skips prices as with real stream.
+					select {
+					case tickers <- event:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	return tickers, nil
+}
+
+func (s *ReplayStream) SubscribePrivate(ctx context.Context, apiKey, secretKey string) (<-chan models.PrivateEvent, error) {
+	return nil, fmt.Errorf("private stream: %w", ErrNotSupported)
+}
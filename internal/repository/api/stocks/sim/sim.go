@@ -0,0 +1,745 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"m1pes/internal/models"
+	"m1pes/internal/repository/api/stocks/bybit"
+)
+
+const (
+	QuoteCoin = "USDT"
+
+	OrderStatusNew                     = "New"
+	OrderStatusPartiallyFilled         = "PartiallyFilled"
+	OrderStatusFilled                  = "Filled"
+	OrderStatusCancelled               = "Cancelled"
+	OrderStatusPartiallyFilledCanceled = "PartiallyFilledCanceled"
+
+	// Default fees of Bybit spot for regular users.
+	DefaultMakerFee = 0.001
+	DefaultTakerFee = 0.001
+)
+
+var ErrNotSupported = errors.New("not supported by simulated exchange")
+
+// Fees are rates which are taken from every execution. Fee of buy is taken in base coin
+// and fee of sell in quote coin, as on Bybit spot.
+type Fees struct {
+	Maker float64
+	Taker float64
+}
+
+// Exchange is in-memory spot exchange which implements api repository. Limit orders rest
+// in book until price reaches them, prices are set from outside, e.g. from historical candles.
+// Every api key has its own account.
+type Exchange struct {
+	fees Fees
+
+	mu       sync.Mutex
+	clock    func() time.Time
+	prices   map[string]float64
+	accounts map[string]*account
+	seq      int64
+	listener Listener
+}
+
+// Listener receives every order, execution and wallet change of account as private stream
+// event. It is called under exchange's lock, so it must not call exchange back.
+type Listener func(account string, event models.PrivateEvent)
+
+type account struct {
+	balances   map[string]float64
+	locked     map[string]float64
+	orders     map[string]*order
+	executions []models.ExecutionEvent
+}
+
+type order struct {
+	id          string
+	linkId      string
+	account     string
+	symbol      string
+	side        string
+	orderType   string
+	price       float64
+	qty         float64
+	locked      float64
+	status      string
+	execQty     float64
+	execValue   float64
+	execFee     float64
+	createdTime time.Time
+	updatedTime time.Time
+}
+
+func New(fees Fees) *Exchange {
+	return &Exchange{
+		fees:     fees,
+		clock:    time.Now,
+		prices:   make(map[string]float64),
+		accounts: make(map[string]*account),
+	}
+}
+
+// SetTime stops exchange's clock at t, it is used for orders' and executions' timestamps.
+// By default exchange uses current time.
+func (e *Exchange) SetTime(t time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.clock = func() time.Time { return t }
+}
+
+// Now returns exchange's clock.
+func (e *Exchange) Now() time.Time {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.clock()
+}
+
+// SetListener sets function which is notified about every change of accounts.
+func (e *Exchange) SetListener(listener Listener) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.listener = listener
+}
+
+// Deposit adds amount of coin to account of api key.
+func (e *Exchange) Deposit(apiKey, coin string, amount float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(apiKey)
+	acc.balances[coin] += amount
+
+	e.emitWallet(apiKey, acc)
+}
+
+// Restore loads account's state which has been stored from listener's events. Locked funds
+// are counted from open orders and ids continue after restored ones.
+func (e *Exchange) Restore(apiKey string, balances map[string]float64, orders []models.OrderEvent, executions []models.ExecutionEvent) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(apiKey)
+	for coin, amount := range balances {
+		acc.balances[coin] = amount
+	}
+
+	for _, event := range orders {
+		o, err := orderFromEvent(event)
+		if err != nil {
+			return fmt.Errorf("restore order %s failed: %w", event.OrderId, err)
+		}
+		o.account = apiKey
+
+		if o.open() {
+			if o.side == "Buy" {
+				o.locked = o.price * (o.qty - o.execQty)
+				acc.locked[QuoteCoin] += o.locked
+			} else {
+				o.locked = o.qty - o.execQty
+				acc.locked[BaseCoin(o.symbol)] += o.locked
+			}
+		}
+
+		acc.orders[o.id] = o
+		e.seq = max(e.seq, orderSeq(o.id))
+	}
+
+	for _, execution := range executions {
+		acc.executions = append(acc.executions, execution)
+		e.seq = max(e.seq, execSeq(execution.ExecId))
+	}
+
+	return nil
+}
+
+// Balance returns amount of coin on account of api key, locked one included.
+func (e *Exchange) Balance(apiKey, coin string) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.account(apiKey).balances[coin]
+}
+
+// Equity returns account's value in quote coin by last prices.
+func (e *Exchange) Equity(apiKey string) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.equity(e.account(apiKey))
+}
+
+// Wallet returns account's wallet as it is sent by private stream.
+func (e *Exchange) Wallet(apiKey string) models.WalletEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.wallet(e.account(apiKey))
+}
+
+// Price returns last price of symbol, false if it has not been set yet.
+func (e *Exchange) Price(symbol string) (float64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	price, ok := e.prices[symbol]
+	return price, ok
+}
+
+// SetPrice sets last price of symbol and fills resting limit orders which are reached by it.
+// Orders are filled at their own price as maker.
+func (e *Exchange) SetPrice(symbol string, price float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.prices[symbol] = price
+
+	for _, acc := range e.accounts {
+		for _, o := range acc.sortedOrders() {
+			if o.symbol != symbol || !o.open() {
+				continue
+			}
+
+			if (o.side == "Buy" && price <= o.price) || (o.side == "Sell" && price >= o.price) {
+				e.fill(acc, o, o.price, e.fees.Maker)
+				e.emitFill(acc, o)
+			}
+		}
+	}
+}
+
+func (e *Exchange) GetCoin(ctx context.Context, coinReq models.GetCoinRequest, apiKey, secretKey string) (models.GetCoinResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	symbol := fmt.Sprint(coinReq["symbol"])
+
+	price, ok := e.prices[symbol]
+	if !ok {
+		return models.GetCoinResponse{}, fmt.Errorf("get coin response failed: %w", bybit.NewError(bybit.RetCodeParamsError, "Not supported symbols"))
+	}
+
+	var getCoinResp models.GetCoinResponse
+	getCoinResp.RetMsg = "OK"
+	getCoinResp.Result.Category = "spot"
+	getCoinResp.Result.List = []models.TickerInfo{{Symbol: symbol, Price: formatFloat(price)}}
+
+	return getCoinResp, nil
+}
+
+func (e *Exchange) GetUserWalletBalance(ctx context.Context, req models.GetUserWalletRequest, apiKey, secretKey string) (models.GetUserWalletResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(apiKey)
+
+	wallet := models.WalletInfo{TotalEquity: formatFloat(e.equity(acc))}
+	for _, coin := range sortedCoins(acc.balances) {
+		if filter, ok := req["coin"]; ok && fmt.Sprint(filter) != coin {
+			continue
+		}
+		wallet.Coin = append(wallet.Coin, models.WalletCoin{Coin: coin, Equity: formatFloat(acc.balances[coin])})
+	}
+
+	var getUserWalletResp models.GetUserWalletResponse
+	getUserWalletResp.RetMsg = "OK"
+	getUserWalletResp.Result.List = []models.WalletInfo{wallet}
+
+	return getUserWalletResp, nil
+}
+
+// CreateOrder places order, limit order which crosses last price and market order are
+// filled immediately at last price as taker. Funds are locked until order is closed.
+func (e *Exchange) CreateOrder(ctx context.Context, orderReq models.CreateOrderRequest, apiKey, secretKey string) (models.CreateOrderResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(apiKey)
+
+	qty, err := strconv.ParseFloat(orderReq.Qty, 64)
+	if err != nil || qty <= 0 {
+		return models.CreateOrderResponse{}, fmt.Errorf("create order failed: %w", bybit.NewError(bybit.RetCodeParamsError, "Order quantity has invalid value"))
+	}
+
+	lastPrice, ok := e.prices[orderReq.Symbol]
+	if !ok {
+		return models.CreateOrderResponse{}, fmt.Errorf("create order failed: %w", bybit.NewError(bybit.RetCodeParamsError, "Not supported symbols"))
+	}
+
+	price := lastPrice
+	if orderReq.OrderType == "Limit" {
+		price, err = strconv.ParseFloat(orderReq.Price, 64)
+		if err != nil || price <= 0 {
+			return models.CreateOrderResponse{}, fmt.Errorf("create order failed: %w", bybit.NewError(bybit.RetCodeParamsError, "Order price has invalid value"))
+		}
+	}
+
+	base := BaseCoin(orderReq.Symbol)
+
+	if orderReq.OrderLinkId != "" && acc.orderByLink(orderReq.OrderLinkId) != nil {
+		return models.CreateOrderResponse{}, fmt.Errorf("create order failed: %w", bybit.NewError(bybit.RetCodeDuplicateLinkId, "Duplicate clientOrderId"))
+	}
+
+	o := &order{
+		linkId:      orderReq.OrderLinkId,
+		symbol:      orderReq.Symbol,
+		side:        orderReq.Side,
+		orderType:   orderReq.OrderType,
+		price:       price,
+		qty:         qty,
+		status:      OrderStatusNew,
+		createdTime: e.clock(),
+		updatedTime: e.clock(),
+	}
+
+	// Locking funds which order can spend.
+	switch orderReq.Side {
+	case "Buy":
+		o.locked = price * qty
+		if acc.balances[QuoteCoin]-acc.locked[QuoteCoin] < o.locked {
+			return models.CreateOrderResponse{}, fmt.Errorf("create order failed: %w", bybit.NewError(bybit.RetCodeInsufficientBalance, "Insufficient balance"))
+		}
+		acc.locked[QuoteCoin] += o.locked
+	case "Sell":
+		o.locked = qty
+		if acc.balances[base]-acc.locked[base] < o.locked {
+			return models.CreateOrderResponse{}, fmt.Errorf("create order failed: %w", bybit.NewError(bybit.RetCodeInsufficientBalance, "Insufficient balance"))
+		}
+		acc.locked[base] += o.locked
+	default:
+		return models.CreateOrderResponse{}, fmt.Errorf("create order failed: %w", bybit.NewError(bybit.RetCodeParamsError, "Side invalid"))
+	}
+
+	e.seq++
+	o.id = "sim-" + strconv.FormatInt(e.seq, 10)
+	o.account = apiKey
+	acc.orders[o.id] = o
+	e.emit(apiKey, models.PrivateEvent{Topic: "order", Orders: []models.OrderEvent{o.event()}})
+
+	crosses := (o.side == "Buy" && lastPrice <= o.price) || (o.side == "Sell" && lastPrice >= o.price)
+	if o.orderType != "Limit" || crosses {
+		e.fill(acc, o, lastPrice, e.fees.Taker)
+		e.emitFill(acc, o)
+	}
+
+	var createOrderResp models.CreateOrderResponse
+	createOrderResp.RetMsg = "OK"
+	createOrderResp.Result.OrderID = o.id
+	createOrderResp.Result.OrderLinkID = o.linkId
+
+	return createOrderResp, nil
+}
+
+func (e *Exchange) CancelOrder(ctx context.Context, orderReq models.CancelOrderRequest, apiKey, secretKey string) (models.CancelOrderResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(apiKey)
+
+	o, ok := acc.orders[orderReq.OrderId]
+	if !ok || !o.open() {
+		return models.CancelOrderResponse{}, fmt.Errorf("cancel order failed: %w", bybit.NewError(bybit.RetCodeSpotOrderNotExists, "Order does not exist"))
+	}
+
+	acc.unlock(o)
+	o.status = OrderStatusCancelled
+	if o.execQty > 0 {
+		o.status = OrderStatusPartiallyFilledCanceled
+	}
+	o.updatedTime = e.clock()
+	e.emit(apiKey, models.PrivateEvent{Topic: "order", Orders: []models.OrderEvent{o.event()}})
+
+	var cancelOrderResp models.CancelOrderResponse
+	cancelOrderResp.RetMsg = "OK"
+	cancelOrderResp.Result.OrderID = o.id
+
+	return cancelOrderResp, nil
+}
+
+// GetOrder returns order by orderId or orderLinkId in any status or all open orders of symbol.
+func (e *Exchange) GetOrder(ctx context.Context, orderReq models.GetOrderRequest, apiKey, secretKey string) (models.GetOrderResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(apiKey)
+
+	var getOrderResp models.GetOrderResponse
+	getOrderResp.RetMsg = "OK"
+	getOrderResp.Result.Category = "spot"
+	getOrderResp.Result.List = make([]models.OrderInfo, 0)
+
+	if orderId, ok := orderReq["orderId"]; ok {
+		if o, ok := acc.orders[fmt.Sprint(orderId)]; ok {
+			getOrderResp.Result.List = append(getOrderResp.Result.List, o.info())
+		}
+		return getOrderResp, nil
+	}
+
+	if linkId, ok := orderReq["orderLinkId"]; ok {
+		if o := acc.orderByLink(fmt.Sprint(linkId)); o != nil {
+			getOrderResp.Result.List = append(getOrderResp.Result.List, o.info())
+		}
+		return getOrderResp, nil
+	}
+
+	symbol, filterSymbol := orderReq["symbol"]
+	for _, o := range acc.sortedOrders() {
+		if !o.open() || (filterSymbol && fmt.Sprint(symbol) != o.symbol) {
+			continue
+		}
+		getOrderResp.Result.List = append(getOrderResp.Result.List, o.info())
+	}
+
+	return getOrderResp, nil
+}
+
+func (e *Exchange) GetExecutions(ctx context.Context, req models.GetExecutionsRequest, apiKey, secretKey string) (models.GetExecutionsResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(apiKey)
+
+	var getExecutionsResp models.GetExecutionsResponse
+	getExecutionsResp.RetMsg = "OK"
+	getExecutionsResp.Result.Category = "spot"
+	getExecutionsResp.Result.List = make([]models.ExecutionEvent, 0)
+
+	symbol, filterSymbol := req["symbol"]
+	orderId, filterOrder := req["orderId"]
+	for _, execution := range acc.executions {
+		if filterSymbol && fmt.Sprint(symbol) != execution.Symbol {
+			continue
+		}
+		if filterOrder && fmt.Sprint(orderId) != execution.OrderId {
+			continue
+		}
+		getExecutionsResp.Result.List = append(getExecutionsResp.Result.List, execution)
+	}
+
+	return getExecutionsResp, nil
+}
+
+func (e *Exchange) CreateSignRequestAndGetRespBody(ctx context.Context, params, endPoint, method, apiKey, apiSecret string) ([]byte, error) {
+	return nil, fmt.Errorf("%s %s: %w", method, endPoint, ErrNotSupported)
+}
+
+// FillPartially executes qty of open order at its own price as maker, order stays open
+// with the rest of qty. Tests use it, because prices fill whole orders.
+func (e *Exchange) FillPartially(apiKey, orderId string, qty float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	acc := e.account(apiKey)
+
+	o, ok := acc.orders[orderId]
+	if !ok || !o.open() {
+		return fmt.Errorf("order %s is not open", orderId)
+	}
+	if qty <= 0 || qty >= o.qty-o.execQty {
+		return fmt.Errorf("qty %v is not part of order's rest %v", qty, o.qty-o.execQty)
+	}
+
+	e.execute(acc, o, o.price, qty, e.fees.Maker)
+	e.emitFill(acc, o)
+	return nil
+}
+
+// fill executes the rest of order at price.
+func (e *Exchange) fill(acc *account, o *order, price, feeRate float64) {
+	e.execute(acc, o, price, o.qty-o.execQty, feeRate)
+}
+
+// execute fills qty of order at price and moves funds between account's coins. Order is
+// filled when nothing is left of it.
+func (e *Exchange) execute(acc *account, o *order, price, qty, feeRate float64) {
+	base := BaseCoin(o.symbol)
+	value := price * qty
+
+	filled := o.execQty+qty >= o.qty
+	if filled {
+		acc.unlock(o)
+	} else {
+		// Funds of executed part are not locked anymore.
+		unlocked := qty
+		if o.side == "Buy" {
+			unlocked = o.price * qty
+			acc.locked[QuoteCoin] -= unlocked
+		} else {
+			acc.locked[base] -= unlocked
+		}
+		o.locked -= unlocked
+	}
+
+	var fee float64
+	var feeCurrency string
+	if o.side == "Buy" {
+		fee = qty * feeRate
+		feeCurrency = base
+		acc.balances[QuoteCoin] = roundAmount(acc.balances[QuoteCoin] - value)
+		acc.balances[base] = roundAmount(acc.balances[base] + qty - fee)
+	} else {
+		fee = value * feeRate
+		feeCurrency = QuoteCoin
+		acc.balances[base] = roundAmount(acc.balances[base] - qty)
+		acc.balances[QuoteCoin] = roundAmount(acc.balances[QuoteCoin] + value - fee)
+	}
+
+	o.status = OrderStatusPartiallyFilled
+	o.execQty += qty
+	if filled {
+		o.status = OrderStatusFilled
+		o.execQty = o.qty
+	}
+	o.execValue += value
+	o.execFee += fee
+	o.updatedTime = e.clock()
+
+	e.seq++
+	acc.executions = append(acc.executions, models.ExecutionEvent{
+		Symbol:      o.symbol,
+		OrderId:     o.id,
+		Side:        o.side,
+		ExecId:      "sim-exec-" + strconv.FormatInt(e.seq, 10),
+		ExecPrice:   formatFloat(price),
+		ExecQty:     formatFloat(qty),
+		ExecValue:   formatFloat(value),
+		ExecFee:     formatFloat(fee),
+		FeeCurrency: feeCurrency,
+		ExecType:    "Trade",
+		ExecTime:    strconv.FormatInt(e.clock().UnixMilli(), 10),
+	})
+}
+
+// emitFill notifies listener about filled order, its execution and changed wallet.
+func (e *Exchange) emitFill(acc *account, o *order) {
+	if e.listener == nil {
+		return
+	}
+
+	e.listener(o.account, models.PrivateEvent{Topic: "order", Orders: []models.OrderEvent{o.event()}})
+	e.listener(o.account, models.PrivateEvent{Topic: "execution", Executions: []models.ExecutionEvent{acc.executions[len(acc.executions)-1]}})
+	e.emitWallet(o.account, acc)
+}
+
+func (e *Exchange) emitWallet(apiKey string, acc *account) {
+	e.emit(apiKey, models.PrivateEvent{Topic: "wallet", Wallets: []models.WalletEvent{e.wallet(acc)}})
+}
+
+func (e *Exchange) emit(apiKey string, event models.PrivateEvent) {
+	if e.listener != nil {
+		e.listener(apiKey, event)
+	}
+}
+
+func (e *Exchange) account(apiKey string) *account {
+	acc, ok := e.accounts[apiKey]
+	if !ok {
+		acc = &account{
+			balances: make(map[string]float64),
+			locked:   make(map[string]float64),
+			orders:   make(map[string]*order),
+		}
+		e.accounts[apiKey] = acc
+	}
+	return acc
+}
+
+func (e *Exchange) wallet(acc *account) models.WalletEvent {
+	wallet := models.WalletEvent{
+		AccountType: "UNIFIED",
+		TotalEquity: formatFloat(e.equity(acc)),
+	}
+	for _, coin := range sortedCoins(acc.balances) {
+		wallet.Coin = append(wallet.Coin, models.WalletCoin{Coin: coin, Equity: formatFloat(acc.balances[coin])})
+	}
+	return wallet
+}
+
+func (e *Exchange) equity(acc *account) float64 {
+	var equity float64
+	for coin, amount := range acc.balances {
+		if coin == QuoteCoin {
+			equity += amount
+			continue
+		}
+		equity += amount * e.prices[coin+QuoteCoin]
+	}
+	return equity
+}
+
+func (a *account) unlock(o *order) {
+	if o.side == "Buy" {
+		a.locked[QuoteCoin] -= o.locked
+	} else {
+		a.locked[BaseCoin(o.symbol)] -= o.locked
+	}
+	o.locked = 0
+}
+
+func (a *account) orderByLink(linkId string) *order {
+	for _, o := range a.orders {
+		if o.linkId == linkId {
+			return o
+		}
+	}
+	return nil
+}
+
+// sortedOrders returns orders in order of their creation, so fills are deterministic.
+func (a *account) sortedOrders() []*order {
+	orders := make([]*order, 0, len(a.orders))
+	for _, o := range a.orders {
+		orders = append(orders, o)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orderSeq(orders[i].id) < orderSeq(orders[j].id)
+	})
+	return orders
+}
+
+// open reports if order rests in book.
+func (o *order) open() bool {
+	return o.status == OrderStatusNew || o.status == OrderStatusPartiallyFilled
+}
+
+func (o *order) event() models.OrderEvent {
+	var avgPrice string
+	if o.execQty > 0 {
+		avgPrice = formatFloat(o.execValue / o.execQty)
+	}
+
+	return models.OrderEvent{
+		Symbol:       o.symbol,
+		OrderId:      o.id,
+		OrderLinkId:  o.linkId,
+		Side:         o.side,
+		OrderType:    o.orderType,
+		OrderStatus:  o.status,
+		Price:        formatFloat(o.price),
+		Qty:          formatFloat(o.qty),
+		AvgPrice:     avgPrice,
+		CumExecQty:   formatFloat(o.execQty),
+		CumExecValue: formatFloat(o.execValue),
+		CumExecFee:   formatFloat(o.execFee),
+		CreatedTime:  strconv.FormatInt(o.createdTime.UnixMilli(), 10),
+		UpdatedTime:  strconv.FormatInt(o.updatedTime.UnixMilli(), 10),
+	}
+}
+
+func orderFromEvent(event models.OrderEvent) (*order, error) {
+	o := &order{
+		id:        event.OrderId,
+		linkId:    event.OrderLinkId,
+		symbol:    event.Symbol,
+		side:      event.Side,
+		orderType: event.OrderType,
+		status:    event.OrderStatus,
+	}
+
+	var err error
+	for _, field := range []struct {
+		value string
+		dst   *float64
+	}{
+		{event.Price, &o.price},
+		{event.Qty, &o.qty},
+		{event.CumExecQty, &o.execQty},
+		{event.CumExecValue, &o.execValue},
+		{event.CumExecFee, &o.execFee},
+	} {
+		if field.value == "" {
+			continue
+		}
+		*field.dst, err = strconv.ParseFloat(field.value, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	createdTime, err := strconv.ParseInt(event.CreatedTime, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	updatedTime, err := strconv.ParseInt(event.UpdatedTime, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	o.createdTime = time.UnixMilli(createdTime)
+	o.updatedTime = time.UnixMilli(updatedTime)
+
+	return o, nil
+}
+
+func (o *order) info() models.OrderInfo {
+	var avgPrice string
+	if o.execQty > 0 {
+		avgPrice = formatFloat(o.execValue / o.execQty)
+	}
+
+	return models.OrderInfo{
+		Symbol:       o.symbol,
+		OrderType:    o.orderType,
+		OrderId:      o.id,
+		OrderLinkId:  o.linkId,
+		AvgPrice:     avgPrice,
+		OrderStatus:  o.status,
+		CumExecValue: formatFloat(o.execValue),
+		Price:        formatFloat(o.price),
+		CreatedTime:  strconv.FormatInt(o.createdTime.UnixMilli(), 10),
+		TimeInForce:  "GTC",
+		LeavesValue:  formatFloat((o.qty - o.execQty) * o.price),
+		UpdatedTime:  strconv.FormatInt(o.updatedTime.UnixMilli(), 10),
+		Side:         o.side,
+		CumExecFee:   formatFloat(o.execFee),
+		LeavesQty:    formatFloat(o.qty - o.execQty),
+		CumExecQty:   formatFloat(o.execQty),
+		Qty:          formatFloat(o.qty),
+	}
+}
+
+// BaseCoin returns base coin of symbol, only USDT pairs are supported.
+func BaseCoin(symbol string) string {
+	return strings.TrimSuffix(symbol, QuoteCoin)
+}
+
+func orderSeq(id string) int64 {
+	seq, _ := strconv.ParseInt(strings.TrimPrefix(id, "sim-"), 10, 64)
+	return seq
+}
+
+func execSeq(id string) int64 {
+	seq, _ := strconv.ParseInt(strings.TrimPrefix(id, "sim-exec-"), 10, 64)
+	return seq
+}
+
+func sortedCoins(balances map[string]float64) []string {
+	coins := make([]string, 0, len(balances))
+	for coin := range balances {
+		coins = append(coins, coin)
+	}
+	sort.Strings(coins)
+	return coins
+}
+
+// roundAmount keeps balances decimal as exchange does, otherwise float error makes balance
+// a bit less than quantity which has been bought (0.15-0.00015 is 0.14984999999999998).
+func roundAmount(value float64) float64 {
+	return math.Round(value*1e10) / 1e10
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -9,7 +9,46 @@ type Repository interface {
 	CreateOrder(ctx context.Context, orderReq models.CreateOrderRequest, apiKey, secretKey string) (models.CreateOrderResponse, error)
 	CancelOrder(ctx context.Context, orderReq models.CancelOrderRequest, apiKey, secretKey string) (models.CancelOrderResponse, error)
 	GetOrder(ctx context.Context, orderReq models.GetOrderRequest, apiKey, secretKey string) (models.GetOrderResponse, error)
+	GetExecutions(ctx context.Context, req models.GetExecutionsRequest, apiKey, secretKey string) (models.GetExecutionsResponse, error)
 	GetCoin(ctx context.Context, coinReq models.GetCoinRequest, apiKey, secretKey string) (models.GetCoinResponse, error)
 	GetUserWalletBalance(ctx context.Context, req models.GetUserWalletRequest, apiKey, secretKey string) (models.GetUserWalletResponse, error)
-	CreateSignRequestAndGetRespBody(params, endPoint, method, apiKey, apiSecret string) ([]byte, error)
+	CreateSignRequestAndGetRespBody(ctx context.Context, params, endPoint, method, apiKey, apiSecret string) ([]byte, error)
+}
+
+// Exchange is spot exchange with user's keys, it hides which exchange they belong to. Orders,
+// executions and statuses are in canonical form of models, e.g. statuses are OrderStatus constants.
+type Exchange interface {
+	PlaceOrder(ctx context.Context, creds models.Credentials, req models.PlaceOrderRequest) (models.PlacedOrder, error)
+	CancelOrder(ctx context.Context, creds models.Credentials, symbol, orderId string) error
+	GetOrders(ctx context.Context, creds models.Credentials, query models.OrderQuery) ([]models.OrderInfo, error)
+	GetExecutions(ctx context.Context, creds models.Credentials, symbol, orderId string) ([]models.ExecutionEvent, error)
+	GetPrice(ctx context.Context, creds models.Credentials, symbol string) (float64, error)
+	GetBalances(ctx context.Context, creds models.Credentials) (models.Balances, error)
+	// GetInstruments returns exchange's spot instruments with their rules.
+	GetInstruments(ctx context.Context) ([]models.Coiniks, error)
+	GetKeyPermissions(ctx context.Context, creds models.Credentials) (models.KeyPermissions, error)
+}
+
+// StreamRepository delivers market data and private account updates as events.
+// Channels are closed when ctx is done.
+type StreamRepository interface {
+	SubscribeTickers(ctx context.Context, symbol string) (<-chan models.TickerEvent, error)
+	SubscribePrivate(ctx context.Context, apiKey, secretKey string) (<-chan models.PrivateEvent, error)
+}
+
+// KlineRepository gives historical klines of coins, it is used by backtest.
+type KlineRepository interface {
+	GetKlines(ctx context.Context, req models.GetKlinesRequest) (models.GetKlinesResponse, error)
+}
+
+// InstrumentRepository gives exchange's instruments with their filters, it is used by
+// instruments sync.
+type InstrumentRepository interface {
+	GetInstruments(ctx context.Context, req models.GetInstrumentsRequest) (models.GetInstrumentsResponse, error)
+}
+
+// PaperRepository moves users' api keys between real and simulated exchange.
+type PaperRepository interface {
+	SetPaper(ctx context.Context, userId int64, apiKey string, paper bool) error
+	IsPaper(apiKey string) bool
 }
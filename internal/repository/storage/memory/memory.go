@@ -0,0 +1,716 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"m1pes/internal/models"
+)
+
+// Repository keeps users, coins and incomes in memory. It implements both stocks and user
+// storage repositories with the same semantics as postgres ones, so algorithm can be run
+// without database, e.g. in backtests.
+type Repository struct {
+	mu       sync.Mutex
+	users    map[int64]models.User
+	coins    map[coinKey]models.Coin
+	coiniks  map[coiniksKey]models.Coiniks
+	grids    map[coinKey]map[int]models.GridLevel
+	settings map[coinKey]models.CoinSettings
+	incomes  []Income
+	fills    []models.Fill
+	orders   []models.Order
+	updates  []models.OrderUpdate
+	history  []StateChange
+	now      func() time.Time
+}
+
+type coinKey struct {
+	userId int64
+	name   string
+}
+
+// coiniksKey is key of coin's coiniks on exchange.
+type coiniksKey struct {
+	exchange string
+	name     string
+}
+
+// Income is a row of income table.
+type Income struct {
+	UserId int64
+	Coin   string
+	Income float64
+	Count  float64
+	Time   time.Time
+}
+
+// StateChange is a row of coin_state_history table.
+type StateChange struct {
+	UserId int64
+	Coin   string
+	From   models.CoinState
+	To     models.CoinState
+	Reason string
+	Time   time.Time
+}
+
+func New() *Repository {
+	return &Repository{
+		users:    make(map[int64]models.User),
+		coins:    make(map[coinKey]models.Coin),
+		coiniks:  make(map[coiniksKey]models.Coiniks),
+		grids:    make(map[coinKey]map[int]models.GridLevel),
+		settings: make(map[coinKey]models.CoinSettings),
+		now:      time.Now,
+	}
+}
+
+// SetClock replaces time source of incomes and history, backtest uses candles' time.
+func (r *Repository) SetClock(now func() time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.now = now
+}
+
+// AddCoiniks stores coin's decimals, in postgres they are filled by instruments sync. Coiniks
+// without exchange are bybit's.
+func (r *Repository) AddCoiniks(coiniks models.Coiniks) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if coiniks.Exchange == "" {
+		coiniks.Exchange = models.ExchangeBybit
+	}
+	r.coiniks[coiniksKey{coiniks.Exchange, coiniks.Name}] = coiniks
+}
+
+// Incomes returns all inserted incomes.
+func (r *Repository) Incomes() []Income {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Income(nil), r.incomes...)
+}
+
+// Fills returns all inserted fills.
+func (r *Repository) Fills() []models.Fill {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]models.Fill(nil), r.fills...)
+}
+
+// Orders returns all placed orders with their current status.
+func (r *Repository) Orders() []models.Order {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]models.Order(nil), r.orders...)
+}
+
+// OrderHistory returns all rows of order_history table.
+func (r *Repository) OrderHistory() []models.OrderUpdate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]models.OrderUpdate(nil), r.updates...)
+}
+
+// History returns all coin state transitions.
+func (r *Repository) History() []StateChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]StateChange(nil), r.history...)
+}
+
+// -----Stocks repository------
+
+func (r *Repository) DeleteCoin(ctx context.Context, userId int64, coinTag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.coins, coinKey{userId, coinTag})
+	return nil
+}
+
+func (r *Repository) GetCoin(ctx context.Context, userId int64, coinName string) (models.Coin, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	coin, ok := r.coins[coinKey{userId, coinName}]
+	if !ok {
+		return models.Coin{}, fmt.Errorf("coin %s of user %d is not found", coinName, userId)
+	}
+	return copyCoin(coin), nil
+}
+
+func (r *Repository) GetCoiniks(ctx context.Context, exchange, coinName string) (models.Coiniks, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	coiniks, ok := r.coiniks[coiniksKey{exchange, coinName}]
+	if !ok {
+		return models.Coiniks{}, fmt.Errorf("coiniks of %s on %s are not found", coinName, exchange)
+	}
+	return coiniks, nil
+}
+
+func (r *Repository) SyncCoiniks(ctx context.Context, exchange string, list []models.Coiniks) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	listed := make(map[coiniksKey]bool, len(list))
+	for _, coiniks := range list {
+		coiniks.Exchange = exchange
+		key := coiniksKey{exchange, coiniks.Name}
+		r.coiniks[key] = coiniks
+		listed[key] = true
+	}
+
+	for key, coiniks := range r.coiniks {
+		if key.exchange == exchange && !listed[key] {
+			coiniks.Status = models.CoiniksStatusDelisted
+			r.coiniks[key] = coiniks
+		}
+	}
+	return nil
+}
+
+func (r *Repository) EditBuy(ctx context.Context, userId int64, buy bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userId]
+	if !ok {
+		return nil
+	}
+	user.Buy = buy
+	r.users[userId] = user
+	return nil
+}
+
+func (r *Repository) ExistCoin(ctx context.Context, exchange, coinTag string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Coiniks which are added by hand have no status.
+	coiniks, ok := r.coiniks[coiniksKey{exchange, coinTag}]
+	return ok && (coiniks.Status == "" || coiniks.Status == models.CoiniksStatusTrading), nil
+}
+
+func (r *Repository) GetCoinList(ctx context.Context, userId int64) ([]models.Coin, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	coinList := make([]models.Coin, 0)
+	for key, coin := range r.coins {
+		if key.userId == userId {
+			coinList = append(coinList, copyCoin(coin))
+		}
+	}
+	return coinList, nil
+}
+
+func (r *Repository) AddCoin(coin models.Coin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := coinKey{coin.UserId, coin.Name}
+	if _, ok := r.coins[key]; ok {
+		return nil
+	}
+
+	strategyName := coin.Strategy
+	if strategyName == "" {
+		strategyName = "ladder"
+	}
+
+	r.coins[key] = models.Coin{
+		UserId:         coin.UserId,
+		Name:           coin.Name,
+		State:          models.CoinStateIdle,
+		Strategy:       strategyName,
+		StrategyParams: append(json.RawMessage(nil), coin.StrategyParams...),
+		OrderSeq:       r.now().Unix(),
+	}
+	return nil
+}
+
+// UpdateCoin stores only non-zero fields of coin, as postgres one does.
+func (r *Repository) UpdateCoin(ctx context.Context, coin models.Coin) error {
+	if coin.UserId == 0 {
+		return fmt.Errorf("user ID is required")
+	}
+	if coin.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := coinKey{coin.UserId, coin.Name}
+	stored, ok := r.coins[key]
+	if !ok {
+		return nil
+	}
+
+	if coin.EntryPrice != 0 {
+		stored.EntryPrice = coin.EntryPrice
+	}
+	if coin.Count != 0 {
+		stored.Count = coin.Count
+	}
+	if coin.Buy != nil {
+		stored.Buy = append([]float64(nil), coin.Buy...)
+	}
+	if coin.Decrement != 0 {
+		stored.Decrement = coin.Decrement
+	}
+	if coin.BuyOrderId != "" {
+		stored.BuyOrderId = coin.BuyOrderId
+	}
+	if coin.SellOrderId != "" {
+		stored.SellOrderId = coin.SellOrderId
+	}
+
+	r.coins[key] = stored
+	return nil
+}
+
+func (r *Repository) SavePosition(ctx context.Context, coin models.Coin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(coin.UserId, coin.Name, func(stored *models.Coin) {
+		stored.EntryPrice = coin.EntryPrice
+		stored.Decrement = coin.Decrement
+		stored.Count = coin.Count
+		stored.Cost = coin.Cost
+		stored.Buy = append([]float64(nil), coin.Buy...)
+		stored.TrailPeak = coin.TrailPeak
+	})
+}
+
+func (r *Repository) TransitionCoinState(ctx context.Context, coin models.Coin, from models.CoinState, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := coinKey{coin.UserId, coin.Name}
+	stored, ok := r.coins[key]
+	if !ok || stored.State != from {
+		return fmt.Errorf("%w: coin %s is not in state %s", models.ErrIllegalTransition, coin.Name, from)
+	}
+
+	stored.State = coin.State
+	stored.BuyOrderId = coin.BuyOrderId
+	stored.SellOrderId = coin.SellOrderId
+	stored.OrderSeq = coin.OrderSeq
+	r.coins[key] = stored
+
+	if from != coin.State {
+		r.history = append(r.history, StateChange{
+			UserId: coin.UserId,
+			Coin:   coin.Name,
+			From:   from,
+			To:     coin.State,
+			Reason: reason,
+			Time:   r.now(),
+		})
+	}
+	return nil
+}
+
+func (r *Repository) UpdateCount(userID int64, count float64, coinTag string, decrement float64, buy []float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(userID, coinTag, func(coin *models.Coin) {
+		coin.Decrement = decrement
+		coin.Count = count
+		coin.Buy = append([]float64(nil), buy...)
+	})
+}
+
+func (r *Repository) SetCoinToDefault(ctx context.Context, userId int64, coinTag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(userId, coinTag, func(coin *models.Coin) {
+		*coin = models.Coin{
+			UserId:          coin.UserId,
+			Name:            coin.Name,
+			State:           coin.State,
+			Strategy:        coin.Strategy,
+			StrategyParams:  coin.StrategyParams,
+			StopLossPercent: coin.StopLossPercent,
+			StopLossPrice:   coin.StopLossPrice,
+			Stopped:         coin.Stopped,
+			OrderSeq:        coin.OrderSeq,
+		}
+	})
+}
+
+func (r *Repository) ResetCoin(ctx context.Context, coin models.Coin, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(user.Id, coin.Name, func(stored *models.Coin) {
+		stored.EntryPrice = coin.EntryPrice
+		stored.Decrement = user.Percent * coin.EntryPrice
+	})
+}
+
+func (r *Repository) SellCoin(userID int64, coinTag string, sellPrice float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(userID, coinTag, func(coin *models.Coin) {
+		coin.Count = 0
+		coin.Buy = nil
+		coin.EntryPrice = sellPrice
+	})
+}
+
+func (r *Repository) InsertFill(ctx context.Context, fill models.Fill) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if fill.ExecId != "" && slices.ContainsFunc(r.fills, func(stored models.Fill) bool {
+		return stored.UserId == fill.UserId && stored.ExecId == fill.ExecId
+	}) {
+		return nil
+	}
+
+	if fill.Time == 0 {
+		fill.Time = r.now().UnixMilli()
+	}
+	r.fills = append(r.fills, fill)
+	return nil
+}
+
+func (r *Repository) InsertOrder(ctx context.Context, order models.Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order.CreatedAt = r.now()
+	order.UpdatedAt = order.CreatedAt
+	r.orders = append(r.orders, order)
+	r.updates = append(r.updates, models.OrderUpdate{
+		UserId:       order.UserId,
+		OrderId:      order.OrderId,
+		Action:       models.OrderActionCreate,
+		Status:       order.Status,
+		RejectReason: order.RejectReason,
+		Request:      order.Request,
+		Response:     order.Response,
+		Time:         order.CreatedAt,
+	})
+	return nil
+}
+
+func (r *Repository) UpdateOrderStatus(ctx context.Context, update models.OrderUpdate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.orders, func(order models.Order) bool {
+		return order.UserId == update.UserId && order.OrderId == update.OrderId && order.OrderId != ""
+	})
+	if i == -1 || (r.orders[i].Status == update.Status && r.orders[i].CumExecQty == update.CumExecQty) {
+		return nil
+	}
+
+	update.Action = models.OrderActionStatus
+	update.Time = r.now()
+	r.orders[i].Status = update.Status
+	r.orders[i].RejectReason = update.RejectReason
+	r.orders[i].CumExecQty = update.CumExecQty
+	r.orders[i].UpdatedAt = update.Time
+	r.updates = append(r.updates, update)
+	return nil
+}
+
+func (r *Repository) InsertOrderHistory(ctx context.Context, update models.OrderUpdate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	update.Time = r.now()
+	r.updates = append(r.updates, update)
+	return nil
+}
+
+func (r *Repository) InsertIncome(userID int64, coinTag string, income, count float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.incomes = append(r.incomes, Income{
+		UserId: userID,
+		Coin:   coinTag,
+		Income: income,
+		Count:  count,
+		Time:   r.now(),
+	})
+	return nil
+}
+
+func (r *Repository) SetCoinStrategy(ctx context.Context, userId int64, coinName, strategy string, params json.RawMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(userId, coinName, func(coin *models.Coin) {
+		coin.Strategy = strategy
+		coin.StrategyParams = append(json.RawMessage(nil), params...)
+	})
+}
+
+func (r *Repository) GetGridLevels(ctx context.Context, userId int64, coinName string) ([]models.GridLevel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	levels := make([]models.GridLevel, 0, len(r.grids[coinKey{userId, coinName}]))
+	for _, level := range r.grids[coinKey{userId, coinName}] {
+		levels = append(levels, level)
+	}
+	sort.Slice(levels, func(i, j int) bool { return levels[i].Level < levels[j].Level })
+	return levels, nil
+}
+
+func (r *Repository) SaveGridLevel(ctx context.Context, userId int64, coinName string, level models.GridLevel) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := coinKey{userId, coinName}
+	if r.grids[key] == nil {
+		r.grids[key] = make(map[int]models.GridLevel)
+	}
+	r.grids[key][level.Level] = level
+	return nil
+}
+
+func (r *Repository) DeleteGridLevels(ctx context.Context, userId int64, coinName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.grids, coinKey{userId, coinName})
+	return nil
+}
+
+func (r *Repository) SetStopLoss(ctx context.Context, userId int64, coinName string, percent, price float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(userId, coinName, func(coin *models.Coin) {
+		coin.StopLossPercent = percent
+		coin.StopLossPrice = price
+	})
+}
+
+func (r *Repository) SetStopped(ctx context.Context, userId int64, coinName string, stopped bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(userId, coinName, func(coin *models.Coin) {
+		coin.Stopped = stopped
+	})
+}
+
+func (r *Repository) ResumeStopped(ctx context.Context, userId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, coin := range r.coins {
+		if key.userId == userId {
+			coin.Stopped = false
+			r.coins[key] = coin
+		}
+	}
+	return nil
+}
+
+// update changes stored coin, missing coin is skipped as UPDATE without rows does.
+func (r *Repository) update(userId int64, coinName string, change func(coin *models.Coin)) error {
+	key := coinKey{userId, coinName}
+	coin, ok := r.coins[key]
+	if !ok {
+		return nil
+	}
+
+	change(&coin)
+	r.coins[key] = coin
+	return nil
+}
+
+// -----User repository------
+
+func (r *Repository) NewUser(ctx context.Context, user models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[user.Id]; ok {
+		return nil
+	}
+
+	if user.Percent == 0 {
+		user.Percent = 0.01
+	}
+	r.users[user.Id] = user
+	return nil
+}
+
+func (r *Repository) GetUser(ctx context.Context, userId int64) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userId]
+	if !ok {
+		return models.User{}, fmt.Errorf("user %d is not found", userId)
+	}
+	return user, nil
+}
+
+// UpdateUser stores only non-zero fields of user and trading flag, as postgres one does.
+func (r *Repository) UpdateUser(ctx context.Context, user models.User) error {
+	if user.Id == 0 {
+		return fmt.Errorf("user ID is required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[user.Id]
+	if !ok {
+		return nil
+	}
+
+	if user.Percent != 0 {
+		stored.Percent = user.Percent
+	}
+	if user.USDTBalance != 0 {
+		stored.USDTBalance = user.USDTBalance
+	}
+	if user.Capital != 0 {
+		stored.Capital = user.Capital
+	}
+	if user.Status != "" {
+		stored.Status = user.Status
+	}
+	if user.ApiKey != "" {
+		stored.ApiKey = user.ApiKey
+	}
+	if user.SecretKey != "" {
+		stored.SecretKey = user.SecretKey
+	}
+	if user.Passphrase != "" {
+		stored.Passphrase = user.Passphrase
+	}
+	if user.Exchange != "" {
+		stored.Exchange = user.Exchange
+	}
+	if user.Environment != "" {
+		stored.Environment = user.Environment
+	}
+	stored.TradingActivated = user.TradingActivated
+
+	r.users[user.Id] = stored
+	return nil
+}
+
+func (r *Repository) GetAllUsers(ctx context.Context) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := make([]models.User, 0, len(r.users))
+	for _, user := range r.users {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (r *Repository) ChangeBalance(ctx context.Context, userId int64, amount float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userId]
+	if !ok {
+		return nil
+	}
+	user.USDTBalance += amount
+	r.users[userId] = user
+	return nil
+}
+
+func (r *Repository) SetMaxDrawdown(ctx context.Context, userId int64, maxDrawdown float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userId]
+	if !ok {
+		return nil
+	}
+	user.MaxDrawdown = maxDrawdown
+	r.users[userId] = user
+	return nil
+}
+
+func (r *Repository) SetPeakEquity(ctx context.Context, userId int64, equity float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userId]
+	if !ok {
+		return nil
+	}
+	user.PeakEquity = equity
+	r.users[userId] = user
+	return nil
+}
+
+// GetIncome returns user's income since the start of current day.
+func (r *Repository) GetIncome(ctx context.Context, userId int64) (float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var incomes float64
+	for _, income := range r.incomes {
+		if income.UserId == userId && !income.Time.Before(today) {
+			incomes += income.Income
+		}
+	}
+	return incomes, nil
+}
+
+func copyCoin(coin models.Coin) models.Coin {
+	if coin.Buy != nil {
+		coin.Buy = append([]float64(nil), coin.Buy...)
+	}
+	return coin
+}
+
+func (r *Repository) GetCoinSettings(ctx context.Context, userId int64, coinName string) (models.CoinSettings, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	settings, ok := r.settings[coinKey{userId, coinName}]
+	if !ok {
+		return models.NewCoinSettings(userId, coinName), nil
+	}
+	return settings, nil
+}
+
+func (r *Repository) SaveCoinSettings(ctx context.Context, settings models.CoinSettings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.settings[coinKey{settings.UserId, settings.CoinName}] = settings
+	return nil
+}
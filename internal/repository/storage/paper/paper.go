@@ -0,0 +1,21 @@
+package paper
+
+import (
+	"context"
+	"time"
+
+	"m1pes/internal/models"
+)
+
+// Repository keeps state of simulated exchange's accounts, orders and executions are stored
+// in the same form as they come from private stream.
+type Repository interface {
+	GetAccounts(ctx context.Context) ([]models.PaperAccount, error)
+	SaveAccount(ctx context.Context, account models.PaperAccount) error
+	GetBalances(ctx context.Context, userId int64) (map[string]float64, error)
+	SaveBalance(ctx context.Context, userId int64, coin string, amount float64) error
+	GetOrders(ctx context.Context, userId int64, since time.Time) ([]models.OrderEvent, error)
+	SaveOrder(ctx context.Context, userId int64, order models.OrderEvent) error
+	GetExecutions(ctx context.Context, userId int64, since time.Time) ([]models.ExecutionEvent, error)
+	SaveExecution(ctx context.Context, userId int64, execution models.ExecutionEvent) error
+}
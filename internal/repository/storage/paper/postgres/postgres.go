@@ -0,0 +1,234 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx"
+
+	"m1pes/internal/config"
+	"m1pes/internal/models"
+)
+
+type Repository struct {
+	Conn *pgx.ConnPool
+}
+
+func New(cfg config.DBConnConfig) *Repository {
+	conn, err := pgx.NewConnPool(pgx.ConnPoolConfig{
+		ConnConfig: pgx.ConnConfig{
+			Host:     cfg.Host,
+			Port:     uint16(cfg.Port),
+			User:     cfg.Username,
+			Password: cfg.Password,
+			Database: cfg.Database,
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return &Repository{Conn: conn}
+}
+
+func (r *Repository) GetAccounts(ctx context.Context) ([]models.PaperAccount, error) {
+	rows, err := r.Conn.QueryEx(ctx, "SELECT user_id, api_key, active FROM paper_account;", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := make([]models.PaperAccount, 0)
+	for rows.Next() {
+		account := models.PaperAccount{}
+		if err = rows.Scan(&account.UserId, &account.ApiKey, &account.Active); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+func (r *Repository) SaveAccount(ctx context.Context, account models.PaperAccount) error {
+	_, err := r.Conn.ExecEx(ctx, "INSERT INTO paper_account (user_id, api_key, active) VALUES ($1, $2, $3) ON CONFLICT (user_id) DO UPDATE SET (api_key, active) = ($2, $3);", nil,
+		account.UserId, account.ApiKey, account.Active)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetBalances(ctx context.Context, userId int64) (map[string]float64, error) {
+	rows, err := r.Conn.QueryEx(ctx, "SELECT coin, amount FROM paper_balance WHERE user_id=$1;", nil, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := make(map[string]float64)
+	for rows.Next() {
+		var coin string
+		var amount float64
+		if err = rows.Scan(&coin, &amount); err != nil {
+			return nil, err
+		}
+		balances[coin] = amount
+	}
+	return balances, rows.Err()
+}
+
+func (r *Repository) SaveBalance(ctx context.Context, userId int64, coin string, amount float64) error {
+	_, err := r.Conn.ExecEx(ctx, "INSERT INTO paper_balance (user_id, coin, amount) VALUES ($1, $2, $3) ON CONFLICT (user_id, coin) DO UPDATE SET amount = $3;", nil,
+		userId, coin, amount)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetOrders returns user's open orders and orders which have been updated since given time.
+func (r *Repository) GetOrders(ctx context.Context, userId int64, since time.Time) ([]models.OrderEvent, error) {
+	rows, err := r.Conn.QueryEx(ctx, "SELECT order_id, symbol, side, order_type, status, price, qty, cum_exec_qty, cum_exec_value, cum_exec_fee, created_time, updated_time FROM paper_order WHERE user_id=$1 AND (status='New' OR updated_time >= $2) ORDER BY created_time;", nil,
+		userId, since.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := make([]models.OrderEvent, 0)
+	for rows.Next() {
+		var order models.OrderEvent
+		var price, qty, cumExecQty, cumExecValue, cumExecFee float64
+		var createdTime, updatedTime int64
+
+		err = rows.Scan(&order.OrderId, &order.Symbol, &order.Side, &order.OrderType, &order.OrderStatus,
+			&price, &qty, &cumExecQty, &cumExecValue, &cumExecFee, &createdTime, &updatedTime)
+		if err != nil {
+			return nil, err
+		}
+
+		order.Price = formatFloat(price)
+		order.Qty = formatFloat(qty)
+		order.CumExecQty = formatFloat(cumExecQty)
+		order.CumExecValue = formatFloat(cumExecValue)
+		order.CumExecFee = formatFloat(cumExecFee)
+		order.CreatedTime = strconv.FormatInt(createdTime, 10)
+		order.UpdatedTime = strconv.FormatInt(updatedTime, 10)
+
+		orders = append(orders, order)
+	}
+	return orders, rows.Err()
+}
+
+func (r *Repository) SaveOrder(ctx context.Context, userId int64, order models.OrderEvent) error {
+	values, err := parseFloats(order.Price, order.Qty, order.CumExecQty, order.CumExecValue, order.CumExecFee)
+	if err != nil {
+		return fmt.Errorf("parse order %s failed: %w", order.OrderId, err)
+	}
+
+	times, err := parseInts(order.CreatedTime, order.UpdatedTime)
+	if err != nil {
+		return fmt.Errorf("parse order %s failed: %w", order.OrderId, err)
+	}
+
+	_, err = r.Conn.ExecEx(ctx, `INSERT INTO paper_order (order_id, user_id, symbol, side, order_type, status, price, qty, cum_exec_qty, cum_exec_value, cum_exec_fee, created_time, updated_time)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT (order_id) DO UPDATE SET (status, cum_exec_qty, cum_exec_value, cum_exec_fee, updated_time) = ($6, $9, $10, $11, $13);`, nil,
+		order.OrderId, userId, order.Symbol, order.Side, order.OrderType, order.OrderStatus,
+		values[0], values[1], values[2], values[3], values[4], times[0], times[1])
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetExecutions(ctx context.Context, userId int64, since time.Time) ([]models.ExecutionEvent, error) {
+	rows, err := r.Conn.QueryEx(ctx, "SELECT exec_id, order_id, symbol, side, exec_price, exec_qty, exec_value, exec_fee, fee_currency, exec_time FROM paper_execution WHERE user_id=$1 AND exec_time >= $2 ORDER BY exec_time;", nil,
+		userId, since.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	executions := make([]models.ExecutionEvent, 0)
+	for rows.Next() {
+		var execution models.ExecutionEvent
+		var execPrice, execQty, execValue, execFee float64
+		var execTime int64
+
+		err = rows.Scan(&execution.ExecId, &execution.OrderId, &execution.Symbol, &execution.Side,
+			&execPrice, &execQty, &execValue, &execFee, &execution.FeeCurrency, &execTime)
+		if err != nil {
+			return nil, err
+		}
+
+		execution.ExecPrice = formatFloat(execPrice)
+		execution.ExecQty = formatFloat(execQty)
+		execution.ExecValue = formatFloat(execValue)
+		execution.ExecFee = formatFloat(execFee)
+		execution.ExecType = "Trade"
+		execution.ExecTime = strconv.FormatInt(execTime, 10)
+
+		executions = append(executions, execution)
+	}
+	return executions, rows.Err()
+}
+
+func (r *Repository) SaveExecution(ctx context.Context, userId int64, execution models.ExecutionEvent) error {
+	values, err := parseFloats(execution.ExecPrice, execution.ExecQty, execution.ExecValue, execution.ExecFee)
+	if err != nil {
+		return fmt.Errorf("parse execution %s failed: %w", execution.ExecId, err)
+	}
+
+	times, err := parseInts(execution.ExecTime)
+	if err != nil {
+		return fmt.Errorf("parse execution %s failed: %w", execution.ExecId, err)
+	}
+
+	_, err = r.Conn.ExecEx(ctx, `INSERT INTO paper_execution (exec_id, order_id, user_id, symbol, side, exec_price, exec_qty, exec_value, exec_fee, fee_currency, exec_time)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) ON CONFLICT DO NOTHING;`, nil,
+		execution.ExecId, execution.OrderId, userId, execution.Symbol, execution.Side,
+		values[0], values[1], values[2], values[3], execution.FeeCurrency, times[0])
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseFloats parses numbers which come as strings from stream, empty string is 0.
+func parseFloats(strs ...string) ([]float64, error) {
+	values := make([]float64, len(strs))
+	for i, str := range strs {
+		if str == "" {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func parseInts(strs ...string) ([]int64, error) {
+	values := make([]int64, len(strs))
+	for i, str := range strs {
+		if str == "" {
+			continue
+		}
+
+		value, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -43,26 +44,60 @@ func (r *Repository) DeleteCoin(ctx context.Context, userId int64, coinTag strin
 
 func (r *Repository) GetCoin(ctx context.Context, userId int64, coinName string) (models.Coin, error) {
 	var coin models.Coin
-	rows := r.Conn.QueryRowEx(ctx, "SELECT coin_name, entry_price, decrement, count, buy, buy_order_id, sell_order_id FROM coin WHERE user_id=$1 AND coin_name=$2;", nil, userId, coinName)
-	err := rows.Scan(&coin.Name, &coin.EntryPrice, &coin.Decrement, &coin.Count, &coin.Buy, &coin.BuyOrderId, &coin.SellOrderId)
+	var params string
+	rows := r.Conn.QueryRowEx(ctx, "SELECT coin_name, coin_state, entry_price, decrement, count, buy, buy_order_id, sell_order_id, strategy, strategy_params, stop_loss_percent, stop_loss_price, stopped, trail_peak, cost, order_seq FROM coin WHERE user_id=$1 AND coin_name=$2;", nil, userId, coinName)
+	err := rows.Scan(&coin.Name, &coin.State, &coin.EntryPrice, &coin.Decrement, &coin.Count, &coin.Buy, &coin.BuyOrderId, &coin.SellOrderId, &coin.Strategy, &params, &coin.StopLossPercent, &coin.StopLossPrice, &coin.Stopped, &coin.TrailPeak, &coin.Cost, &coin.OrderSeq)
 	if err != nil {
 		return coin, err
 	}
 	coin.UserId = userId
+	coin.StrategyParams = json.RawMessage(params)
 	return coin, nil
 }
 
-func (r *Repository) GetCoiniks(ctx context.Context, coinName string) (models.Coiniks, error) {
+func (r *Repository) GetCoiniks(ctx context.Context, exchange, coinName string) (models.Coiniks, error) {
 	var coiniks models.Coiniks
-	rows := r.Conn.QueryRowEx(ctx, "SELECT qty_decimals, price_decimals, min_sum_buy FROM coiniks WHERE coin_name=$1;", nil, coinName)
-	err := rows.Scan(&coiniks.QtyDecimals, &coiniks.PriceDecimals, &coiniks.MinSumBuy)
+	rows := r.Conn.QueryRowEx(ctx, "SELECT qty_decimals, price_decimals, min_sum_buy, base_precision, quote_precision, tick_size, min_order_qty, min_order_amt, max_order_qty, status FROM coiniks WHERE exchange=$1 AND coin_name=$2;", nil, exchange, coinName)
+	err := rows.Scan(&coiniks.QtyDecimals, &coiniks.PriceDecimals, &coiniks.MinSumBuy, &coiniks.BasePrecision, &coiniks.QuotePrecision, &coiniks.TickSize,
+		&coiniks.MinOrderQty, &coiniks.MinOrderAmt, &coiniks.MaxOrderQty, &coiniks.Status)
 	if err != nil {
 		return coiniks, err
 	}
+	coiniks.Exchange = exchange
 	coiniks.Name = coinName
 	return coiniks, nil
 }
 
+// SyncCoiniks stores coiniks of exchange's instruments, coiniks of exchange which are not in
+// list are marked as delisted.
+func (r *Repository) SyncCoiniks(ctx context.Context, exchange string, list []models.Coiniks) error {
+	tx, err := r.Conn.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	names := make([]string, 0, len(list))
+	for _, c := range list {
+		_, err = tx.ExecEx(ctx, `INSERT INTO coiniks (exchange, coin_name, qty_decimals, price_decimals, min_sum_buy, base_precision, quote_precision, tick_size, min_order_qty, min_order_amt, max_order_qty, status, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, now())
+			ON CONFLICT (exchange, coin_name) DO UPDATE SET (qty_decimals, price_decimals, min_sum_buy, base_precision, quote_precision, tick_size, min_order_qty, min_order_amt, max_order_qty, status, updated_at) =
+			(excluded.qty_decimals, excluded.price_decimals, excluded.min_sum_buy, excluded.base_precision, excluded.quote_precision, excluded.tick_size, excluded.min_order_qty, excluded.min_order_amt, excluded.max_order_qty, excluded.status, now());`, nil,
+			exchange, c.Name, c.QtyDecimals, c.PriceDecimals, c.MinSumBuy, c.BasePrecision, c.QuotePrecision, c.TickSize, c.MinOrderQty, c.MinOrderAmt, c.MaxOrderQty, c.Status)
+		if err != nil {
+			return err
+		}
+		names = append(names, c.Name)
+	}
+
+	_, err = tx.ExecEx(ctx, "UPDATE coiniks SET (status, updated_at) = ($1, now()) WHERE exchange = $2 AND status <> $1 AND NOT coin_name = ANY($3);", nil, models.CoiniksStatusDelisted, exchange, names)
+	if err != nil {
+		return err
+	}
+
+	return tx.CommitEx(ctx)
+}
+
 func (r *Repository) EditBuy(ctx context.Context, userId int64, buy bool) error {
 	_, err := r.Conn.ExecEx(ctx, "UPDATE users SET buy = $1 WHERE tg_id = $2;", nil, buy, userId)
 	if err != nil {
@@ -71,9 +106,9 @@ func (r *Repository) EditBuy(ctx context.Context, userId int64, buy bool) error
 	return nil
 }
 
-func (r *Repository) ExistCoin(ctx context.Context, coinTag string) (bool, error) {
+func (r *Repository) ExistCoin(ctx context.Context, exchange, coinTag string) (bool, error) {
 	var exist bool
-	rows := r.Conn.QueryRowEx(ctx, "SELECT EXISTS(SELECT coin_name FROM coiniks WHERE coin_name = $1);", nil, coinTag)
+	rows := r.Conn.QueryRowEx(ctx, "SELECT EXISTS(SELECT coin_name FROM coiniks WHERE exchange = $1 AND coin_name = $2 AND status = $3);", nil, exchange, coinTag, models.CoiniksStatusTrading)
 	err := rows.Scan(&exist)
 	if err != nil {
 		return false, err
@@ -83,26 +118,44 @@ func (r *Repository) ExistCoin(ctx context.Context, coinTag string) (bool, error
 
 func (r *Repository) GetCoinList(ctx context.Context, userId int64) ([]models.Coin, error) {
 	coinList := make([]models.Coin, 0)
-	rows, err := r.Conn.QueryEx(ctx, "SELECT coin_name, count, buy, entry_price, user_id, decrement, buy_order_id, sell_order_id FROM coin WHERE user_id=$1;", nil, userId)
+	rows, err := r.Conn.QueryEx(ctx, "SELECT coin_name, coin_state, count, buy, entry_price, user_id, decrement, buy_order_id, sell_order_id, strategy, strategy_params, stop_loss_percent, stop_loss_price, stopped, trail_peak, cost, order_seq FROM coin WHERE user_id=$1;", nil, userId)
 	if err != nil {
 		return nil, err
 	}
 
 	for rows.Next() {
 		coin := models.Coin{}
-		if err = rows.Scan(&coin.Name, &coin.Count, &coin.Buy, &coin.EntryPrice, &coin.UserId, &coin.Decrement, &coin.BuyOrderId, &coin.SellOrderId); err != nil {
+		var params string
+		if err = rows.Scan(&coin.Name, &coin.State, &coin.Count, &coin.Buy, &coin.EntryPrice, &coin.UserId, &coin.Decrement, &coin.BuyOrderId, &coin.SellOrderId, &coin.Strategy, &params, &coin.StopLossPercent, &coin.StopLossPrice, &coin.Stopped, &coin.TrailPeak, &coin.Cost, &coin.OrderSeq); err != nil {
 			return nil, err
 		}
+		coin.StrategyParams = json.RawMessage(params)
 		coinList = append(coinList, coin)
 	}
 
 	return coinList, nil
 }
 
+// AddCoin adds coin with its strategy, default strategy of column is used if coin has none.
 func (r *Repository) AddCoin(coin models.Coin) error {
-	_, err := r.Conn.Exec("INSERT INTO coin (user_id, coin_name) VALUES ($1, $2) ON CONFLICT DO NOTHING;",
+	if coin.Strategy == "" {
+		_, err := r.Conn.Exec("INSERT INTO coin (user_id, coin_name) VALUES ($1, $2) ON CONFLICT DO NOTHING;",
+			coin.UserId,
+			coin.Name,
+		)
+		return err
+	}
+
+	params := string(coin.StrategyParams)
+	if params == "" {
+		params = "{}"
+	}
+
+	_, err := r.Conn.Exec("INSERT INTO coin (user_id, coin_name, strategy, strategy_params) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING;",
 		coin.UserId,
 		coin.Name,
+		coin.Strategy,
+		params,
 	)
 	if err != nil {
 		return err
@@ -145,20 +198,12 @@ func generateUpdateCoinQuery(coin models.Coin) (string, []interface{}, error) {
 	}
 	if coin.BuyOrderId != "" {
 		setClauses = append(setClauses, fmt.Sprintf("buy_order_id = $%d", i))
-		if coin.BuyOrderId == "setNull" {
-			values = append(values, "")
-		} else {
-			values = append(values, coin.BuyOrderId)
-		}
+		values = append(values, coin.BuyOrderId)
 		i++
 	}
 	if coin.SellOrderId != "" {
 		setClauses = append(setClauses, fmt.Sprintf("sell_order_id = $%d", i))
-		if coin.SellOrderId == "setNull" {
-			values = append(values, "")
-		} else {
-			values = append(values, coin.SellOrderId)
-		}
+		values = append(values, coin.SellOrderId)
 		i++
 	}
 
@@ -185,6 +230,48 @@ func (r *Repository) UpdateCoin(ctx context.Context, coin models.Coin) error {
 	return nil
 }
 
+// SavePosition stores what is bought, unlike UpdateCoin zero values are stored too.
+func (r *Repository) SavePosition(ctx context.Context, coin models.Coin) error {
+	_, err := r.Conn.ExecEx(ctx, "UPDATE coin SET (entry_price, decrement, count, cost, buy, trail_peak) = ($1, $2, $3, $4, $5, $6) WHERE (user_id, coin_name) = ($7, $8);", nil,
+		coin.EntryPrice, coin.Decrement, coin.Count, coin.Cost, coin.Buy, coin.TrailPeak, coin.UserId, coin.Name)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// TransitionCoinState moves coin from state "from" to coin.State and stores coin's order ids
+// with their sequence.
+// It fails if coin is not in state "from" anymore. Transition is written to history,
+// if state has not changed only order ids are stored.
+func (r *Repository) TransitionCoinState(ctx context.Context, coin models.Coin, from models.CoinState, reason string) error {
+	tx, err := r.Conn.BeginEx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tag, err := tx.ExecEx(ctx, "UPDATE coin SET (coin_state, buy_order_id, sell_order_id, order_seq) = ($1, $2, $3, $4) WHERE (user_id, coin_name, coin_state) = ($5, $6, $7);", nil,
+		coin.State, coin.BuyOrderId, coin.SellOrderId, coin.OrderSeq, coin.UserId, coin.Name, from)
+	if err != nil {
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: coin %s is not in state %s", models.ErrIllegalTransition, coin.Name, from)
+	}
+
+	if from != coin.State {
+		_, err = tx.ExecEx(ctx, "INSERT INTO coin_state_history (user_id, coin_name, from_state, to_state, reason) VALUES ($1, $2, $3, $4, $5);", nil,
+			coin.UserId, coin.Name, from, coin.State, reason)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.CommitEx(ctx)
+}
+
 func (r *Repository) UpdateCount(userID int64, count float64, coinTag string, decrement float64, buy []float64) error {
 	_, err := r.Conn.Exec("UPDATE coin SET (decrement, count,buy) = ($1,$2,$3) WHERE (user_id,coin_name)=($4,$5);", decrement, count, buy, userID, coinTag)
 	if err != nil {
@@ -195,7 +282,7 @@ func (r *Repository) UpdateCount(userID int64, count float64, coinTag string, de
 }
 
 func (r *Repository) SetCoinToDefault(ctx context.Context, userId int64, coinTag string) error {
-	_, err := r.Conn.ExecEx(ctx, "UPDATE coin SET (count, buy, entry_price, decrement, buy_order_id, sell_order_id) = (DEFAULT, DEFAULT, DEFAULT, DEFAULT, DEFAULT, DEFAULT) WHERE (user_id,coin_name)=($1,$2);", nil, userId, coinTag)
+	_, err := r.Conn.ExecEx(ctx, "UPDATE coin SET (count, cost, buy, entry_price, decrement, buy_order_id, sell_order_id, trail_peak) = (DEFAULT, DEFAULT, DEFAULT, DEFAULT, DEFAULT, DEFAULT, DEFAULT, DEFAULT) WHERE (user_id,coin_name)=($1,$2);", nil, userId, coinTag)
 	if err != nil {
 		return err
 	}
@@ -225,3 +312,151 @@ func (r *Repository) InsertIncome(userID int64, coinTag string, income, count fl
 	}
 	return nil
 }
+
+// InsertFill adds fill to ledger, fill whose execution is stored already is skipped.
+func (r *Repository) InsertFill(ctx context.Context, fill models.Fill) error {
+	_, err := r.Conn.ExecEx(ctx, `INSERT INTO fills (user_id, coin_name, side, order_id, exec_id, strategy, price, qty, fee, fee_coin, time)
+VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11) ON CONFLICT (user_id, exec_id) DO NOTHING;`, nil,
+		fill.UserId, fill.CoinName, fill.Side, fill.OrderId, fill.ExecId, fill.Strategy, fill.Price, fill.Qty, fill.Fee, fill.FeeCoin, fill.Time)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertOrder adds order which bot has placed, its creation is written to order's history.
+func (r *Repository) InsertOrder(ctx context.Context, order models.Order) error {
+	_, err := r.Conn.ExecEx(ctx, `WITH inserted AS (INSERT INTO orders (user_id, coin_name, order_id, side, order_type, price, qty, status, reject_reason, request, response)
+VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9, $10, $11) RETURNING user_id, order_id, status, reject_reason, request, response)
+INSERT INTO order_history (user_id, order_id, action, status, reject_reason, request, response) SELECT user_id, order_id, $12, status, reject_reason, request, response FROM inserted;`, nil,
+		order.UserId, order.CoinName, order.OrderId, order.Side, order.OrderType, order.Price, order.Qty, order.Status, order.RejectReason, order.Request, order.Response, models.OrderActionCreate)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateOrderStatus changes status of bot's order, only changes are written to order's history.
+// Orders which bot has not placed are skipped.
+func (r *Repository) UpdateOrderStatus(ctx context.Context, update models.OrderUpdate) error {
+	_, err := r.Conn.ExecEx(ctx, `WITH updated AS (UPDATE orders SET (status, reject_reason, cum_exec_qty, updated_at) = ($3, $4, $5, now())
+WHERE (user_id, order_id) = ($1, $2) AND (status IS DISTINCT FROM $3 OR cum_exec_qty IS DISTINCT FROM $5) RETURNING user_id, order_id)
+INSERT INTO order_history (user_id, order_id, action, status, reject_reason, cum_exec_qty) SELECT user_id, order_id, $6, $3, $4, $5 FROM updated;`, nil,
+		update.UserId, update.OrderId, update.Status, update.RejectReason, update.CumExecQty, models.OrderActionStatus)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) InsertOrderHistory(ctx context.Context, update models.OrderUpdate) error {
+	_, err := r.Conn.ExecEx(ctx, "INSERT INTO order_history (user_id, order_id, action, status, reject_reason, cum_exec_qty, request, response) VALUES ($1, $2, $3, $4, $5, $6, $7, $8);", nil,
+		update.UserId, update.OrderId, update.Action, update.Status, update.RejectReason, update.CumExecQty, update.Request, update.Response)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetCoinStrategy replaces strategy of coin, it is changed only when nothing is bought.
+func (r *Repository) SetCoinStrategy(ctx context.Context, userId int64, coinName, strategy string, params json.RawMessage) error {
+	_, err := r.Conn.ExecEx(ctx, "UPDATE coin SET (strategy, strategy_params) = ($1, $2) WHERE (user_id, coin_name) = ($3, $4);", nil,
+		strategy, string(params), userId, coinName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetGridLevels(ctx context.Context, userId int64, coinName string) ([]models.GridLevel, error) {
+	levels := make([]models.GridLevel, 0)
+	rows, err := r.Conn.QueryEx(ctx, "SELECT level, price, side, qty, buy_price, order_id FROM grid_level WHERE (user_id, coin_name) = ($1, $2) ORDER BY level;", nil, userId, coinName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var level models.GridLevel
+		if err = rows.Scan(&level.Level, &level.Price, &level.Side, &level.Qty, &level.BuyPrice, &level.OrderId); err != nil {
+			return nil, err
+		}
+		levels = append(levels, level)
+	}
+
+	return levels, rows.Err()
+}
+
+func (r *Repository) SaveGridLevel(ctx context.Context, userId int64, coinName string, level models.GridLevel) error {
+	_, err := r.Conn.ExecEx(ctx, `INSERT INTO grid_level (user_id, coin_name, level, price, side, qty, buy_price, order_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (user_id, coin_name, level) DO UPDATE SET (price, side, qty, buy_price, order_id) = (EXCLUDED.price, EXCLUDED.side, EXCLUDED.qty, EXCLUDED.buy_price, EXCLUDED.order_id);`, nil,
+		userId, coinName, level.Level, level.Price, level.Side, level.Qty, level.BuyPrice, level.OrderId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) DeleteGridLevels(ctx context.Context, userId int64, coinName string) error {
+	_, err := r.Conn.ExecEx(ctx, "DELETE FROM grid_level WHERE (user_id, coin_name) = ($1, $2);", nil, userId, coinName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetStopLoss sets coin's stop loss, zero values turn it off.
+func (r *Repository) SetStopLoss(ctx context.Context, userId int64, coinName string, percent, price float64) error {
+	_, err := r.Conn.ExecEx(ctx, "UPDATE coin SET (stop_loss_percent, stop_loss_price) = ($1, $2) WHERE (user_id, coin_name) = ($3, $4);", nil,
+		percent, price, userId, coinName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) SetStopped(ctx context.Context, userId int64, coinName string, stopped bool) error {
+	_, err := r.Conn.ExecEx(ctx, "UPDATE coin SET stopped = $1 WHERE (user_id, coin_name) = ($2, $3);", nil, stopped, userId, coinName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResumeStopped resumes all user's coins which have been stopped by protection.
+func (r *Repository) ResumeStopped(ctx context.Context, userId int64) error {
+	_, err := r.Conn.ExecEx(ctx, "UPDATE coin SET stopped = false WHERE user_id = $1;", nil, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetCoinSettings returns coin's settings, settings which are not stored are zero.
+func (r *Repository) GetCoinSettings(ctx context.Context, userId int64, coinName string) (models.CoinSettings, error) {
+	settings := models.NewCoinSettings(userId, coinName)
+	rows, err := r.Conn.QueryEx(ctx, "SELECT take_profit, step, order_size, order_size_usdt, sizing, multiplier, target_average, spacing, step_widening, max_depth, max_allocation FROM coin_settings WHERE (user_id, coin_name) = ($1, $2);", nil, userId, coinName)
+	if err != nil {
+		return settings, err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		err = rows.Scan(&settings.TakeProfit, &settings.Step, &settings.OrderSize, &settings.OrderSizeUSDT, &settings.Sizing, &settings.Multiplier, &settings.TargetAverage, &settings.Spacing, &settings.StepWidening, &settings.MaxDepth, &settings.MaxAllocation)
+		if err != nil {
+			return settings, err
+		}
+	}
+
+	return settings, rows.Err()
+}
+
+func (r *Repository) SaveCoinSettings(ctx context.Context, settings models.CoinSettings) error {
+	_, err := r.Conn.ExecEx(ctx, `INSERT INTO coin_settings (user_id, coin_name, take_profit, step, order_size, order_size_usdt, sizing, multiplier, target_average, spacing, step_widening, max_depth, max_allocation) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+ON CONFLICT (user_id, coin_name) DO UPDATE SET (take_profit, step, order_size, order_size_usdt, sizing, multiplier, target_average, spacing, step_widening, max_depth, max_allocation) = (EXCLUDED.take_profit, EXCLUDED.step, EXCLUDED.order_size, EXCLUDED.order_size_usdt, EXCLUDED.sizing, EXCLUDED.multiplier, EXCLUDED.target_average, EXCLUDED.spacing, EXCLUDED.step_widening, EXCLUDED.max_depth, EXCLUDED.max_allocation);`, nil,
+		settings.UserId, settings.CoinName, settings.TakeProfit, settings.Step, settings.OrderSize, settings.OrderSizeUSDT, settings.Sizing, settings.Multiplier, settings.TargetAverage, settings.Spacing, settings.StepWidening, settings.MaxDepth, settings.MaxAllocation)
+	if err != nil {
+		return err
+	}
+	return nil
+}
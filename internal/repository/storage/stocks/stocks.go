@@ -2,22 +2,39 @@ package stocks
 
 import (
 	"context"
+	"encoding/json"
 
 	"m1pes/internal/models"
 )
 
 type Repository interface {
 	GetCoin(ctx context.Context, userId int64, coin string) (models.Coin, error)
-	GetCoiniks(ctx context.Context, coinName string) (models.Coiniks, error)
+	GetCoiniks(ctx context.Context, exchange, coinName string) (models.Coiniks, error)
+	SyncCoiniks(ctx context.Context, exchange string, list []models.Coiniks) error
 	EditBuy(ctx context.Context, userId int64, buy bool) error
-	ExistCoin(ctx context.Context, coinTag string) (bool, error)
+	ExistCoin(ctx context.Context, exchange, coinTag string) (bool, error)
 	GetCoinList(ctx context.Context, userId int64) ([]models.Coin, error)
 	AddCoin(coin models.Coin) error
 	UpdateCoin(ctx context.Context, coin models.Coin) error
+	SavePosition(ctx context.Context, coin models.Coin) error
+	TransitionCoinState(ctx context.Context, coin models.Coin, from models.CoinState, reason string) error
 	ResetCoin(ctx context.Context, coin models.Coin, user models.User) error
 	UpdateCount(userID int64, count float64, coinTag string, decrement float64, buy []float64) error
 	SellCoin(userID int64, coinTag string, sellPrice float64) error
 	SetCoinToDefault(ctx context.Context, userId int64, coinTag string) error
 	DeleteCoin(ctx context.Context, userID int64, coinTag string) error
 	InsertIncome(userID int64, coinTag string, income, count float64) error
+	InsertFill(ctx context.Context, fill models.Fill) error
+	InsertOrder(ctx context.Context, order models.Order) error
+	UpdateOrderStatus(ctx context.Context, update models.OrderUpdate) error
+	InsertOrderHistory(ctx context.Context, update models.OrderUpdate) error
+	SetCoinStrategy(ctx context.Context, userId int64, coinName, strategy string, params json.RawMessage) error
+	GetGridLevels(ctx context.Context, userId int64, coinName string) ([]models.GridLevel, error)
+	SaveGridLevel(ctx context.Context, userId int64, coinName string, level models.GridLevel) error
+	DeleteGridLevels(ctx context.Context, userId int64, coinName string) error
+	SetStopLoss(ctx context.Context, userId int64, coinName string, percent, price float64) error
+	SetStopped(ctx context.Context, userId int64, coinName string, stopped bool) error
+	ResumeStopped(ctx context.Context, userId int64) error
+	GetCoinSettings(ctx context.Context, userId int64, coinName string) (models.CoinSettings, error)
+	SaveCoinSettings(ctx context.Context, settings models.CoinSettings) error
 }
@@ -72,6 +72,21 @@ func generateUpdateUserQuery(user models.User) (string, []interface{}, error) {
 		values = append(values, user.SecretKey)
 		i++
 	}
+	if user.Passphrase != "" {
+		setClauses = append(setClauses, fmt.Sprintf("passphrase = $%d", i))
+		values = append(values, user.Passphrase)
+		i++
+	}
+	if user.Exchange != "" {
+		setClauses = append(setClauses, fmt.Sprintf("exchange = $%d", i))
+		values = append(values, user.Exchange)
+		i++
+	}
+	if user.Environment != "" {
+		setClauses = append(setClauses, fmt.Sprintf("environment = $%d", i))
+		values = append(values, user.Environment)
+		i++
+	}
 
 	setClauses = append(setClauses, fmt.Sprintf("trading_activated = $%d", i))
 	values = append(values, user.TradingActivated)
@@ -136,8 +151,8 @@ func (r *Repository) NewUser(ctx context.Context, user models.User) error {
 
 func (r *Repository) GetUser(ctx context.Context, userId int64) (models.User, error) {
 	var user models.User
-	res := r.Conn.QueryRowEx(ctx, "SELECT bal, capital, percent, status, api_key, secret_key, trading_activated, buy FROM users WHERE tg_id=$1;", nil, userId)
-	err := res.Scan(&user.USDTBalance, &user.Capital, &user.Percent, &user.Status, &user.ApiKey, &user.SecretKey, &user.TradingActivated, &user.Buy)
+	res := r.Conn.QueryRowEx(ctx, "SELECT bal, capital, percent, status, api_key, secret_key, passphrase, exchange, environment, trading_activated, buy, max_drawdown, peak_equity FROM users WHERE tg_id=$1;", nil, userId)
+	err := res.Scan(&user.USDTBalance, &user.Capital, &user.Percent, &user.Status, &user.ApiKey, &user.SecretKey, &user.Passphrase, &user.Exchange, &user.Environment, &user.TradingActivated, &user.Buy, &user.MaxDrawdown, &user.PeakEquity)
 	if err != nil {
 		return models.User{}, err
 	}
@@ -162,3 +177,21 @@ func (r *Repository) GetIncome(ctx context.Context, userId int64) (float64, erro
 	}
 	return incomes, nil
 }
+
+// SetMaxDrawdown sets user's max drawdown, zero turns it off.
+func (r *Repository) SetMaxDrawdown(ctx context.Context, userId int64, maxDrawdown float64) error {
+	_, err := r.Conn.ExecEx(ctx, "UPDATE users SET max_drawdown = $1 WHERE tg_id = $2;", nil, maxDrawdown, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetPeakEquity stores the highest user's equity, drawdown is counted from it.
+func (r *Repository) SetPeakEquity(ctx context.Context, userId int64, equity float64) error {
+	_, err := r.Conn.ExecEx(ctx, "UPDATE users SET peak_equity = $1 WHERE tg_id = $2;", nil, equity, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -13,4 +13,6 @@ type Repository interface {
 	GetAllUsers(ctx context.Context) ([]models.User, error)
 	ChangeBalance(ctx context.Context, userId int64, amount float64) error
 	GetIncome(ctx context.Context, userId int64) (float64, error)
+	SetMaxDrawdown(ctx context.Context, userId int64, maxDrawdown float64) error
+	SetPeakEquity(ctx context.Context, userId int64, equity float64) error
 }
@@ -6,12 +6,15 @@ import (
 	"log/slog"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strconv"
-	"strings"
+	"sync"
 
+	"m1pes/internal/config"
 	"m1pes/internal/logging"
+	"m1pes/internal/service/engine"
+	"m1pes/internal/service/strategy"
 
-	"m1pes/internal/delivery/telegram/bot"
 	"m1pes/internal/models"
 
 	apiStock "m1pes/internal/repository/api/stocks"
@@ -24,84 +27,161 @@ const (
 )
 
 type Service struct {
-	apiRepo      apiStock.Repository
+	exchange     apiStock.Exchange
 	sStorageRepo storageStock.Repository
 	uStorageRepo storageUser.Repository
-	stopCoinMap  map[int64]map[string]chan struct{}
+	engine       *engine.Engine
+
+	balanceMu sync.Mutex
+	balances  map[int64]float64
+
+	// reported is kind of error which has been sent to user about coin, it is sent once until
+	// coin is handled without error.
+	reportedMu sync.Mutex
+	reported   map[engine.Key]models.ErrorKind
 }
 
-func New(apiRepo apiStock.Repository, sStoRepo storageStock.Repository, uStoRepo storageUser.Repository) *Service {
-	return &Service{apiRepo, sStoRepo, uStoRepo, make(map[int64]map[string]chan struct{})}
+func New(exchange apiStock.Exchange, streamRepo apiStock.StreamRepository, sStoRepo storageStock.Repository, uStoRepo storageUser.Repository, engineCfg config.EngineConfig) *Service {
+	s := &Service{
+		exchange:     exchange,
+		sStorageRepo: sStoRepo,
+		uStorageRepo: uStoRepo,
+		balances:     make(map[int64]float64),
+		reported:     make(map[engine.Key]models.ErrorKind),
+	}
+	s.engine = engine.New(streamRepo, engineCfg, s.UpdateBalance, s.HandlePrivateEvent)
+
+	return s
 }
 
 func (s *Service) StartTrading(ctx context.Context, userId int64, actionChanMap map[int64]chan models.Message) error {
+	user, err := s.uStorageRepo.GetUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting user from storage", "err", err)
+		return err
+	}
+
 	coinList, err := s.sStorageRepo.GetCoinList(ctx, userId)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting coin list from storage", err)
+		slog.ErrorContext(ctx, "Error getting coin list from storage", "err", err)
 		return err
 	}
 
-	// In that for loop we are starting handling every user's coin.
+	// In that for loop we are starting handling every user's coin, engine skips coins
+	// which are already handled.
 	for _, coin := range coinList {
-		ctx = logging.WithCoinTag(ctx, coin.Name)
-
-		// init map that stores coin name as key and map2 as value
-		// map2 stores userId as key and struct{} as value
-		if _, ok := s.stopCoinMap[userId][coin.Name]; ok {
-			continue
+		err = s.engine.Register(ctx, user, coin.Name, s.coinHandler(coin, actionChanMap))
+		if err != nil {
+			slog.ErrorContext(logging.WithCoinTag(ctx, coin.Name), "Error registering coin in engine", "err", err)
+			return err
 		}
+	}
 
-		if _, ok := s.stopCoinMap[userId]; !ok {
-			s.stopCoinMap[userId] = make(map[string]chan struct{})
-		}
-		s.stopCoinMap[userId][coin.Name] = make(chan struct{})
+	// Indicates that the user has started trading.
+	updateUser := models.NewUser(userId)
+	updateUser.TradingActivated = true
 
-		go func(ctx context.Context, coin models.Coin) {
-			// This function needs for catching panics.
-			defer func() {
-				if r := recover(); r != nil {
-					slog.ErrorContext(ctx, "Recovered in goroutine in Service.StartTrading", slog.String("stacktrace", string(debug.Stack())), r)
+	err = s.uStorageRepo.UpdateUser(ctx, updateUser)
+	if err != nil {
+		return err
+	}
 
-					actionChanMap[userId] <- models.Message{
-						Coin:   coin,
-						Action: r.(string),
-						File:   string(debug.Stack()),
-					}
-				}
-			}()
-
-			ctx = logging.WithCoinTag(ctx, coin.Name)
-
-			for {
-				select {
-				case <-s.stopCoinMap[userId][coin.Name]:
-					delete(s.stopCoinMap[userId], coin.Name)
-					return
-				default:
-					err, eris := s.HandleCoinUpdate(ctx, coin, userId, actionChanMap)
-					if err != nil {
-						actionChanMap[userId] <- models.Message{
-							Coin:   coin,
-							Action: err.Error(),
-							File:   eris.File,
-							Line:   eris.Line,
-						}
-					}
+	return nil
+}
+
+// coinHandler returns function which is called by engine on every coin's event.
+func (s *Service) coinHandler(coin models.Coin, actionChanMap map[int64]chan models.Message) engine.HandlerFunc {
+	return func(ctx context.Context, key engine.Key, event engine.Event) error {
+		// This function needs for catching panics.
+		defer func() {
+			if r := recover(); r != nil {
+				slog.ErrorContext(ctx, "Recovered in Service.coinHandler", slog.String("stacktrace", string(debug.Stack())), "panic", r)
+
+				actionChanMap[key.UserId] <- models.Message{
+					Coin:   coin,
+					Action: fmt.Sprint(r),
+					File:   string(debug.Stack()),
 				}
 			}
-		}(ctx, coin)
+		}()
+
+		currentPrice := event.Price
+		if currentPrice == 0 {
+			user, err := s.uStorageRepo.GetUser(ctx, key.UserId)
+			if err != nil {
+				return err
+			}
+
+			// Price is requested only once, next prices come from stream. Users who are not on
+			// stream, e.g. of bybit's testnet, have their price requested every time.
+			currentPrice, err = s.getCurrentPrice(ctx, user, coin.Name)
+			if err != nil {
+				s.reportError(ctx, key, coin, err, models.Error{}, actionChanMap)
+				return err
+			}
+		}
+
+		err, eris := s.HandleCoinUpdate(ctx, coin, key.UserId, currentPrice, event.CheckOrders, actionChanMap)
+		if err != nil {
+			s.reportError(ctx, key, coin, err, eris, actionChanMap)
+			return err
+		}
+
+		s.resetReported(key)
+		return nil
 	}
+}
 
-	// Indicates that the user has started trading.
-	user := models.NewUser(userId)
-	user.TradingActivated = true
+func (s *Service) getCurrentPrice(ctx context.Context, user models.User, coinName string) (float64, error) {
+	currentPrice, err := s.exchange.GetPrice(ctx, user.Credentials(), coinName)
+	if err != nil {
+		return 0, fmt.Errorf("get coin failed: %w", err)
+	}
 
-	err = s.uStorageRepo.UpdateUser(ctx, user)
+	return currentPrice, nil
+}
+
+// getBalance returns user's total equity from cache, it is requested from api only
+// if wallet stream has not sent it yet.
+func (s *Service) getBalance(ctx context.Context, user models.User) (float64, error) {
+	s.balanceMu.Lock()
+	balance, ok := s.balances[user.Id]
+	s.balanceMu.Unlock()
+	if ok {
+		return balance, nil
+	}
+
+	balances, err := s.exchange.GetBalances(ctx, user.Credentials())
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("get user's wallet failed: %w", err)
 	}
+	balance = balances.TotalEquity
 
-	return nil
+	s.balanceMu.Lock()
+	s.balances[user.Id] = balance
+	s.balanceMu.Unlock()
+
+	return balance, nil
+}
+
+// UpdateBalance stores user's total equity from wallets, it is called on every wallet
+// stream event. Backtest calls it after every simulated candle.
+func (s *Service) UpdateBalance(userId int64, wallets []models.WalletEvent) {
+	for _, wallet := range wallets {
+		if wallet.AccountType != "UNIFIED" {
+			continue
+		}
+
+		balance, err := strconv.ParseFloat(wallet.TotalEquity, 64)
+		if err != nil {
+			slog.Error("Error parsing total equity from wallet stream", "err", err)
+			continue
+		}
+
+		s.balanceMu.Lock()
+		s.balances[userId] = balance
+		s.balanceMu.Unlock()
+	}
 }
 
 func (s *Service) StopTrading(ctx context.Context, userID int64) error {
@@ -110,15 +190,15 @@ func (s *Service) StopTrading(ctx context.Context, userID int64) error {
 
 	err := s.uStorageRepo.UpdateUser(ctx, user)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error updating user", err)
+		slog.ErrorContext(ctx, "Error updating user", "err", err)
 		return err
 	}
 
 	// Stopping and deleting all user.
-	for coinName := range s.stopCoinMap[userID] {
+	for _, coinName := range s.engine.Coins(userID) {
 		err = s.DeleteCoin(ctx, userID, coinName)
 		if err != nil {
-			slog.ErrorContext(ctx, "Error deleting coin", err)
+			slog.ErrorContext(ctx, "Error deleting coin", "err", err)
 			return err
 		}
 	}
@@ -126,136 +206,216 @@ func (s *Service) StopTrading(ctx context.Context, userID int64) error {
 }
 
 func (s *Service) DeleteCoin(ctx context.Context, userId int64, coinTag string) error {
-	// Deleting coin from map of coins.
-	if _, ok := s.stopCoinMap[userId][coinTag]; ok {
-		s.stopCoinMap[userId][coinTag] <- struct{}{}
-	}
+	// Stopping coin's worker.
+	s.engine.Unregister(engine.Key{UserId: userId, Coin: coinTag})
 
 	// Getting user from storage.
 	user, err := s.uStorageRepo.GetUser(ctx, userId)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting user", err)
+		slog.ErrorContext(ctx, "Error getting user", "err", err)
 		return err
 	}
 
 	// Getting coin from storage.
 	coin, err := s.sStorageRepo.GetCoin(ctx, userId, coinTag)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting coin", err)
+		slog.ErrorContext(ctx, "Error getting coin", "err", err)
 		return err
 	}
 
-	// Canceling sell order.
-	if coin.SellOrderId != "" {
-		cancelReq := models.CancelOrderRequest{
-			Category: "spot",
-			OrderId:  coin.SellOrderId,
-			Symbol:   coin.Name,
-		}
+	err = s.transition(ctx, &coin, models.CoinStateLiquidating, "coin is deleted")
+	if err != nil {
+		slog.ErrorContext(ctx, "Error moving coin to liquidating state", "err", err)
+		return err
+	}
 
-		_, err = s.apiRepo.CancelOrder(ctx, cancelReq, user.ApiKey, user.SecretKey)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error canceling sell order", err)
-			return err
-		}
+	// Deleted coin is not sold again by algorithm if liquidation fails, user deletes it again.
+	err = s.sStorageRepo.SetStopped(ctx, userId, coinTag, false)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error resetting stopped coin", "err", err)
+		return err
+	}
+
+	_, err = s.liquidate(ctx, user, coin)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error liquidating coin", "err", err)
+		return err
+	}
+
+	coin.BuyOrderId, coin.SellOrderId = "", ""
+	err = s.transition(ctx, &coin, models.CoinStateIdle, "coin is liquidated")
+	if err != nil {
+		slog.ErrorContext(ctx, "Error moving coin to idle state", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// liquidation is what has been sold when coin is liquidated.
+type liquidation struct {
+	Count  float64
+	Price  float64
+	Income float64
+}
+
+// liquidate cancels coin's orders, sells bought coins at market and stores income. Orders
+// which have been filled before they are canceled are counted too. Coin's position and
+// grid levels are reset.
+func (s *Service) liquidate(ctx context.Context, user models.User, coin models.Coin) (liquidation, error) {
+	var sold liquidation
+
+	// Getting coin's data from storage.
+	coiniks, err := s.sStorageRepo.GetCoiniks(ctx, user.Credentials().Exchange, coin.Name)
+	if err != nil {
+		return sold, fmt.Errorf("get coiniks failed: %w", err)
+	}
+
+	// Orders which have been filled before cancel and market sell are counted by position.
+	position := coin.Position()
+	levels, err := s.sStorageRepo.GetGridLevels(ctx, user.Id, coin.Name)
+	if err != nil {
+		return sold, fmt.Errorf("get grid levels failed: %w", err)
+	}
+
+	orderIds := []string{coin.SellOrderId, coin.BuyOrderId}
+	for _, level := range levels {
+		orderIds = append(orderIds, level.OrderId)
 	}
 
-	// Canceling buy order.
-	if coin.BuyOrderId != "" {
-		cancelReq := models.CancelOrderRequest{
-			Category: "spot",
-			OrderId:  coin.BuyOrderId,
-			Symbol:   coin.Name,
+	for _, orderId := range orderIds {
+		if orderId == "" {
+			continue
 		}
 
-		_, err = s.apiRepo.CancelOrder(ctx, cancelReq, user.ApiKey, user.SecretKey)
+		orderCtx := logging.WithOrderId(ctx, orderId)
+
+		order, err := s.cancelClosing(orderCtx, user, coin.Name, orderId)
 		if err != nil {
-			slog.ErrorContext(ctx, "Error canceling buy order", err)
-			return err
+			return sold, err
 		}
-	}
+		side := order.Side
 
-	// If user already bought something.
-	if len(coin.Buy) > 0 {
-		// Getting coin's data from storage.
-		coiniks, err := s.sStorageRepo.GetCoiniks(ctx, coin.Name)
+		// Order could be filled before it has been canceled.
+		qty, value, fee, err := s.getExecuted(orderCtx, user, coin, orderId)
 		if err != nil {
-			slog.ErrorContext(ctx, "Error getting coiniks", err)
-			return err
+			return sold, err
 		}
 
-		// Creating sell order.
-		createOrderReq := models.CreateOrderRequest{
-			Category:    "spot",
-			Side:        "Sell",
-			Symbol:      coin.Name,
-			OrderType:   "Market",
-			Qty:         fmt.Sprintf("%."+strconv.Itoa(coiniks.QtyDecimals)+"f", coin.Count),
-			TimeInForce: "GTC",
+		if qty == 0 || (side != "Buy" && side != "Sell") {
+			continue
 		}
 
-		_, err = s.apiRepo.CreateOrder(ctx, createOrderReq, user.ApiKey, user.SecretKey)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error creating order", err)
-			return err
+		fill := models.NewFill(coin, side, orderId, value/qty, qty, fee)
+		sold.Income += position.Apply(fill)
+		if side == "Sell" {
+			sold.Count += qty
 		}
+	}
 
-		// Getting current price of coin from api.
-		getCoinReqParams := make(models.GetCoinRequest)
-		getCoinReqParams["category"] = "spot"
-		getCoinReqParams["symbol"] = coin.Name
+	count := coiniks.FloorQty(position.Qty)
 
-		getCoinResp, err := s.apiRepo.GetCoin(ctx, getCoinReqParams, user.ApiKey, user.SecretKey)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error getting coin from algorithm", err)
-			return err
+	// If user already bought something.
+	if count > 0 {
+		// Creating sell order.
+		createOrderReq := models.PlaceOrderRequest{
+			Side:      "Sell",
+			Symbol:    coin.Name,
+			OrderType: "Market",
+			Qty:       coiniks.FormatQty(count),
+			// Liquidation which is tried again after lost response does not sell twice.
+			OrderLinkId: orderLinkId(coin, linkTagLiquidate),
 		}
 
-		currentPrice, err := strconv.ParseFloat(getCoinResp.Result.List[0].Price, 64)
+		placed, err := s.createOrder(ctx, user, createOrderReq)
 		if err != nil {
-			slog.ErrorContext(ctx, "Error parsing current price to float", err)
-			return err
+			return sold, fmt.Errorf("create order failed: %w", err)
 		}
 
-		// Counting income.
-		var money float64
-		for i := 0; i < len(coin.Buy); i++ {
-			money += coin.Buy[i]
+		// Getting current price of coin from api, fee of market order is not known yet.
+		sold.Price, err = s.getCurrentPrice(ctx, user, coin.Name)
+		if err != nil {
+			return sold, err
 		}
 
-		avg := money / float64(len(coin.Buy))
-
-		spentMoney := avg * coin.Count
-		earnMoney := currentPrice * coin.Count
+		fill := models.NewFill(coin, "Sell", placed.OrderId, sold.Price, count, 0)
+		sold.Income += position.Apply(fill)
+		sold.Count += count
 
-		income := earnMoney - spentMoney
+		s.recordOrderFills(ctx, user, coin, placed.OrderId)
+	}
 
-		err = s.sStorageRepo.InsertIncome(userId, coinTag, income, coin.Count)
+	if sold.Count > 0 {
+		err = s.sStorageRepo.InsertIncome(user.Id, coin.Name, sold.Income, sold.Count)
 		if err != nil {
-			slog.ErrorContext(ctx, "Error insert income", err)
-			return err
+			return sold, fmt.Errorf("insert income failed: %w", err)
 		}
 	}
 
 	// Setting all coin columns to null.
-	err = s.sStorageRepo.SetCoinToDefault(ctx, userId, coinTag)
+	err = s.sStorageRepo.SetCoinToDefault(ctx, user.Id, coin.Name)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error setting coin to default", err)
-		return err
+		return sold, fmt.Errorf("set coin to default failed: %w", err)
 	}
 
-	return nil
+	err = s.sStorageRepo.DeleteGridLevels(ctx, user.Id, coin.Name)
+	if err != nil {
+		return sold, fmt.Errorf("delete grid levels failed: %w", err)
+	}
+
+	return sold, nil
+}
+
+// cancelClosing cancels order and returns it as it is after cancel, with its executed part.
+// Order which is closed already is not an error, only order which stays open is.
+func (s *Service) cancelClosing(ctx context.Context, user models.User, coinName, orderId string) (models.OrderInfo, error) {
+	cancelErr := s.cancelOrder(ctx, user, coinName, orderId)
+
+	orders, err := s.getOrders(ctx, user, models.OrderQuery{Symbol: coinName, OrderId: orderId})
+	if err != nil {
+		return models.OrderInfo{}, fmt.Errorf("get order failed: %w", err)
+	}
+
+	if len(orders) == 0 {
+		// Order which exchange does not know is not open, there is nothing to cancel.
+		if cancelErr != nil && models.ErrorKindOf(cancelErr) != models.ErrorKindOrderNotFound {
+			return models.OrderInfo{}, cancelErr
+		}
+		return models.OrderInfo{}, nil
+	}
+
+	order := orders[0]
+	if cancelErr != nil && (order.OrderStatus == models.OrderStatusNew || order.OrderStatus == models.OrderStatusPartiallyFilled) {
+		return models.OrderInfo{}, cancelErr
+	}
+	return order, nil
+}
+
+// executed reports if order is closed and has bought or sold something: it is filled or it
+// has been canceled after partial fill.
+func executed(order models.OrderInfo) bool {
+	switch order.OrderStatus {
+	case models.OrderStatusFilled:
+		return true
+	case models.OrderStatusCancelled, models.OrderStatusPartiallyFilledCanceled:
+		qty, err := strconv.ParseFloat(order.CumExecQty, 64)
+		return err == nil && qty > 0
+	default:
+		return false
+	}
 }
 
 // These functions do not need for implementing AlgorithmService.
 
-func (s *Service) HandleCoinUpdate(ctx context.Context, coin models.Coin, userId int64, actionChanMap map[int64]chan models.Message) (error, models.Error) {
+// HandleCoinUpdate gives coin's event to coin's strategy and executes its decision, orders
+// are requested from api only if checkOrders is true.
+func (s *Service) HandleCoinUpdate(ctx context.Context, coin models.Coin, userId int64, currentPrice float64, checkOrders bool, actionChanMap map[int64]chan models.Message) (error, models.Error) {
 	var candik bool
 	candik = true
 	var eris models.Error
 	user, err := s.uStorageRepo.GetUser(ctx, userId)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting user from algorithm", err)
+		slog.ErrorContext(ctx, "Error getting user from algorithm", "err", err)
 		_, eris.File, eris.Line, _ = runtime.Caller(0)
 		return err, eris
 	}
@@ -263,517 +423,291 @@ func (s *Service) HandleCoinUpdate(ctx context.Context, coin models.Coin, userId
 	// Getting coin from storage.
 	coin, err = s.sStorageRepo.GetCoin(ctx, user.Id, coin.Name)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting coin from storage", err)
+		slog.ErrorContext(ctx, "Error getting coin from storage", "err", err)
 		_, eris.File, eris.Line, _ = runtime.Caller(0)
 		return err, eris
 	}
 
-	// Getting user's wallet balance from api.
-	getUserWalletParams := make(models.GetUserWalletRequest)
-	getUserWalletParams["accountType"] = "UNIFIED"
+	switch coin.State {
+	case models.CoinStateError:
+		// Coin waits for user to resume or to delete it, bought coins are sold after resume.
+		if !user.Buy || coin.Stopped {
+			return nil, eris
+		}
 
-	getUserWalletResp, err := s.apiRepo.GetUserWalletBalance(ctx, getUserWalletParams, user.ApiKey, user.SecretKey)
+		resumed := models.CoinStateIdle
+		if coin.Count > 0 {
+			resumed = models.CoinStateWaitingExit
+		}
+		err = s.transition(ctx, &coin, resumed, "coin is resumed after error")
+		if err != nil {
+			slog.ErrorContext(ctx, "Error resuming coin", "err", err)
+			_, eris.File, eris.Line, _ = runtime.Caller(0)
+			return err, eris
+		}
+	case models.CoinStateLiquidating:
+		// Deleted coin waits for user to delete it again, stopped one is sold below.
+		if !coin.Stopped {
+			return nil, eris
+		}
+	case models.CoinStatePaused:
+		// Coin which has been stopped by protection waits for user to resume buying.
+		if !user.Buy || coin.Stopped {
+			return nil, eris
+		}
+
+		err = s.transition(ctx, &coin, models.CoinStateIdle, "buying is resumed")
+		if err != nil {
+			slog.ErrorContext(ctx, "Error resuming coin", "err", err)
+			_, eris.File, eris.Line, _ = runtime.Caller(0)
+			return err, eris
+		}
+	}
+
+	strat, err := strategy.Get(coin.Strategy)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting user wallet balance", err)
+		slog.ErrorContext(ctx, "Error getting coin's strategy", "err", err)
 		_, eris.File, eris.Line, _ = runtime.Caller(0)
 		return err, eris
 	}
 
-	userUSDTBalance, err := strconv.ParseFloat(getUserWalletResp.Result.List[0].TotalEquity, 64)
+	// Getting user's wallet balance, it is kept up to date by wallet stream.
+	userUSDTBalance, err := s.getBalance(ctx, user)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error converting user USDT wallet balance to float", err)
+		slog.ErrorContext(ctx, "Error getting user wallet balance", "err", err)
 		_, eris.File, eris.Line, _ = runtime.Caller(0)
 		return err, eris
 	}
 
 	user.USDTBalance = userUSDTBalance
 
+	err = s.updatePeakEquity(ctx, &user)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating peak equity", "err", err)
+		_, eris.File, eris.Line, _ = runtime.Caller(0)
+		return err, eris
+	}
+
 	list, err := s.sStorageRepo.GetCoinList(ctx, user.Id)
 	if err != nil {
-		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in Algorithm.GetCoinList", err)
+		slog.ErrorContext(logging.ErrorCtx(ctx, err), "error in Algorithm.GetCoinList", "err", err)
 	}
 
 	var userSum float64
 	for i := 0; i < len(list); i++ {
-		var coinSum float64
-		for d := 0; d < len(list[i].Buy); d++ {
-			coinSum += list[i].Buy[d]
-		}
-
-		if len(list[i].Buy) != 0 {
-			avg := coinSum / float64(len(list[i].Buy))
-
-			userSum += list[i].Count * avg
-		}
+		userSum += list[i].Cost
 	}
 
-	if userSum > userUSDTBalance*0.95 {
+	if userSum > userUSDTBalance*models.DefaultMaxAllocation {
 		candik = false
 	}
-	// Getting current price of coin from api.
-	getCoinReqParams := make(models.GetCoinRequest)
-	getCoinReqParams["category"] = "spot"
-	getCoinReqParams["symbol"] = coin.Name
 
-	getCoinResp, err := s.apiRepo.GetCoin(ctx, getCoinReqParams, user.ApiKey, user.SecretKey)
+	// Getting coin's settings, zero ones are inherited from user.
+	settings, err := s.sStorageRepo.GetCoinSettings(ctx, user.Id, coin.Name)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting coin from algorithm", err)
+		slog.ErrorContext(ctx, "Error getting coin settings", "err", err)
 		_, eris.File, eris.Line, _ = runtime.Caller(0)
 		return err, eris
 	}
+	settings = settings.WithDefaults(user)
 
-	currentPrice, err := strconv.ParseFloat(getCoinResp.Result.List[0].Price, 64)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error parsing current price to float", err)
-		_, eris.File, eris.Line, _ = runtime.Caller(0)
-		return err, eris
+	// Coin can't take more than its allocation of balance.
+	if coin.Cost > userUSDTBalance*settings.MaxAllocation {
+		candik = false
 	}
 
 	// Getting coiniks from storage.
-	coiniks, err := s.sStorageRepo.GetCoiniks(ctx, coin.Name)
+	coiniks, err := s.sStorageRepo.GetCoiniks(ctx, user.Credentials().Exchange, coin.Name)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting coiniks", err)
+		slog.ErrorContext(ctx, "Error getting coiniks", "err", err)
 		_, eris.File, eris.Line, _ = runtime.Caller(0)
 		return err, eris
 	}
 
-	if coin.Count > 0 {
-		if coin.SellOrderId == "" {
-			var sum float64
-			for i := 0; i < len(coin.Buy); i++ {
-				sum += coin.Buy[i]
-			}
-			avg := sum / float64(len(coin.Buy))
-
-			createReq := models.CreateOrderRequest{
-				Category:    "spot",
-				Side:        "Sell",
-				Symbol:      coin.Name,
-				OrderType:   "Limit",
-				Qty:         fmt.Sprintf("%."+strconv.Itoa(coiniks.QtyDecimals)+"f", coin.Count),
-				Price:       fmt.Sprintf("%."+strconv.Itoa(coiniks.PriceDecimals)+"f", avg*(1+user.Percent)),
-				TimeInForce: "GTC",
-			}
-
-			createOrderResp, err := s.apiRepo.CreateOrder(ctx, createReq, user.ApiKey, user.SecretKey)
-			if err != nil {
-				slog.ErrorContext(ctx, "Error creating order", err)
-				return err, eris
-			}
-
-			updateCoin := models.NewCoin(user.Id, coin.Name)
-			updateCoin.SellOrderId = createOrderResp.Result.OrderID
-
-			err = s.sStorageRepo.UpdateCoin(ctx, updateCoin)
-			if err != nil {
-				slog.ErrorContext(ctx, "Error updating coin", err)
-				return err, eris
-			}
-		}
+	// Position is sold at market before strategy places anything new.
+	reason := stopReason(user, coin, currentPrice)
+	if reason == "" && coin.State == models.CoinStateLiquidating {
+		// Sell of stopped coin has failed before, it is sold even if price has come back.
+		reason = models.StopReasonStopLoss
 	}
-
-	if !user.Buy {
-		if coin.Count == 0 {
-			if coin.BuyOrderId != "" {
-				cancelReq := models.CancelOrderRequest{
-					Category: "spot",
-					OrderId:  coin.BuyOrderId,
-					Symbol:   coin.Name,
-				}
-
-				_, err = s.apiRepo.CancelOrder(ctx, cancelReq, user.ApiKey, user.SecretKey)
-				if err != nil {
-					slog.ErrorContext(ctx, "Error canceling order", err)
-					_, eris.File, eris.Line, _ = runtime.Caller(0)
-					return err, eris
-				}
-			}
-			err = s.DeleteCoin(ctx, user.Id, coin.Name)
-			if err != nil {
-				slog.ErrorContext(ctx, "Error delete coin", err)
-				_, eris.File, eris.Line, _ = runtime.Caller(0)
-				return err, eris
-			}
-		}
-	}
-
-	// If price becomes higher than entry price and amount of coin equals 0 we should raise entryPrice.
-	if currentPrice > coin.EntryPrice && coin.Count == 0 {
-		slog.DebugContext(ctx, "Raising entry price")
-
-		err = s.HandleRaisingEntryPrice(ctx, currentPrice, coin, user, coiniks, candik)
+	if reason != "" {
+		err = s.stop(ctx, user, coin, reason, actionChanMap)
 		if err != nil {
-			slog.ErrorContext(ctx, "Error handling entry price", err)
+			slog.ErrorContext(ctx, "Error stopping coin", "err", err, "reason", reason)
 			_, eris.File, eris.Line, _ = runtime.Caller(0)
 			return err, eris
 		}
 		return nil, eris
 	}
 
-	if coin.BuyOrderId != "" {
-		// Checking if BUY ORDER has been fulfilled.
-		getReq := make(models.GetOrderRequest)
-		getReq["category"] = "spot"
-		getReq["orderId"] = coin.BuyOrderId // <- BUY ORDER ID!
-		getReq["symbol"] = coin.Name
-
-		getOrderResp, err := s.apiRepo.GetOrder(ctx, getReq, user.ApiKey, user.SecretKey)
-		if getOrderResp.Result.List == nil {
-			fmt.Println("у меня сдохла мать бубубубуб\n\n\n\n\n\n\n\n\n я даун")
-		}
+	// Grid levels are loaded only for strategies which keep orders in them.
+	var levels []models.GridLevel
+	if _, ok := strat.(strategy.Leveled); ok {
+		levels, err = s.sStorageRepo.GetGridLevels(ctx, user.Id, coin.Name)
 		if err != nil {
-			slog.ErrorContext(ctx, "Error getting order", err)
+			slog.ErrorContext(ctx, "Error getting grid levels", "err", err)
 			_, eris.File, eris.Line, _ = runtime.Caller(0)
 			return err, eris
 		}
-		ctx = logging.WithOrderId(ctx, getOrderResp.Result.List[0].OrderId)
+	}
 
-		// If that buy order has no errors and order status is filled.
-		if len(getOrderResp.Result.List) > 0 && getOrderResp.Result.List[0].OrderStatus == SuccessfulOrderStatus && getOrderResp.Result.List[0].Side == "Buy" {
-			slog.DebugContext(ctx, "fulfilled BUY ORDER was found", "resp", getOrderResp.Result.List[0])
+	event := strategy.Event{Price: currentPrice}
 
-			err = s.HandleFilledBuyOrder(ctx, getOrderResp, coin, user, coiniks, actionChanMap, candik)
-			if err != nil {
-				slog.ErrorContext(ctx, "Error handling filled buy order", err)
-				_, eris.File, eris.Line, _ = runtime.Caller(0)
-				return err, eris
-			}
-			return nil, eris
+	// Orders are checked only when something has happened with them.
+	if checkOrders {
+		event.Filled, err = s.filledOrders(ctx, &coin, levels, user, coiniks)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error getting order", "err", err)
+			_, eris.File, eris.Line, _ = runtime.Caller(0)
+			return err, eris
 		}
 	}
 
-	// If SELL ORDER does not exist then skip.
-	if coin.SellOrderId == "" {
-		return nil, eris
+	decision, err := strat.Handle(ctx, strategy.Input{
+		Event:    event,
+		User:     user,
+		Coin:     coin,
+		Coiniks:  coiniks,
+		Levels:   levels,
+		Settings: settings,
+		CanBuy:   candik,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error handling coin by strategy", "err", err, "strategy", strat.Name())
+		_, eris.File, eris.Line, _ = runtime.Caller(0)
+		return err, eris
 	}
 
-	// Checking if SELL ORDER has been fulfilled.
-	getReq := make(models.GetOrderRequest)
-	getReq["category"] = "spot"
-	getReq["orderId"] = coin.SellOrderId // <- SELL ORDER ID!
-	getReq["symbol"] = coin.Name
-
-	getOrderResp, err := s.apiRepo.GetOrder(ctx, getReq, user.ApiKey, user.SecretKey)
+	err = s.execute(ctx, coin, levels, user, coiniks, event.Filled, decision, actionChanMap)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting order", err)
+		slog.ErrorContext(ctx, "Error executing strategy's decision", "err", err, "strategy", strat.Name())
 		_, eris.File, eris.Line, _ = runtime.Caller(0)
 		return err, eris
 	}
-	ctx = logging.WithOrderId(ctx, getOrderResp.Result.List[0].OrderId)
 
-	// If that sell order has no errors and order status is filled.
-	if len(getOrderResp.Result.List) > 0 {
-		if getOrderResp.Result.List[0].OrderStatus == SuccessfulOrderStatus && getOrderResp.Result.List[0].Side == "Sell" {
-			slog.DebugContext(ctx, "fulfilled SELL ORDER was found")
-
-			err = s.HandleFilledSellOrder(ctx, getOrderResp, coin, user, coiniks, actionChanMap)
-			if err != nil {
-				slog.ErrorContext(ctx, "Error handling filled sell order", err)
-				_, eris.File, eris.Line, _ = runtime.Caller(0)
-				return err, eris
-			}
-		}
-	}
 	return nil, eris
 }
 
-func (s *Service) HandleRaisingEntryPrice(ctx context.Context, currentPrice float64, coin models.Coin, user models.User, coiniks models.Coiniks, candik bool) error {
-	coin.EntryPrice = currentPrice
-
-	err := s.sStorageRepo.ResetCoin(ctx, coin, user)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error update coin", err)
-		return err
-	}
-
-	resetedCoin, err := s.sStorageRepo.GetCoin(ctx, user.Id, coin.Name)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error getting reseted coin", err)
-		return err
+// filledOrders returns coin's orders which have been filled, buy order is checked first.
+func (s *Service) filledOrders(ctx context.Context, coin *models.Coin, levels []models.GridLevel, user models.User, coiniks models.Coiniks) ([]models.OrderInfo, error) {
+	filled := make([]models.OrderInfo, 0)
+	slots := []struct {
+		orderId string
+		side    string
+	}{
+		{coin.BuyOrderId, "Buy"},
+		{coin.SellOrderId, "Sell"},
 	}
 
-	// Canceling buy order.
-	if resetedCoin.BuyOrderId != "" {
-		cancelReq := models.CancelOrderRequest{
-			Category: "spot",
-			OrderId:  resetedCoin.BuyOrderId,
-			Symbol:   resetedCoin.Name,
+	for _, slot := range slots {
+		if slot.orderId == "" {
+			continue
 		}
 
-		_, err = s.apiRepo.CancelOrder(ctx, cancelReq, user.ApiKey, user.SecretKey)
+		order, err := s.getOrder(ctx, coin, user, slot.orderId)
 		if err != nil {
-			slog.ErrorContext(ctx, "Error canceling order", err)
-			return err
-		}
-	}
-
-	// Creating new buy order.
-	if candik {
-		createReq := models.CreateOrderRequest{
-			Category:    "spot",
-			Side:        "Buy",
-			Symbol:      coin.Name,
-			OrderType:   "Limit",
-			Qty:         fmt.Sprintf("%."+strconv.Itoa(coiniks.QtyDecimals)+"f", user.USDTBalance*0.015/currentPrice),
-			MarketUint:  "baseCoin",
-			PositionIdx: 0,
-			Price:       fmt.Sprintf("%."+strconv.Itoa(coiniks.PriceDecimals)+"f", coin.EntryPrice-resetedCoin.Decrement),
-			TimeInForce: "GTC",
+			return nil, err
 		}
 
-		createOrderResp, err := s.apiRepo.CreateOrder(ctx, createReq, user.ApiKey, user.SecretKey)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error creating order", err)
-			return err
-		}
-		ctx = logging.WithOrderId(ctx, createOrderResp.Result.OrderID)
-
-		updateCoin := models.NewCoin(user.Id, coin.Name)
-		updateCoin.BuyOrderId = createOrderResp.Result.OrderID
-
-		err = s.sStorageRepo.UpdateCoin(ctx, updateCoin)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error updating coin", err)
-			return err
+		if executed(order) && order.Side == slot.side {
+			slog.DebugContext(logging.WithOrderId(ctx, order.OrderId), "filled order was found", "side", order.Side, "status", order.OrderStatus)
+			filled = append(filled, order)
 		}
 	}
 
-	return nil
-}
-
-func (s *Service) HandleFilledBuyOrder(ctx context.Context, getOrderResp models.GetOrderResponse, coin models.Coin, user models.User, coiniks models.Coiniks, actionChanMap map[int64]chan models.Message, candik bool) error {
-	price, err := strconv.ParseFloat(getOrderResp.Result.List[0].Price, 64)
+	levelsFilled, err := s.filledLevelOrders(ctx, coin, levels, user, coiniks)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error parsing price to float", err)
-		return err
+		return nil, err
 	}
 
-	coin.Buy = append(coin.Buy, price)
-
-	count, err := strconv.ParseFloat(getOrderResp.Result.List[0].Qty, 64)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error parsing count to float", err)
-		return err
-	}
+	return append(filled, levelsFilled...), nil
+}
 
-	fee, err := strconv.ParseFloat(getOrderResp.Result.List[0].CumExecFee, 64)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error parsing fee to float", err)
-		return err
+// filledLevelOrders returns filled orders of grid levels. Open orders are requested by one
+// request, only orders which are not open are requested by id. Level of canceled or unknown
+// order is stored without order, so strategy places it again. Executed part of canceled order
+// is credited to position before.
+func (s *Service) filledLevelOrders(ctx context.Context, coin *models.Coin, levels []models.GridLevel, user models.User, coiniks models.Coiniks) ([]models.OrderInfo, error) {
+	filled := make([]models.OrderInfo, 0)
+	if !slices.ContainsFunc(levels, func(level models.GridLevel) bool { return level.OrderId != "" }) {
+		return filled, nil
 	}
 
-	count -= fee
-
-	dotIdx := strings.Index(fmt.Sprintf("%f", count), ".")
-
-	count, err = strconv.ParseFloat(fmt.Sprintf("%f", count)[:dotIdx+1+coiniks.QtyDecimals], 64)
+	openOrders, err := s.getOrders(ctx, user, models.OrderQuery{Symbol: coin.Name, Limit: strategy.MaxGridLevels})
 	if err != nil {
-		slog.ErrorContext(ctx, "Error parsing count to float", err)
-		return err
+		return nil, err
 	}
 
-	coin.Count += count
-
-	err = s.sStorageRepo.UpdateCoin(ctx, coin)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error updating coin", err)
-		return err
+	open := make(map[string]bool, len(openOrders))
+	for _, order := range openOrders {
+		open[order.OrderId] = true
 	}
 
-	if candik {
-		// Creating new buy order.
-		createReq := models.CreateOrderRequest{
-			Category:    "spot",
-			Side:        "Buy",
-			Symbol:      coin.Name,
-			OrderType:   "Limit",
-			Qty:         fmt.Sprintf("%."+strconv.Itoa(coiniks.QtyDecimals)+"f", coin.Count/float64(len(coin.Buy))),
-			Price:       fmt.Sprintf("%."+strconv.Itoa(coiniks.PriceDecimals)+"f", price-coin.Decrement),
-			TimeInForce: "GTC",
+	for i, level := range levels {
+		if level.OrderId == "" || open[level.OrderId] {
+			continue
 		}
 
-		createOrderResp, err := s.apiRepo.CreateOrder(ctx, createReq, user.ApiKey, user.SecretKey)
+		orderCtx := logging.WithOrderId(ctx, level.OrderId)
+
+		orders, err := s.getOrders(orderCtx, user, models.OrderQuery{Symbol: coin.Name, OrderId: level.OrderId})
 		if err != nil {
-			slog.ErrorContext(ctx, "Error creating order", err)
-			return err
-		}
+			return nil, err
+		}
+
+		if len(orders) != 0 {
+			order := orders[0]
+			switch order.OrderStatus {
+			case SuccessfulOrderStatus:
+				slog.DebugContext(orderCtx, "filled order of grid level was found", "side", order.Side, "level", level.Level)
+				filled = append(filled, order)
+				continue
+			case models.OrderStatusNew, models.OrderStatusPartiallyFilled, models.OrderStatusUntriggered:
+				continue
+			}
 
-		updateCoin := models.NewCoin(user.Id, coin.Name)
-		if createOrderResp.Result.OrderID == "" {
-			return nil
-		}
-		updateCoin.BuyOrderId = createOrderResp.Result.OrderID
+			if executed(order) {
+				err = s.creditLevel(orderCtx, coin, user, coiniks, &levels[i], order)
+				if err != nil {
+					return nil, err
+				}
 
-		err = s.sStorageRepo.UpdateCoin(ctx, updateCoin)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error updating coin", err)
-			return err
+				err = s.sStorageRepo.SavePosition(ctx, *coin)
+				if err != nil {
+					return nil, fmt.Errorf("save credited position failed: %w", err)
+				}
+			}
 		}
-	}
 
-	// Canceling old sell order, if it exists.
-	if coin.SellOrderId != "" {
-		cancelReq := models.CancelOrderRequest{
-			Category: "spot",
-			OrderId:  coin.SellOrderId,
-			Symbol:   coin.Name,
-		}
+		slog.WarnContext(orderCtx, "Order of grid level is closed without full fill", "level", level.Level)
 
-		_, err = s.apiRepo.CancelOrder(ctx, cancelReq, user.ApiKey, user.SecretKey)
+		levels[i].OrderId = ""
+		err = s.sStorageRepo.SaveGridLevel(ctx, user.Id, coin.Name, levels[i])
 		if err != nil {
-			slog.ErrorContext(ctx, "Error canceling order", err)
-			return err
+			return nil, fmt.Errorf("save grid level failed: %w", err)
 		}
 	}
 
-	// Creating new sell order.
-	var sum float64
-	for i := 0; i < len(coin.Buy); i++ {
-		sum += coin.Buy[i]
-	}
-	avg := sum / float64(len(coin.Buy))
-
-	createReq := models.CreateOrderRequest{
-		Category:    "spot",
-		Side:        "Sell",
-		Symbol:      coin.Name,
-		OrderType:   "Limit",
-		Qty:         fmt.Sprintf("%."+strconv.Itoa(coiniks.QtyDecimals)+"f", coin.Count),
-		Price:       fmt.Sprintf("%."+strconv.Itoa(coiniks.PriceDecimals)+"f", avg*(1+user.Percent)),
-		TimeInForce: "GTC",
-	}
-
-	createOrderResp, err := s.apiRepo.CreateOrder(ctx, createReq, user.ApiKey, user.SecretKey)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error creating order", err)
-		return err
-	}
-
-	updateCoin := models.NewCoin(user.Id, coin.Name)
-	updateCoin.SellOrderId = createOrderResp.Result.OrderID
-
-	err = s.sStorageRepo.UpdateCoin(ctx, updateCoin)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error updating coin", err)
-		return err
-	}
-
-	// Sending message for goroutine from handler to notify user about buy
-	msg := models.Message{
-		User:   user,
-		Coin:   coin,
-		Action: bot.BuyAction,
-	}
-
-	actionChanMap[user.Id] <- msg
-
-	return nil
+	return filled, nil
 }
 
-func (s *Service) HandleFilledSellOrder(ctx context.Context, getOrderResp models.GetOrderResponse, coin models.Coin, user models.User, coiniks models.Coiniks, actionChanMap map[int64]chan models.Message) error {
-	sellPrice, err := strconv.ParseFloat(getOrderResp.Result.List[0].Price, 64)
+// getOrder requests coin's order from api, if exchange does not know that order coin
+// is moved to error state, because it's ladder can not be continued.
+func (s *Service) getOrder(ctx context.Context, coin *models.Coin, user models.User, orderId string) (models.OrderInfo, error) {
+	orders, err := s.getOrders(ctx, user, models.OrderQuery{Symbol: coin.Name, OrderId: orderId})
 	if err != nil {
-		slog.ErrorContext(ctx, "Error parsing price to float", err)
-		return err
+		return models.OrderInfo{}, err
 	}
 
-	err = s.sStorageRepo.SellCoin(user.Id, coin.Name, sellPrice)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error updating coin", err)
-		return err
-	}
-
-	// Canceling old buy order.
-	cancelReq := models.CancelOrderRequest{
-		Category: "spot",
-		OrderId:  coin.BuyOrderId,
-		Symbol:   coin.Name,
-	}
+	if len(orders) == 0 {
+		err = fmt.Errorf("order %s is not found", orderId)
 
-	_, err = s.apiRepo.CancelOrder(ctx, cancelReq, user.ApiKey, user.SecretKey)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error canceling order", err)
-	}
-
-	updateCoin := models.NewCoin(user.Id, coin.Name)
-	updateCoin.EntryPrice = sellPrice
-
-	err = s.sStorageRepo.UpdateCoin(ctx, updateCoin)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error updating coin", err)
-		return err
-	}
-
-	resetedCoin, err := s.sStorageRepo.GetCoin(ctx, user.Id, coin.Name)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error getting reseted coin", err)
-		return err
-	}
-
-	if user.Buy {
-		// Creating new buy order.
-		createReq := models.CreateOrderRequest{
-			Category:    "spot",
-			Side:        "Buy",
-			Symbol:      coin.Name,
-			OrderType:   "Limit",
-			Qty:         fmt.Sprintf("%."+strconv.Itoa(coiniks.QtyDecimals)+"f", user.USDTBalance*0.015/sellPrice),
-			MarketUint:  "baseCoin",
-			PositionIdx: 0,
-			Price:       fmt.Sprintf("%."+strconv.Itoa(coiniks.PriceDecimals)+"f", resetedCoin.EntryPrice-resetedCoin.Decrement),
-			TimeInForce: "GTC",
-		}
-
-		createOrderResp, err := s.apiRepo.CreateOrder(ctx, createReq, user.ApiKey, user.SecretKey)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error creating order", err)
-			return err
-		}
-
-		updateCoin = models.NewCoin(user.Id, coin.Name)
-		updateCoin.BuyOrderId = createOrderResp.Result.OrderID
-		updateCoin.SellOrderId = "setNull"
-
-		err = s.sStorageRepo.UpdateCoin(ctx, updateCoin)
-		if err != nil {
-			slog.ErrorContext(ctx, "Error updating coin", err)
-			return err
+		tErr := s.stopOnError(ctx, coin, err.Error())
+		if tErr != nil {
+			slog.ErrorContext(ctx, "Error moving coin to error state", "err", tErr)
 		}
+		return models.OrderInfo{}, err
 	}
 
-	// Sending message for goroutine from handler to notify user about sell
-	msg := models.Message{
-		User:   user,
-		Coin:   coin,
-		Action: bot.SellAction,
-	}
-
-	var sum float64
-	for i := 0; i < len(coin.Buy); i++ {
-		sum += coin.Buy[i]
-	}
-
-	avg := sum / float64(len(coin.Buy))
-
-	income := sellPrice*coin.Count - avg*coin.Count
-
-	err = s.sStorageRepo.InsertIncome(user.Id, coin.Name, income, coin.Count)
-	if err != nil {
-		slog.ErrorContext(ctx, "Error inserting income", err)
-		return err
-	}
-
-	coin.Count = 0
-
-	msg.Coin.CurrentPrice = sellPrice
-	msg.Coin.Income = income
-
-	if !user.Buy {
-		err = s.DeleteCoin(ctx, user.Id, coin.Name)
-		return err
-	}
-
-	actionChanMap[user.Id] <- msg
-
-	return nil
+	return orders[0], nil
 }
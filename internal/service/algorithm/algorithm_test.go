@@ -0,0 +1,1023 @@
+package algorithm
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"m1pes/internal/config"
+	"m1pes/internal/delivery/telegram/bot"
+	"m1pes/internal/models"
+	"m1pes/internal/repository/api/stocks/bybit"
+	"m1pes/internal/repository/api/stocks/bybit/bybittest"
+	"m1pes/internal/repository/api/stocks/sim"
+	"m1pes/internal/repository/storage/memory"
+	"m1pes/internal/service/engine"
+	"m1pes/internal/service/strategy"
+)
+
+const (
+	testUserId = 1
+	testApiKey = "test-key"
+	testSecret = "test-secret"
+	testSymbol = "BTCUSDT"
+)
+
+type testEnv struct {
+	server  *bybittest.Server
+	storage *memory.Repository
+	service *Service
+	actions chan models.Message
+}
+
+func newTestEnv(t *testing.T, balance float64) *testEnv {
+	t.Helper()
+
+	server := bybittest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddAccount(testApiKey, testSecret, balance)
+
+	storage := memory.New()
+	storage.AddCoiniks(models.Coiniks{Name: testSymbol, QtyDecimals: 6, PriceDecimals: 2})
+
+	ctx := context.Background()
+	err := storage.NewUser(ctx, models.User{
+		Id:               testUserId,
+		Percent:          0.01,
+		ApiKey:           testApiKey,
+		SecretKey:        testSecret,
+		TradingActivated: true,
+		Buy:              true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.AddCoin(models.NewCoin(testUserId, testSymbol)); err != nil {
+		t.Fatal(err)
+	}
+
+	client := bybit.New(server.URL)
+	return &testEnv{
+		server:  server,
+		storage: storage,
+		service: New(bybit.NewExchange(client, client), nil, storage, storage, config.EngineConfig{}),
+		actions: make(chan models.Message, 16),
+	}
+}
+
+// step moves price to the next one of path and handles coin as on order event.
+func (e *testEnv) step(t *testing.T) error {
+	t.Helper()
+
+	price, ok := e.server.Step(testSymbol)
+	if !ok {
+		t.Fatal("price path is over")
+	}
+
+	actionChanMap := map[int64]chan models.Message{testUserId: e.actions}
+	err, _ := e.service.HandleCoinUpdate(context.Background(), models.NewCoin(testUserId, testSymbol), testUserId, price, true, actionChanMap)
+	return err
+}
+
+func (e *testEnv) coin(t *testing.T) models.Coin {
+	t.Helper()
+
+	coin, err := e.storage.GetCoin(context.Background(), testUserId, testSymbol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coin
+}
+
+func (e *testEnv) order(t *testing.T, orderId string) models.OrderInfo {
+	t.Helper()
+
+	req := models.GetOrderRequest{"category": "spot", "symbol": testSymbol, "orderId": orderId}
+	resp, err := e.server.Exchange().GetOrder(context.Background(), req, testApiKey, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Result.List) == 0 {
+		t.Fatalf("order %q is not found", orderId)
+	}
+	return resp.Result.List[0]
+}
+
+func TestBuySellCycle(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 99, 100.1)
+
+	// Entry order is placed one step below price.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	if coin := env.coin(t); coin.State != models.CoinStateWaitingEntry || coin.BuyOrderId == "" {
+		t.Fatalf("after entry: state %s, buy order %q", coin.State, coin.BuyOrderId)
+	}
+
+	// Entry order is filled, next buy and sell orders are placed.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	coin := env.coin(t)
+	if coin.State != models.CoinStateAccumulating || coin.SellOrderId == "" {
+		t.Fatalf("after buy: state %s, sell order %q", coin.State, coin.SellOrderId)
+	}
+	if len(coin.Buy) != 1 || coin.Buy[0] != 99 {
+		t.Fatalf("after buy: buys %v", coin.Buy)
+	}
+	// Fee of buy is taken in coin.
+	if want := 0.14985; math.Abs(coin.Count-want) > 1e-9 {
+		t.Fatalf("after buy: count %v, want %v", coin.Count, want)
+	}
+	// So average price is higher than price of buy and sell is a bit more than 1% above it.
+	if want := 99 * 0.15 / 0.14985; math.Abs(coin.AvgPrice()-want) > 1e-9 {
+		t.Fatalf("after buy: average price %v, want %v", coin.AvgPrice(), want)
+	}
+	if sell := env.order(t, coin.SellOrderId); sell.Price != "100.09" {
+		t.Fatalf("after buy: sell price %s", sell.Price)
+	}
+
+	// Sell order is filled, coin waits for new entry.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	coin = env.coin(t)
+	if coin.State != models.CoinStateWaitingEntry || coin.SellOrderId != "" || coin.BuyOrderId == "" {
+		t.Fatalf("after sell: state %s, buy order %q, sell order %q", coin.State, coin.BuyOrderId, coin.SellOrderId)
+	}
+	if coin.EntryPrice != 100.09 {
+		t.Fatalf("after sell: entry price %v", coin.EntryPrice)
+	}
+
+	var actions []string
+	for len(env.actions) > 0 {
+		actions = append(actions, (<-env.actions).Action)
+	}
+	if strings.Join(actions, ",") != bot.BuyAction+","+bot.SellAction {
+		t.Fatalf("actions %v", actions)
+	}
+
+	incomes := env.storage.Incomes()
+	if len(incomes) != 1 {
+		t.Fatalf("incomes %v", incomes)
+	}
+	if want := 100.09*0.14985*(1-sim.DefaultMakerFee) - 99*0.15; math.Abs(incomes[0].Income-want) > 1e-9 {
+		t.Fatalf("income %v, want %v", incomes[0].Income, want)
+	}
+
+	fills := env.storage.Fills()
+	if len(fills) != 2 || fills[0].Side != "Buy" || fills[0].FeeCoin != "BTC" || fills[1].Side != "Sell" || fills[1].FeeCoin != models.QuoteCurrency {
+		t.Fatalf("fills %+v", fills)
+	}
+	if fills[0].ExecId == "" || fills[0].ExecId == fills[1].ExecId {
+		t.Fatalf("fills have no exec ids %+v", fills)
+	}
+
+	// The same execution from private stream is not stored twice.
+	env.service.RecordExecutions(testUserId, []models.ExecutionEvent{{
+		Symbol: testSymbol, OrderId: fills[1].OrderId, Side: "Sell", ExecId: fills[1].ExecId,
+		ExecPrice: "100.09", ExecQty: "0.14985", ExecFee: "0", ExecType: "Trade",
+	}})
+	if n := len(env.storage.Fills()); n != 2 {
+		t.Fatalf("fills %d after duplicate execution", n)
+	}
+
+	if balance := env.server.Exchange().Balance(testApiKey, sim.QuoteCoin); balance <= 1000 {
+		t.Fatalf("balance %v has not grown", balance)
+	}
+
+	// Entry order has been polled until it was filled, status is written once.
+	var statuses []string
+	for _, update := range env.storage.OrderHistory() {
+		if update.OrderId == fills[0].OrderId {
+			statuses = append(statuses, update.Action+" "+update.Status)
+		}
+	}
+	if want := []string{"create New", "status Filled"}; !slices.Equal(statuses, want) {
+		t.Fatalf("history of entry order %v, want %v", statuses, want)
+	}
+
+	// Entry, next buy, sell and new entry.
+	if n := env.server.Requests(bybit.CreateOrderEndpoint); n != 4 {
+		t.Fatalf("create order requests %d", n)
+	}
+}
+
+func TestRejectedEntryOrder(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 100)
+	env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{
+		RetCode: bybittest.RetCodeInsufficientBalance,
+		RetMsg:  "Insufficient balance.",
+	})
+
+	err := env.step(t)
+	if err == nil || !strings.Contains(err.Error(), "Insufficient balance") || models.ErrorKindOf(err) != models.ErrorKindInsufficientFunds {
+		t.Fatalf("err %v", err)
+	}
+	// Refused order is not looked up by its orderLinkId.
+	if n := env.server.Requests(bybit.GetOrderEndpoint); n != 0 {
+		t.Fatalf("get order requests %d", n)
+	}
+	if coin := env.coin(t); coin.State != models.CoinStateIdle || coin.BuyOrderId != "" {
+		t.Fatalf("after rejected entry: state %s, buy order %q", coin.State, coin.BuyOrderId)
+	}
+
+	// Entry price has been raised already, so entry order is placed again on lower price.
+	env.server.SetPricePath(testSymbol, 101)
+	if err = env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	if coin := env.coin(t); coin.State != models.CoinStateWaitingEntry || coin.BuyOrderId == "" {
+		t.Fatalf("after retry: state %s, buy order %q", coin.State, coin.BuyOrderId)
+	}
+
+	orders := env.storage.Orders()
+	if len(orders) != 2 || orders[0].Status != models.OrderStatusRejected || !strings.Contains(orders[0].RejectReason, "Insufficient balance") ||
+		orders[1].Status != models.OrderStatusNew || orders[1].OrderId != env.coin(t).BuyOrderId || orders[1].Request == "" {
+		t.Fatalf("orders %+v", orders)
+	}
+}
+
+func TestPartialFills(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 101, 100.05, 100.05)
+	exchange := env.server.Exchange()
+
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entry order is partially filled and then canceled by raised entry price, its executed
+	// part is credited to position.
+	if err := exchange.FillPartially(testApiKey, env.coin(t).BuyOrderId, 0.05); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	coin := env.coin(t)
+	if want := 0.05 - 0.05*sim.DefaultMakerFee; math.Abs(coin.Count-want) > 1e-9 || len(coin.Buy) != 1 || coin.Buy[0] != 99 {
+		t.Fatalf("after canceled entry: count %v, want %v, buys %v", coin.Count, want, coin.Buy)
+	}
+	if coin.State != models.CoinStateAccumulating || coin.BuyOrderId == "" {
+		t.Fatalf("after canceled entry: state %s, buy order %q", coin.State, coin.BuyOrderId)
+	}
+
+	// Sell order is placed for credited position.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	coin = env.coin(t)
+	if sell := env.order(t, coin.SellOrderId); sell.Qty != strconv.FormatFloat(coin.Count, 'f', -1, 64) {
+		t.Fatalf("sell qty %s, count %v", sell.Qty, coin.Count)
+	}
+
+	// Sell order is canceled on exchange after partial fill, the rest is sold by new order.
+	if err := exchange.FillPartially(testApiKey, coin.SellOrderId, 0.02); err != nil {
+		t.Fatal(err)
+	}
+	cancelReq := models.CancelOrderRequest{Category: "spot", Symbol: testSymbol, OrderId: coin.SellOrderId}
+	if _, err := exchange.CancelOrder(context.Background(), cancelReq, testApiKey, testSecret); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	rest := env.coin(t)
+	if want := coin.Count - 0.02; math.Abs(rest.Count-want) > 1e-9 || rest.SellOrderId == "" || rest.SellOrderId == coin.SellOrderId {
+		t.Fatalf("after partial sell: count %v, want %v, sell order %q", rest.Count, want, rest.SellOrderId)
+	}
+	if sell := env.order(t, rest.SellOrderId); sell.Qty != strconv.FormatFloat(rest.Count, 'f', -1, 64) {
+		t.Fatalf("rest sell qty %s, count %v", sell.Qty, rest.Count)
+	}
+
+	incomes := env.storage.Incomes()
+	if len(incomes) != 1 || incomes[0].Count != 0.02 {
+		t.Fatalf("incomes %+v", incomes)
+	}
+	if fills := env.storage.Fills(); len(fills) != 2 {
+		t.Fatalf("fills %+v", fills)
+	}
+}
+
+func TestLostOrderResponse(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 100.5)
+	seq := env.coin(t).OrderSeq
+
+	// Entry order is placed, but its response is lost, its retry is rejected as duplicate
+	// and it can't be found at once.
+	env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{Status: http.StatusGatewayTimeout, Lost: true})
+	env.server.FailNext(bybit.GetOrderEndpoint, bybittest.Error{RetCode: bybittest.RetCodeParamsError, RetMsg: "Server is busy."})
+	if err := env.step(t); err == nil {
+		t.Fatal("lost entry order is not an error")
+	}
+	if coin := env.coin(t); coin.BuyOrderId != "" || coin.OrderSeq != seq {
+		t.Fatalf("after lost entry: buy order %q, seq %d", coin.BuyOrderId, coin.OrderSeq)
+	}
+
+	// Next try has the same orderLinkId, exchange rejects it and placed order is taken.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+
+	req := models.GetOrderRequest{"category": "spot", "symbol": testSymbol}
+	resp, err := env.server.Exchange().GetOrder(context.Background(), req, testApiKey, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Result.List) != 1 {
+		t.Fatalf("open orders %+v", resp.Result.List)
+	}
+
+	coin := env.coin(t)
+	if coin.BuyOrderId != resp.Result.List[0].OrderId || coin.OrderSeq != seq+1 || coin.State != models.CoinStateWaitingEntry {
+		t.Fatalf("after retry: buy order %q, open %q, seq %d, state %s", coin.BuyOrderId, resp.Result.List[0].OrderId, coin.OrderSeq, coin.State)
+	}
+	if n := env.server.Requests(bybit.CreateOrderEndpoint); n != 3 {
+		t.Fatalf("create order requests %d", n)
+	}
+}
+
+func TestRateLimitRetry(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 100.5)
+
+	// Rejected requests are not handled by exchange, so they are retried even without orderLinkId.
+	env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{RetCode: bybittest.RetCodeRateLimit, RetMsg: "Too many visits!"})
+	env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{RetCode: bybittest.RetCodeTimestampExpired, RetMsg: "invalid request, please check your server timestamp or recv_window param"})
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+
+	coin := env.coin(t)
+	if coin.BuyOrderId == "" || coin.State != models.CoinStateWaitingEntry {
+		t.Fatalf("buy order %q, state %s", coin.BuyOrderId, coin.State)
+	}
+	if n := env.server.Requests(bybit.CreateOrderEndpoint); n != 3 {
+		t.Fatalf("create order requests %d", n)
+	}
+
+	// Order which could be handled is not retried without orderLinkId.
+	env.server.FailNext(bybit.CancelOrderEndpoint, bybittest.Error{Status: http.StatusBadGateway})
+	cancelReq := models.CancelOrderRequest{Category: "spot", Symbol: testSymbol, OrderId: coin.BuyOrderId}
+	if _, err := bybit.New(env.server.URL).CancelOrder(context.Background(), cancelReq, testApiKey, testSecret); err == nil {
+		t.Fatal("failed cancel is not an error")
+	}
+	if n := env.server.Requests(bybit.CancelOrderEndpoint); n != 1 {
+		t.Fatalf("cancel order requests %d", n)
+	}
+}
+
+func TestErrorReports(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 101, 102, 103, 104)
+
+	actionChanMap := map[int64]chan models.Message{testUserId: env.actions}
+	handle := env.service.coinHandler(models.NewCoin(testUserId, testSymbol), actionChanMap)
+	handleNext := func() error {
+		price, _ := env.server.Step(testSymbol)
+		return handle(context.Background(), engine.Key{UserId: testUserId, Coin: testSymbol}, engine.Event{Price: price, CheckOrders: true})
+	}
+	reports := func() []string {
+		var actions []string
+		for {
+			select {
+			case msg := <-env.actions:
+				actions = append(actions, msg.Action)
+			default:
+				return actions
+			}
+		}
+	}
+
+	// Error which user has to fix is sent to user once, not to chat with errors.
+	for i := 0; i < 2; i++ {
+		env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{RetCode: bybittest.RetCodeInsufficientBalance, RetMsg: "Insufficient balance."})
+		if err := handleNext(); err == nil {
+			t.Fatal("rejected entry is not an error")
+		}
+	}
+	env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{RetCode: bybittest.RetCodeInvalidApiKey, RetMsg: "API key is invalid."})
+	if err := handleNext(); err == nil {
+		t.Fatal("invalid api key is not an error")
+	}
+	if actions := reports(); !slices.Equal(actions, []string{bot.BalanceAction, bot.ApiKeyAction}) {
+		t.Fatalf("reports %v", actions)
+	}
+
+	// Temporary error is only logged, even when retries are over.
+	for i := 0; i < 3; i++ {
+		env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{RetCode: bybittest.RetCodeServerError, RetMsg: "Internal server error."})
+	}
+	if err := handleNext(); err == nil {
+		t.Fatal("server error is not an error")
+	}
+	if actions := reports(); len(actions) != 0 {
+		t.Fatalf("reports %v", actions)
+	}
+
+	if err := handleNext(); err != nil {
+		t.Fatal(err)
+	}
+	if coin := env.coin(t); coin.BuyOrderId == "" {
+		t.Fatal("entry order is not placed")
+	}
+}
+
+func TestInstrumentSteps(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.storage.AddCoiniks(models.Coiniks{Name: testSymbol, QtyDecimals: 6, PriceDecimals: 2, BasePrecision: 0.001, TickSize: 0.1})
+	env.server.SetPricePath(testSymbol, 100.37)
+
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+
+	// Order is rounded to instrument's steps, not to decimals.
+	buy := env.order(t, env.coin(t).BuyOrderId)
+	price, _ := strconv.ParseFloat(buy.Price, 64)
+	qty, _ := strconv.ParseFloat(buy.Qty, 64)
+	if steps := price / 0.1; math.Abs(steps-math.Round(steps)) > 1e-9 {
+		t.Fatalf("price %s is not rounded to tick", buy.Price)
+	}
+	if steps := qty / 0.001; math.Abs(steps-math.Round(steps)) > 1e-9 || qty == 0 {
+		t.Fatalf("qty %s is not cut to step", buy.Qty)
+	}
+}
+
+// TestIncomeOfFailedDecision checks that buy which has been filled reaches user even if order
+// after it can't be placed.
+func TestIncomeOfFailedDecision(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 99)
+
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+
+	// Optional next buy and sell after it are refused.
+	for i := 0; i < 2; i++ {
+		env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{
+			RetCode: bybittest.RetCodeInsufficientBalance,
+			RetMsg:  "Insufficient balance.",
+		})
+	}
+	if err := env.step(t); err == nil {
+		t.Fatal("failed sell is not returned")
+	}
+
+	if coin := env.coin(t); len(coin.Buy) != 1 || coin.Count == 0 {
+		t.Fatalf("after buy: buys %v, count %v", coin.Buy, coin.Count)
+	}
+	if len(env.actions) != 1 || (<-env.actions).Action != bot.BuyAction {
+		t.Fatal("user is not notified about buy")
+	}
+}
+
+// TestReconcileKeepsUserOrders checks that reconciliation cancels only bot's orders which coin
+// does not know about.
+func TestReconcileKeepsUserOrders(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100)
+	if _, ok := env.server.Step(testSymbol); !ok {
+		t.Fatal("price path is over")
+	}
+
+	ctx := context.Background()
+	place := func(linkId string) string {
+		resp, err := env.server.Exchange().CreateOrder(ctx, models.CreateOrderRequest{
+			Category: "spot", Side: "Buy", Symbol: testSymbol, OrderType: "Limit",
+			Qty: "0.1", Price: "90", TimeInForce: "GTC", OrderLinkId: linkId,
+		}, testApiKey, testSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Result.OrderID
+	}
+
+	coin := env.coin(t)
+	manual := place("")
+	stray := place(orderLinkId(coin, linkTagLevel+"1"))
+
+	if _, err := env.service.Reconcile(ctx, testUserId); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := env.order(t, manual).OrderStatus; status != models.OrderStatusNew {
+		t.Fatalf("user's order has status %s", status)
+	}
+	if status := env.order(t, stray).OrderStatus; status != models.OrderStatusCancelled {
+		t.Fatalf("bot's stray order has status %s", status)
+	}
+}
+
+// TestGridPartialCancel checks that executed part of canceled grid order reaches position and
+// level sells only the rest of its coins.
+func TestGridPartialCancel(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 100, 100)
+
+	params := []byte(`{"lower":90,"upper":110,"levels":5,"investment":400}`)
+	if err := env.storage.SetCoinStrategy(context.Background(), testUserId, testSymbol, strategy.GridName, params); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := env.step(t); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := env.coin(t)
+	sell := env.levels(t)[3]
+	if sell.Side != "Sell" || sell.OrderId == "" {
+		t.Fatalf("level 3 is %+v", sell)
+	}
+
+	// A third of sell is executed, then order is canceled.
+	qty := strategy.Truncate(sell.Qty/3, 6)
+	if err := env.server.Exchange().FillPartially(testApiKey, sell.OrderId, qty); err != nil {
+		t.Fatal(err)
+	}
+	cancelReq := models.CancelOrderRequest{Category: "spot", Symbol: testSymbol, OrderId: sell.OrderId}
+	if _, err := env.server.Exchange().CancelOrder(context.Background(), cancelReq, testApiKey, testSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+
+	if coin := env.coin(t); math.Abs(before.Count-qty-coin.Count) > 1e-9 {
+		t.Fatalf("count %v, want %v", coin.Count, before.Count-qty)
+	}
+	level := env.levels(t)[3]
+	if level.Side != "Sell" || level.OrderId == "" || level.OrderId == sell.OrderId || math.Abs(level.Qty-(sell.Qty-qty)) > 1e-9 {
+		t.Fatalf("level 3 after cancel is %+v", level)
+	}
+	if order := env.order(t, level.OrderId); order.Qty != strconv.FormatFloat(level.Qty, 'f', -1, 64) {
+		t.Fatalf("new sell qty %s, want %v", order.Qty, level.Qty)
+	}
+	if incomes := env.storage.Incomes(); len(incomes) != 1 || incomes[0].Count != qty {
+		t.Fatalf("incomes %+v", incomes)
+	}
+}
+
+// TestInvalidOrderStopsCoin checks that coin whose order is invalid is stopped and user is
+// told about it instead of chat with errors.
+func TestInvalidOrderStopsCoin(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 101, 102)
+
+	actionChanMap := map[int64]chan models.Message{testUserId: env.actions}
+	handle := env.service.coinHandler(models.NewCoin(testUserId, testSymbol), actionChanMap)
+	key := engine.Key{UserId: testUserId, Coin: testSymbol}
+
+	env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{RetCode: bybittest.RetCodeOrderValueTooLow, RetMsg: "Order value exceeded lower limit."})
+	price, _ := env.server.Step(testSymbol)
+	if err := handle(context.Background(), key, engine.Event{Price: price, CheckOrders: true}); models.ErrorKindOf(err) != models.ErrorKindInvalidOrder {
+		t.Fatalf("err %v", err)
+	}
+
+	if coin := env.coin(t); coin.State != models.CoinStateError {
+		t.Fatalf("state %s", coin.State)
+	}
+	if len(env.actions) != 1 || (<-env.actions).Action != bot.InvalidOrderAction {
+		t.Fatal("user is not told about invalid order")
+	}
+
+	// Stopped coin does not place orders anymore.
+	price, _ = env.server.Step(testSymbol)
+	if err := handle(context.Background(), key, engine.Event{Price: price, CheckOrders: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := env.server.Requests(bybit.CreateOrderEndpoint); n != 1 {
+		t.Fatalf("create order requests %d", n)
+	}
+
+	// User resumes buying by /startBuy, entry is placed again.
+	if err := env.storage.ResumeStopped(context.Background(), testUserId); err != nil {
+		t.Fatal(err)
+	}
+	price, _ = env.server.Step(testSymbol)
+	if err := handle(context.Background(), key, engine.Event{Price: price, CheckOrders: true}); err != nil {
+		t.Fatal(err)
+	}
+	if coin := env.coin(t); coin.State != models.CoinStateWaitingEntry || coin.BuyOrderId == "" {
+		t.Fatalf("after resume: state %s, buy order %q", coin.State, coin.BuyOrderId)
+	}
+	if n := env.server.Requests(bybit.CreateOrderEndpoint); n != 2 {
+		t.Fatalf("create order requests after resume %d", n)
+	}
+}
+
+func TestWrongSecretIsRejected(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPrice(testSymbol, 100)
+
+	getUserWalletParams := make(models.GetUserWalletRequest)
+	getUserWalletParams["accountType"] = "UNIFIED"
+
+	_, err := bybit.New(env.server.URL).GetUserWalletBalance(context.Background(), getUserWalletParams, testApiKey, "wrong-secret")
+	if err == nil || !strings.Contains(err.Error(), "error sign") {
+		t.Fatalf("err %v", err)
+	}
+
+	_, err = bybit.New(env.server.URL).GetUserWalletBalance(context.Background(), getUserWalletParams, testApiKey, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGridCycle(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 100, 95, 100)
+
+	params := []byte(`{"lower":90,"upper":110,"levels":5,"investment":400}`)
+	if err := env.storage.SetCoinStrategy(context.Background(), testUserId, testSymbol, strategy.GridName, params); err != nil {
+		t.Fatal(err)
+	}
+
+	// Coins for sells above price are bought by start order.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	if coin := env.coin(t); coin.State != models.CoinStateWaitingEntry || coin.BuyOrderId == "" {
+		t.Fatalf("after start: state %s, buy order %q", coin.State, coin.BuyOrderId)
+	}
+
+	// Start order is filled, buys are placed below price and sells above it.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	coin := env.coin(t)
+	if coin.State != models.CoinStateAccumulating || coin.BuyOrderId != "" {
+		t.Fatalf("after layout: state %s, buy order %q", coin.State, coin.BuyOrderId)
+	}
+	levels := env.levels(t)
+	if sides := gridSides(levels); sides != "Buy,Buy,,Sell,Sell" {
+		t.Fatalf("after layout: sides %s", sides)
+	}
+	for _, level := range levels {
+		if level.Side != "" && level.OrderId == "" {
+			t.Fatalf("after layout: level %d has no order", level.Level)
+		}
+	}
+
+	// Buy on 95 is filled, its coins are sold on 100.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	if sides := gridSides(env.levels(t)); sides != "Buy,,Sell,Sell,Sell" {
+		t.Fatalf("after buy: sides %s", sides)
+	}
+
+	// Sell on 100 is filled, money is used for buy on 95 again.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	if sides := gridSides(env.levels(t)); sides != "Buy,Buy,,Sell,Sell" {
+		t.Fatalf("after sell: sides %s", sides)
+	}
+
+	incomes := env.storage.Incomes()
+	if len(incomes) != 1 {
+		t.Fatalf("incomes %v", incomes)
+	}
+	// Fee of buy is taken in coin, so a bit less than 100/95 coins is sold, fee of sell is taken in USDT.
+	if want := (100*(1-sim.DefaultMakerFee) - 95) * 100 / 95 * (1 - sim.DefaultMakerFee); math.Abs(incomes[0].Income-want) > 1e-4 {
+		t.Fatalf("income %v, want %v", incomes[0].Income, want)
+	}
+
+	var actions []string
+	for len(env.actions) > 0 {
+		actions = append(actions, (<-env.actions).Action)
+	}
+	if strings.Join(actions, ",") != bot.BuyAction+","+bot.BuyAction+","+bot.SellAction {
+		t.Fatalf("actions %v", actions)
+	}
+
+	// Deleted coin forgets its grid.
+	if err := env.service.DeleteCoin(context.Background(), testUserId, testSymbol); err != nil {
+		t.Fatal(err)
+	}
+	if levels := env.levels(t); len(levels) != 0 {
+		t.Fatalf("after delete: levels %v", levels)
+	}
+	openOrders, err := env.server.Exchange().GetOrder(context.Background(), models.GetOrderRequest{"symbol": testSymbol}, testApiKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(openOrders.Result.List); n != 0 {
+		t.Fatalf("after delete: %d open orders", n)
+	}
+}
+
+func (e *testEnv) levels(t *testing.T) []models.GridLevel {
+	t.Helper()
+
+	levels, err := e.storage.GetGridLevels(context.Background(), testUserId, testSymbol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return levels
+}
+
+func gridSides(levels []models.GridLevel) string {
+	sides := make([]string, 0, len(levels))
+	for _, level := range levels {
+		sides = append(sides, level.Side)
+	}
+	return strings.Join(sides, ",")
+}
+
+func TestStopLoss(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 99, 93, 100)
+
+	if err := env.storage.SetStopLoss(context.Background(), testUserId, testSymbol, 0.05, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entry order is placed and filled.
+	for i := 0; i < 2; i++ {
+		if err := env.step(t); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Price falls more than 5% below average buy, next buy is filled on the way down and is
+	// sold at market too.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	coin := env.coin(t)
+	if coin.State != models.CoinStatePaused || !coin.Stopped || coin.Count != 0 || coin.BuyOrderId != "" || coin.SellOrderId != "" {
+		t.Fatalf("after stop: state %s, stopped %v, count %v, orders %q %q", coin.State, coin.Stopped, coin.Count, coin.BuyOrderId, coin.SellOrderId)
+	}
+	if btc := env.server.Exchange().Balance(testApiKey, "BTC"); btc > 1e-6 {
+		t.Fatalf("after stop: %v BTC is left", btc)
+	}
+
+	incomes := env.storage.Incomes()
+	if len(incomes) != 1 || incomes[0].Income >= 0 {
+		t.Fatalf("incomes %v", incomes)
+	}
+
+	var stop models.Message
+	for len(env.actions) > 0 {
+		if msg := <-env.actions; msg.Action == bot.StopAction {
+			stop = msg
+		}
+	}
+	if stop.Reason != models.StopReasonStopLoss || stop.Coin.Income != incomes[0].Income {
+		t.Fatalf("stop message %+v", stop)
+	}
+
+	// Stopped coin waits for user even if price is back.
+	requests := env.server.Requests(bybit.CreateOrderEndpoint)
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	if n := env.server.Requests(bybit.CreateOrderEndpoint); n != requests {
+		t.Fatalf("stopped coin has placed %d orders", n-requests)
+	}
+}
+
+// TestStopLossSellFails checks that market sell of stopped coin which has failed is tried again
+// on next event, user is told about failure once.
+func TestStopLossSellFails(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 99, 93, 100)
+
+	if err := env.storage.SetStopLoss(context.Background(), testUserId, testSymbol, 0.05, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := env.step(t); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env.server.FailNext(bybit.CreateOrderEndpoint, bybittest.Error{RetCode: bybittest.RetCodeInsufficientBalance, RetMsg: "Insufficient balance."})
+	if err := env.step(t); err == nil {
+		t.Fatal("failed market sell is not an error")
+	}
+	coin := env.coin(t)
+	if coin.State != models.CoinStateLiquidating || !coin.Stopped || coin.Count == 0 {
+		t.Fatalf("after failed sell: state %s, stopped %v, count %v", coin.State, coin.Stopped, coin.Count)
+	}
+
+	failed := 0
+	for len(env.actions) > 0 {
+		if msg := <-env.actions; msg.Action == bot.StopFailedAction {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("user is told about failed sell %d times", failed)
+	}
+
+	// Price is back, but position is sold anyway.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	coin = env.coin(t)
+	if coin.State != models.CoinStatePaused || !coin.Stopped || coin.Count != 0 {
+		t.Fatalf("after retry: state %s, stopped %v, count %v", coin.State, coin.Stopped, coin.Count)
+	}
+	if btc := env.server.Exchange().Balance(testApiKey, "BTC"); btc > 1e-6 {
+		t.Fatalf("after retry: %v BTC is left", btc)
+	}
+
+	var stop models.Message
+	for len(env.actions) > 0 {
+		if msg := <-env.actions; msg.Action == bot.StopAction {
+			stop = msg
+		}
+	}
+	if stop.Coin.Count == 0 {
+		t.Fatalf("stop message %+v", stop)
+	}
+}
+
+func TestMaxDrawdown(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 99, 90)
+
+	if err := env.storage.SetMaxDrawdown(context.Background(), testUserId, 0.002); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := env.step(t); err != nil {
+			t.Fatal(err)
+		}
+		// Equity comes from wallet stream.
+		env.service.UpdateBalance(testUserId, []models.WalletEvent{env.server.Exchange().Wallet(testApiKey)})
+	}
+
+	// Drawdown is noticed on the next event after equity has fallen.
+	env.server.SetPricePath(testSymbol, 90)
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+
+	coin := env.coin(t)
+	if coin.State != models.CoinStatePaused || !coin.Stopped || coin.Count != 0 {
+		t.Fatalf("after drawdown: state %s, stopped %v, count %v", coin.State, coin.Stopped, coin.Count)
+	}
+
+	user, err := env.storage.GetUser(context.Background(), testUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PeakEquity != 1000 {
+		t.Fatalf("peak equity %v", user.PeakEquity)
+	}
+}
+
+func TestTrailingTakeProfit(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 99, 100.5, 103, 101.9, 101.9)
+
+	err := env.storage.SetCoinStrategy(context.Background(), testUserId, testSymbol, strategy.LadderName, []byte(`{"trail_callback":0.01}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entry order is placed and filled, there is no sell order.
+	for i := 0; i < 2; i++ {
+		if err = env.step(t); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if coin := env.coin(t); coin.Count == 0 || coin.SellOrderId != "" || coin.TrailPeak != 0 {
+		t.Fatalf("after buy: count %v, sell order %q, peak %v", coin.Count, coin.SellOrderId, coin.TrailPeak)
+	}
+
+	// Price crosses take profit and goes up, its peak is stored.
+	for i := 0; i < 2; i++ {
+		if err = env.step(t); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if coin := env.coin(t); coin.TrailPeak != 103 || coin.SellOrderId != "" {
+		t.Fatalf("after rise: peak %v, sell order %q", coin.TrailPeak, coin.SellOrderId)
+	}
+
+	// Price falls back by more than 1% from peak, position is sold at market.
+	if err = env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	if coin := env.coin(t); coin.SellOrderId == "" {
+		t.Fatal("market sell order is not placed")
+	}
+
+	if err = env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	coin := env.coin(t)
+	if coin.Count != 0 || coin.TrailPeak != 0 || coin.SellOrderId != "" || coin.EntryPrice != 101.9 {
+		t.Fatalf("after sell: count %v, peak %v, sell order %q, entry price %v", coin.Count, coin.TrailPeak, coin.SellOrderId, coin.EntryPrice)
+	}
+
+	incomes := env.storage.Incomes()
+	if len(incomes) != 1 {
+		t.Fatalf("incomes %v", incomes)
+	}
+	if want := 101.9*0.14985*(1-sim.DefaultTakerFee) - 99*0.15; math.Abs(incomes[0].Income-want) > 1e-9 {
+		t.Fatalf("income %v, want %v", incomes[0].Income, want)
+	}
+}
+
+func TestCoinSettings(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 98, 96)
+
+	settings := models.CoinSettings{
+		UserId:        testUserId,
+		CoinName:      testSymbol,
+		TakeProfit:    0.03,
+		Step:          0.02,
+		OrderSizeUSDT: 50,
+		Multiplier:    2,
+		MaxDepth:      2,
+	}
+	if err := env.storage.SaveCoinSettings(context.Background(), settings); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entry order of 50 USDT is placed one step below price and filled.
+	for i := 0; i < 2; i++ {
+		if err := env.step(t); err != nil {
+			t.Fatal(err)
+		}
+	}
+	coin := env.coin(t)
+	if len(coin.Buy) != 1 || coin.Buy[0] != 98 || math.Abs(coin.Count-0.4995) > 1e-9 {
+		t.Fatalf("after entry: buys %v, count %v", coin.Buy, coin.Count)
+	}
+
+	buy, sell := env.order(t, coin.BuyOrderId), env.order(t, coin.SellOrderId)
+	if buy.Price != "96" || buy.Qty != "0.999" {
+		t.Fatalf("next buy: price %s, qty %s", buy.Price, buy.Qty)
+	}
+	// Fee of buy makes average price higher than price of buy.
+	if sell.Price != "101.04" {
+		t.Fatalf("sell: price %s", sell.Price)
+	}
+
+	// Ladder is as deep as it can be, so there is no next buy.
+	if err := env.step(t); err != nil {
+		t.Fatal(err)
+	}
+	coin = env.coin(t)
+	if len(coin.Buy) != 2 || coin.BuyOrderId != "" || coin.SellOrderId == "" {
+		t.Fatalf("after depth: buys %v, buy order %q, sell order %q", coin.Buy, coin.BuyOrderId, coin.SellOrderId)
+	}
+}
+
+func TestTargetSizingWideningSpacing(t *testing.T) {
+	env := newTestEnv(t, 1000)
+	env.server.SetPricePath(testSymbol, 100, 99)
+
+	settings := models.NewCoinSettings(testUserId, testSymbol)
+	settings.Sizing = models.SizingTarget
+	settings.TargetAverage = 0.01
+	settings.Spacing = models.SpacingWidening
+	settings.StepWidening = 1
+	if err := env.storage.SaveCoinSettings(context.Background(), settings); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := env.step(t); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second step is twice as wide as the first one, next buy moves average to 1% above it.
+	coin := env.coin(t)
+	buy := env.order(t, coin.BuyOrderId)
+	if buy.Price != "97" {
+		t.Fatalf("next buy: price %s", buy.Price)
+	}
+
+	qty, err := strconv.ParseFloat(buy.Qty, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	avg := (coin.Cost + qty*97) / (coin.Count + qty)
+	if math.Abs(avg-97*1.01) > 1e-3 {
+		t.Fatalf("next buy: qty %v moves average to %v", qty, avg)
+	}
+}
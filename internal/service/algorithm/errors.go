@@ -0,0 +1,85 @@
+package algorithm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"m1pes/internal/delivery/telegram/bot"
+	"m1pes/internal/models"
+	"m1pes/internal/service/engine"
+)
+
+// reportError sends error of coin's handling by its kind. Error which user has to fix is sent
+// to user once, error which passes by itself is only logged, other errors are sent to chat
+// with errors as before. Coin whose order is invalid is moved to error state.
+func (s *Service) reportError(ctx context.Context, key engine.Key, coin models.Coin, err error, eris models.Error, actionChanMap map[int64]chan models.Message) {
+	msg := models.Message{User: models.NewUser(key.UserId), Coin: coin}
+
+	kind := models.ErrorKindOf(err)
+	switch kind {
+	case models.ErrorKindRetryable:
+		slog.WarnContext(ctx, "Coin is not handled by temporary error of exchange", "err", err)
+		return
+	case models.ErrorKindAuth:
+		msg.Action = bot.ApiKeyAction
+		msg.Reason = err.Error()
+	case models.ErrorKindInsufficientFunds:
+		msg.Action = bot.BalanceAction
+		msg.Reason = err.Error()
+	case models.ErrorKindInvalidOrder:
+		// Order is refused on every try until user fixes coin's settings, so coin is stopped.
+		s.stopInvalid(ctx, key, err)
+		msg.Action = bot.InvalidOrderAction
+		msg.Reason = err.Error()
+	default:
+		msg.Action = err.Error()
+		msg.File = eris.File
+		msg.Line = eris.Line
+		actionChanMap[key.UserId] <- msg
+		return
+	}
+
+	s.reportedMu.Lock()
+	reported := s.reported[key] == kind
+	s.reported[key] = kind
+	s.reportedMu.Unlock()
+
+	if !reported {
+		actionChanMap[key.UserId] <- msg
+	}
+}
+
+// stopInvalid moves coin whose order exchange refuses as invalid to error state, so it is not
+// retried on every event.
+func (s *Service) stopInvalid(ctx context.Context, key engine.Key, err error) {
+	coin, gErr := s.sStorageRepo.GetCoin(ctx, key.UserId, key.Coin)
+	if gErr != nil {
+		slog.ErrorContext(ctx, "Error getting coin from storage", "err", gErr)
+		return
+	}
+
+	tErr := s.stopOnError(ctx, &coin, err.Error())
+	if tErr != nil {
+		slog.ErrorContext(ctx, "Error moving coin to error state", "err", tErr)
+	}
+}
+
+// stopOnError moves coin to error state, it is stopped until user resumes it.
+func (s *Service) stopOnError(ctx context.Context, coin *models.Coin, reason string) error {
+	err := s.sStorageRepo.SetStopped(ctx, coin.UserId, coin.Name, true)
+	if err != nil {
+		return fmt.Errorf("set stopped failed: %w", err)
+	}
+	coin.Stopped = true
+
+	return s.transition(ctx, coin, models.CoinStateError, reason)
+}
+
+// resetReported forgets error which has been sent to user, coin is handled without errors.
+func (s *Service) resetReported(key engine.Key) {
+	s.reportedMu.Lock()
+	defer s.reportedMu.Unlock()
+
+	delete(s.reported, key)
+}
@@ -0,0 +1,337 @@
+package algorithm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"m1pes/internal/logging"
+	"m1pes/internal/models"
+	"m1pes/internal/service/strategy"
+)
+
+// execute stores position of strategy's decision, executes its intents and moves coin to
+// state which follows from its position and orders. Decision is executed until required
+// intent fails, everything which has been done is stored anyway.
+func (s *Service) execute(ctx context.Context, stored models.Coin, storedLevels []models.GridLevel, user models.User, coiniks models.Coiniks, filled []models.OrderInfo, d strategy.Decision, actionChanMap map[int64]chan models.Message) error {
+	if d.Empty() {
+		return nil
+	}
+
+	coin := d.Coin
+
+	if !samePosition(stored, coin) {
+		err := s.sStorageRepo.SavePosition(ctx, coin)
+		if err != nil {
+			return fmt.Errorf("save position failed: %w", err)
+		}
+	}
+
+	levels := s.applyLevels(ctx, coin, user, storedLevels, filled, d.Levels)
+
+	var intentErr error
+	placedSell := false
+	for _, intent := range d.Intents {
+		err := s.executeIntent(ctx, &coin, levels, user, coiniks, intent)
+		if err == nil {
+			placedSell = placedSell || (intent.Type == strategy.IntentPlace && intent.Slot == strategy.SlotSell)
+			continue
+		}
+
+		if intent.Optional {
+			slog.ErrorContext(ctx, "Error executing optional intent", "err", err, "intent", intent.Type, "slot", intent.Slot)
+			continue
+		}
+
+		intentErr = err
+		break
+	}
+
+	// Sell order is for whole position, if position has been changed by partial fills of
+	// canceled orders old sell order is canceled and strategy places it again.
+	if coin.Count != d.Coin.Count && coin.SellOrderId != "" && !placedSell {
+		err := s.executeIntent(ctx, &coin, levels, user, coiniks, strategy.Cancel(strategy.SlotSell))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error canceling sell order of changed position", "err", err)
+		}
+	}
+
+	if !samePosition(d.Coin, coin) {
+		err := s.sStorageRepo.SavePosition(ctx, coin)
+		if err != nil {
+			return fmt.Errorf("save credited position failed: %w", err)
+		}
+	}
+
+	for _, level := range levels {
+		i := slices.IndexFunc(storedLevels, func(old models.GridLevel) bool { return old.Level == level.Level })
+		if i != -1 && storedLevels[i] == *level {
+			continue
+		}
+
+		err := s.sStorageRepo.SaveGridLevel(ctx, coin.UserId, coin.Name, *level)
+		if err != nil {
+			return fmt.Errorf("save grid level failed: %w", err)
+		}
+	}
+
+	err := s.transition(ctx, &coin, nextState(coin, levels, user), d.Reason)
+	if err != nil {
+		return err
+	}
+
+	// Fills, incomes and messages are of orders which have been executed already, they are handled
+	// even if some intent has failed.
+	for _, fill := range d.Fills {
+		s.recordOrderFills(ctx, user, coin, fill.OrderId)
+	}
+
+	for _, income := range d.Incomes {
+		err = s.sStorageRepo.InsertIncome(user.Id, coin.Name, income.Value, income.Count)
+		if err != nil {
+			return fmt.Errorf("insert income failed: %w", err)
+		}
+	}
+
+	// Sending messages for goroutine from handler to notify user.
+	for _, msg := range d.Notify {
+		msg.User = user
+		actionChanMap[user.Id] <- msg
+	}
+
+	return intentErr
+}
+
+// applyLevels returns stored grid levels which are replaced by levels of decision. Order of
+// level which has been changed is not needed anymore, it is canceled unless it is filled.
+func (s *Service) applyLevels(ctx context.Context, coin models.Coin, user models.User, stored []models.GridLevel, filled []models.OrderInfo, changed []models.GridLevel) map[int]*models.GridLevel {
+	levels := make(map[int]*models.GridLevel, len(stored))
+	for _, level := range stored {
+		level := level
+		levels[level.Level] = &level
+	}
+
+	for _, level := range changed {
+		level := level
+		old, ok := levels[level.Level]
+		if !ok {
+			level.OrderId = ""
+			levels[level.Level] = &level
+			continue
+		}
+
+		level.OrderId = old.OrderId
+		if old.OrderId != "" && (old.Side != level.Side || old.Qty != level.Qty || old.Price != level.Price) {
+			isFilled := slices.ContainsFunc(filled, func(order models.OrderInfo) bool { return order.OrderId == old.OrderId })
+			if !isFilled {
+				err := s.cancelOrder(logging.WithOrderId(ctx, old.OrderId), user, coin.Name, old.OrderId)
+				if err != nil {
+					slog.ErrorContext(ctx, "Error canceling order of grid level", "err", err, "level", level.Level)
+				}
+			}
+			level.OrderId = ""
+		}
+
+		levels[level.Level] = &level
+	}
+
+	return levels
+}
+
+func (s *Service) executeIntent(ctx context.Context, coin *models.Coin, levels map[int]*models.GridLevel, user models.User, coiniks models.Coiniks, intent strategy.Intent) error {
+	orderId := slot(coin, intent.Slot)
+	if intent.Slot == strategy.SlotLevel {
+		level, ok := levels[intent.Level]
+		if !ok {
+			return fmt.Errorf("grid level %d is not found", intent.Level)
+		}
+		if intent.Type == strategy.IntentPlace && level.OrderId != "" {
+			return fmt.Errorf("grid level %d has order %s already", intent.Level, level.OrderId)
+		}
+		orderId = &level.OrderId
+	}
+
+	switch intent.Type {
+	case strategy.IntentCancel:
+		if *orderId == "" {
+			return nil
+		}
+
+		orderCtx := logging.WithOrderId(ctx, *orderId)
+
+		if intent.Slot == strategy.SlotLevel {
+			// Order is forgotten even if it can't be canceled, optional cancel is used for orders
+			// which may be gone already.
+			err := s.cancelOrder(orderCtx, user, coin.Name, *orderId)
+			if err != nil && !intent.Optional {
+				return err
+			}
+			*orderId = ""
+			return err
+		}
+
+		order, err := s.cancelClosing(orderCtx, user, coin.Name, *orderId)
+		if err != nil && !intent.Optional {
+			return err
+		}
+		*orderId = ""
+		if err != nil {
+			return err
+		}
+
+		// Order could be partially filled or even filled before it has been canceled.
+		if executed(order) {
+			return s.credit(orderCtx, coin, user, coiniks, order)
+		}
+		return nil
+	case strategy.IntentPlace:
+		qty := intent.Qty
+		if intent.Slot == strategy.SlotSell {
+			qty = coin.Count
+		}
+
+		createReq := models.PlaceOrderRequest{
+			Side:        intent.Side,
+			Symbol:      coin.Name,
+			OrderType:   intent.OrderType,
+			Qty:         coiniks.FormatQty(qty),
+			OrderLinkId: orderLinkId(*coin, slotTag(intent)),
+		}
+		if intent.OrderType == "Limit" {
+			createReq.Price = coiniks.FormatPrice(intent.Price)
+		}
+
+		placed, err := s.createOrder(ctx, user, createReq)
+		if err != nil {
+			return err
+		}
+
+		*orderId = placed.OrderId
+		// Sequence is stored with order id, so order whose response has been lost gets the
+		// same orderLinkId on next try.
+		coin.OrderSeq++
+		return nil
+	default:
+		return fmt.Errorf("unknown intent %q", intent.Type)
+	}
+}
+
+// credit adds executed part of canceled order of coin's slot to position. Price of buy is
+// added to coin's buys, realised PnL of sell is stored in income.
+func (s *Service) credit(ctx context.Context, coin *models.Coin, user models.User, coiniks models.Coiniks, order models.OrderInfo) error {
+	fill, err := strategy.FillOf(*coin, order)
+	if err != nil {
+		return fmt.Errorf("credit canceled order failed: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Executed part of canceled order is credited", "side", fill.Side, "qty", fill.Qty, "price", fill.Price)
+
+	income := strategy.ApplyFill(coin, fill, coiniks.QtyDecimals)
+	s.recordOrderFills(ctx, user, *coin, order.OrderId)
+
+	if fill.Side == "Buy" {
+		price, err := strconv.ParseFloat(order.Price, 64)
+		if err != nil || price == 0 {
+			price = fill.Price
+		}
+		coin.Buy = append(append([]float64(nil), coin.Buy...), price)
+		coin.TrailPeak = 0
+		return nil
+	}
+
+	if coin.Count == 0 {
+		coin.Buy = nil
+	}
+
+	err = s.sStorageRepo.InsertIncome(user.Id, coin.Name, income, fill.Qty)
+	if err != nil {
+		return fmt.Errorf("insert income failed: %w", err)
+	}
+	return nil
+}
+
+// creditLevel adds executed part of canceled order of grid level to position. Bought coins
+// are counted by price of level, sold coins cost as much as level has bought them for and
+// level sells only the rest of them when it is placed again.
+func (s *Service) creditLevel(ctx context.Context, coin *models.Coin, user models.User, coiniks models.Coiniks, level *models.GridLevel, order models.OrderInfo) error {
+	fill, err := strategy.FillOf(*coin, order)
+	if err != nil {
+		return fmt.Errorf("credit canceled order of grid level failed: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Executed part of canceled grid order is credited", "side", fill.Side, "qty", fill.Qty, "price", fill.Price, "level", level.Level)
+
+	s.recordOrderFills(ctx, user, *coin, order.OrderId)
+
+	if fill.Side == "Buy" {
+		strategy.ApplyFill(coin, fill, coiniks.QtyDecimals)
+		coin.Buy = append(append([]float64(nil), coin.Buy...), level.Price)
+		return nil
+	}
+
+	position := coin.Position()
+	income := position.Close(fill, level.BuyPrice*fill.Qty)
+	strategy.SetPosition(coin, position, coiniks.QtyDecimals)
+	level.Qty = strategy.Truncate(level.Qty-fill.Qty, coiniks.QtyDecimals)
+	if level.Qty <= 0 {
+		if i := slices.Index(coin.Buy, level.BuyPrice); i != -1 {
+			coin.Buy = slices.Delete(slices.Clone(coin.Buy), i, i+1)
+		}
+		*level = models.GridLevel{Level: level.Level, Price: level.Price}
+	}
+
+	err = s.sStorageRepo.InsertIncome(user.Id, coin.Name, income, fill.Qty)
+	if err != nil {
+		return fmt.Errorf("insert income failed: %w", err)
+	}
+	return nil
+}
+
+// slotTag is tag of orderLinkId of intent's slot.
+func slotTag(intent strategy.Intent) string {
+	switch intent.Slot {
+	case strategy.SlotSell:
+		return linkTagSell
+	case strategy.SlotLevel:
+		return linkTagLevel + strconv.Itoa(intent.Level)
+	default:
+		return linkTagBuy
+	}
+}
+
+func slot(coin *models.Coin, slot strategy.Slot) *string {
+	if slot == strategy.SlotSell {
+		return &coin.SellOrderId
+	}
+	return &coin.BuyOrderId
+}
+
+// nextState is state of coin by what is bought and which orders are placed, buy orders of
+// grid levels are counted as coin's buy order.
+func nextState(coin models.Coin, levels map[int]*models.GridLevel, user models.User) models.CoinState {
+	hasPosition := coin.Count > 0 || len(coin.Buy) > 0
+	hasBuy := coin.BuyOrderId != ""
+	for _, level := range levels {
+		hasBuy = hasBuy || (level.Side == "Buy" && level.OrderId != "")
+	}
+
+	switch {
+	case hasPosition && hasBuy:
+		return models.CoinStateAccumulating
+	case hasPosition:
+		return models.CoinStateWaitingExit
+	case hasBuy:
+		return models.CoinStateWaitingEntry
+	case !user.Buy:
+		return models.CoinStatePaused
+	default:
+		return models.CoinStateIdle
+	}
+}
+
+func samePosition(a, b models.Coin) bool {
+	return a.EntryPrice == b.EntryPrice && a.Decrement == b.Decrement && a.Count == b.Count && a.Cost == b.Cost &&
+		slices.Equal(a.Buy, b.Buy) && a.TrailPeak == b.TrailPeak
+}
@@ -0,0 +1,96 @@
+package algorithm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"m1pes/internal/logging"
+	"m1pes/internal/models"
+)
+
+// RecordExecutions stores executions of user's private stream in fills ledger, it is called
+// on every execution stream event. Executions which are stored already are skipped by storage.
+func (s *Service) RecordExecutions(userId int64, executions []models.ExecutionEvent) {
+	ctx := logging.WithUserId(context.Background(), userId)
+
+	strategies := make(map[string]string)
+	for _, execution := range executions {
+		strat, ok := strategies[execution.Symbol]
+		if !ok {
+			// Coin could be deleted already, its fills are stored anyway.
+			coin, err := s.sStorageRepo.GetCoin(ctx, userId, execution.Symbol)
+			if err != nil {
+				slog.WarnContext(logging.WithCoinTag(ctx, execution.Symbol), "Error getting coin of execution from storage", "err", err)
+			}
+			strat = coin.Strategy
+			strategies[execution.Symbol] = strat
+		}
+
+		s.recordExecutions(ctx, userId, strat, []models.ExecutionEvent{execution})
+	}
+}
+
+// recordOrderFills stores executions of coin's order in fills ledger. Failure is only logged,
+// executions come from private stream too.
+func (s *Service) recordOrderFills(ctx context.Context, user models.User, coin models.Coin, orderId string) {
+	executions, err := s.getExecutions(ctx, user, coin.Name, orderId)
+	if err != nil {
+		slog.ErrorContext(logging.WithOrderId(ctx, orderId), "Error getting executions of order", "err", err)
+		return
+	}
+
+	s.recordExecutions(ctx, user.Id, coin.Strategy, executions)
+}
+
+func (s *Service) recordExecutions(ctx context.Context, userId int64, strategyName string, executions []models.ExecutionEvent) {
+	for _, execution := range executions {
+		// Funding and other executions which are not trades don't change position.
+		if execution.ExecType != "" && execution.ExecType != "Trade" {
+			continue
+		}
+
+		execCtx := logging.WithOrderId(ctx, execution.OrderId)
+
+		fill, err := executionFill(userId, execution)
+		if err != nil {
+			slog.ErrorContext(execCtx, "Error parsing execution", "err", err, "exec_id", execution.ExecId)
+			continue
+		}
+		fill.Strategy = strategyName
+
+		err = s.sStorageRepo.InsertFill(execCtx, fill)
+		if err != nil {
+			slog.ErrorContext(execCtx, "Error inserting fill", "err", err, "exec_id", execution.ExecId)
+		}
+	}
+}
+
+// executionFill returns fill of exchange's execution.
+func executionFill(userId int64, execution models.ExecutionEvent) (models.Fill, error) {
+	price, err := strconv.ParseFloat(execution.ExecPrice, 64)
+	if err != nil {
+		return models.Fill{}, fmt.Errorf("parse exec price failed: %w", err)
+	}
+
+	qty, err := strconv.ParseFloat(execution.ExecQty, 64)
+	if err != nil {
+		return models.Fill{}, fmt.Errorf("parse exec qty failed: %w", err)
+	}
+
+	fee, err := strconv.ParseFloat(execution.ExecFee, 64)
+	if err != nil {
+		return models.Fill{}, fmt.Errorf("parse exec fee failed: %w", err)
+	}
+
+	coin := models.Coin{UserId: userId, Name: execution.Symbol}
+	fill := models.NewFill(coin, execution.Side, execution.OrderId, price, qty, fee)
+	fill.ExecId = execution.ExecId
+	if execution.FeeCurrency != "" {
+		fill.FeeCoin = execution.FeeCurrency
+	}
+	fill.Time, _ = strconv.ParseInt(execution.ExecTime, 10, 64)
+
+	return fill, nil
+}
@@ -0,0 +1,195 @@
+package algorithm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"m1pes/internal/logging"
+	"m1pes/internal/models"
+)
+
+// Tags of orderLinkId, order of grid level has tag of level's number after linkTagLevel.
+const (
+	linkTagBuy       = "b"
+	linkTagSell      = "s"
+	linkTagLevel     = "l"
+	linkTagLiquidate = "x"
+)
+
+// HandlePrivateEvent stores orders' statuses and executions of user's private stream, it is
+// called on every order and execution stream event.
+func (s *Service) HandlePrivateEvent(userId int64, event models.PrivateEvent) {
+	ctx := logging.WithUserId(context.Background(), userId)
+
+	for _, order := range event.Orders {
+		s.updateOrderStatus(ctx, models.OrderEventUpdate(userId, order))
+	}
+
+	if len(event.Executions) > 0 {
+		s.RecordExecutions(userId, event.Executions)
+	}
+}
+
+// createOrder places order and stores it with request and response, order which exchange has
+// rejected is stored too. If placing of order with orderLinkId fails, order is looked up by it:
+// it could be placed by this or previous try whose response has been lost, e.g. by timeout.
+// Order which exchange has refused for sure, e.g. by balance, is not looked up.
+func (s *Service) createOrder(ctx context.Context, user models.User, req models.PlaceOrderRequest) (models.PlacedOrder, error) {
+	placed, err := s.exchange.PlaceOrder(ctx, user.Credentials(), req)
+	if err != nil && req.OrderLinkId != "" && mayBePlaced(err) {
+		found, ok := s.findPlaced(ctx, user, req)
+		if ok {
+			slog.WarnContext(logging.WithOrderId(ctx, found.OrderId), "Order is found by orderLinkId after failed placing", "err", err, "order_link_id", req.OrderLinkId)
+			placed = models.PlacedOrder{OrderId: found.OrderId, OrderLinkId: found.OrderLinkId}
+			err = nil
+		}
+	}
+
+	order := models.Order{
+		UserId:    user.Id,
+		CoinName:  req.Symbol,
+		OrderId:   placed.OrderId,
+		Side:      req.Side,
+		OrderType: req.OrderType,
+		Price:     req.Price,
+		Qty:       req.Qty,
+		Status:    models.OrderStatusNew,
+		Request:   marshalOrderCall(req),
+		Response:  marshalOrderCall(placed),
+	}
+	if err != nil {
+		order.Status = models.OrderStatusRejected
+		order.RejectReason = err.Error()
+		order.Response = err.Error()
+	}
+
+	orderCtx := logging.WithOrderId(ctx, order.OrderId)
+	if sErr := s.sStorageRepo.InsertOrder(orderCtx, order); sErr != nil {
+		slog.ErrorContext(orderCtx, "Error inserting order", "err", sErr)
+	}
+
+	return placed, err
+}
+
+// findPlaced returns order which has been placed by request with the same orderLinkId. It is
+// the same step of coin even if its price or quantity differs, strategy could change them
+// since lost try. Order which is adopted this way is handled as any coin's order.
+func (s *Service) findPlaced(ctx context.Context, user models.User, req models.PlaceOrderRequest) (models.OrderInfo, bool) {
+	orders, err := s.exchange.GetOrders(ctx, user.Credentials(), models.OrderQuery{Symbol: req.Symbol, OrderLinkId: req.OrderLinkId})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting order by orderLinkId", "err", err, "order_link_id", req.OrderLinkId)
+		return models.OrderInfo{}, false
+	}
+
+	for _, order := range orders {
+		if order.OrderLinkId == req.OrderLinkId && order.Side == req.Side {
+			return order, true
+		}
+	}
+	return models.OrderInfo{}, false
+}
+
+// mayBePlaced reports if order could be placed by exchange although its placing has failed.
+func mayBePlaced(err error) bool {
+	switch models.ErrorKindOf(err) {
+	case models.ErrorKindUnknown, models.ErrorKindRetryable, models.ErrorKindDuplicateOrder:
+		return true
+	default:
+		return false
+	}
+}
+
+// orderLinkId is client id of coin's order which is derived from user, coin, tag of order's
+// slot and coin's order sequence. Retried placing has the same id, so exchange does not place
+// order twice. Bybit allows 36 characters, so user and coin are hashed.
+func orderLinkId(coin models.Coin, tag string) string {
+	return linkPrefix(coin.UserId, coin.Name) + tag + "-" + strconv.FormatInt(coin.OrderSeq, 36)
+}
+
+// parseLinkId returns tag and sequence of orderLinkId of coin's order, ok is false if order
+// has not been placed by bot for this coin.
+func parseLinkId(coin models.Coin, linkId string) (tag string, seq int64, ok bool) {
+	rest, ok := strings.CutPrefix(linkId, linkPrefix(coin.UserId, coin.Name))
+	if !ok {
+		return "", 0, false
+	}
+
+	tag, rawSeq, ok := strings.Cut(rest, "-")
+	if !ok {
+		return "", 0, false
+	}
+
+	seq, err := strconv.ParseInt(rawSeq, 36, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return tag, seq, true
+}
+
+func linkPrefix(userId int64, coinName string) string {
+	h := fnv.New32a()
+	_, _ = fmt.Fprintf(h, "%d/%s", userId, coinName)
+	return fmt.Sprintf("m1-%08x-", h.Sum32())
+}
+
+// cancelRequest is cancel call which is written to order's history.
+type cancelRequest struct {
+	Symbol  string `json:"symbol"`
+	OrderId string `json:"orderId"`
+}
+
+// recordCancel writes cancel call to order's history.
+func (s *Service) recordCancel(ctx context.Context, user models.User, req cancelRequest, err error) {
+	update := models.OrderUpdate{
+		UserId:   user.Id,
+		OrderId:  req.OrderId,
+		Action:   models.OrderActionCancel,
+		Request:  marshalOrderCall(req),
+		Response: "OK",
+	}
+	if err != nil {
+		update.Response = err.Error()
+	}
+
+	if sErr := s.sStorageRepo.InsertOrderHistory(ctx, update); sErr != nil {
+		slog.ErrorContext(ctx, "Error inserting order history", "err", sErr)
+	}
+}
+
+// getOrders requests orders from exchange and stores their statuses.
+func (s *Service) getOrders(ctx context.Context, user models.User, query models.OrderQuery) ([]models.OrderInfo, error) {
+	orders, err := s.exchange.GetOrders(ctx, user.Credentials(), query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, order := range orders {
+		s.updateOrderStatus(ctx, models.OrderInfoUpdate(user.Id, order))
+	}
+
+	return orders, nil
+}
+
+func (s *Service) updateOrderStatus(ctx context.Context, update models.OrderUpdate) {
+	if update.OrderId == "" || update.Status == "" {
+		return
+	}
+
+	orderCtx := logging.WithOrderId(ctx, update.OrderId)
+	if err := s.sStorageRepo.UpdateOrderStatus(orderCtx, update); err != nil {
+		slog.ErrorContext(orderCtx, "Error updating order status", "err", err)
+	}
+}
+
+func marshalOrderCall(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
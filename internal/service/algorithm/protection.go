@@ -0,0 +1,99 @@
+package algorithm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"m1pes/internal/delivery/telegram/bot"
+	"m1pes/internal/models"
+)
+
+// stopReason returns why coin has to be stopped: user's equity has fallen too far from its
+// peak or price has reached coin's stop loss. Empty reason means that coin is safe.
+func stopReason(user models.User, coin models.Coin, price float64) string {
+	if user.MaxDrawdown > 0 && user.PeakEquity > 0 && user.USDTBalance < user.PeakEquity*(1-user.MaxDrawdown) {
+		return models.StopReasonDrawdown
+	}
+
+	if coin.Count == 0 {
+		return ""
+	}
+
+	if coin.StopLossPrice > 0 && price <= coin.StopLossPrice {
+		return models.StopReasonStopLoss
+	}
+
+	if coin.StopLossPercent > 0 && price <= coin.AvgPrice()*(1-coin.StopLossPercent) {
+		return models.StopReasonStopLoss
+	}
+
+	return ""
+}
+
+// updatePeakEquity stores user's equity if it is the highest one, drawdown is counted from it.
+func (s *Service) updatePeakEquity(ctx context.Context, user *models.User) error {
+	if user.USDTBalance <= user.PeakEquity {
+		return nil
+	}
+
+	err := s.uStorageRepo.SetPeakEquity(ctx, user.Id, user.USDTBalance)
+	if err != nil {
+		return fmt.Errorf("set peak equity failed: %w", err)
+	}
+
+	user.PeakEquity = user.USDTBalance
+	return nil
+}
+
+// stop sells coin's position at market as DeleteCoin does and pauses coin until user resumes
+// buying. Realised loss is stored in income and user is notified. Coin stays liquidating
+// while market sell fails, it is tried again on next handling of coin.
+func (s *Service) stop(ctx context.Context, user models.User, coin models.Coin, reason string, actionChanMap map[int64]chan models.Message) error {
+	slog.WarnContext(ctx, "Coin is stopped by protection", "reason", reason)
+
+	retry := coin.State == models.CoinStateLiquidating
+	err := s.transition(ctx, &coin, models.CoinStateLiquidating, reason)
+	if err != nil {
+		return err
+	}
+
+	// Stopped coin in liquidating state is one whose sell has to be tried again, deleted coin
+	// is not.
+	err = s.sStorageRepo.SetStopped(ctx, user.Id, coin.Name, true)
+	if err != nil {
+		return fmt.Errorf("set stopped failed: %w", err)
+	}
+
+	sold, err := s.liquidate(ctx, user, coin)
+	if err != nil {
+		// User is told only once, retries are logged.
+		if !retry {
+			actionChanMap[user.Id] <- models.Message{
+				User:   user,
+				Coin:   models.Coin{Name: coin.Name},
+				Action: bot.StopFailedAction,
+				Reason: err.Error(),
+			}
+		}
+		return err
+	}
+
+	coin.BuyOrderId, coin.SellOrderId = "", ""
+	// Market sell of liquidation has taken orderLinkId of current sequence.
+	coin.OrderSeq++
+	err = s.transition(ctx, &coin, models.CoinStatePaused, "coin is stopped")
+	if err != nil {
+		return err
+	}
+
+	// Sending message for goroutine from handler to notify user.
+	actionChanMap[user.Id] <- models.Message{
+		User:   user,
+		Coin:   models.Coin{Name: coin.Name, Count: sold.Count, CurrentPrice: sold.Price, Income: sold.Income},
+		Action: bot.StopAction,
+		Reason: reason,
+	}
+
+	return nil
+}
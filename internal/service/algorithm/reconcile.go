@@ -0,0 +1,344 @@
+package algorithm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"m1pes/internal/logging"
+	"m1pes/internal/models"
+	"m1pes/internal/service/strategy"
+)
+
+// Reconcile compares every user's coin with exchange after restart. Orders which were
+// filled or canceled while bot was stopped are applied to coin, orphaned orders are canceled
+// and coin's state is derived again. It returns everything that has been corrected.
+func (s *Service) Reconcile(ctx context.Context, userId int64) ([]models.Correction, error) {
+	user, err := s.uStorageRepo.GetUser(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting user from storage", "err", err)
+		return nil, err
+	}
+
+	coinList, err := s.sStorageRepo.GetCoinList(ctx, userId)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting coin list from storage", "err", err)
+		return nil, err
+	}
+
+	corrections := make([]models.Correction, 0)
+	for _, coin := range coinList {
+		coinCtx := logging.WithCoinTag(ctx, coin.Name)
+
+		coinCorrections, err := s.reconcileCoin(coinCtx, user, coin)
+		if err != nil {
+			slog.ErrorContext(coinCtx, "Error reconciling coin", "err", err)
+			coinCorrections = append(coinCorrections, models.Correction{
+				Coin:        coin.Name,
+				Description: fmt.Sprintf("сверка с биржей не удалась: %s", err),
+			})
+		}
+		corrections = append(corrections, coinCorrections...)
+	}
+
+	return corrections, nil
+}
+
+func (s *Service) reconcileCoin(ctx context.Context, user models.User, coin models.Coin) ([]models.Correction, error) {
+	corrections := make([]models.Correction, 0)
+	addCorrection := func(format string, args ...interface{}) {
+		corrections = append(corrections, models.Correction{Coin: coin.Name, Description: fmt.Sprintf(format, args...)})
+	}
+
+	// Coins in these states wait for user.
+	if coin.State == models.CoinStateError || coin.State == models.CoinStateLiquidating {
+		return corrections, nil
+	}
+
+	// Orders of grid levels are checked by algorithm on first handling of coin.
+	strat, err := strategy.Get(coin.Strategy)
+	if err != nil {
+		return corrections, err
+	}
+	if _, ok := strat.(strategy.Leveled); ok {
+		return corrections, nil
+	}
+
+	coiniks, err := s.sStorageRepo.GetCoiniks(ctx, user.Credentials().Exchange, coin.Name)
+	if err != nil {
+		return corrections, fmt.Errorf("get coiniks failed: %w", err)
+	}
+
+	openOrders, err := s.getOpenOrders(ctx, user, coin.Name)
+	if err != nil {
+		return corrections, err
+	}
+
+	changed := false
+	adopted := false
+
+	// Buy order was closed while bot was stopped.
+	if _, ok := openOrders[coin.BuyOrderId]; coin.BuyOrderId != "" && !ok {
+		qty, value, fee, err := s.getExecuted(ctx, user, coin, coin.BuyOrderId)
+		if err != nil {
+			return corrections, err
+		}
+
+		if qty > 0 {
+			price := value / qty
+			count := strategy.Truncate(qty-fee, coiniks.QtyDecimals)
+
+			fill := models.NewFill(coin, "Buy", coin.BuyOrderId, price, qty, fee)
+			strategy.ApplyFill(&coin, fill, coiniks.QtyDecimals)
+			coin.Buy = append(coin.Buy, price)
+
+			addCorrection("ордер на покупку %s исполнен, пока бот был выключен: куплено %s по цене %s",
+				coin.BuyOrderId, formatDecimals(count, coiniks.QtyDecimals), formatDecimals(price, coiniks.PriceDecimals))
+		} else {
+			addCorrection("ордер на покупку %s был отменён на бирже", coin.BuyOrderId)
+		}
+
+		coin.BuyOrderId = ""
+		changed = true
+	}
+
+	// Sell order was closed while bot was stopped.
+	if _, ok := openOrders[coin.SellOrderId]; coin.SellOrderId != "" && !ok {
+		qty, value, fee, err := s.getExecuted(ctx, user, coin, coin.SellOrderId)
+		if err != nil {
+			return corrections, err
+		}
+
+		if qty > 0 {
+			price := value / qty
+
+			fill := models.NewFill(coin, "Sell", coin.SellOrderId, price, qty, fee)
+			income := strategy.ApplyFill(&coin, fill, coiniks.QtyDecimals)
+
+			err = s.sStorageRepo.InsertIncome(user.Id, coin.Name, income, qty)
+			if err != nil {
+				return corrections, fmt.Errorf("insert income failed: %w", err)
+			}
+
+			if coin.Count <= 0 {
+				coin.Buy = nil
+				coin.EntryPrice = price
+			}
+
+			addCorrection("ордер на продажу %s исполнен, пока бот был выключен: продано %s по цене %s, доход %.5f",
+				coin.SellOrderId, formatDecimals(qty, coiniks.QtyDecimals), formatDecimals(price, coiniks.PriceDecimals), income)
+		} else {
+			addCorrection("ордер на продажу %s был отменён на бирже", coin.SellOrderId)
+		}
+
+		coin.SellOrderId = ""
+		changed = true
+	}
+
+	// Coins can not be more than wallet has, e.g. if user has sold them by himself.
+	equity, err := s.getCoinEquity(ctx, user, coin.Name)
+	if err != nil {
+		return corrections, err
+	}
+
+	if coin.Count > equity {
+		count := strategy.Truncate(equity, coiniks.QtyDecimals)
+
+		addCorrection("количество монет исправлено с %s на %s по балансу кошелька",
+			formatDecimals(coin.Count, coiniks.QtyDecimals), formatDecimals(count, coiniks.QtyDecimals))
+
+		// Cost of coins which are gone is forgotten, average price stays the same.
+		coin.Cost = coin.Cost * count / coin.Count
+		coin.Count = count
+		if coin.Count == 0 {
+			coin.Buy = nil
+		}
+		changed = true
+	}
+
+	// Sell order has old amount of coins, new one will be placed by algorithm.
+	if changed && coin.SellOrderId != "" {
+		err = s.cancelOrder(ctx, user, coin.Name, coin.SellOrderId)
+		if err != nil {
+			return corrections, err
+		}
+
+		addCorrection("ордер на продажу %s отменён, потому что изменилось количество монет", coin.SellOrderId)
+		coin.SellOrderId = ""
+	}
+
+	// Orders of coin which it does not know about are canceled. Order which bot has placed into
+	// empty slot, but has not stored because of failure, is found by its orderLinkId. Orders
+	// which user has placed by himself are left as they are.
+	for orderId, order := range openOrders {
+		if orderId == coin.BuyOrderId || orderId == coin.SellOrderId {
+			continue
+		}
+		if _, _, ok := parseLinkId(coin, order.OrderLinkId); !ok {
+			continue
+		}
+
+		// Sell order has old amount of coins if position has been changed.
+		if slotId := orphanSlot(&coin, order); slotId != nil && (order.Side == "Buy" || !changed) {
+			*slotId = orderId
+			addCorrection("ордер %s найден по orderLinkId и возвращён монете", orderId)
+			adopted = true
+			continue
+		}
+
+		err = s.cancelOrder(ctx, user, coin.Name, orderId)
+		if err != nil {
+			return corrections, err
+		}
+
+		addCorrection("отменён лишний ордер %s", orderId)
+	}
+
+	if adopted && !changed {
+		err = s.resync(ctx, &coin, reconciledState(coin), "orders are matched by orderLinkId")
+		if err != nil {
+			return corrections, fmt.Errorf("store reconciled state failed: %w", err)
+		}
+		return corrections, nil
+	}
+
+	if !changed {
+		return corrections, nil
+	}
+
+	// Storing fixed coin.
+	err = s.sStorageRepo.SavePosition(ctx, coin)
+	if err != nil {
+		return corrections, fmt.Errorf("save position failed: %w", err)
+	}
+
+	err = s.resync(ctx, &coin, reconciledState(coin), "reconciliation with exchange")
+	if err != nil {
+		return corrections, fmt.Errorf("store reconciled state failed: %w", err)
+	}
+
+	return corrections, nil
+}
+
+// reconciledState derives coin's state from its position and orders.
+func reconciledState(coin models.Coin) models.CoinState {
+	switch {
+	case coin.Count > 0 && coin.BuyOrderId != "":
+		return models.CoinStateAccumulating
+	case coin.Count > 0:
+		return models.CoinStateWaitingExit
+	case coin.BuyOrderId != "":
+		return models.CoinStateWaitingEntry
+	case coin.State == models.CoinStatePaused:
+		return models.CoinStatePaused
+	default:
+		return models.CoinStateIdle
+	}
+}
+
+// orphanSlot returns coin's empty slot which open order has been placed into by bot, nil if
+// order is not bot's order of this coin. Sequence of coin is moved after order's one, so its
+// orderLinkId is not used again.
+func orphanSlot(coin *models.Coin, order models.OrderInfo) *string {
+	tag, seq, ok := parseLinkId(*coin, order.OrderLinkId)
+	if !ok {
+		return nil
+	}
+
+	var slotId *string
+	switch {
+	case tag == linkTagBuy && order.Side == "Buy" && coin.BuyOrderId == "":
+		slotId = &coin.BuyOrderId
+	case tag == linkTagSell && order.Side == "Sell" && coin.SellOrderId == "":
+		slotId = &coin.SellOrderId
+	default:
+		return nil
+	}
+
+	coin.OrderSeq = max(coin.OrderSeq, seq+1)
+	return slotId
+}
+
+// getOpenOrders returns coin's open orders by their ids.
+func (s *Service) getOpenOrders(ctx context.Context, user models.User, coinName string) (map[string]models.OrderInfo, error) {
+	orders, err := s.getOrders(ctx, user, models.OrderQuery{Symbol: coinName})
+	if err != nil {
+		return nil, fmt.Errorf("get open orders failed: %w", err)
+	}
+
+	openOrders := make(map[string]models.OrderInfo, len(orders))
+	for _, order := range orders {
+		openOrders[order.OrderId] = order
+	}
+
+	return openOrders, nil
+}
+
+// getExecuted returns executed quantity, value and fee of coin's order, its executions are
+// stored in fills ledger.
+func (s *Service) getExecuted(ctx context.Context, user models.User, coin models.Coin, orderId string) (qty, value, fee float64, err error) {
+	executions, err := s.getExecutions(ctx, user, coin.Name, orderId)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	s.recordExecutions(ctx, user.Id, coin.Strategy, executions)
+
+	for _, execution := range executions {
+		execQty, err := strconv.ParseFloat(execution.ExecQty, 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("parse exec qty failed: %w", err)
+		}
+
+		execPrice, err := strconv.ParseFloat(execution.ExecPrice, 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("parse exec price failed: %w", err)
+		}
+
+		execFee, err := strconv.ParseFloat(execution.ExecFee, 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("parse exec fee failed: %w", err)
+		}
+
+		qty += execQty
+		value += execQty * execPrice
+		fee += execFee
+	}
+
+	return qty, value, fee, nil
+}
+
+// getExecutions returns executions of order.
+func (s *Service) getExecutions(ctx context.Context, user models.User, coinName, orderId string) ([]models.ExecutionEvent, error) {
+	executions, err := s.exchange.GetExecutions(ctx, user.Credentials(), coinName, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("get executions failed: %w", err)
+	}
+
+	return executions, nil
+}
+
+// getCoinEquity returns how many coins of symbol's base coin user's wallet has.
+func (s *Service) getCoinEquity(ctx context.Context, user models.User, coinName string) (float64, error) {
+	balances, err := s.exchange.GetBalances(ctx, user.Credentials())
+	if err != nil {
+		return 0, fmt.Errorf("get user's wallet failed: %w", err)
+	}
+
+	return balances.Coins[strings.TrimSuffix(coinName, "USDT")], nil
+}
+
+func (s *Service) cancelOrder(ctx context.Context, user models.User, coinName, orderId string) error {
+	err := s.exchange.CancelOrder(ctx, user.Credentials(), coinName, orderId)
+	s.recordCancel(ctx, user, cancelRequest{Symbol: coinName, OrderId: orderId}, err)
+	if err != nil {
+		return fmt.Errorf("cancel order %s failed: %w", orderId, err)
+	}
+	return nil
+}
+
+func formatDecimals(value float64, decimals int) string {
+	return fmt.Sprintf("%."+strconv.Itoa(decimals)+"f", value)
+}
@@ -0,0 +1,57 @@
+package algorithm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"m1pes/internal/models"
+)
+
+// transition moves coin to state "to" and stores it together with coin's order ids.
+// Illegal transitions are rejected before anything is stored.
+func (s *Service) transition(ctx context.Context, coin *models.Coin, to models.CoinState, reason string) error {
+	from := coin.State
+	if from == "" {
+		from = models.CoinStateIdle
+	}
+
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s -> %s (%s)", models.ErrIllegalTransition, from, to, reason)
+	}
+
+	next := *coin
+	next.State = to
+
+	err := s.sStorageRepo.TransitionCoinState(ctx, next, from, reason)
+	if err != nil {
+		return err
+	}
+
+	if from != to {
+		slog.DebugContext(ctx, "Coin state is changed", "from", from, "to", to, "reason", reason)
+	}
+
+	coin.State = to
+	return nil
+}
+
+// resync stores state which was derived from exchange by reconciliation. Transitions table
+// is not checked, because stored state is outdated and exchange is the source of truth.
+func (s *Service) resync(ctx context.Context, coin *models.Coin, to models.CoinState, reason string) error {
+	from := coin.State
+	if from == "" {
+		from = models.CoinStateIdle
+	}
+
+	next := *coin
+	next.State = to
+
+	err := s.sStorageRepo.TransitionCoinState(ctx, next, from, reason)
+	if err != nil {
+		return err
+	}
+
+	coin.State = to
+	return nil
+}
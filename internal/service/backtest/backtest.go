@@ -0,0 +1,275 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"m1pes/internal/config"
+	"m1pes/internal/delivery/telegram/bot"
+	"m1pes/internal/models"
+	"m1pes/internal/service/algorithm"
+
+	apiStock "m1pes/internal/repository/api/stocks"
+	"m1pes/internal/repository/api/stocks/bybit"
+	"m1pes/internal/repository/api/stocks/sim"
+	"m1pes/internal/repository/storage/memory"
+)
+
+const (
+	userId = 1
+	apiKey = "backtest"
+)
+
+type Service struct {
+	klineRepo apiStock.KlineRepository
+	cacheDir  string
+}
+
+func New(klineRepo apiStock.KlineRepository, cacheDir string) *Service {
+	return &Service{klineRepo: klineRepo, cacheDir: cacheDir}
+}
+
+// Config is what backtest is run with.
+type Config struct {
+	Symbol string
+	// Balance is initial USDT balance.
+	Balance float64
+	// Percent is user's ladder step and take profit.
+	Percent float64
+	// Settings are coin's settings, zero ones are inherited from user.
+	Settings models.CoinSettings
+	Fees     sim.Fees
+	// Coiniks are symbol's decimals, orders are rounded to them as in live trading.
+	Coiniks models.Coiniks
+}
+
+type Report struct {
+	Symbol  string
+	Candles int
+
+	StartEquity float64
+	EndEquity   float64
+	PnL         float64
+	PnLPercent  float64
+	// RealisedPnL is sum of incomes which algorithm has stored, UnrealisedPnL is PnL of coin's
+	// position at the last price.
+	RealisedPnL   float64
+	UnrealisedPnL float64
+	Fees          float64
+	// BuyAndHoldPercent is what just holding coin would give.
+	BuyAndHoldPercent float64
+
+	MaxDrawdown        float64
+	MaxDrawdownPercent float64
+
+	// Utilisation is part of equity which is in coin.
+	AvgUtilisation float64
+	MaxUtilisation float64
+
+	// LadderSteps is amount of filled buy orders, Cycles is amount of filled sell orders.
+	LadderSteps int
+	MaxDepth    int
+	Cycles      int
+	// Stops is amount of positions which have been sold by stop loss or max drawdown.
+	Stops         int
+	OpenPosition  float64
+	Errors        int
+	LastError     string
+	EquityCurve   []EquityPoint
+	StateHistory  []memory.StateChange
+	IncomeHistory []memory.Income
+}
+
+type EquityPoint struct {
+	Time        int64
+	Price       float64
+	Equity      float64
+	Utilisation float64
+}
+
+// Run replays candles through the ladder algorithm on simulated exchange. Every candle is
+// walked by sim.PricePath, on every price limit orders are matched and algorithm handles
+// coin as on ticker event.
+func (s *Service) Run(ctx context.Context, cfg Config, candles []models.Candle) (Report, error) {
+	if len(candles) == 0 {
+		return Report{}, fmt.Errorf("no candles for %s", cfg.Symbol)
+	}
+
+	exchange := sim.New(cfg.Fees)
+	exchange.SetTime(candles[0].Time)
+	exchange.Deposit(apiKey, sim.QuoteCoin, cfg.Balance)
+
+	storage := memory.New()
+	storage.SetClock(exchange.Now)
+
+	coiniks := cfg.Coiniks
+	coiniks.Name = cfg.Symbol
+	storage.AddCoiniks(coiniks)
+
+	err := storage.NewUser(ctx, models.User{
+		Id:               userId,
+		Percent:          cfg.Percent,
+		ApiKey:           apiKey,
+		SecretKey:        apiKey,
+		TradingActivated: true,
+		Buy:              true,
+	})
+	if err != nil {
+		return Report{}, err
+	}
+
+	err = storage.AddCoin(models.NewCoin(userId, cfg.Symbol))
+	if err != nil {
+		return Report{}, err
+	}
+
+	settings := cfg.Settings
+	settings.UserId, settings.CoinName = userId, cfg.Symbol
+	err = storage.SaveCoinSettings(ctx, settings)
+	if err != nil {
+		return Report{}, err
+	}
+
+	// Engine is not started, coin is handled directly candle by candle.
+	algoService := algorithm.New(bybit.NewExchange(exchange, nil), nil, storage, storage, config.EngineConfig{})
+
+	actionChan := make(chan models.Message, 16)
+	actionChanMap := map[int64]chan models.Message{userId: actionChan}
+
+	report := Report{
+		Symbol:      cfg.Symbol,
+		Candles:     len(candles),
+		StartEquity: cfg.Balance,
+		EquityCurve: make([]EquityPoint, 0, len(candles)),
+	}
+
+	peak := cfg.Balance
+	var utilisationSum float64
+
+	for _, candle := range candles {
+		exchange.SetTime(candle.Time)
+
+		for _, price := range sim.PricePath(candle) {
+			exchange.SetPrice(cfg.Symbol, price)
+			algoService.UpdateBalance(userId, []models.WalletEvent{exchange.Wallet(apiKey)})
+
+			err, _ = algoService.HandleCoinUpdate(ctx, models.NewCoin(userId, cfg.Symbol), userId, price, true, actionChanMap)
+			if err != nil {
+				slog.DebugContext(ctx, "Error handling coin in backtest", "err", err)
+				report.Errors++
+				report.LastError = err.Error()
+			}
+
+			report.drainActions(actionChan)
+		}
+
+		coin, err := storage.GetCoin(ctx, userId, cfg.Symbol)
+		if err != nil {
+			return Report{}, err
+		}
+		report.MaxDepth = max(report.MaxDepth, len(coin.Buy))
+
+		equity := exchange.Equity(apiKey)
+		var utilisation float64
+		if equity > 0 {
+			utilisation = exchange.Balance(apiKey, sim.BaseCoin(cfg.Symbol)) * candle.Close / equity
+		}
+
+		report.EquityCurve = append(report.EquityCurve, EquityPoint{
+			Time:        candle.Time.UnixMilli(),
+			Price:       candle.Close,
+			Equity:      equity,
+			Utilisation: utilisation,
+		})
+
+		peak = math.Max(peak, equity)
+		if drawdown := peak - equity; drawdown > report.MaxDrawdown {
+			report.MaxDrawdown = drawdown
+			report.MaxDrawdownPercent = drawdown / peak * 100
+		}
+
+		utilisationSum += utilisation
+		report.MaxUtilisation = math.Max(report.MaxUtilisation, utilisation)
+	}
+
+	report.EndEquity = exchange.Equity(apiKey)
+	report.PnL = report.EndEquity - report.StartEquity
+	report.PnLPercent = report.PnL / report.StartEquity * 100
+	report.AvgUtilisation = utilisationSum / float64(len(candles))
+	report.BuyAndHoldPercent = (candles[len(candles)-1].Close/candles[0].Open - 1) * 100
+	report.OpenPosition = exchange.Balance(apiKey, sim.BaseCoin(cfg.Symbol))
+
+	coin, err := storage.GetCoin(ctx, userId, cfg.Symbol)
+	if err != nil {
+		return Report{}, err
+	}
+	report.UnrealisedPnL = coin.Position().Unrealised(candles[len(candles)-1].Close)
+	report.StateHistory = storage.History()
+	report.IncomeHistory = storage.Incomes()
+
+	for _, income := range report.IncomeHistory {
+		report.RealisedPnL += income.Income
+	}
+
+	report.Fees, err = fees(ctx, exchange, cfg.Symbol)
+	if err != nil {
+		return Report{}, err
+	}
+
+	return report, nil
+}
+
+// drainActions counts messages which algorithm sends to user.
+func (r *Report) drainActions(actionChan chan models.Message) {
+	for {
+		select {
+		case msg := <-actionChan:
+			switch msg.Action {
+			case bot.BuyAction:
+				r.LadderSteps++
+			case bot.SellAction:
+				r.Cycles++
+			case bot.StopAction:
+				r.Stops++
+			default:
+				r.Errors++
+				r.LastError = msg.Action
+			}
+		default:
+			return
+		}
+	}
+}
+
+// fees returns all paid fees in USDT, fees of buys are taken in coin and counted by execution price.
+func fees(ctx context.Context, exchange *sim.Exchange, symbol string) (float64, error) {
+	getReq := make(models.GetExecutionsRequest)
+	getReq["symbol"] = symbol
+
+	getExecutionsResp, err := exchange.GetExecutions(ctx, getReq, apiKey, apiKey)
+	if err != nil {
+		return 0, err
+	}
+
+	var total float64
+	for _, execution := range getExecutionsResp.Result.List {
+		fee, err := strconv.ParseFloat(execution.ExecFee, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parse exec fee failed: %w", err)
+		}
+
+		if execution.FeeCurrency != sim.QuoteCoin {
+			price, err := strconv.ParseFloat(execution.ExecPrice, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parse exec price failed: %w", err)
+			}
+			fee *= price
+		}
+		total += fee
+	}
+
+	return total, nil
+}
@@ -0,0 +1,48 @@
+package backtest
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"m1pes/internal/models"
+	"m1pes/internal/repository/api/stocks/sim"
+)
+
+// TestRun checks one ladder cycle on synthetic candles: entry is filled on the dip, its sell
+// on the rise, PnL is income of cycle after fees of both orders.
+func TestRun(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	candles := []models.Candle{
+		{Time: start, Open: 100, High: 100, Low: 100, Close: 100},
+		{Time: start.Add(time.Minute), Open: 100, High: 100, Low: 98.9, Close: 99},
+		{Time: start.Add(2 * time.Minute), Open: 99, High: 100.5, Low: 99, Close: 100.5},
+	}
+
+	fees := sim.Fees{Maker: sim.DefaultMakerFee, Taker: sim.DefaultTakerFee}
+	report, err := New(nil, "").Run(context.Background(), Config{
+		Symbol:  "BTCUSDT",
+		Balance: 1000,
+		Percent: 0.01,
+		Fees:    fees,
+		Coiniks: models.Coiniks{QtyDecimals: 6, PriceDecimals: 2},
+	}, candles)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.LadderSteps != 1 || report.Cycles != 1 || report.Errors != 0 || report.OpenPosition != 0 {
+		t.Fatalf("steps %d, cycles %d, errors %d (%s), open position %v", report.LadderSteps, report.Cycles, report.Errors, report.LastError, report.OpenPosition)
+	}
+
+	// 0.15 coins are bought on 99, fee of buy is taken in coin, the rest is sold 1% above average.
+	bought := 0.15 * (1 - fees.Maker)
+	income := 100.09*bought*(1-fees.Maker) - 99*0.15
+	if math.Abs(report.PnL-income) > 1e-6 || math.Abs(report.RealisedPnL-income) > 1e-6 {
+		t.Fatalf("PnL %v, realised %v, want %v", report.PnL, report.RealisedPnL, income)
+	}
+	if want := 0.15*fees.Maker*99 + 100.09*bought*fees.Maker; math.Abs(report.Fees-want) > 1e-6 {
+		t.Fatalf("fees %v, want %v", report.Fees, want)
+	}
+}
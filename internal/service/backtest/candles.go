@@ -0,0 +1,88 @@
+package backtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"m1pes/internal/models"
+	"m1pes/internal/repository/api/stocks/sim"
+)
+
+// Bybit returns not more than that amount of klines per request.
+const klinesLimit = 1000
+
+// FetchCandles returns symbol's candles between from and to from Bybit. Candles are cached
+// on disk, so the same period is requested only once.
+func (s *Service) FetchCandles(ctx context.Context, symbol, interval string, from, to time.Time) ([]models.Candle, error) {
+	cachePath := filepath.Join(s.cacheDir, fmt.Sprintf("%s_%s_%d_%d.csv", symbol, interval, from.UnixMilli(), to.UnixMilli()))
+
+	candles, err := sim.LoadCandlesCSV(cachePath)
+	if err == nil {
+		return candles, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("load cached candles failed: %w", err)
+	}
+
+	seen := make(map[int64]bool)
+	end := to.UnixMilli()
+	for end >= from.UnixMilli() {
+		getKlinesReq := make(models.GetKlinesRequest)
+		getKlinesReq["category"] = "spot"
+		getKlinesReq["symbol"] = symbol
+		getKlinesReq["interval"] = interval
+		getKlinesReq["start"] = strconv.FormatInt(from.UnixMilli(), 10)
+		getKlinesReq["end"] = strconv.FormatInt(end, 10)
+		getKlinesReq["limit"] = strconv.Itoa(klinesLimit)
+
+		getKlinesResp, err := s.klineRepo.GetKlines(ctx, getKlinesReq)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(getKlinesResp.Result.List) == 0 {
+			break
+		}
+
+		// Klines come newest first, next page ends right before the oldest one.
+		oldest := end
+		for _, kline := range getKlinesResp.Result.List {
+			candle, err := sim.ParseCandle(kline)
+			if err != nil {
+				return nil, err
+			}
+
+			ts := candle.Time.UnixMilli()
+			if ts < oldest {
+				oldest = ts
+			}
+			if !seen[ts] {
+				seen[ts] = true
+				candles = append(candles, candle)
+			}
+		}
+
+		if len(getKlinesResp.Result.List) < klinesLimit {
+			break
+		}
+		end = oldest - 1
+	}
+
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time.Before(candles[j].Time) })
+
+	if err = os.MkdirAll(s.cacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create cache dir failed: %w", err)
+	}
+
+	if err = sim.WriteCandlesCSV(cachePath, candles); err != nil {
+		return nil, fmt.Errorf("cache candles failed: %w", err)
+	}
+
+	return candles, nil
+}
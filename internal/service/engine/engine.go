@@ -0,0 +1,400 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"m1pes/internal/config"
+	"m1pes/internal/logging"
+	"m1pes/internal/models"
+
+	apiStock "m1pes/internal/repository/api/stocks"
+)
+
+const (
+	DefaultMinInterval    = time.Second
+	DefaultResyncInterval = time.Minute
+	DefaultMaxConcurrent  = 16
+)
+
+// Key identifies worker, every user's coin is handled by its own worker.
+type Key struct {
+	UserId int64
+	Coin   string
+}
+
+// Event is what has happened with coin since last handling. Events which come while
+// worker is busy or waits for min interval are merged into one.
+type Event struct {
+	// Price is the last known price of coin, 0 if it is not known yet.
+	Price float64
+	// CheckOrders is true if some order of coin has been updated.
+	CheckOrders bool
+}
+
+type HandlerFunc func(ctx context.Context, key Key, event Event) error
+
+type WalletFunc func(userId int64, wallets []models.WalletEvent)
+
+// PrivateFunc is called with order and execution events of user's private stream before
+// coins' workers are woken up.
+type PrivateFunc func(userId int64, event models.PrivateEvent)
+
+// Engine routes price, order and balance events from streams to per-(user, coin) workers.
+// Streams are shared: one ticker subscription per coin and one private subscription per user.
+// Every worker is handled not more often than min interval and not more than max concurrent
+// workers are handled at the same time, so load does not depend on amount of events.
+type Engine struct {
+	streamRepo     apiStock.StreamRepository
+	onWallet       WalletFunc
+	onPrivate      PrivateFunc
+	minInterval    time.Duration
+	resyncInterval time.Duration
+	sem            chan struct{}
+
+	mu      sync.Mutex
+	workers map[Key]*worker
+	tickers map[string]*feed
+	users   map[int64]*feed
+}
+
+// feed is shared stream subscription which is canceled when last worker is unregistered.
+type feed struct {
+	cancel context.CancelFunc
+	refs   int
+}
+
+func New(streamRepo apiStock.StreamRepository, cfg config.EngineConfig, onWallet WalletFunc, onPrivate PrivateFunc) *Engine {
+	e := &Engine{
+		streamRepo:     streamRepo,
+		onWallet:       onWallet,
+		onPrivate:      onPrivate,
+		minInterval:    cfg.MinInterval,
+		resyncInterval: cfg.ResyncInterval,
+		workers:        make(map[Key]*worker),
+		tickers:        make(map[string]*feed),
+		users:          make(map[int64]*feed),
+	}
+
+	if e.minInterval <= 0 {
+		e.minInterval = DefaultMinInterval
+	}
+	if e.resyncInterval <= 0 {
+		e.resyncInterval = DefaultResyncInterval
+	}
+
+	maxConcurrent := cfg.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxConcurrent
+	}
+	e.sem = make(chan struct{}, maxConcurrent)
+
+	return e
+}
+
+// Register starts worker for user's coin, handle is called with ctx on every event.
+// It does nothing if worker already exists.
+func (e *Engine) Register(ctx context.Context, user models.User, coin string, handle HandlerFunc) error {
+	key := Key{UserId: user.Id, Coin: coin}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.workers[key]; ok {
+		return nil
+	}
+
+	// Prices of other exchanges and of bybit's sandboxes differ from mainnet's, so their
+	// workers poll instead of tickers.
+	poll := !onStream(user)
+	if !poll {
+		if err := e.subscribeTickers(coin); err != nil {
+			return err
+		}
+	}
+
+	if err := e.subscribePrivate(user); err != nil {
+		if !poll {
+			release(e.tickers, coin)
+		}
+		return err
+	}
+
+	w := newWorker(key, handle)
+	w.poll = poll
+	e.workers[key] = w
+
+	go e.run(ctx, w)
+
+	return nil
+}
+
+// Unregister stops worker for user's coin. It does not wait for worker's current
+// handling, so it is safe to call it from the handler itself.
+func (e *Engine) Unregister(key Key) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w, ok := e.workers[key]
+	if !ok {
+		return
+	}
+
+	delete(e.workers, key)
+	close(w.done)
+
+	if !w.poll {
+		release(e.tickers, key.Coin)
+	}
+	release(e.users, key.UserId)
+}
+
+// Registered reports if worker for user's coin is running.
+func (e *Engine) Registered(key Key) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, ok := e.workers[key]
+	return ok
+}
+
+// Coins returns names of coins which are handled for user.
+func (e *Engine) Coins(userId int64) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	coins := make([]string, 0)
+	for key := range e.workers {
+		if key.UserId == userId {
+			coins = append(coins, key.Coin)
+		}
+	}
+	return coins
+}
+
+func (e *Engine) subscribeTickers(coin string) error {
+	if f, ok := e.tickers[coin]; ok {
+		f.refs++
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	tickers, err := e.streamRepo.SubscribeTickers(ctx, coin)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("subscribe to tickers failed: %w", err)
+	}
+	e.tickers[coin] = &feed{cancel: cancel, refs: 1}
+
+	go func() {
+		for ticker := range tickers {
+			price, err := strconv.ParseFloat(ticker.Price, 64)
+			if err != nil {
+				slog.Error("Error parsing ticker price to float", "coin", coin, "err", err)
+				continue
+			}
+
+			e.dispatch(func(key Key) bool { return key.Coin == coin }, Event{Price: price})
+		}
+	}()
+
+	return nil
+}
+
+func (e *Engine) subscribePrivate(user models.User) error {
+	if f, ok := e.users[user.Id]; ok {
+		f.refs++
+		return nil
+	}
+
+	// Orders of users who are not on stream are checked by polling.
+	if !onStream(user) {
+		e.users[user.Id] = &feed{cancel: func() {}, refs: 1}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(logging.WithUserId(context.Background(), user.Id))
+
+	events, err := e.streamRepo.SubscribePrivate(ctx, user.ApiKey, user.SecretKey)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("subscribe to private stream failed: %w", err)
+	}
+	e.users[user.Id] = &feed{cancel: cancel, refs: 1}
+
+	go func() {
+		for event := range events {
+			if len(event.Wallets) > 0 && e.onWallet != nil {
+				e.onWallet(user.Id, event.Wallets)
+				continue
+			}
+
+			if e.onPrivate != nil {
+				e.onPrivate(user.Id, event)
+			}
+
+			for _, symbol := range event.Symbols() {
+				e.dispatch(func(key Key) bool { return key.UserId == user.Id && key.Coin == symbol }, Event{CheckOrders: true})
+			}
+		}
+	}()
+
+	return nil
+}
+
+// onStream tells if user's prices and orders come from streams, they are bybit mainnet's.
+// Workers of other users are woken up every min interval and price is requested by handler.
+func onStream(user models.User) bool {
+	creds := user.Credentials()
+	return creds.Exchange == models.ExchangeBybit && creds.Environment == models.EnvironmentMainnet
+}
+
+// release decrements feed's references and cancels its subscription if it is not used anymore.
+func release[K comparable](feeds map[K]*feed, id K) {
+	f, ok := feeds[id]
+	if !ok {
+		return
+	}
+
+	f.refs--
+	if f.refs == 0 {
+		delete(feeds, id)
+		f.cancel()
+	}
+}
+
+func (e *Engine) dispatch(match func(key Key) bool, event Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for key, w := range e.workers {
+		if match(key) {
+			w.push(event)
+		}
+	}
+}
+
+func (e *Engine) run(ctx context.Context, w *worker) {
+	ctx = logging.WithCoinTag(logging.WithUserId(ctx, w.key.UserId), w.key.Coin)
+
+	resync := time.NewTicker(e.resyncInterval)
+	defer resync.Stop()
+
+	// Worker which is not on streams gets no events, it checks price and orders by itself.
+	var poll <-chan time.Time
+	if w.poll {
+		ticker := time.NewTicker(e.minInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	// First handling checks orders, because something could happen while worker was stopped.
+	w.push(Event{CheckOrders: true})
+
+	var lastRun time.Time
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-resync.C:
+			// Orders are checked from time to time in case some private event was lost.
+			w.push(Event{CheckOrders: true})
+			continue
+		case <-poll:
+			w.push(Event{CheckOrders: true})
+			continue
+		case <-w.notify:
+		}
+
+		// Waiting for min interval, events which come meanwhile are merged.
+		if wait := e.minInterval - time.Since(lastRun); wait > 0 {
+			select {
+			case <-w.done:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		select {
+		case <-w.done:
+			return
+		case e.sem <- struct{}{}:
+		}
+
+		event := w.take()
+		lastRun = time.Now()
+
+		err := e.handle(ctx, w, event)
+		<-e.sem
+
+		if err != nil {
+			slog.ErrorContext(ctx, "Error handling coin event", "err", err)
+		}
+	}
+}
+
+func (e *Engine) handle(ctx context.Context, w *worker, event Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic in worker: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	return w.handle(ctx, w.key, event)
+}
+
+type worker struct {
+	key    Key
+	handle HandlerFunc
+	notify chan struct{}
+	done   chan struct{}
+
+	// poll is true if worker is not on streams, it is woken up every min interval then.
+	poll bool
+
+	mu      sync.Mutex
+	pending Event
+	price   float64
+}
+
+func newWorker(key Key, handle HandlerFunc) *worker {
+	return &worker{
+		key:    key,
+		handle: handle,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// push merges event into pending one and wakes worker up. It never blocks.
+func (w *worker) push(event Event) {
+	w.mu.Lock()
+	if event.Price != 0 {
+		w.price = event.Price
+	}
+	w.pending.CheckOrders = w.pending.CheckOrders || event.CheckOrders
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take returns pending event with the last known price and resets it.
+func (w *worker) take() Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	event := w.pending
+	event.Price = w.price
+	w.pending = Event{}
+
+	return event
+}
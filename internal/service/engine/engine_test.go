@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"m1pes/internal/config"
+	"m1pes/internal/models"
+)
+
+// stream counts subscriptions which are alive, subscription ends when its ctx is done.
+type stream struct {
+	mu      sync.Mutex
+	tickers map[string]int
+	users   map[string]int
+}
+
+func newStream() *stream {
+	return &stream{tickers: make(map[string]int), users: make(map[string]int)}
+}
+
+func (s *stream) SubscribeTickers(ctx context.Context, symbol string) (<-chan models.TickerEvent, error) {
+	return subscribe(ctx, &s.mu, s.tickers, symbol, make(chan models.TickerEvent)), nil
+}
+
+func (s *stream) SubscribePrivate(ctx context.Context, apiKey, secretKey string) (<-chan models.PrivateEvent, error) {
+	return subscribe(ctx, &s.mu, s.users, apiKey, make(chan models.PrivateEvent)), nil
+}
+
+func subscribe[E any](ctx context.Context, mu *sync.Mutex, alive map[string]int, id string, events chan E) <-chan E {
+	mu.Lock()
+	alive[id]++
+	mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		mu.Lock()
+		alive[id]--
+		mu.Unlock()
+		close(events)
+	}()
+	return events
+}
+
+// alive waits until subscriptions of id become n.
+func (s *stream) alive(t *testing.T, subs map[string]int, id string, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); ; {
+		s.mu.Lock()
+		got := subs[id]
+		s.mu.Unlock()
+
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s has %d subscriptions, want %d", id, got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestPushTake checks that events which come while worker is busy are merged into one with
+// the last price.
+func TestPushTake(t *testing.T) {
+	w := newWorker(Key{UserId: 1, Coin: "BTCUSDT"}, nil)
+
+	w.push(Event{Price: 100})
+	w.push(Event{CheckOrders: true})
+	w.push(Event{Price: 101})
+
+	if n := len(w.notify); n != 1 {
+		t.Fatalf("worker is notified %d times", n)
+	}
+	if event := w.take(); event != (Event{Price: 101, CheckOrders: true}) {
+		t.Fatalf("event is %+v", event)
+	}
+
+	// Price is kept for events without it, pending checks are reset.
+	w.push(Event{})
+	if event := w.take(); event != (Event{Price: 101}) {
+		t.Fatalf("event after take is %+v", event)
+	}
+}
+
+// TestRegisterRefs checks that ticker and private subscriptions are shared by workers and
+// canceled with the last of them.
+func TestRegisterRefs(t *testing.T) {
+	s := newStream()
+	e := New(s, config.EngineConfig{MinInterval: time.Hour, ResyncInterval: time.Hour}, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handle := func(ctx context.Context, key Key, event Event) error { return nil }
+	alice := models.User{Id: 1, ApiKey: "alice"}
+	bob := models.User{Id: 2, ApiKey: "bob"}
+
+	for _, reg := range []struct {
+		user models.User
+		coin string
+	}{
+		{alice, "BTCUSDT"},
+		{alice, "ETHUSDT"},
+		{bob, "BTCUSDT"},
+		// Worker which exists already is not registered again.
+		{bob, "BTCUSDT"},
+	} {
+		if err := e.Register(ctx, reg.user, reg.coin, handle); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if refs := e.tickers["BTCUSDT"].refs; refs != 2 {
+		t.Fatalf("BTCUSDT ticker has %d refs", refs)
+	}
+	if refs := e.users[alice.Id].refs; refs != 2 {
+		t.Fatalf("alice's private stream has %d refs", refs)
+	}
+	s.alive(t, s.tickers, "BTCUSDT", 1)
+	s.alive(t, s.users, "alice", 1)
+
+	e.Unregister(Key{UserId: alice.Id, Coin: "BTCUSDT"})
+	if refs := e.tickers["BTCUSDT"].refs; refs != 1 {
+		t.Fatalf("BTCUSDT ticker has %d refs after unregister", refs)
+	}
+	s.alive(t, s.users, "alice", 1)
+
+	e.Unregister(Key{UserId: alice.Id, Coin: "ETHUSDT"})
+	if _, ok := e.users[alice.Id]; ok {
+		t.Fatal("alice's private stream is kept without workers")
+	}
+	s.alive(t, s.users, "alice", 0)
+	s.alive(t, s.tickers, "ETHUSDT", 0)
+	s.alive(t, s.tickers, "BTCUSDT", 1)
+
+	e.Unregister(Key{UserId: bob.Id, Coin: "BTCUSDT"})
+	s.alive(t, s.tickers, "BTCUSDT", 0)
+	s.alive(t, s.users, "bob", 0)
+
+	if coins := e.Coins(alice.Id); len(coins) != 0 {
+		t.Fatalf("alice has coins %v", coins)
+	}
+}
+
+// TestPoll checks that users who are not on bybit mainnet have no streams, their workers are
+// woken up every min interval to check price and orders.
+func TestPoll(t *testing.T) {
+	s := newStream()
+	e := New(s, config.EngineConfig{MinInterval: 10 * time.Millisecond, ResyncInterval: time.Hour}, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	handled := make(map[int64]int)
+	handle := func(ctx context.Context, key Key, event Event) error {
+		if event.Price != 0 || !event.CheckOrders {
+			t.Errorf("worker of %d has got %+v", key.UserId, event)
+		}
+		mu.Lock()
+		handled[key.UserId]++
+		mu.Unlock()
+		return nil
+	}
+
+	mainnet := models.User{Id: 1, ApiKey: "mainnet"}
+	testnet := models.User{Id: 2, ApiKey: "testnet", Environment: models.EnvironmentTestnet}
+	okx := models.User{Id: 3, ApiKey: "okx", Exchange: models.ExchangeOKX}
+
+	for _, user := range []models.User{mainnet, testnet, okx} {
+		if err := e.Register(ctx, user, "BTCUSDT", handle); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only mainnet's user has streams.
+	s.alive(t, s.users, "mainnet", 1)
+	s.alive(t, s.users, "testnet", 0)
+	s.alive(t, s.users, "okx", 0)
+	s.alive(t, s.tickers, "BTCUSDT", 1)
+	if refs := e.tickers["BTCUSDT"].refs; refs != 1 {
+		t.Fatalf("BTCUSDT ticker has %d refs", refs)
+	}
+
+	// No tickers are sent, mainnet's worker is handled only on its start.
+	for deadline := time.Now().Add(time.Second); ; {
+		mu.Lock()
+		done := handled[testnet.Id] >= 3 && handled[okx.Id] >= 3
+		mainnetHandled := handled[mainnet.Id]
+		mu.Unlock()
+
+		if mainnetHandled > 1 {
+			t.Fatalf("mainnet's worker is handled %d times without events", mainnetHandled)
+		}
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("workers which are not on stream are not polled: %v", handled)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	e.Unregister(Key{UserId: testnet.Id, Coin: "BTCUSDT"})
+	e.Unregister(Key{UserId: okx.Id, Coin: "BTCUSDT"})
+	s.alive(t, s.tickers, "BTCUSDT", 1)
+}
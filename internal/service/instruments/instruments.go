@@ -0,0 +1,102 @@
+package instruments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"m1pes/internal/models"
+	apiStock "m1pes/internal/repository/api/stocks"
+	storageStock "m1pes/internal/repository/storage/stocks"
+)
+
+const (
+	// DefaultSyncInterval is how often instruments are synced if interval is not set.
+	DefaultSyncInterval = time.Hour
+
+	// Only coins which are bought for USDT are traded by bot.
+	quoteCoin = "USDT"
+)
+
+type Service struct {
+	exchanges    map[string]apiStock.Exchange
+	sStorageRepo storageStock.Repository
+}
+
+// New creates service which syncs instruments of exchanges by their names.
+func New(exchanges map[string]apiStock.Exchange, sStorageRepo storageStock.Repository) *Service {
+	return &Service{exchanges: exchanges, sStorageRepo: sStorageRepo}
+}
+
+// Run syncs instruments at once and then every interval until ctx is done.
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error syncing instruments", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync stores spot instruments of every exchange in coiniks of that exchange. Exchange which
+// fails does not stop others from being synced.
+func (s *Service) Sync(ctx context.Context) error {
+	names := make([]string, 0, len(s.exchanges))
+	for name := range s.exchanges {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := s.syncExchange(ctx, name, s.exchanges[name]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// syncExchange stores spot instruments of exchange in coiniks, coiniks which exchange does not
+// list anymore are marked as delisted.
+func (s *Service) syncExchange(ctx context.Context, name string, exchange apiStock.Exchange) error {
+	instruments, err := exchange.GetInstruments(ctx)
+	if err != nil {
+		return fmt.Errorf("get instruments failed: %w", err)
+	}
+
+	list := make([]models.Coiniks, 0, len(instruments))
+	for _, coiniks := range instruments {
+		if strings.HasSuffix(coiniks.Name, quoteCoin) {
+			list = append(list, coiniks)
+		}
+	}
+
+	// Empty list would mark all coins as delisted.
+	if len(list) == 0 {
+		return fmt.Errorf("exchange has no %s instruments", quoteCoin)
+	}
+
+	err = s.sStorageRepo.SyncCoiniks(ctx, name, list)
+	if err != nil {
+		return fmt.Errorf("sync coiniks failed: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Instruments are synced", "exchange", name, "count", len(list))
+	return nil
+}
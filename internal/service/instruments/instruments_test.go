@@ -0,0 +1,113 @@
+package instruments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"m1pes/internal/models"
+	apiStock "m1pes/internal/repository/api/stocks"
+	"m1pes/internal/repository/api/stocks/okx"
+	"m1pes/internal/repository/storage/memory"
+)
+
+// exchange lists instruments, its other calls are not used by sync.
+type exchange struct {
+	apiStock.Exchange
+	list []models.Coiniks
+	err  error
+}
+
+func (e *exchange) GetInstruments(ctx context.Context) ([]models.Coiniks, error) {
+	return e.list, e.err
+}
+
+// TestSyncPerExchange checks that every exchange has its own coiniks and coins are delisted
+// only on exchange which does not list them anymore.
+func TestSyncPerExchange(t *testing.T) {
+	ctx := context.Background()
+	storage := memory.New()
+
+	bybit := &exchange{list: []models.Coiniks{
+		{Name: "BTCUSDT", TickSize: 0.01, Status: models.CoiniksStatusTrading},
+		{Name: "ETHUSDT", TickSize: 0.01, Status: models.CoiniksStatusTrading},
+		{Name: "BTCEUR", TickSize: 0.01, Status: models.CoiniksStatusTrading},
+	}}
+	binance := &exchange{list: []models.Coiniks{
+		{Name: "BTCUSDT", TickSize: 0.1, Status: models.CoiniksStatusTrading},
+		{Name: "ETHUSDT", TickSize: 0.1, Status: models.CoiniksStatusTrading},
+	}}
+	s := New(map[string]apiStock.Exchange{models.ExchangeBybit: bybit, models.ExchangeBinance: binance}, storage)
+
+	if err := s.Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tickSize := range map[string]float64{models.ExchangeBybit: 0.01, models.ExchangeBinance: 0.1} {
+		coiniks, err := storage.GetCoiniks(ctx, name, "BTCUSDT")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if coiniks.Exchange != name || coiniks.TickSize != tickSize {
+			t.Fatalf("coiniks of %s are %+v", name, coiniks)
+		}
+	}
+	if ok, _ := storage.ExistCoin(ctx, models.ExchangeBybit, "BTCEUR"); ok {
+		t.Fatal("coin which is not bought for USDT is synced")
+	}
+
+	// Binance delists ETHUSDT, bybit fails and keeps its coiniks as they are.
+	binance.list = binance.list[:1]
+	bybit.err = errors.New("bybit is down")
+
+	if err := s.Sync(ctx); err == nil {
+		t.Fatal("error of bybit is lost")
+	}
+
+	if ok, _ := storage.ExistCoin(ctx, models.ExchangeBinance, "ETHUSDT"); ok {
+		t.Fatal("ETHUSDT is not delisted on binance")
+	}
+	if ok, _ := storage.ExistCoin(ctx, models.ExchangeBybit, "ETHUSDT"); !ok {
+		t.Fatal("ETHUSDT is delisted on bybit")
+	}
+}
+
+// TestSyncOKX checks that instruments of okx are synced through router with their steps.
+func TestSyncOKX(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != okx.InstrumentsEndpoint {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":"0","msg":"","data":[
+			{"instId":"BTC-USDT","instType":"SPOT","lotSz":"0.00000001","minSz":"0.00001","maxLmtSz":"9999999999","tickSz":"0.1","state":"live"},
+			{"instId":"ETH-USDT","instType":"SPOT","lotSz":"0.000001","minSz":"0.001","maxLmtSz":"100000","tickSz":"0.01","state":"suspend"}]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	storage := memory.New()
+
+	bybit := &exchange{list: []models.Coiniks{{Name: "BTCUSDT", TickSize: 0.01, Status: models.CoiniksStatusTrading}}}
+	router := apiStock.NewRouter(bybit, map[string]apiStock.Exchange{models.ExchangeOKX: okx.New(server.URL)}, nil)
+
+	if err := New(router.Exchanges(), storage).Sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	btc, err := storage.GetCoiniks(ctx, models.ExchangeOKX, "BTCUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if btc.BasePrecision != 0.00000001 || btc.QtyDecimals != 8 || btc.TickSize != 0.1 || btc.PriceDecimals != 1 || btc.MinOrderQty != 0.00001 {
+		t.Fatalf("coiniks of okx are %+v", btc)
+	}
+	if ok, _ := storage.ExistCoin(ctx, models.ExchangeOKX, "ETHUSDT"); ok {
+		t.Fatal("suspended instrument of okx is traded")
+	}
+	if ok, _ := storage.ExistCoin(ctx, models.ExchangeBybit, "BTCUSDT"); !ok {
+		t.Fatal("coiniks of bybit are not synced")
+	}
+}
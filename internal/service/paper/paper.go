@@ -0,0 +1,68 @@
+package paper
+
+import (
+	"context"
+
+	"m1pes/internal/logging"
+	"m1pes/internal/models"
+	apiStock "m1pes/internal/repository/api/stocks"
+	"m1pes/internal/repository/storage/user"
+)
+
+// Secret key of users which trade on paper without real keys, simulated exchange doesn't check it.
+const paperSecretKey = "paper"
+
+type Service struct {
+	paperRepo    apiStock.PaperRepository
+	uStorageRepo user.Repository
+}
+
+func New(paperRepo apiStock.PaperRepository, uStorageRepo user.Repository) *Service {
+	return &Service{paperRepo: paperRepo, uStorageRepo: uStorageRepo}
+}
+
+// EnablePaper moves user's account to simulated exchange. User without keys gets paper api key.
+func (s *Service) EnablePaper(ctx context.Context, userId int64) error {
+	u, err := s.uStorageRepo.GetUser(ctx, userId)
+	if err != nil {
+		return logging.WrapError(ctx, err)
+	}
+
+	if u.ApiKey == "" {
+		u.ApiKey = models.PaperApiKey(userId)
+		u.SecretKey = paperSecretKey
+
+		err = s.uStorageRepo.UpdateUser(ctx, u)
+		if err != nil {
+			return logging.WrapError(ctx, err)
+		}
+	}
+
+	err = s.paperRepo.SetPaper(ctx, userId, u.ApiKey, true)
+	if err != nil {
+		return logging.WrapError(ctx, err)
+	}
+	return nil
+}
+
+// DisablePaper moves user's account back to real exchange.
+func (s *Service) DisablePaper(ctx context.Context, userId int64) error {
+	u, err := s.uStorageRepo.GetUser(ctx, userId)
+	if err != nil {
+		return logging.WrapError(ctx, err)
+	}
+
+	err = s.paperRepo.SetPaper(ctx, userId, u.ApiKey, false)
+	if err != nil {
+		return logging.WrapError(ctx, err)
+	}
+	return nil
+}
+
+func (s *Service) IsPaper(ctx context.Context, userId int64) (bool, error) {
+	u, err := s.uStorageRepo.GetUser(ctx, userId)
+	if err != nil {
+		return false, logging.WrapError(ctx, err)
+	}
+	return s.paperRepo.IsPaper(u.ApiKey), nil
+}
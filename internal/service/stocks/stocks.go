@@ -3,14 +3,14 @@ package stocks
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
-	"m1pes/internal/repository/api/stocks/bybit"
-	"net/http"
-	"strconv"
+	"slices"
 
 	"m1pes/internal/models"
 	apiStock "m1pes/internal/repository/api/stocks"
 	storageStock "m1pes/internal/repository/storage/stocks"
+	"m1pes/internal/service/strategy"
 )
 
 type CreateOrderResponse struct {
@@ -26,41 +26,32 @@ type CreateOrderResponse struct {
 }
 
 type Service struct {
-	apiRepo     apiStock.Repository
+	exchange    apiStock.Exchange
 	storageRepo storageStock.Repository
 	stopCoinMap map[string]map[int64]chan struct{}
 }
 
-func New(stockRepo apiStock.Repository, storageRepo storageStock.Repository) *Service {
-	return &Service{apiRepo: stockRepo, storageRepo: storageRepo, stopCoinMap: make(map[string]map[int64]chan struct{})}
+func New(exchange apiStock.Exchange, storageRepo storageStock.Repository) *Service {
+	return &Service{exchange: exchange, storageRepo: storageRepo, stopCoinMap: make(map[string]map[int64]chan struct{})}
 }
 
-func (s *Service) GetCoinFromStockExchange(ctx context.Context, coinReq models.GetCoinRequest, apiKey, secretKey string) (models.GetCoinResponse, error) {
-	coin, err := s.apiRepo.GetCoin(ctx, coinReq, apiKey, secretKey)
+// GetCoinPrice returns last price of coin on exchange of user's keys.
+func (s *Service) GetCoinPrice(ctx context.Context, creds models.Credentials, coinName string) (float64, error) {
+	price, err := s.exchange.GetPrice(ctx, creds, coinName)
 	if err != nil {
-		return coin, err
+		return 0, err
 	}
-	return coin, nil
+	return price, nil
 }
 
-func (s *Service) GetApiKeyPermissions(ctx context.Context, apiKey, apiSecret string) (models.GetApiKeyPermissionsResponse, error) {
-	var resp models.GetApiKeyPermissionsResponse
-
-	byteResp, err := s.apiRepo.CreateSignRequestAndGetRespBody("", bybit.GetApiKeyPermissions, http.MethodGet, apiKey, apiSecret)
-	if err != nil {
-		slog.ErrorContext(ctx, "error in creating request", "err", err)
-		return models.GetApiKeyPermissionsResponse{}, err
-	}
-
-	//fmt.Println(string(byteResp))
-
-	err = json.Unmarshal(byteResp, &resp)
+func (s *Service) GetApiKeyPermissions(ctx context.Context, creds models.Credentials) (models.KeyPermissions, error) {
+	perm, err := s.exchange.GetKeyPermissions(ctx, creds)
 	if err != nil {
-		slog.ErrorContext(ctx, "error in unmarshalling response", "err", err)
-		return models.GetApiKeyPermissionsResponse{}, err
+		slog.ErrorContext(ctx, "error in getting api key permissions", "err", err)
+		return models.KeyPermissions{}, err
 	}
 
-	return resp, nil
+	return perm, nil
 }
 
 func (s *Service) DeleteCoin(ctx context.Context, coinTag string, userId int64) error {
@@ -79,8 +70,8 @@ func (s *Service) GetCoinList(ctx context.Context, userId int64) ([]models.Coin,
 	return list, nil
 }
 
-func (s *Service) ExistCoin(ctx context.Context, coinTag string) (bool, error) {
-	list, err := s.storageRepo.ExistCoin(ctx, coinTag)
+func (s *Service) ExistCoin(ctx context.Context, exchange, coinTag string) (bool, error) {
+	list, err := s.storageRepo.ExistCoin(ctx, exchange, coinTag)
 	if err != nil {
 		return false, err
 	}
@@ -95,6 +86,60 @@ func (s *Service) AddCoin(coin models.Coin) error {
 	return nil
 }
 
+// SetGrid makes coin traded by grid strategy, coin is added if user has no such coin.
+func (s *Service) SetGrid(ctx context.Context, userId int64, coinName string, params models.GridParams) error {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	return s.setStrategy(ctx, userId, coinName, strategy.GridName, rawParams)
+}
+
+// setStrategy changes strategy of coin, it can be changed only when nothing is bought and
+// there are no orders.
+func (s *Service) setStrategy(ctx context.Context, userId int64, coinName, strategyName string, params json.RawMessage) error {
+	strat, err := strategy.Get(strategyName)
+	if err != nil {
+		return err
+	}
+
+	err = strat.Validate(params)
+	if err != nil {
+		return err
+	}
+
+	list, err := s.storageRepo.GetCoinList(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(list, func(coin models.Coin) bool { return coin.Name == coinName })
+	if i == -1 {
+		return s.storageRepo.AddCoin(models.Coin{UserId: userId, Name: coinName, Strategy: strategyName, StrategyParams: params})
+	}
+
+	coin := list[i]
+	if coin.Count > 0 || len(coin.Buy) > 0 || coin.BuyOrderId != "" || coin.SellOrderId != "" {
+		return fmt.Errorf("%w: %s", models.ErrCoinIsTraded, coinName)
+	}
+
+	levels, err := s.storageRepo.GetGridLevels(ctx, userId, coinName)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(levels, func(level models.GridLevel) bool { return level.OrderId != "" }) {
+		return fmt.Errorf("%w: %s", models.ErrCoinIsTraded, coinName)
+	}
+
+	err = s.storageRepo.DeleteGridLevels(ctx, userId, coinName)
+	if err != nil {
+		return err
+	}
+
+	return s.storageRepo.SetCoinStrategy(ctx, userId, coinName, strategyName, params)
+}
+
 func (s *Service) InsertIncome(userID int64, coinTag string, income, count float64) error {
 	err := s.storageRepo.InsertIncome(userID, coinTag, income, count)
 	if err != nil {
@@ -103,63 +148,136 @@ func (s *Service) InsertIncome(userID int64, coinTag string, income, count float
 	return nil
 }
 
-func (s *Service) GetCoiniks(ctx context.Context, coinTag string) (models.Coiniks, error) {
-	u, err := s.storageRepo.GetCoiniks(ctx, coinTag)
+func (s *Service) GetCoiniks(ctx context.Context, exchange, coinTag string) (models.Coiniks, error) {
+	u, err := s.storageRepo.GetCoiniks(ctx, exchange, coinTag)
 	if err != nil {
 		return u, err
 	}
 	return u, nil
 }
 
+// EditBuy stops or resumes buying, coins which have been stopped by protection are resumed too.
 func (s *Service) EditBuy(ctx context.Context, userId int64, buy bool) error {
 	err := s.storageRepo.EditBuy(ctx, userId, buy)
 	if err != nil {
 		return err
 	}
+
+	if buy {
+		err = s.storageRepo.ResumeStopped(ctx, userId)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *Service) CreateOrder(apiKey, apiSecret string, order models.OrderCreate) (string, error) {
-	resp := models.CreateOrderResponse{}
-	postParams := map[string]interface{}{
-		"category":    "spot",
-		"symbol":      order.Symbol,
-		"side":        order.Side,
-		"positionIdx": 0,
-		"orderType":   "Limit",
-		"qty":         order.Qty,
-		"price":       order.Price,
-		"timeInForce": "GTC",
+// SetStopLoss sets stop loss of user's coin: percent below average buy price or price,
+// zero values turn it off.
+func (s *Service) SetStopLoss(ctx context.Context, userId int64, coinName string, percent, price float64) error {
+	list, err := s.storageRepo.GetCoinList(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	if !slices.ContainsFunc(list, func(coin models.Coin) bool { return coin.Name == coinName }) {
+		return fmt.Errorf("%w: %s", models.ErrCoinNotFound, coinName)
+	}
+
+	return s.storageRepo.SetStopLoss(ctx, userId, coinName, percent, price)
+}
+
+// GetCoinSettings returns coin's stored settings, zero ones are inherited from user.
+func (s *Service) GetCoinSettings(ctx context.Context, userId int64, coinName string) (models.CoinSettings, error) {
+	list, err := s.storageRepo.GetCoinList(ctx, userId)
+	if err != nil {
+		return models.CoinSettings{}, err
+	}
+
+	if !slices.ContainsFunc(list, func(coin models.Coin) bool { return coin.Name == coinName }) {
+		return models.CoinSettings{}, fmt.Errorf("%w: %s", models.ErrCoinNotFound, coinName)
 	}
-	jsonData, err := json.Marshal(postParams)
+
+	return s.storageRepo.GetCoinSettings(ctx, userId, coinName)
+}
+
+// SetCoinSettings stores coin's settings, they are used from next coin's event. Coin which has
+// been stopped by error is resumed with new settings.
+func (s *Service) SetCoinSettings(ctx context.Context, settings models.CoinSettings) error {
+	list, err := s.storageRepo.GetCoinList(ctx, settings.UserId)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	data, err := s.apiRepo.CreateSignRequestAndGetRespBody(string(jsonData), "/v5/order/create", "POST", apiKey, apiSecret)
+	i := slices.IndexFunc(list, func(coin models.Coin) bool { return coin.Name == settings.CoinName })
+	if i == -1 {
+		return fmt.Errorf("%w: %s", models.ErrCoinNotFound, settings.CoinName)
+	}
+
+	err = s.storageRepo.SaveCoinSettings(ctx, settings)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	json.Unmarshal(data, &resp)
-	return resp.Result.OrderID, nil
+	if list[i].State == models.CoinStateError {
+		return s.storageRepo.SetStopped(ctx, settings.UserId, settings.CoinName, false)
+	}
+	return nil
 }
 
-func (s *Service) GetUserWalletBalance(ctx context.Context, apiKey, apiSecret string) (float64, error) {
-	getUserWalletParams := make(models.GetUserWalletRequest)
-	getUserWalletParams["accountType"] = "UNIFIED"
+// SetTrailing sets trailing take profit of ladder coin, zero callback turns it off. It can be
+// changed while coin is traded, position which has sell order already is sold by it.
+func (s *Service) SetTrailing(ctx context.Context, userId int64, coinName string, callback float64) error {
+	list, err := s.storageRepo.GetCoinList(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	i := slices.IndexFunc(list, func(coin models.Coin) bool { return coin.Name == coinName })
+	if i == -1 {
+		return fmt.Errorf("%w: %s", models.ErrCoinNotFound, coinName)
+	}
 
-	getUserWalletResp, err := s.apiRepo.GetUserWalletBalance(ctx, getUserWalletParams, apiKey, apiSecret)
+	coin := list[i]
+	if coin.Strategy != "" && coin.Strategy != strategy.LadderName {
+		return fmt.Errorf("%w: %s is %s", models.ErrWrongStrategy, coinName, coin.Strategy)
+	}
+
+	params, err := strategy.ParseLadderParams(coin.StrategyParams)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error converting user USDT wallet balance to float", err)
-		return 0, err
+		return err
+	}
+	params.TrailCallback = callback
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
 	}
 
-	userUSDTBalance, err := strconv.ParseFloat(getUserWalletResp.Result.List[0].TotalEquity, 64)
+	return s.storageRepo.SetCoinStrategy(ctx, userId, coinName, coin.Strategy, rawParams)
+}
+
+func (s *Service) CreateOrder(creds models.Credentials, order models.OrderCreate) (string, error) {
+	placed, err := s.exchange.PlaceOrder(context.Background(), creds, models.PlaceOrderRequest{
+		Symbol:    order.Symbol,
+		Side:      order.Side,
+		OrderType: "Limit",
+		Qty:       order.Qty,
+		Price:     order.Price,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return placed.OrderId, nil
+}
+
+func (s *Service) GetUserWalletBalance(ctx context.Context, creds models.Credentials) (float64, error) {
+	balances, err := s.exchange.GetBalances(ctx, creds)
 	if err != nil {
-		slog.ErrorContext(ctx, "Error getting user wallet balance", err)
+		slog.ErrorContext(ctx, "Error getting user wallet balance", "err", err)
 		return 0, err
 	}
 
-	return userUSDTBalance, nil
+	return balances.TotalEquity, nil
 }
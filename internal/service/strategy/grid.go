@@ -0,0 +1,343 @@
+package strategy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+
+	"m1pes/internal/delivery/telegram/bot"
+	"m1pes/internal/models"
+)
+
+const GridName = "grid"
+
+// MaxGridLevels is limit of levels, open orders of grid are requested by one page.
+const MaxGridLevels = 50
+
+// Start order is placed above price, so it is filled at once as market order.
+const gridStartSlippage = 0.005
+
+// Grid is symmetric grid: levels are spread evenly between lower and upper bound, buy orders
+// are placed on levels below price and sell orders on levels above it. Every filled order
+// places opposite one on the neighbouring level, so every cell earns its spread again and again.
+// Coins for sells above price are bought by start order.
+type Grid struct{}
+
+func (g Grid) Name() string {
+	return GridName
+}
+
+func (g Grid) Leveled() {}
+
+func (g Grid) Validate(params json.RawMessage) error {
+	_, err := ParseGridParams(params)
+	return err
+}
+
+func ParseGridParams(raw json.RawMessage) (models.GridParams, error) {
+	var params models.GridParams
+	err := json.Unmarshal(raw, &params)
+	if err != nil {
+		return models.GridParams{}, fmt.Errorf("parse grid params failed: %w", err)
+	}
+
+	switch {
+	case params.Lower <= 0 || params.Upper <= params.Lower:
+		return models.GridParams{}, fmt.Errorf("grid bounds %v-%v are wrong", params.Lower, params.Upper)
+	case params.Levels < 2 || params.Levels > MaxGridLevels:
+		return models.GridParams{}, fmt.Errorf("grid must have from 2 to %d levels, not %d", MaxGridLevels, params.Levels)
+	case params.Investment <= 0:
+		return models.GridParams{}, fmt.Errorf("grid investment must be positive")
+	}
+
+	return params, nil
+}
+
+// nearest returns level which is the closest to price, it is left without order.
+func nearest(p models.GridParams, price float64) int {
+	i := int(math.Round((price - p.Lower) / (p.Upper - p.Lower) * float64(p.Levels-1)))
+	return max(0, min(p.Levels-1, i))
+}
+
+func (g Grid) Handle(ctx context.Context, in Input) (Decision, error) {
+	params, err := ParseGridParams(in.Coin.StrategyParams)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	stored := g.levels(in.Levels, params)
+
+	if !g.started(in.Coin, stored) {
+		return g.start(in, params, stored), nil
+	}
+
+	levels := append([]models.GridLevel(nil), stored...)
+	d := Decision{Coin: in.Coin}
+
+	for _, filled := range sortFilled(in.Event.Filled) {
+		if filled.OrderId == in.Coin.BuyOrderId {
+			err = g.filledStart(in, params, filled, levels, &d)
+			if err != nil {
+				return Decision{}, err
+			}
+			continue
+		}
+
+		i := slices.IndexFunc(levels, func(level models.GridLevel) bool { return level.OrderId == filled.OrderId })
+		if i == -1 {
+			continue
+		}
+
+		switch levels[i].Side {
+		case "Buy":
+			err = g.filledBuy(in, filled, levels, i, &d)
+		case "Sell":
+			err = g.filledSell(in, params, filled, levels, i, &d)
+		}
+		if err != nil {
+			return Decision{}, err
+		}
+	}
+
+	// Levels which have lost their orders get them again. Buys wait while user has stopped buying.
+	for i, level := range levels {
+		if level.Side == "" || (level == stored[i] && level.OrderId != "") {
+			continue
+		}
+		if level.Side == "Buy" && !in.User.Buy {
+			continue
+		}
+		d.Intents = append(d.Intents, PlaceLevel(i, level.Side, level.Price, level.Qty).AsOptional())
+	}
+
+	if !slices.Equal(levels, stored) {
+		d.Levels = levels
+	}
+	if len(d.Intents) > 0 && d.Reason == "" {
+		d.Reason = "grid orders are placed"
+	}
+
+	return d, nil
+}
+
+// started reports if grid has bought something or placed its orders.
+func (g Grid) started(coin models.Coin, levels []models.GridLevel) bool {
+	if coin.Count > 0 || coin.BuyOrderId != "" {
+		return true
+	}
+	return slices.ContainsFunc(levels, func(level models.GridLevel) bool { return level.Side != "" })
+}
+
+// start buys coins for sells above price, levels are laid out when they are bought.
+func (g Grid) start(in Input, params models.GridParams, levels []models.GridLevel) Decision {
+	coin := in.Coin
+
+	if !in.User.Buy {
+		if coin.State == models.CoinStateIdle {
+			return Decision{Coin: coin, Reason: "buying is stopped"}
+		}
+		return Decision{}
+	}
+
+	if !in.CanBuy {
+		return Decision{}
+	}
+
+	// Level of entry price is kept as gap between buys and sells.
+	coin.EntryPrice = in.Event.Price
+	gap := nearest(params, coin.EntryPrice)
+
+	var qty float64
+	for i := gap + 1; i < params.Levels; i++ {
+		qty += params.Qty(i - 1)
+	}
+
+	if qty == 0 {
+		d := Decision{Coin: coin, Reason: "grid is started"}
+		levels = g.layout(params, levels, gap, 0, 0, in.Coiniks.QtyDecimals)
+		for i, level := range levels {
+			if level.Side != "" {
+				d.Intents = append(d.Intents, PlaceLevel(i, level.Side, level.Price, level.Qty).AsOptional())
+			}
+		}
+		d.Levels = levels
+		return d
+	}
+
+	return Decision{
+		Coin:    coin,
+		Intents: []Intent{Place(SlotBuy, "Buy", "Limit", coin.EntryPrice*(1+gridStartSlippage), qty)},
+		Reason:  "grid is started",
+	}
+}
+
+// filledStart lays out levels when coins for sells have been bought.
+func (g Grid) filledStart(in Input, params models.GridParams, filled models.OrderInfo, levels []models.GridLevel, d *Decision) error {
+	fill, err := FillOf(d.Coin, filled)
+	if err != nil {
+		return err
+	}
+	price := fill.Price
+
+	// Fee of buy is taken in coin.
+	received := Truncate(fill.Qty-fill.Fee, in.Coiniks.QtyDecimals)
+
+	gap := nearest(params, d.Coin.EntryPrice)
+	copy(levels, g.layout(params, levels, gap, price, received/fill.Qty, in.Coiniks.QtyDecimals))
+
+	d.Coin.BuyOrderId = ""
+	ApplyFill(&d.Coin, fill, in.Coiniks.QtyDecimals)
+	d.Fills = append(d.Fills, fill)
+	for _, level := range levels {
+		if level.Side == "Sell" {
+			d.Coin.Buy = append(append([]float64(nil), d.Coin.Buy...), price)
+		}
+	}
+	d.Reason = "grid is laid out"
+
+	d.Notify = append(d.Notify, models.Message{
+		Coin:   models.Coin{Name: d.Coin.Name, Buy: []float64{price}, Count: received},
+		Action: bot.BuyAction,
+	})
+	return nil
+}
+
+// layout places buys below gap and sells above it, ratio is part of start order which has
+// been received after fee. Sells are truncated, so they never need more coins than are bought.
+func (g Grid) layout(params models.GridParams, levels []models.GridLevel, gap int, buyPrice, ratio float64, qtyDecimals int) []models.GridLevel {
+	laidOut := make([]models.GridLevel, len(levels))
+	for i, level := range levels {
+		level.Side, level.Qty, level.BuyPrice = "", 0, 0
+
+		switch {
+		case i < gap:
+			level.Side = "Buy"
+			level.Qty = params.Qty(i)
+		case i > gap:
+			level.Side = "Sell"
+			level.Qty = Truncate(params.Qty(i-1)*ratio, qtyDecimals)
+			level.BuyPrice = buyPrice
+		}
+
+		laidOut[i] = level
+	}
+	return laidOut
+}
+
+func (g Grid) filledBuy(in Input, filled models.OrderInfo, levels []models.GridLevel, i int, d *Decision) error {
+	fill, err := FillOf(d.Coin, filled)
+	if err != nil {
+		return err
+	}
+
+	price := levels[i].Price
+	received := Truncate(fill.Qty-fill.Fee, in.Coiniks.QtyDecimals)
+
+	ApplyFill(&d.Coin, fill, in.Coiniks.QtyDecimals)
+	d.Fills = append(d.Fills, fill)
+	d.Coin.Buy = append(append([]float64(nil), d.Coin.Buy...), price)
+	levels[i] = gapLevel(levels[i])
+
+	// Bought coins are sold on the closest free level above.
+	if j := freeLevel(levels, i, 1); j != -1 {
+		levels[j].Side = "Sell"
+		levels[j].Qty = received
+		levels[j].BuyPrice = price
+	}
+
+	d.Reason = "grid order is filled"
+	d.Notify = append(d.Notify, models.Message{
+		Coin:   models.Coin{Name: d.Coin.Name, Buy: []float64{price}, Count: received},
+		Action: bot.BuyAction,
+	})
+	return nil
+}
+
+func (g Grid) filledSell(in Input, params models.GridParams, filled models.OrderInfo, levels []models.GridLevel, i int, d *Decision) error {
+	fill, err := FillOf(d.Coin, filled)
+	if err != nil {
+		return err
+	}
+	qty := fill.Qty
+
+	// Every sell closes coins of its own buy, so they cost as much as they have been bought for.
+	sold := levels[i]
+	position := d.Coin.Position()
+	income := position.Close(fill, sold.BuyPrice*qty)
+	SetPosition(&d.Coin, position, in.Coiniks.QtyDecimals)
+	d.Fills = append(d.Fills, fill)
+
+	d.Coin.Buy = removeBuy(d.Coin.Buy, sold.BuyPrice)
+	levels[i] = gapLevel(levels[i])
+
+	// Money of sell buys coins again on the closest free level below.
+	if j := freeLevel(levels, i, -1); j != -1 {
+		levels[j].Side = "Buy"
+		levels[j].Qty = params.Qty(j)
+	}
+
+	d.Reason = "grid order is filled"
+	d.Incomes = append(d.Incomes, Income{Value: income, Count: qty})
+	d.Notify = append(d.Notify, models.Message{
+		Coin:   models.Coin{Name: d.Coin.Name, CurrentPrice: sold.Price, Count: qty, Income: income},
+		Action: bot.SellAction,
+	})
+	return nil
+}
+
+// levels returns stored levels by number, levels which are not stored yet are added.
+func (g Grid) levels(stored []models.GridLevel, params models.GridParams) []models.GridLevel {
+	levels := make([]models.GridLevel, params.Levels)
+	for i := range levels {
+		levels[i] = models.GridLevel{Level: i, Price: params.Price(i)}
+	}
+
+	for _, level := range stored {
+		if level.Level >= 0 && level.Level < len(levels) {
+			levels[level.Level] = level
+		}
+	}
+	return levels
+}
+
+// freeLevel returns the closest level without side from i in direction dir, -1 if there is none.
+func freeLevel(levels []models.GridLevel, i, dir int) int {
+	for j := i + dir; j >= 0 && j < len(levels); j += dir {
+		if levels[j].Side == "" {
+			return j
+		}
+	}
+	return -1
+}
+
+func gapLevel(level models.GridLevel) models.GridLevel {
+	return models.GridLevel{Level: level.Level, Price: level.Price}
+}
+
+// removeBuy removes one buy of price, the first one is removed if there is no such buy.
+func removeBuy(buy []float64, price float64) []float64 {
+	if len(buy) == 0 {
+		return nil
+	}
+
+	i := slices.Index(buy, price)
+	if i == -1 {
+		i = 0
+	}
+	return slices.Delete(append([]float64(nil), buy...), i, i+1)
+}
+
+// sortFilled returns orders in order they have been filled.
+func sortFilled(filled []models.OrderInfo) []models.OrderInfo {
+	sorted := append([]models.OrderInfo(nil), filled...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, _ := strconv.ParseInt(sorted[i].UpdatedTime, 10, 64)
+		b, _ := strconv.ParseInt(sorted[j].UpdatedTime, 10, 64)
+		return a < b
+	})
+	return sorted
+}
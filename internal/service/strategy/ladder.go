@@ -0,0 +1,294 @@
+package strategy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"m1pes/internal/delivery/telegram/bot"
+	"m1pes/internal/models"
+)
+
+const LadderName = "ladder"
+
+// Ladder is DCA ladder: first order buys below entry price, every filled buy places next one
+// step lower and sell order above average price of all buys. Entry price follows price up
+// while nothing is bought. Steps and sizes are taken from coin's settings.
+type Ladder struct{}
+
+// LadderParams are coin's parameters of ladder exit.
+type LadderParams struct {
+	// TrailCallback turns on trailing take profit instead of sell order: when price rises
+	// above activation its peak is tracked and position is sold at market when price falls
+	// back from peak by callback.
+	TrailCallback float64 `json:"trail_callback"`
+	// TrailActivation is part above average price of buys when trailing starts, take profit by default.
+	TrailActivation float64 `json:"trail_activation"`
+}
+
+// Trailing reports if position is sold by trailing take profit.
+func (p LadderParams) Trailing() bool {
+	return p.TrailCallback > 0
+}
+
+func (l Ladder) Name() string {
+	return LadderName
+}
+
+func (l Ladder) Handle(ctx context.Context, in Input) (Decision, error) {
+	params, err := l.params(in)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	coin := in.Coin
+
+	// Buy order is handled first, sell order is handled on next event.
+	for _, filled := range in.Event.Filled {
+		switch {
+		case filled.Side == "Buy" && filled.OrderId == coin.BuyOrderId:
+			return l.filledBuy(in, filled, params)
+		case filled.Side == "Sell" && filled.OrderId == coin.SellOrderId:
+			return l.filledSell(in, filled, params)
+		}
+	}
+
+	// Trailing take profit has no sell order until price falls back from peak.
+	if coin.State.HasPosition() && coin.SellOrderId == "" && params.Trailing() {
+		return l.trail(in, params), nil
+	}
+
+	// Sell order could fail to be placed after buy, it is placed again.
+	if coin.State.HasPosition() && coin.SellOrderId == "" {
+		return Decision{
+			Coin:    coin,
+			Intents: []Intent{l.sell(coin, in.Settings)},
+			Reason:  "sell order is placed",
+		}, nil
+	}
+
+	if coin.State != models.CoinStateIdle && coin.State != models.CoinStateWaitingEntry {
+		return Decision{}, nil
+	}
+
+	// Nothing is bought and user has stopped buying, so coin is paused.
+	if !in.User.Buy {
+		return Decision{
+			Coin:    coin,
+			Intents: []Intent{Cancel(SlotBuy)},
+			Reason:  "buying is stopped",
+		}, nil
+	}
+
+	// If price becomes higher than entry price and nothing is bought we should raise entry price.
+	if in.Event.Price > coin.EntryPrice {
+		return l.raiseEntry(in, params), nil
+	}
+
+	return Decision{}, nil
+}
+
+func (l Ladder) raiseEntry(in Input, params LadderParams) Decision {
+	coin := in.Coin
+	coin.EntryPrice = in.Event.Price
+	coin.Decrement = in.Settings.Step * coin.EntryPrice
+
+	d := Decision{Coin: coin, Intents: []Intent{Cancel(SlotBuy)}}
+
+	if !in.CanBuy {
+		// There is no free balance for new entry.
+		d.Reason = "not enough balance for entry"
+		return d
+	}
+
+	d.Intents = append(d.Intents, Place(SlotBuy, "Buy", "Limit", coin.EntryPrice-coin.Decrement, in.Settings.EntrySum(in.User.USDTBalance)/in.Event.Price))
+	d.Reason = "entry order is placed"
+	return d
+}
+
+func (l Ladder) filledBuy(in Input, filled models.OrderInfo, params LadderParams) (Decision, error) {
+	price, err := strconv.ParseFloat(filled.Price, 64)
+	if err != nil {
+		return Decision{}, fmt.Errorf("parse price failed: %w", err)
+	}
+
+	coin := in.Coin
+	fill, err := FillOf(coin, filled)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	// Fee of buy is taken in coin, so it makes average price higher.
+	ApplyFill(&coin, fill, in.Coiniks.QtyDecimals)
+	coin.Buy = append(append([]float64(nil), coin.Buy...), price)
+	coin.BuyOrderId = ""
+	// Average price is changed, so trailing starts again from new activation.
+	coin.TrailPeak = 0
+
+	d := Decision{Coin: coin, Reason: "buy order is filled", Fills: []models.Fill{fill}}
+
+	// Next buy order is placed only if there is free balance and ladder is not too deep.
+	if in.CanBuy && (in.Settings.MaxDepth == 0 || len(coin.Buy) < in.Settings.MaxDepth) {
+		nextPrice, qty := nextBuy(coin, price, in.Settings)
+		if qty > 0 {
+			next := Place(SlotBuy, "Buy", "Limit", nextPrice, qty)
+			d.Intents = append(d.Intents, next.AsOptional())
+		}
+	}
+
+	// Old sell order is replaced by order for whole position.
+	d.Intents = append(d.Intents, Cancel(SlotSell))
+	if !params.Trailing() {
+		d.Intents = append(d.Intents, l.sell(coin, in.Settings))
+	}
+
+	received := Truncate(fill.Qty-fill.Fee, in.Coiniks.QtyDecimals)
+	d.Notify = []models.Message{{Coin: models.Coin{Name: coin.Name, Buy: []float64{price}, Count: received}, Action: bot.BuyAction}}
+
+	return d, nil
+}
+
+func (l Ladder) filledSell(in Input, filled models.OrderInfo, params LadderParams) (Decision, error) {
+	sellPrice, err := strconv.ParseFloat(filled.Price, 64)
+	if err != nil {
+		return Decision{}, fmt.Errorf("parse price failed: %w", err)
+	}
+
+	sold := in.Coin
+	fill, err := FillOf(sold, filled)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	// Market order of trailing take profit has no price, its average price is taken.
+	if filled.OrderType == "Market" {
+		sellPrice = fill.Price
+	}
+
+	coin := sold
+	income := ApplyFill(&coin, fill, in.Coiniks.QtyDecimals)
+	coin.SellOrderId = ""
+
+	if filled.OrderStatus != models.OrderStatusFilled && coin.Count > 0 {
+		return l.partialSell(in, coin, fill, income, params), nil
+	}
+
+	// Sell order is for whole position, dust which could be left is forgotten.
+	coin.Count, coin.Cost = 0, 0
+	coin.Buy = nil
+	coin.EntryPrice = sellPrice
+	coin.TrailPeak = 0
+
+	// Old buy order is not needed anymore, it may be gone already.
+	d := Decision{
+		Coin:    coin,
+		Intents: []Intent{Cancel(SlotBuy).AsOptional()},
+		Reason:  "sell order is filled",
+		Incomes: []Income{{Value: income, Count: sold.Count}},
+		Fills:   []models.Fill{fill},
+	}
+
+	if in.User.Buy {
+		// Entry order will be placed on next update if it fails.
+		entry := Place(SlotBuy, "Buy", "Limit", coin.EntryPrice-coin.Decrement, in.Settings.EntrySum(in.User.USDTBalance)/sellPrice)
+		d.Intents = append(d.Intents, entry.AsOptional())
+	}
+
+	sold.CurrentPrice = sellPrice
+	sold.Income = income
+	d.Notify = []models.Message{{Coin: sold, Action: bot.SellAction}}
+
+	return d, nil
+}
+
+// partialSell keeps the rest of position whose sell order has been canceled after partial
+// fill, it is sold by new order. Trailing take profit sells it when price is still below peak.
+func (l Ladder) partialSell(in Input, coin models.Coin, fill models.Fill, income float64, params LadderParams) Decision {
+	d := Decision{
+		Coin:    coin,
+		Reason:  "sell order is partially filled",
+		Incomes: []Income{{Value: income, Count: fill.Qty}},
+		Fills:   []models.Fill{fill},
+	}
+	if !params.Trailing() {
+		d.Intents = []Intent{l.sell(coin, in.Settings)}
+	}
+
+	sold := models.Coin{Name: coin.Name, Count: fill.Qty, CurrentPrice: fill.Price, Income: income}
+	d.Notify = []models.Message{{Coin: sold, Action: bot.SellAction}}
+
+	return d
+}
+
+// sell is order for whole position above average price of buys.
+func (l Ladder) sell(coin models.Coin, settings models.CoinSettings) Intent {
+	return Place(SlotSell, "Sell", "Limit", coin.AvgPrice()*(1+settings.TakeProfit), coin.Count)
+}
+
+// trail activates trailing take profit when price rises above activation, raises its peak
+// and sells position at market when price falls back from peak by callback.
+func (l Ladder) trail(in Input, params LadderParams) Decision {
+	coin := in.Coin
+	price := in.Event.Price
+
+	if coin.TrailPeak == 0 {
+		if price < coin.AvgPrice()*(1+params.TrailActivation) {
+			return Decision{}
+		}
+
+		coin.TrailPeak = price
+		return Decision{Coin: coin, Reason: "trailing take profit is activated"}
+	}
+
+	if price > coin.TrailPeak {
+		coin.TrailPeak = price
+		return Decision{Coin: coin, Reason: "trailing peak is raised"}
+	}
+
+	if price > coin.TrailPeak*(1-params.TrailCallback) {
+		return Decision{}
+	}
+
+	return Decision{
+		Coin:    coin,
+		Intents: []Intent{Place(SlotSell, "Sell", "Market", 0, coin.Count)},
+		Reason:  "trailing take profit is hit",
+	}
+}
+
+func (l Ladder) Validate(params json.RawMessage) error {
+	_, err := ParseLadderParams(params)
+	return err
+}
+
+func (l Ladder) params(in Input) (LadderParams, error) {
+	params, err := ParseLadderParams(in.Coin.StrategyParams)
+	if err != nil {
+		return LadderParams{}, err
+	}
+
+	if params.TrailActivation == 0 {
+		params.TrailActivation = in.Settings.TakeProfit
+	}
+
+	return params, nil
+}
+
+func ParseLadderParams(raw json.RawMessage) (LadderParams, error) {
+	var params LadderParams
+	if len(raw) > 0 {
+		err := json.Unmarshal(raw, &params)
+		if err != nil {
+			return LadderParams{}, fmt.Errorf("parse ladder params failed: %w", err)
+		}
+	}
+
+	if params.TrailCallback < 0 || params.TrailActivation < 0 {
+		return LadderParams{}, fmt.Errorf("ladder params can not be negative")
+	}
+	if params.TrailCallback >= 1 {
+		return LadderParams{}, fmt.Errorf("trail callback must be less than 100%%")
+	}
+	return params, nil
+}
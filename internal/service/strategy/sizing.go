@@ -0,0 +1,65 @@
+package strategy
+
+import (
+	"math"
+
+	"m1pes/internal/models"
+)
+
+// nextBuy returns price and size of ladder's next buy after buy of price, coin has all its
+// buys already.
+func nextBuy(coin models.Coin, price float64, settings models.CoinSettings) (float64, float64) {
+	next := nextPrice(coin, price, settings)
+	return next, nextQty(coin, next, settings)
+}
+
+// nextPrice steps down from price of previous buy.
+func nextPrice(coin models.Coin, price float64, settings models.CoinSettings) float64 {
+	switch settings.Spacing {
+	case models.SpacingPercent:
+		return price * (1 - settings.Step)
+	case models.SpacingWidening:
+		return price - coin.Decrement*(1+settings.StepWidening*float64(len(coin.Buy)))
+	default:
+		return price - coin.Decrement
+	}
+}
+
+// nextQty is size of next buy of price. First buy is found from what is bought, so fee of
+// buys is taken into account. It is 0 if next buy can't be sized.
+func nextQty(coin models.Coin, price float64, settings models.CoinSettings) float64 {
+	n := len(coin.Buy)
+
+	if settings.Sizing == models.SizingTarget {
+		// Average price of position is moved from avg to target by next buy.
+		avg := coin.AvgPrice()
+		target := price * (1 + settings.TargetAverage)
+		if target <= price {
+			// Average can't be moved to price of buy itself, nothing is bought.
+			return 0
+		}
+		if target < avg {
+			return coin.Count * (avg - target) / (target - price)
+		}
+		// Position is cheap enough already, next buy is flat.
+		return coin.Count / float64(n)
+	}
+
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += sizeWeight(settings, i)
+	}
+	return coin.Count / sum * sizeWeight(settings, n)
+}
+
+// sizeWeight is size of buy i in sizes of the first buy.
+func sizeWeight(settings models.CoinSettings, i int) float64 {
+	switch settings.Sizing {
+	case models.SizingLinear:
+		return 1 + (settings.Multiplier-1)*float64(i)
+	case models.SizingGeometric:
+		return math.Pow(settings.Multiplier, float64(i))
+	default:
+		return 1
+	}
+}
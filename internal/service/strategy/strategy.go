@@ -0,0 +1,244 @@
+package strategy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"m1pes/internal/models"
+)
+
+// Strategy decides which orders coin needs. It doesn't call exchange or storage: algorithm
+// stores position which strategy returns, places and cancels orders of intents and moves
+// coin to state which follows from its position and orders.
+type Strategy interface {
+	Name() string
+	// Handle is called on every coin's event. Empty decision means that nothing has to be done.
+	Handle(ctx context.Context, in Input) (Decision, error)
+	// Validate checks coin's parameters of strategy before they are stored.
+	Validate(params json.RawMessage) error
+}
+
+// Leveled is strategy which keeps its orders in grid levels instead of coin's slots.
+// Algorithm gives it stored levels and checks their orders.
+type Leveled interface {
+	Strategy
+	Leveled()
+}
+
+// Event is what has happened with coin.
+type Event struct {
+	Price float64
+	// Filled are coin's orders which have been filled since last event, order which has been
+	// canceled after partial fill is here too with its executed quantity.
+	Filled []models.OrderInfo
+}
+
+type Input struct {
+	Event Event
+	// User has USDTBalance which is kept up to date by wallet stream.
+	User    models.User
+	Coin    models.Coin
+	Coiniks models.Coiniks
+	// Levels are stored grid levels, only leveled strategies get them.
+	Levels []models.GridLevel
+	// Settings are coin's settings with inherited defaults.
+	Settings models.CoinSettings
+	// CanBuy is false when user's balance or coin's allocation is already in coins.
+	CanBuy bool
+}
+
+type IntentType string
+
+const (
+	IntentPlace  IntentType = "place"
+	IntentCancel IntentType = "cancel"
+)
+
+// Slot is coin's field where id of order is kept.
+type Slot string
+
+const (
+	SlotBuy Slot = "buy"
+	// SlotSell is order for whole position, its quantity is coin's count when it is placed,
+	// because position could be changed by partial fills of canceled orders.
+	SlotSell Slot = "sell"
+	// SlotLevel is order of grid level, intent's Level is its number.
+	SlotLevel Slot = "level"
+)
+
+// Intent is order which has to be placed into slot or canceled in slot. Prices and quantities
+// are rounded to coin's decimals by algorithm.
+type Intent struct {
+	Type      IntentType
+	Slot      Slot
+	Side      string
+	OrderType string
+	Price     float64
+	Qty       float64
+	Level     int
+	// Failure of optional intent is only logged, failure of other intent stops decision.
+	Optional bool
+}
+
+// Decision is what strategy wants to do with coin.
+type Decision struct {
+	// Coin is coin with new position, slots of filled orders have to be cleared by strategy.
+	Coin models.Coin
+	// Intents are executed in order.
+	Intents []Intent
+	// Reason is written to coin's state history.
+	Reason string
+	// Incomes are stored when position or its part is closed.
+	Incomes []Income
+	// Fills are filled orders which have changed position, executions of their orders are
+	// stored in fills ledger.
+	Fills []models.Fill
+	// Notify are sent to user when decision is executed.
+	Notify []models.Message
+	// Levels are grid levels with new sides and quantities, changed ones are stored. Their
+	// OrderId is kept by algorithm: order of changed level is canceled unless it is filled.
+	Levels []models.GridLevel
+}
+
+type Income struct {
+	Value float64
+	Count float64
+}
+
+// Empty reports if nothing has to be done.
+func (d Decision) Empty() bool {
+	return d.Reason == "" && len(d.Intents) == 0
+}
+
+func Place(slot Slot, side, orderType string, price, qty float64) Intent {
+	return Intent{Type: IntentPlace, Slot: slot, Side: side, OrderType: orderType, Price: price, Qty: qty}
+}
+
+func Cancel(slot Slot) Intent {
+	return Intent{Type: IntentCancel, Slot: slot}
+}
+
+// PlaceLevel places order of grid level.
+func PlaceLevel(level int, side string, price, qty float64) Intent {
+	return Intent{Type: IntentPlace, Slot: SlotLevel, Side: side, OrderType: "Limit", Price: price, Qty: qty, Level: level}
+}
+
+func CancelLevel(level int) Intent {
+	return Intent{Type: IntentCancel, Slot: SlotLevel, Level: level}
+}
+
+// AsOptional returns intent whose failure does not stop decision.
+func (i Intent) AsOptional() Intent {
+	i.Optional = true
+	return i
+}
+
+// DefaultName is strategy of coins which have no strategy.
+const DefaultName = LadderName
+
+var strategies = map[string]Strategy{
+	LadderName: Ladder{},
+	GridName:   Grid{},
+}
+
+// Get returns strategy by name, empty name is DefaultName.
+func Get(name string) (Strategy, error) {
+	if name == "" {
+		name = DefaultName
+	}
+
+	s, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q, known: %s", name, strings.Join(Names(), ", "))
+	}
+	return s, nil
+}
+
+func Names() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Truncate cuts value to decimals without rounding, so it never becomes more than exchange has.
+func Truncate(value float64, decimals int) float64 {
+	// 12 digits drop float error of subtraction (0.15-0.00015 is 0.14984999999999998),
+	// %f would round value to 6 digits before cutting.
+	str := strconv.FormatFloat(value, 'f', 12, 64)
+	dotIdx := strings.Index(str, ".")
+	if dotIdx == -1 {
+		return value
+	}
+
+	if decimals > 0 && dotIdx+1+decimals < len(str) {
+		str = str[:dotIdx+1+decimals]
+	} else if decimals <= 0 {
+		str = str[:dotIdx]
+	}
+
+	truncated, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return value
+	}
+	return truncated
+}
+
+// FillOf returns fill of order's executed part by its average price.
+func FillOf(coin models.Coin, filled models.OrderInfo) (models.Fill, error) {
+	price, qty, fee, err := parseFilled(filled)
+	if err != nil {
+		return models.Fill{}, err
+	}
+
+	fill := models.NewFill(coin, filled.Side, filled.OrderId, price, qty, fee)
+	fill.Time, _ = strconv.ParseInt(filled.UpdatedTime, 10, 64)
+	return fill, nil
+}
+
+// ApplyFill changes coin's position by fill and returns realised PnL, count is cut to coin's decimals.
+func ApplyFill(coin *models.Coin, fill models.Fill, qtyDecimals int) float64 {
+	position := coin.Position()
+	realised := position.Apply(fill)
+	SetPosition(coin, position, qtyDecimals)
+	return realised
+}
+
+// SetPosition stores position in coin, count is cut to coin's decimals.
+func SetPosition(coin *models.Coin, position models.Position, qtyDecimals int) {
+	coin.Count = Truncate(position.Qty, qtyDecimals)
+	coin.Cost = position.Cost
+	if coin.Count == 0 {
+		coin.Cost = 0
+	}
+}
+
+// parseFilled returns average price, quantity and fee of filled order.
+func parseFilled(filled models.OrderInfo) (price, qty, fee float64, err error) {
+	qty, err = strconv.ParseFloat(filled.CumExecQty, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("parse qty failed: %w", err)
+	}
+
+	fee, err = strconv.ParseFloat(filled.CumExecFee, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("parse fee failed: %w", err)
+	}
+
+	value, err := strconv.ParseFloat(filled.CumExecValue, 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("parse value failed: %w", err)
+	}
+
+	if qty == 0 {
+		return 0, 0, 0, fmt.Errorf("order %s is filled without qty", filled.OrderId)
+	}
+
+	return value / qty, qty, fee, nil
+}
@@ -55,3 +55,20 @@ func (s *Service) GetUser(ctx context.Context, userId int64) (models.User, error
 	}
 	return u, nil
 }
+
+func (s *Service) SetMaxDrawdown(ctx context.Context, userId int64, maxDrawdown float64) error {
+	err := s.userRepo.SetMaxDrawdown(ctx, userId, maxDrawdown)
+	if err != nil {
+		return logging.WrapError(ctx, err)
+	}
+	return nil
+}
+
+// ResetPeakEquity forgets user's peak equity, drawdown is counted from next equity.
+func (s *Service) ResetPeakEquity(ctx context.Context, userId int64) error {
+	err := s.userRepo.SetPeakEquity(ctx, userId, 0)
+	if err != nil {
+		return logging.WrapError(ctx, err)
+	}
+	return nil
+}